@@ -1,22 +1,36 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"os"
+	"src/l"
 	"src/pkg/conf"
 	"src/pkg/db"
 	"src/pkg/env"
+	"src/pkg/job"
+	"src/pkg/jwtkeys"
+	"src/pkg/mail"
 	address "src/pkg/module/address"
 	auth "src/pkg/module/auth"
 	brand "src/pkg/module/brand"
 	cart "src/pkg/module/cart"
 	category "src/pkg/module/category"
+	"src/pkg/module/contact"
+	"src/pkg/module/inventory"
 	"src/pkg/module/merchant"
 	order "src/pkg/module/order"
 	"src/pkg/module/payment"
 	product "src/pkg/module/product"
 	review "src/pkg/module/review"
+	role "src/pkg/module/role"
 	user "src/pkg/module/user"
+	"src/pkg/module/wishlist"
+	"src/pkg/oauth"
+	"src/pkg/permission"
+	"src/pkg/sms"
+	"src/pkg/throttle"
 	"strings"
 	"time"
 
@@ -24,6 +38,16 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// keyReloadInterval is how often the JWT keys are reloaded, picking up the
+// keys other instances rotated in.
+const keyReloadInterval = time.Hour
+
+// sentMailRetention is how long sent mails stay in the outbox.
+const sentMailRetention = 30 * 24 * time.Hour
+
+// loginAttemptRetention is how long the sign in audit is kept.
+const loginAttemptRetention = 90 * 24 * time.Hour
+
 func main() {
 	log.SetFlags(log.LstdFlags | log.Llongfile)
 	envs, err := env.GetEnv()
@@ -66,22 +90,123 @@ func main() {
 		DB: pgDb,
 
 		Env:           envs,
-		TokenLifetime: 24,
+		TokenLifetime: envs.AccessTokenTTL,
+		Permissions:   permission.NewStore(pgDb),
 		// MongoClient:   clinet,
 	}
 
+	// Empty the trash once a day
+	job.Every("trash purge", 24*time.Hour, func(ctx context.Context) error {
+		before := time.Now().AddDate(0, 0, -envs.TrashRetentionDays)
+		products, productErr := product.PurgeDeletedProducts(ctx, config, before)
+		brands, brandErr := brand.PurgeDeletedBrands(ctx, config.DB, before)
+		categories, categoryErr := category.PurgeDeletedCategories(ctx, config.DB, before)
+		l.InfoF("Purged %d products, %d brands and %d categories from the trash", products, brands, categories)
+		return errors.Join(productErr, brandErr, categoryErr)
+	})
+
+	if envs.JWTKeysDir != "" {
+		config.Keys, err = jwtkeys.Load(envs.JWTKeysDir, envs.JWTAlgorithm)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		// Old keys verify tokens until the last ones they signed expired, mail
+		// links included, and instances that did not reload yet may still sign
+		// with them
+		retire := auth.SignedTokenLifetime(envs.AccessTokenTTL) + keyReloadInterval
+		job.Every("jwt key rotation", keyReloadInterval, func(ctx context.Context) error {
+			return config.Keys.RotateIfDue(envs.JWTKeyRotation, retire)
+		})
+	} else if envs.SecretJWT == "" {
+		log.Fatalln("SECRET_JWT or JWT_KEYS_DIR is required")
+	}
+
+	job.Every("refresh token purge", 24*time.Hour, func(ctx context.Context) error {
+		n, err := auth.PurgeExpiredRefreshTokens(ctx, config.DB)
+		l.InfoF("Purged %d expired refresh tokens", n)
+		return err
+	})
+
+	switch envs.LoginThrottle {
+	case "postgres":
+		config.LoginGuard = throttle.NewGuard(throttle.NewPostgresStore(config.DB))
+	case "memory":
+		config.LoginGuard = throttle.NewGuard(throttle.NewMemoryStore())
+	default:
+		log.Fatalf("unknown LOGIN_THROTTLE %q", envs.LoginThrottle)
+	}
+	job.Every("login throttle purge", 24*time.Hour, func(ctx context.Context) error {
+		n, err := auth.PurgeLoginAttempts(ctx, config.DB, time.Now().Add(-loginAttemptRetention))
+		l.InfoF("Purged %d login attempts", n)
+		_, linkErr := auth.PurgeUsedUnlockLinks(ctx, config.DB)
+		return errors.Join(err, linkErr, config.LoginGuard.Purge(ctx))
+	})
+
+	config.OAuth, err = oauth.Load(envs)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	job.Every("oauth state purge", time.Hour, func(ctx context.Context) error {
+		_, err := auth.PurgeOAuthStates(ctx, config.DB)
+		return err
+	})
+
+	config.SMS, err = sms.NewSender(envs)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	job.Every("phone code purge", 24*time.Hour, func(ctx context.Context) error {
+		n, err := auth.PurgePhoneCodes(ctx, config.DB, time.Now().Add(-24*time.Hour))
+		l.InfoF("Purged %d phone codes", n)
+		return err
+	})
+
+	mailer, err := mail.NewMailer(envs)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	job.Every("mail outbox", 15*time.Second, func(ctx context.Context) error {
+		_, err := mail.SendPending(ctx, config.DB, mailer)
+		return err
+	})
+
+	job.Every("sent mail purge", 24*time.Hour, func(ctx context.Context) error {
+		n, err := mail.PurgeSentMail(ctx, config.DB, time.Now().Add(-sentMailRetention))
+		l.InfoF("Purged %d sent mails", n)
+		return err
+	})
+
+	job.Every("stock reservation expiry", 5*time.Minute, func(ctx context.Context) error {
+		n, err := order.ReleaseExpiredReservations(ctx, config.DB)
+		if n > 0 {
+			l.InfoF("Released the stock of %d unpaid orders", n)
+		}
+		return err
+	})
+
+	job.Every("recommendations", 6*time.Hour, func(ctx context.Context) error {
+		return product.RefreshRecommendations(ctx, config.DB)
+	})
+
 	// Start the server
 	router := gin.Default()
+	// Throttles key on the client IP, which must not come from a header anyone
+	// can set
+	if err := router.SetTrustedProxies(envs.TrustedProxies); err != nil {
+		log.Fatalln(err)
+	}
 	router.Use(normalizeURLMiddleware())
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Content-Type", "Authorization", product.VisitorHeader},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
+	router.GET("/.well-known/jwks.json", auth.JWKS(config))
+
 	r := router.Group("/api")
 	r.GET("/ping", func(c *gin.Context) {
 		c.JSON(200, gin.H{
@@ -102,6 +227,10 @@ func main() {
 		order.SetupRoute("/order", r, config)
 		review.SetupRouter("/review", r, config)
 		payment.SetupRouter("/payment", r, config)
+		inventory.SetupRouter("/inventory", r, config)
+		wishlist.SetupRouter("/wishlist", r, config)
+		contact.SetupRouter("/contact", r, config)
+		role.SetupRouter("/role", r, config)
 	}
 
 	router.Run(":3000")
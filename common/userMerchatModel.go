@@ -30,6 +30,7 @@ type User struct {
 	Role                 string        `db:"role" json:"role"`
 	ResetPasswordToken   null.String   `db:"reset_password_token" json:"-"`
 	ResetPasswordExpires null.Time     `db:"reset_password_expires" json:"-"`
+	EmailVerifiedAt      null.Time     `db:"email_verified_at" json:"emailVerifiedAt"`
 	Updated              null.Time     `db:"updated_at" json:"updatedAt"`
 	Created              time.Time     `db:"created_at" json:"createdAt"`
 }
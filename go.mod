@@ -3,6 +3,7 @@ module src
 go 1.22.4
 
 require (
+	github.com/HugoSmits86/nativewebp v1.1.1
 	github.com/aws/aws-sdk-go v1.55.5
 	github.com/cashfree/cashfree-pg/v4 v4.3.3
 	github.com/gin-contrib/cors v1.7.2
@@ -15,6 +16,7 @@ require (
 	github.com/razorpay/razorpay-go v1.3.2
 	go.uber.org/zap v1.27.0
 	golang.org/x/crypto v0.31.0
+	golang.org/x/image v0.24.0
 	golang.org/x/oauth2 v0.23.0
 )
 
@@ -55,9 +57,9 @@ require (
 	go.uber.org/multierr v1.10.0 // indirect
 	golang.org/x/arch v0.8.0 // indirect
 	golang.org/x/net v0.25.0 // indirect
-	golang.org/x/sync v0.10.0 // indirect
+	golang.org/x/sync v0.11.0 // indirect
 	golang.org/x/sys v0.28.0 // indirect
-	golang.org/x/text v0.21.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
 	google.golang.org/protobuf v1.34.1 // indirect
 	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -0,0 +1,2 @@
+-- Add down migration script here
+drop table if exists product_images;
@@ -0,0 +1,31 @@
+-- Add up migration script here
+CREATE TABLE product_images (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    content_hash VARCHAR(64) NOT NULL,
+    image_key TEXT NOT NULL,
+    image_url TEXT NOT NULL,
+    webp_key TEXT,
+    webp_url TEXT,
+    thumbnail_key TEXT,
+    thumbnail_url TEXT,
+    width INTEGER NOT NULL DEFAULT 0,
+    height INTEGER NOT NULL DEFAULT 0,
+    alt_text TEXT NOT NULL DEFAULT '',
+    sort_order INTEGER NOT NULL DEFAULT 0,
+    is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+    updated TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (product_id, content_hash)
+);
+
+CREATE INDEX product_images_product_id_sort_idx ON product_images (product_id, sort_order);
+
+-- Only one primary image per product
+CREATE UNIQUE INDEX product_images_primary_idx ON product_images (product_id) WHERE is_primary;
+
+-- Carry the existing single image over as the primary gallery image
+INSERT INTO product_images (product_id, content_hash, image_key, image_url, sort_order, is_primary)
+SELECT id, md5(image_url), COALESCE(image_key, ''), image_url, 0, TRUE
+FROM products
+WHERE image_url IS NOT NULL AND image_url <> '';
@@ -0,0 +1,8 @@
+-- Add down migration script here
+DROP INDEX IF EXISTS idx_categories_path;
+DROP INDEX IF EXISTS idx_categories_parent_id;
+
+ALTER TABLE categories
+    DROP COLUMN IF EXISTS depth,
+    DROP COLUMN IF EXISTS path,
+    DROP COLUMN IF EXISTS parent_id;
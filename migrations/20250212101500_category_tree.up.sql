@@ -0,0 +1,13 @@
+-- Add up migration script here
+ALTER TABLE categories
+    ADD COLUMN parent_id UUID REFERENCES categories(id) ON DELETE RESTRICT,
+    ADD COLUMN path TEXT, -- materialized path of ancestor ids, e.g. /<root>/<child>/
+    ADD COLUMN depth INT NOT NULL DEFAULT 0;
+
+UPDATE categories SET path = '/' || id::text || '/';
+
+ALTER TABLE categories
+    ALTER COLUMN path SET NOT NULL;
+
+CREATE INDEX idx_categories_parent_id ON categories (parent_id);
+CREATE INDEX idx_categories_path ON categories (path text_pattern_ops);
@@ -0,0 +1,3 @@
+-- Add down migration script here
+drop table if exists product_attribute_values;
+drop table if exists category_attributes;
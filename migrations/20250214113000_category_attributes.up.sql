@@ -0,0 +1,29 @@
+-- Add up migration script here
+CREATE TABLE category_attributes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    category_id UUID NOT NULL REFERENCES categories(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    slug VARCHAR(255) NOT NULL,
+    type VARCHAR(20) NOT NULL CHECK (type IN ('text', 'number', 'boolean', 'enum')),
+    unit VARCHAR(50) NOT NULL DEFAULT '',
+    allowed_values TEXT[] NOT NULL DEFAULT '{}', -- only used by enum attributes
+    is_filterable BOOLEAN NOT NULL DEFAULT FALSE,
+    is_required BOOLEAN NOT NULL DEFAULT FALSE,
+    sort_order INT NOT NULL DEFAULT 0,
+    updated TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (category_id, slug)
+);
+
+CREATE TABLE product_attribute_values (
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    attribute_id UUID NOT NULL REFERENCES category_attributes(id) ON DELETE CASCADE,
+    value_text TEXT,
+    value_number DOUBLE PRECISION,
+    value_boolean BOOLEAN,
+    updated TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (product_id, attribute_id)
+);
+
+CREATE INDEX idx_product_attribute_values_text ON product_attribute_values (attribute_id, value_text);
+CREATE INDEX idx_product_attribute_values_number ON product_attribute_values (attribute_id, value_number);
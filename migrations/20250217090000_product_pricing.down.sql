@@ -0,0 +1,6 @@
+-- Add down migration script here
+drop table if exists product_price_history;
+drop table if exists product_sale_prices;
+
+alter table products
+    drop column if exists compare_at_price;
@@ -0,0 +1,34 @@
+-- Add up migration script here
+ALTER TABLE products
+    ADD COLUMN compare_at_price NUMERIC(10, 2); -- MSRP shown crossed out next to the price
+
+CREATE TABLE product_sale_prices (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    sale_price NUMERIC(10, 2) NOT NULL CHECK (sale_price > 0),
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ends_at TIMESTAMP WITH TIME ZONE, -- NULL runs until removed
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CHECK (ends_at IS NULL OR ends_at > starts_at)
+);
+
+CREATE INDEX idx_product_sale_prices_product ON product_sale_prices (product_id, starts_at);
+
+CREATE TABLE product_price_history (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    change_type VARCHAR(30) NOT NULL, -- initial, price, sale_scheduled, sale_removed
+    price NUMERIC(10, 2) NOT NULL,
+    compare_at_price NUMERIC(10, 2),
+    sale_price NUMERIC(10, 2),
+    sale_starts_at TIMESTAMP WITH TIME ZONE,
+    sale_ends_at TIMESTAMP WITH TIME ZONE,
+    changed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_product_price_history_product ON product_price_history (product_id, created DESC);
+
+INSERT INTO product_price_history (product_id, change_type, price, created)
+SELECT id, 'initial', price, COALESCE(created, NOW()) FROM products;
@@ -0,0 +1,6 @@
+-- Add down migration script here
+drop table if exists stock_movements;
+
+alter table products
+    drop column if exists low_stock_threshold,
+    drop column if exists reserved_quantity;
@@ -0,0 +1,23 @@
+-- Add up migration script here
+ALTER TABLE products
+    ADD COLUMN reserved_quantity INT NOT NULL DEFAULT 0, -- held by placed but unpaid orders
+    ADD COLUMN low_stock_threshold INT NOT NULL DEFAULT 0; -- 0 disables low-stock alerts
+
+CREATE TABLE stock_movements (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    movement_type VARCHAR(20) NOT NULL CHECK (movement_type IN ('received', 'sold', 'reserved', 'released', 'returned', 'adjusted')),
+    quantity INT NOT NULL, -- change of the on-hand stock
+    reserved_quantity INT NOT NULL DEFAULT 0, -- change of the reserved stock
+    reason TEXT NOT NULL DEFAULT '',
+    order_id UUID REFERENCES orders(id) ON DELETE SET NULL,
+    actor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_stock_movements_product ON stock_movements (product_id, created DESC);
+CREATE INDEX idx_stock_movements_order ON stock_movements (order_id) WHERE order_id IS NOT NULL;
+
+-- Opening balance so the ledger sums up to the current quantity
+INSERT INTO stock_movements (product_id, movement_type, quantity, reason)
+SELECT id, 'adjusted', quantity, 'Opening balance' FROM products WHERE quantity <> 0;
@@ -0,0 +1,14 @@
+-- Add down migration script here
+ALTER TABLE cart_items DROP COLUMN IF EXISTS location_id;
+
+DELETE FROM stock_movements WHERE movement_type = 'transferred';
+
+ALTER TABLE stock_movements
+    DROP COLUMN IF EXISTS location_id,
+    DROP CONSTRAINT stock_movements_movement_type_check,
+    ADD CONSTRAINT stock_movements_movement_type_check
+        CHECK (movement_type IN ('received', 'sold', 'reserved', 'released', 'returned', 'adjusted'));
+
+DROP TABLE IF EXISTS stock_transfers;
+DROP TABLE IF EXISTS location_stock;
+DROP TABLE IF EXISTS merchant_locations;
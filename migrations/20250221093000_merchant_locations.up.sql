@@ -0,0 +1,64 @@
+-- Add up migration script here
+CREATE TABLE merchant_locations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    merchant_id UUID NOT NULL REFERENCES merchants(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    address_line1 VARCHAR(255) NOT NULL DEFAULT '',
+    city VARCHAR(50) NOT NULL DEFAULT '',
+    state VARCHAR(50) NOT NULL DEFAULT '',
+    country VARCHAR(50) NOT NULL DEFAULT '',
+    zip_code VARCHAR(10) NOT NULL DEFAULT '',
+    is_default BOOLEAN NOT NULL DEFAULT FALSE, -- receives stock booked without a location
+    is_active BOOLEAN NOT NULL DEFAULT TRUE, -- inactive locations are not used for new orders
+    updated TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_merchant_locations_merchant ON merchant_locations (merchant_id);
+CREATE UNIQUE INDEX idx_merchant_locations_default ON merchant_locations (merchant_id) WHERE is_default;
+
+CREATE TABLE location_stock (
+    location_id UUID NOT NULL REFERENCES merchant_locations(id) ON DELETE CASCADE,
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    quantity INT NOT NULL DEFAULT 0,
+    reserved_quantity INT NOT NULL DEFAULT 0,
+    updated TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (location_id, product_id)
+);
+
+CREATE INDEX idx_location_stock_product ON location_stock (product_id);
+
+CREATE TABLE stock_transfers (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    from_location_id UUID NOT NULL REFERENCES merchant_locations(id) ON DELETE CASCADE,
+    to_location_id UUID NOT NULL REFERENCES merchant_locations(id) ON DELETE CASCADE,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    reason TEXT NOT NULL DEFAULT '',
+    actor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+ALTER TABLE stock_movements
+    ADD COLUMN location_id UUID REFERENCES merchant_locations(id) ON DELETE SET NULL,
+    DROP CONSTRAINT stock_movements_movement_type_check,
+    ADD CONSTRAINT stock_movements_movement_type_check
+        CHECK (movement_type IN ('received', 'sold', 'reserved', 'released', 'returned', 'adjusted', 'transferred'));
+
+-- Location that fulfils the order item
+ALTER TABLE cart_items ADD COLUMN location_id UUID REFERENCES merchant_locations(id) ON DELETE SET NULL;
+
+-- Every merchant starts with a default location holding the existing stock
+INSERT INTO merchant_locations (merchant_id, name, is_default)
+SELECT id, 'Default', TRUE FROM merchants;
+
+INSERT INTO location_stock (location_id, product_id, quantity, reserved_quantity)
+SELECT ml.id, p.id, p.quantity, p.reserved_quantity
+FROM products p
+JOIN merchant_locations ml ON ml.merchant_id = p.merchant_id AND ml.is_default;
+
+UPDATE stock_movements sm
+SET location_id = ml.id
+FROM products p
+JOIN merchant_locations ml ON ml.merchant_id = p.merchant_id AND ml.is_default
+WHERE p.id = sm.product_id;
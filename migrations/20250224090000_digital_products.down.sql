@@ -0,0 +1,11 @@
+-- Add down migration script here
+DELETE FROM orders WHERE address_id IS NULL;
+ALTER TABLE orders ALTER COLUMN address_id SET NOT NULL;
+
+DROP TABLE IF EXISTS download_grants;
+DROP TABLE IF EXISTS product_files;
+
+ALTER TABLE products
+    DROP COLUMN IF EXISTS download_expiry_days,
+    DROP COLUMN IF EXISTS download_limit,
+    DROP COLUMN IF EXISTS product_type;
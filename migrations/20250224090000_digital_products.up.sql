@@ -0,0 +1,38 @@
+-- Add up migration script here
+ALTER TABLE products
+    ADD COLUMN product_type VARCHAR(20) NOT NULL DEFAULT 'physical' CHECK (product_type IN ('physical', 'digital')),
+    ADD COLUMN download_limit INT NOT NULL DEFAULT 0 CHECK (download_limit >= 0), -- 0 means unlimited
+    ADD COLUMN download_expiry_days INT NOT NULL DEFAULT 0 CHECK (download_expiry_days >= 0); -- 0 means never
+
+-- Files sold with a digital product, kept in private storage
+CREATE TABLE product_files (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    file_key TEXT NOT NULL,
+    file_name VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL DEFAULT 'application/octet-stream',
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_product_files_product ON product_files (product_id);
+
+-- Right of a customer to download a file of a paid order
+CREATE TABLE download_grants (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    file_id UUID NOT NULL REFERENCES product_files(id) ON DELETE CASCADE,
+    download_limit INT NOT NULL DEFAULT 0, -- 0 means unlimited
+    download_count INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE, -- NULL means never
+    last_downloaded TIMESTAMP WITH TIME ZONE,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (order_id, file_id)
+);
+
+CREATE INDEX idx_download_grants_user ON download_grants (user_id);
+
+-- Fully digital orders have nothing to ship
+ALTER TABLE orders ALTER COLUMN address_id DROP NOT NULL;
@@ -0,0 +1,8 @@
+-- Add down migration script here
+DROP TABLE IF EXISTS bundle_components;
+
+DELETE FROM products WHERE product_type = 'bundle';
+
+ALTER TABLE products
+    DROP CONSTRAINT products_product_type_check,
+    ADD CONSTRAINT products_product_type_check CHECK (product_type IN ('physical', 'digital'));
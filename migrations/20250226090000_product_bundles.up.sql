@@ -0,0 +1,15 @@
+-- Add up migration script here
+ALTER TABLE products
+    DROP CONSTRAINT products_product_type_check,
+    ADD CONSTRAINT products_product_type_check CHECK (product_type IN ('physical', 'digital', 'bundle'));
+
+-- Products a bundle is made of; a bundle has no stock of its own
+CREATE TABLE bundle_components (
+    bundle_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    component_id UUID NOT NULL REFERENCES products(id) ON DELETE RESTRICT,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    PRIMARY KEY (bundle_id, component_id),
+    CHECK (bundle_id <> component_id)
+);
+
+CREATE INDEX idx_bundle_components_component ON bundle_components (component_id);
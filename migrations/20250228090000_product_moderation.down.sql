@@ -0,0 +1,15 @@
+-- Add down migration script here
+DROP TABLE IF EXISTS product_revisions;
+DROP TABLE IF EXISTS product_status_history;
+
+DROP INDEX IF EXISTS idx_products_status;
+
+ALTER TABLE products DROP COLUMN is_active;
+ALTER TABLE products ADD COLUMN is_active BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE products SET is_active = (status = 'published');
+
+ALTER TABLE products
+    DROP COLUMN reviewed_at,
+    DROP COLUMN reviewed_by,
+    DROP COLUMN status_reason,
+    DROP COLUMN status;
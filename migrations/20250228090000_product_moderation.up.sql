@@ -0,0 +1,43 @@
+-- Add up migration script here
+-- Listings go live only after an admin approved them; is_active now follows the status
+ALTER TABLE products
+    ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'draft'
+        CHECK (status IN ('draft', 'pending_review', 'published', 'rejected', 'archived')),
+    ADD COLUMN status_reason TEXT, -- why the listing was rejected
+    ADD COLUMN reviewed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    ADD COLUMN reviewed_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE products SET status = CASE WHEN is_active THEN 'published' ELSE 'draft' END;
+
+ALTER TABLE products DROP COLUMN is_active;
+ALTER TABLE products ADD COLUMN is_active BOOLEAN GENERATED ALWAYS AS (status = 'published') STORED;
+
+CREATE INDEX idx_products_status ON products (status);
+
+CREATE TABLE product_status_history (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    from_status VARCHAR(20) NOT NULL,
+    to_status VARCHAR(20) NOT NULL,
+    reason TEXT,
+    changed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_product_status_history_product ON product_status_history (product_id, created DESC);
+
+-- Listing edits to published products wait here until an admin approves them
+CREATE TABLE product_revisions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    changes JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    reason TEXT,
+    submitted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    reviewed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+    updated TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX idx_product_revisions_pending ON product_revisions (product_id) WHERE status = 'pending';
@@ -0,0 +1,9 @@
+-- Add down migration script here
+-- Trashed rows come back hidden rather than being dropped with their references
+UPDATE products SET status = 'archived' WHERE deleted_at IS NOT NULL;
+UPDATE brands SET is_active = FALSE WHERE deleted_at IS NOT NULL;
+UPDATE categories SET is_active = FALSE WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE products DROP COLUMN deleted_at, DROP COLUMN deleted_by;
+ALTER TABLE brands DROP COLUMN deleted_at, DROP COLUMN deleted_by;
+ALTER TABLE categories DROP COLUMN deleted_at, DROP COLUMN deleted_by;
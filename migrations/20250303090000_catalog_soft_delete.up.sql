@@ -0,0 +1,17 @@
+-- Add up migration script here
+-- Deleted catalog rows stay in the trash until the purge job removes them
+ALTER TABLE products
+    ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN deleted_by UUID REFERENCES users(id) ON DELETE SET NULL;
+
+ALTER TABLE brands
+    ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN deleted_by UUID REFERENCES users(id) ON DELETE SET NULL;
+
+ALTER TABLE categories
+    ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN deleted_by UUID REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_products_deleted_at ON products (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_brands_deleted_at ON brands (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX idx_categories_deleted_at ON categories (deleted_at) WHERE deleted_at IS NOT NULL;
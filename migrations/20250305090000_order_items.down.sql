@@ -0,0 +1,10 @@
+-- Add down migration script here
+ALTER TABLE cart_items ADD COLUMN location_id UUID REFERENCES merchant_locations(id) ON DELETE SET NULL;
+
+UPDATE cart_items ci
+SET location_id = oi.location_id, status = oi.status
+FROM order_items oi
+JOIN orders o ON o.id = oi.order_id
+WHERE ci.cart_id = o.cart_id AND ci.product_id = oi.product_id;
+
+DROP TABLE order_items;
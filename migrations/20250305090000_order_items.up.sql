@@ -0,0 +1,43 @@
+-- Add up migration script here
+-- Order lines keep what was bought as it was at checkout, independent of
+-- later changes to (or removal of) the product
+CREATE TABLE order_items (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    product_id UUID REFERENCES products(id) ON DELETE SET NULL,
+    product_type VARCHAR(20) NOT NULL DEFAULT 'physical',
+    name VARCHAR(255) NOT NULL,
+    sku VARCHAR(255) NOT NULL,
+    image_url TEXT,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    purchase_price NUMERIC(10, 2) NOT NULL, -- unit price charged
+    tax_rate NUMERIC(5, 2) NOT NULL DEFAULT 0, -- percent
+    merchant_id UUID REFERENCES merchants(id) ON DELETE SET NULL,
+    merchant_name VARCHAR(255) NOT NULL DEFAULT '',
+    brand_id UUID REFERENCES brands(id) ON DELETE SET NULL,
+    brand_name VARCHAR(255),
+    status VARCHAR(255) NOT NULL DEFAULT 'Not_processed',
+    location_id UUID REFERENCES merchant_locations(id) ON DELETE SET NULL,
+    updated TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_order_items_order ON order_items (order_id);
+CREATE INDEX idx_order_items_product ON order_items (product_id);
+CREATE INDEX idx_order_items_merchant ON order_items (merchant_id);
+
+-- Existing orders get their lines from the cart as the products look today.
+-- The cart item id is kept so item ids handed out before stay valid.
+INSERT INTO order_items (id, order_id, product_id, product_type, name, sku, image_url, quantity, purchase_price,
+    tax_rate, merchant_id, merchant_name, brand_id, brand_name, status, location_id, updated, created)
+SELECT ci.id, o.id, p.id, p.product_type, p.name, p.sku, p.image_url, ci.quantity, ci.purchase_price,
+    0, p.merchant_id, COALESCE(m.name, ''), p.brand_id, b.name,
+    CASE WHEN ci.status = 'Not_ordered' THEN 'Not_processed' ELSE ci.status END,
+    ci.location_id, ci.updated, ci.created
+FROM orders o
+JOIN cart_items ci ON ci.cart_id = o.cart_id
+JOIN products p ON p.id = ci.product_id
+LEFT JOIN merchants m ON m.id = p.merchant_id
+LEFT JOIN brands b ON b.id = p.brand_id;
+
+ALTER TABLE cart_items DROP COLUMN location_id;
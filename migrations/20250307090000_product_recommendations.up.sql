@@ -0,0 +1,24 @@
+-- Add up migration script here
+-- Product page views, by signed in user or by anonymous visitor
+CREATE TABLE product_views (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    visitor_id UUID, -- id the client keeps for anonymous visitors
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CHECK (user_id IS NOT NULL OR visitor_id IS NOT NULL)
+);
+
+CREATE INDEX idx_product_views_created ON product_views (created);
+CREATE INDEX idx_product_views_user ON product_views (user_id, created) WHERE user_id IS NOT NULL;
+CREATE INDEX idx_product_views_visitor ON product_views (visitor_id, created) WHERE visitor_id IS NOT NULL;
+
+-- Precomputed by the recommendations job, read by the product page
+CREATE TABLE product_recommendations (
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    related_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('bought_together', 'similar', 'also_viewed')),
+    score DOUBLE PRECISION NOT NULL,
+    updated TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (product_id, kind, related_id)
+);
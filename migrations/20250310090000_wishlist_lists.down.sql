@@ -0,0 +1,27 @@
+-- Add down migration script here
+DROP INDEX IF EXISTS idx_wishlist_items_product;
+
+ALTER TABLE wishlist_items
+    ADD COLUMN user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    ADD COLUMN is_liked BOOLEAN;
+
+UPDATE wishlist_items wi SET user_id = w.user_id, is_liked = TRUE FROM wishlists w WHERE w.id = wi.wishlist_id;
+
+-- A product can only be liked once per user
+DELETE FROM wishlist_items a USING wishlist_items b
+WHERE a.user_id = b.user_id AND a.product_id = b.product_id
+    AND (a.created, a.id) > (b.created, b.id);
+
+ALTER TABLE wishlist_items
+    DROP CONSTRAINT wishlist_items_wishlist_product_key,
+    DROP COLUMN wishlist_id,
+    DROP COLUMN notify_price_drop,
+    DROP COLUMN notify_back_in_stock,
+    DROP COLUMN price_at_add,
+    DROP COLUMN in_stock_at_add,
+    ALTER COLUMN user_id SET NOT NULL;
+
+DROP TABLE IF EXISTS wishlists;
+
+ALTER INDEX wishlist_items_pkey RENAME TO wishlists_pkey;
+ALTER TABLE wishlist_items RENAME TO wishlists;
@@ -0,0 +1,48 @@
+-- Add up migration script here
+-- The old wishlists table held one row per liked product; it becomes the
+-- items of named lists.
+ALTER TABLE wishlists RENAME TO wishlist_items;
+ALTER INDEX wishlists_pkey RENAME TO wishlist_items_pkey;
+
+CREATE TABLE wishlists (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    is_default BOOLEAN NOT NULL DEFAULT FALSE, -- receives items added without a list
+    share_token VARCHAR(64) UNIQUE, -- set while the list is shared publicly
+    updated TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_wishlists_user ON wishlists (user_id);
+CREATE UNIQUE INDEX idx_wishlists_default ON wishlists (user_id) WHERE is_default;
+
+-- Unliked rows were removals
+DELETE FROM wishlist_items WHERE is_liked IS NOT TRUE;
+
+INSERT INTO wishlists (user_id, name, is_default)
+SELECT DISTINCT user_id, 'My wishlist', TRUE FROM wishlist_items;
+
+ALTER TABLE wishlist_items
+    ADD COLUMN wishlist_id UUID REFERENCES wishlists(id) ON DELETE CASCADE,
+    ADD COLUMN notify_price_drop BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN notify_back_in_stock BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN price_at_add NUMERIC(10, 2), -- baseline for price drop alerts
+    ADD COLUMN in_stock_at_add BOOLEAN NOT NULL DEFAULT TRUE; -- baseline for back in stock alerts
+
+UPDATE wishlist_items wi SET wishlist_id = w.id FROM wishlists w WHERE w.user_id = wi.user_id;
+UPDATE wishlist_items wi SET price_at_add = p.price FROM products p WHERE p.id = wi.product_id;
+
+-- Keep the oldest row of products liked more than once
+DELETE FROM wishlist_items a USING wishlist_items b
+WHERE a.wishlist_id = b.wishlist_id AND a.product_id = b.product_id
+    AND (a.created, a.id) > (b.created, b.id);
+
+ALTER TABLE wishlist_items
+    ALTER COLUMN wishlist_id SET NOT NULL,
+    ALTER COLUMN price_at_add SET NOT NULL,
+    DROP COLUMN user_id,
+    DROP COLUMN is_liked,
+    ADD CONSTRAINT wishlist_items_wishlist_product_key UNIQUE (wishlist_id, product_id);
+
+CREATE INDEX idx_wishlist_items_product ON wishlist_items (product_id);
@@ -0,0 +1,4 @@
+-- Add down migration script here
+DROP TABLE IF EXISTS support_attachments;
+DROP TABLE IF EXISTS support_messages;
+DROP TABLE IF EXISTS support_tickets;
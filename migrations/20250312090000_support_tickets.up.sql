@@ -0,0 +1,46 @@
+-- Add up migration script here
+CREATE TABLE support_tickets (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL, -- empty for guests
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    order_id UUID REFERENCES orders(id) ON DELETE SET NULL,
+    product_id UUID REFERENCES products(id) ON DELETE SET NULL,
+    merchant_id UUID REFERENCES merchants(id) ON DELETE SET NULL, -- merchant that can answer the ticket
+    status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'pending', 'resolved', 'closed')),
+    assignee_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    access_token VARCHAR(64) NOT NULL UNIQUE, -- lets guests follow their ticket
+    client_ip VARCHAR(45) NOT NULL DEFAULT '', -- for rate limiting the contact form
+    last_message_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_support_tickets_user ON support_tickets (user_id);
+CREATE INDEX idx_support_tickets_merchant ON support_tickets (merchant_id, status);
+CREATE INDEX idx_support_tickets_status ON support_tickets (status, last_message_at DESC);
+CREATE INDEX idx_support_tickets_client_ip ON support_tickets (client_ip, created);
+
+CREATE TABLE support_messages (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    ticket_id UUID NOT NULL REFERENCES support_tickets(id) ON DELETE CASCADE,
+    author_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    author_role VARCHAR(20) NOT NULL CHECK (author_role IN ('customer', 'admin', 'merchant')),
+    body TEXT NOT NULL,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_support_messages_ticket ON support_messages (ticket_id, created);
+
+CREATE TABLE support_attachments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID NOT NULL REFERENCES support_messages(id) ON DELETE CASCADE,
+    file_key VARCHAR(255) NOT NULL,
+    file_name VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_support_attachments_message ON support_attachments (message_id);
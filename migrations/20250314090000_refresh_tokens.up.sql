@@ -0,0 +1,17 @@
+-- Add up migration script here
+CREATE TABLE refresh_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id UUID NOT NULL, -- tokens rotated from the same sign in
+    token_hash VARCHAR(64) NOT NULL UNIQUE, -- SHA-256 of the token, the token itself is never stored
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE, -- set once rotated or signed out
+    replaced_by UUID REFERENCES refresh_tokens(id) ON DELETE SET NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    client_ip VARCHAR(45) NOT NULL DEFAULT '',
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_refresh_tokens_user ON refresh_tokens (user_id) WHERE revoked_at IS NULL;
+CREATE INDEX idx_refresh_tokens_family ON refresh_tokens (family_id);
+CREATE INDEX idx_refresh_tokens_expires ON refresh_tokens (expires_at);
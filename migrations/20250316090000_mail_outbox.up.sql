@@ -0,0 +1,17 @@
+-- Add up migration script here
+CREATE TABLE mail_outbox (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    template VARCHAR(100) NOT NULL, -- kept for logs, the mail is rendered when queued
+    recipient VARCHAR(255) NOT NULL,
+    subject TEXT NOT NULL,
+    text_body TEXT NOT NULL,
+    html_body TEXT NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    send_after TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(), -- pushed back after each failed attempt
+    sent_at TIMESTAMP WITH TIME ZONE,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_mail_outbox_pending ON mail_outbox (send_after) WHERE sent_at IS NULL;
+CREATE INDEX idx_mail_outbox_sent ON mail_outbox (sent_at) WHERE sent_at IS NOT NULL;
@@ -0,0 +1,5 @@
+-- Add up migration script here
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP WITH TIME ZONE;
+
+-- Accounts from before verification existed keep working
+UPDATE users SET email_verified_at = NOW();
@@ -0,0 +1,9 @@
+-- Add down migration script here
+DROP TABLE IF EXISTS two_factor_policy;
+DROP TABLE IF EXISTS recovery_codes;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS totp_last_step,
+    DROP COLUMN IF EXISTS totp_enabled_at,
+    DROP COLUMN IF EXISTS totp_pending_secret,
+    DROP COLUMN IF EXISTS totp_secret;
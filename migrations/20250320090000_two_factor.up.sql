@@ -0,0 +1,24 @@
+-- Add up migration script here
+ALTER TABLE users
+    ADD COLUMN totp_secret TEXT, -- base32, set once enrollment is confirmed
+    ADD COLUMN totp_pending_secret TEXT, -- being enrolled
+    ADD COLUMN totp_enabled_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0; -- codes of earlier steps are refused
+
+CREATE TABLE recovery_codes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL, -- SHA-256 of the normalized code
+    used_at TIMESTAMP WITH TIME ZONE,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (user_id, code_hash)
+);
+
+-- Roles whose users must use two-factor authentication
+CREATE TABLE two_factor_policy (
+    role VARCHAR(50) PRIMARY KEY,
+    required BOOLEAN NOT NULL DEFAULT FALSE,
+    updated TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+INSERT INTO two_factor_policy (role) VALUES ('ROLE ADMIN'), ('ROLE MERCHANT');
@@ -0,0 +1,3 @@
+-- Add down migration script here
+DROP TABLE IF EXISTS login_attempts;
+DROP TABLE IF EXISTS login_throttle;
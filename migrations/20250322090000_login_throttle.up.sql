@@ -0,0 +1,25 @@
+-- Add up migration script here
+CREATE TABLE login_throttle (
+    key VARCHAR(320) PRIMARY KEY, -- account:<email> or ip:<address>
+    failures INT NOT NULL DEFAULT 0,
+    last_failure TIMESTAMP WITH TIME ZONE NOT NULL,
+    locked_until TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_login_throttle_last_failure ON login_throttle (last_failure);
+
+-- Every sign in attempt, kept for auditing
+CREATE TABLE login_attempts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    email VARCHAR(255) NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL, -- empty for unknown emails
+    client_ip VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    success BOOLEAN NOT NULL,
+    reason VARCHAR(50) NOT NULL DEFAULT '', -- why it failed, or the step that follows
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_login_attempts_email ON login_attempts (email, created DESC);
+CREATE INDEX idx_login_attempts_ip ON login_attempts (client_ip, created DESC);
+CREATE INDEX idx_login_attempts_created ON login_attempts (created);
@@ -0,0 +1,4 @@
+-- Add down migration script here
+DROP TABLE IF EXISTS phone_codes;
+DROP INDEX IF EXISTS idx_users_verified_phone;
+ALTER TABLE users DROP COLUMN IF EXISTS phone_verified_at;
@@ -0,0 +1,21 @@
+-- Add up migration script here
+ALTER TABLE users ADD COLUMN phone_verified_at TIMESTAMP WITH TIME ZONE;
+
+-- Only verified numbers sign in, so only they have to be unique
+CREATE UNIQUE INDEX idx_users_verified_phone ON users (phone_number) WHERE phone_verified_at IS NOT NULL;
+
+CREATE TABLE phone_codes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    phone VARCHAR(20) NOT NULL, -- E.164
+    purpose VARCHAR(20) NOT NULL, -- login or link
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE, -- the account a link code is for
+    code_hash VARCHAR(255) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    client_ip VARCHAR(45) NOT NULL DEFAULT '',
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    consumed_at TIMESTAMP WITH TIME ZONE,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_phone_codes_phone ON phone_codes (phone, purpose, created DESC);
+CREATE INDEX idx_phone_codes_ip ON phone_codes (client_ip, created);
@@ -0,0 +1,3 @@
+-- Add down migration script here
+DROP TABLE IF EXISTS oauth_states;
+DROP TABLE IF EXISTS user_identities;
@@ -0,0 +1,33 @@
+-- Add up migration script here
+-- Accounts at external identity providers users sign in with
+CREATE TABLE user_identities (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL, -- name in the oauth registry
+    subject VARCHAR(255) NOT NULL, -- ID of the user at the provider
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE (provider, subject),
+    UNIQUE (user_id, provider)
+);
+
+INSERT INTO user_identities (user_id, provider, subject, email)
+SELECT id, 'google', google_id, email FROM users WHERE google_id IS NOT NULL AND google_id <> ''
+ON CONFLICT DO NOTHING;
+
+INSERT INTO user_identities (user_id, provider, subject, email)
+SELECT id, 'facebook', facebook_id, email FROM users WHERE facebook_id IS NOT NULL AND facebook_id <> ''
+ON CONFLICT DO NOTHING;
+
+-- Sign ins waiting for the provider to redirect back
+CREATE TABLE oauth_states (
+    state_hash VARCHAR(64) PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    nonce VARCHAR(64) NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE, -- set when linking to a signed in user
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_oauth_states_expires_at ON oauth_states (expires_at);
@@ -0,0 +1,12 @@
+-- Add down migration script here
+DROP TABLE IF EXISTS merchant_staff;
+
+ALTER TABLE two_factor_policy DROP CONSTRAINT IF EXISTS two_factor_policy_role_fkey;
+
+CREATE TYPE user_role AS ENUM ('ROLE ADMIN', 'ROLE MERCHANT', 'ROLE USER');
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_fkey;
+UPDATE users SET role = 'ROLE USER' WHERE role NOT IN ('ROLE ADMIN', 'ROLE MERCHANT', 'ROLE USER');
+ALTER TABLE users ALTER COLUMN role TYPE user_role USING role::user_role;
+
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
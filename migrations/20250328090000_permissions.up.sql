@@ -0,0 +1,58 @@
+-- Add up migration script here
+CREATE TABLE roles (
+    name VARCHAR(50) PRIMARY KEY,
+    description TEXT NOT NULL DEFAULT '',
+    -- global roles are given to users, merchant roles to the staff of a merchant
+    scope VARCHAR(20) NOT NULL DEFAULT 'global' CHECK (scope IN ('global', 'merchant')),
+    built_in BOOLEAN NOT NULL DEFAULT FALSE,
+    updated TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE role_permissions (
+    role VARCHAR(50) NOT NULL REFERENCES roles(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    permission VARCHAR(100) NOT NULL,
+    PRIMARY KEY (role, permission)
+);
+
+INSERT INTO roles (name, description, scope, built_in) VALUES
+    ('ROLE ADMIN', 'Administrators', 'global', TRUE),
+    ('ROLE MERCHANT', 'Owners of a merchant', 'global', TRUE),
+    ('ROLE USER', 'Customers', 'global', TRUE),
+    ('MERCHANT STAFF', 'Staff of a merchant, managing its catalog and orders', 'merchant', TRUE);
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('ROLE ADMIN', '*'),
+    ('ROLE MERCHANT', 'product:write:own'),
+    ('ROLE MERCHANT', 'inventory:write:own'),
+    ('ROLE MERCHANT', 'order:read:own'),
+    ('ROLE MERCHANT', 'order:fulfill:own'),
+    ('ROLE MERCHANT', 'review:moderate:own'),
+    ('ROLE MERCHANT', 'support:reply:own'),
+    ('ROLE MERCHANT', 'merchant:manage:own'),
+    ('ROLE MERCHANT', 'staff:manage:own'),
+    ('ROLE MERCHANT', 'brand:write'),
+    ('MERCHANT STAFF', 'product:write:own'),
+    ('MERCHANT STAFF', 'inventory:write:own'),
+    ('MERCHANT STAFF', 'order:read:own'),
+    ('MERCHANT STAFF', 'order:fulfill:own'),
+    ('MERCHANT STAFF', 'support:reply:own');
+
+-- Roles are rows now, the enum would need a migration for each new role
+ALTER TABLE users ALTER COLUMN role TYPE VARCHAR(50) USING role::text;
+ALTER TABLE users ADD CONSTRAINT users_role_fkey FOREIGN KEY (role) REFERENCES roles(name) ON UPDATE CASCADE;
+DROP TYPE user_role;
+
+DELETE FROM two_factor_policy WHERE role NOT IN (SELECT name FROM roles);
+ALTER TABLE two_factor_policy ADD CONSTRAINT two_factor_policy_role_fkey FOREIGN KEY (role) REFERENCES roles(name) ON UPDATE CASCADE ON DELETE CASCADE;
+
+-- Users working for a merchant they do not own. Owners are merchants.user_id.
+CREATE TABLE merchant_staff (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    merchant_id UUID NOT NULL REFERENCES merchants(id) ON DELETE CASCADE,
+    role VARCHAR(50) NOT NULL REFERENCES roles(name) ON UPDATE CASCADE,
+    added_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_merchant_staff_merchant ON merchant_staff(merchant_id);
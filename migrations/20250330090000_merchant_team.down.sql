@@ -0,0 +1,19 @@
+-- Add down migration script here
+DROP TABLE IF EXISTS merchant_invites;
+
+DELETE FROM merchant_staff WHERE role = 'MERCHANT OWNER';
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('ROLE MERCHANT', 'product:write:own'),
+    ('ROLE MERCHANT', 'inventory:write:own'),
+    ('ROLE MERCHANT', 'order:read:own'),
+    ('ROLE MERCHANT', 'order:fulfill:own'),
+    ('ROLE MERCHANT', 'review:moderate:own'),
+    ('ROLE MERCHANT', 'support:reply:own'),
+    ('ROLE MERCHANT', 'merchant:manage:own'),
+    ('ROLE MERCHANT', 'staff:manage:own')
+ON CONFLICT DO NOTHING;
+
+-- Members keep working for their merchant with the staff role
+UPDATE merchant_staff SET role = 'MERCHANT STAFF' WHERE role IN ('MERCHANT MANAGER', 'CATALOG EDITOR', 'FULFILLMENT');
+DELETE FROM roles WHERE name IN ('MERCHANT OWNER', 'MERCHANT MANAGER', 'CATALOG EDITOR', 'FULFILLMENT');
@@ -0,0 +1,60 @@
+-- Add up migration script here
+INSERT INTO roles (name, description, scope, built_in) VALUES
+    ('MERCHANT OWNER', 'Owner of a merchant, given by transferring the ownership', 'merchant', TRUE),
+    ('MERCHANT MANAGER', 'Manages the catalog, orders, reviews, support and team of a merchant', 'merchant', TRUE),
+    ('CATALOG EDITOR', 'Manages the products and stock of a merchant', 'merchant', TRUE),
+    ('FULFILLMENT', 'Handles the orders and support tickets of a merchant', 'merchant', TRUE);
+
+-- What the owner may do follows their merchant role now; ROLE MERCHANT only
+-- keeps the permissions that are not about their merchant
+DELETE FROM role_permissions WHERE role = 'ROLE MERCHANT' AND permission LIKE '%:own';
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('MERCHANT OWNER', 'product:write:own'),
+    ('MERCHANT OWNER', 'inventory:write:own'),
+    ('MERCHANT OWNER', 'order:read:own'),
+    ('MERCHANT OWNER', 'order:fulfill:own'),
+    ('MERCHANT OWNER', 'review:moderate:own'),
+    ('MERCHANT OWNER', 'support:reply:own'),
+    ('MERCHANT OWNER', 'merchant:manage:own'),
+    ('MERCHANT OWNER', 'staff:manage:own'),
+    ('MERCHANT MANAGER', 'product:write:own'),
+    ('MERCHANT MANAGER', 'inventory:write:own'),
+    ('MERCHANT MANAGER', 'order:read:own'),
+    ('MERCHANT MANAGER', 'order:fulfill:own'),
+    ('MERCHANT MANAGER', 'review:moderate:own'),
+    ('MERCHANT MANAGER', 'support:reply:own'),
+    ('MERCHANT MANAGER', 'staff:manage:own'),
+    ('CATALOG EDITOR', 'product:write:own'),
+    ('CATALOG EDITOR', 'inventory:write:own'),
+    ('FULFILLMENT', 'order:read:own'),
+    ('FULFILLMENT', 'order:fulfill:own'),
+    ('FULFILLMENT', 'support:reply:own');
+
+-- The owners of approved merchants are members too. merchants.user_id stays
+-- the owner and is changed with the membership on transfers.
+INSERT INTO merchant_staff (user_id, merchant_id, role)
+SELECT m.user_id, m.id, 'MERCHANT OWNER'
+FROM merchants m
+JOIN users u ON u.id = m.user_id
+WHERE u.role = 'ROLE MERCHANT'
+ON CONFLICT (user_id) DO NOTHING;
+
+-- Invitations to join a merchant, accepted by the user with the email
+CREATE TABLE merchant_invites (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    merchant_id UUID NOT NULL REFERENCES merchants(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(50) NOT NULL REFERENCES roles(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    invited_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    accepted_at TIMESTAMP WITH TIME ZONE,
+    accepted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- One pending invite per email and merchant
+CREATE UNIQUE INDEX idx_merchant_invites_pending ON merchant_invites(merchant_id, LOWER(email))
+    WHERE accepted_at IS NULL AND revoked_at IS NULL;
@@ -0,0 +1,5 @@
+-- Add down migration script here
+DELETE FROM product_images WHERE staged;
+DELETE FROM product_files WHERE staged;
+ALTER TABLE product_images DROP COLUMN IF EXISTS staged;
+ALTER TABLE product_files DROP COLUMN IF EXISTS staged;
@@ -0,0 +1,4 @@
+-- Add up migration script here
+-- Uploads to a published product wait for review before buyers see them
+ALTER TABLE product_images ADD COLUMN staged BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE product_files ADD COLUMN staged BOOLEAN NOT NULL DEFAULT FALSE;
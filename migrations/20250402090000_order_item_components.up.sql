@@ -0,0 +1,22 @@
+-- Add up migration script here
+-- Bundle lines keep the components they were sold with, later edits to the
+-- bundle do not change what is shipped or restocked
+CREATE TABLE order_item_components (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_item_id UUID NOT NULL REFERENCES order_items(id) ON DELETE CASCADE,
+    component_id UUID REFERENCES products(id) ON DELETE SET NULL,
+    name VARCHAR(255) NOT NULL,
+    sku VARCHAR(255) NOT NULL,
+    quantity INT NOT NULL CHECK (quantity > 0), -- per bundle
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_order_item_components_item ON order_item_components (order_item_id);
+
+-- Existing bundle lines get the components the bundles have today
+INSERT INTO order_item_components (order_item_id, component_id, name, sku, quantity)
+SELECT oi.id, p.id, p.name, p.sku, bc.quantity
+FROM order_items oi
+JOIN bundle_components bc ON bc.bundle_id = oi.product_id
+JOIN products p ON p.id = bc.component_id
+WHERE oi.product_type = 'bundle';
@@ -0,0 +1,11 @@
+-- Add up migration script here
+-- One-time codes the client exchanges for tokens after a provider sign in,
+-- so the tokens never show up in a URL
+CREATE TABLE oauth_login_codes (
+    code_hash VARCHAR(64) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_oauth_login_codes_expires_at ON oauth_login_codes (expires_at);
@@ -0,0 +1,10 @@
+-- Add up migration script here
+-- Unlock links that were already used, kept until they expire so each one
+-- lifts a lock only once
+CREATE TABLE used_unlock_links (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_used_unlock_links_expires_at ON used_unlock_links (expires_at);
@@ -0,0 +1,14 @@
+-- Add up migration script here
+-- Identities a linking flow brought back, until the signed in client claims
+-- them with the one-time code
+CREATE TABLE oauth_link_codes (
+    code_hash VARCHAR(64) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider VARCHAR(50) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL DEFAULT '',
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX idx_oauth_link_codes_expires_at ON oauth_link_codes (expires_at);
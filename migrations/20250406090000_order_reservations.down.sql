@@ -0,0 +1,3 @@
+-- Add down migration script here
+DROP INDEX IF EXISTS idx_orders_reserved_until;
+ALTER TABLE orders DROP COLUMN IF EXISTS reserved_until;
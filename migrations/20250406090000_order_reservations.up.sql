@@ -0,0 +1,9 @@
+-- Add up migration script here
+-- Stock reserved for an unpaid order is given back after this
+ALTER TABLE orders ADD COLUMN reserved_until TIMESTAMP WITH TIME ZONE;
+
+-- Payments of open orders may still be in flight
+UPDATE orders o SET reserved_until = NOW() + INTERVAL '30 minutes'
+WHERE NOT EXISTS (SELECT 1 FROM receipts r WHERE r.order_id = o.id AND r.payment_status = 'captured');
+
+CREATE INDEX idx_orders_reserved_until ON orders (reserved_until) WHERE reserved_until IS NOT NULL;
@@ -3,6 +3,11 @@ package conf
 import (
 	"database/sql"
 	"src/pkg/env"
+	"src/pkg/jwtkeys"
+	"src/pkg/oauth"
+	"src/pkg/permission"
+	"src/pkg/sms"
+	"src/pkg/throttle"
 	"time"
 	// "go.mongodb.org/mongo-driver/mongo"
 )
@@ -23,6 +28,11 @@ type Config struct {
 	// ReceiptCollection  *mongo.Collection
 	Env           *env.Env
 	TokenLifetime time.Duration
+	Keys          *jwtkeys.KeySet // nil signs tokens HS256 with SECRET_JWT
+	LoginGuard    *throttle.Guard
+	SMS           sms.Sender
+	OAuth         *oauth.Registry
+	Permissions   *permission.Store
 	// MongoClient        *mongo.Client
 	DB *sql.DB
 }
@@ -55,22 +55,48 @@ package env
 // }
 
 import (
+	"time"
+
 	"github.com/kelseyhightower/envconfig"
 )
 
 type Env struct {
-	DBName             string `envconfig:"DB_NAME" required:"true"`
-	DBUri              string `envconfig:"DB_URI" required:"true"`
-	SecretJWT          string `envconfig:"SECRET_JWT" required:"true"`
-	GoogleClientID     string `envconfig:"GOOGLE_CLIENT_ID" required:"true"`
-	GoogleClientSecret string `envconfig:"GOOGLE_CLIENT_SECRET" required:"true"`
-	GoogleRedirectURL  string `envconfig:"GOOGLE_REDIRECT_URL" required:"true"`
-	ClientURL          string `envconfig:"CLIENT_URL" required:"true"`
-	AWSAccessKeyID     string `envconfig:"AWS_ACCESS_KEY_ID" required:"true"`
-	AWSSecretAccessKey string `envconfig:"AWS_SECRET_ACCESS_KEY" required:"true"`
-	AWSRegion          string `envconfig:"AWS_REGION" required:"true"`
-	AWSBucketName      string `envconfig:"AWS_BUCKET_NAME" required:"true"`
-	AWSEndpoint        string `envconfig:"AWS_ENDPOINT" required:"true"`
+	DBName             string   `envconfig:"DB_NAME" required:"true"`
+	DBUri              string   `envconfig:"DB_URI" required:"true"`
+	SecretJWT          string   `envconfig:"SECRET_JWT"` // HS256 secret, only used without JWT_KEYS_DIR
+	GoogleClientID     string   `envconfig:"GOOGLE_CLIENT_ID" required:"true"`
+	GoogleClientSecret string   `envconfig:"GOOGLE_CLIENT_SECRET" required:"true"`
+	GoogleRedirectURL  string   `envconfig:"GOOGLE_REDIRECT_URL" required:"true"`
+	OAuthProviders     []string `envconfig:"OAUTH_PROVIDERS"` // more sign in providers, each configured with OAUTH_<NAME>_*, see oauth
+	ClientURL          string   `envconfig:"CLIENT_URL" required:"true"`
+	TrustedProxies     []string `envconfig:"TRUSTED_PROXIES"` // addresses or CIDRs of the proxies whose X-Forwarded-For is believed; empty trusts none
+	AWSAccessKeyID     string   `envconfig:"AWS_ACCESS_KEY_ID" required:"true"`
+	AWSSecretAccessKey string   `envconfig:"AWS_SECRET_ACCESS_KEY" required:"true"`
+	AWSRegion          string   `envconfig:"AWS_REGION" required:"true"`
+	AWSBucketName      string   `envconfig:"AWS_BUCKET_NAME" required:"true"`
+	AWSEndpoint        string   `envconfig:"AWS_ENDPOINT" required:"true"`
+	TrashRetentionDays int      `envconfig:"TRASH_RETENTION_DAYS" default:"30"` // deleted catalog rows are purged after this
+	TaxRate            float64  `envconfig:"TAX_RATE" default:"0"`              // percent charged on taxable products
+
+	AccessTokenTTL  time.Duration `envconfig:"ACCESS_TOKEN_TTL" default:"15m"`   // lifetime of the JWTs sent with each request
+	RefreshTokenTTL time.Duration `envconfig:"REFRESH_TOKEN_TTL" default:"720h"` // how long a session lasts without being used
+
+	JWTKeysDir     string        `envconfig:"JWT_KEYS_DIR"`                      // signing keys, see jwtkeys; empty signs with SECRET_JWT
+	JWTAlgorithm   string        `envconfig:"JWT_ALGORITHM" default:"EdDSA"`     // RS256 or EdDSA, for generated keys
+	JWTKeyRotation time.Duration `envconfig:"JWT_KEY_ROTATION" default:"720h"`   // 0 disables rotation
+	TOTPIssuer     string        `envconfig:"TOTP_ISSUER" default:"Store"`       // name shown in authenticator apps
+	LoginThrottle  string        `envconfig:"LOGIN_THROTTLE" default:"postgres"` // postgres, or memory for a single instance
+
+	MailDriver   string `envconfig:"MAIL_DRIVER" default:"log"` // smtp, file or log
+	MailFrom     string `envconfig:"MAIL_FROM" default:"no-reply@localhost"`
+	MailDir      string `envconfig:"MAIL_DIR" default:"tmp/mail"` // where the file driver writes .eml files
+	SMTPHost     string `envconfig:"SMTP_HOST"`
+	SMTPPort     int    `envconfig:"SMTP_PORT" default:"587"`
+	SMTPUsername string `envconfig:"SMTP_USERNAME"`
+	SMTPPassword string `envconfig:"SMTP_PASSWORD"`
+
+	SMSDriver      string `envconfig:"SMS_DRIVER" default:"log"`      // only log for now
+	SMSCountryCode string `envconfig:"SMS_COUNTRY_CODE" default:"91"` // for phone numbers entered without one
 }
 
 func GetEnv() (*Env, error) {
@@ -0,0 +1,27 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"src/l"
+)
+
+// Every runs fn in the background right away and then once per interval for
+// as long as the process lives. Errors are logged and the next run goes ahead
+// as scheduled.
+func Every(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := fn(ctx); err != nil {
+				l.ErrorF("Job %s failed: %v", name, err)
+			}
+			cancel()
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,292 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// Algorithms keys can be generated for.
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+const (
+	rsaKeyBits    = 2048
+	privateSuffix = ".pem"     // <kid>.pem holds a PKCS#8 (or PKCS#1 RSA) private key
+	publicSuffix  = ".pub.pem" // <kid>.pub.pem holds a PKIX public key, used for verification only
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// Key is a JWT signing or verification key. The file name is its kid.
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.PrivateKey // nil for verification only keys
+	Public  crypto.PublicKey
+	Created time.Time // modification time of the key file
+}
+
+// KeySet is the set of keys found in a directory. Tokens are signed with the
+// newest private key and verified with any key of the set, so keys can be
+// added and retired without signing anyone out.
+type KeySet struct {
+	dir string
+	alg string // algorithm of generated keys
+
+	mu   sync.RWMutex
+	keys []*Key // oldest first
+}
+
+// Load reads the keys in dir, generating a first key with alg when there is
+// no private key yet.
+func Load(dir, alg string) (*KeySet, error) {
+	if alg != AlgRS256 && alg != AlgEdDSA {
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", alg)
+	}
+	s := &KeySet{dir: dir, alg: alg}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	if _, ok := s.signingKey(); !ok {
+		if err := s.generate(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Reload reads the key directory again, picking up keys written by other
+// instances or by hand.
+func (s *KeySet) Reload() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[string]*Key)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, privateSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, name))
+		if err != nil {
+			return err
+		}
+
+		var key *Key
+		id, public := strings.CutSuffix(name, publicSuffix)
+		if public {
+			key, err = parsePublicKey(data)
+		} else {
+			id = strings.TrimSuffix(name, privateSuffix)
+			key, err = parsePrivateKey(data)
+		}
+		if err != nil {
+			return fmt.Errorf("key %s: %w", name, err)
+		}
+		if existing, ok := byID[id]; ok && existing.Private != nil {
+			continue // the private key already provides the public one
+		}
+		key.ID = id
+		key.Created = info.ModTime()
+		byID[id] = key
+	}
+
+	keys := make([]*Key, 0, len(byID))
+	for _, key := range byID {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].Created.Equal(keys[j].Created) {
+			return keys[i].Created.Before(keys[j].Created)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+// RotateIfDue reloads the keys and generates a new signing key once the
+// current one is older than every. Superseded private keys are deleted when
+// no token signed with them can still be valid, retire after the next key
+// took over. every = 0 disables rotation.
+func (s *KeySet) RotateIfDue(every, retire time.Duration) error {
+	if err := s.Reload(); err != nil {
+		return err
+	}
+	if current, ok := s.signingKey(); every > 0 && (!ok || time.Since(current.Created) >= every) {
+		if err := s.generate(); err != nil {
+			return err
+		}
+	}
+	return s.prune(retire)
+}
+
+// Sign signs the claims with the current signing key.
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key, ok := s.signingKey()
+	if !ok {
+		return "", ErrUnknownKey
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
+}
+
+// Keyfunc returns the key to verify the token with, picked by its kid. The
+// token must use the algorithm of that key.
+func (s *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.keys {
+		if key.ID == kid {
+			if token.Method.Alg() != key.Method.Alg() {
+				return nil, fmt.Errorf("key %s does not sign %s tokens", kid, token.Method.Alg())
+			}
+			return key.Public, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+func (s *KeySet) signingKey() (*Key, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		if s.keys[i].Private != nil {
+			return s.keys[i], true
+		}
+	}
+	return nil, false
+}
+
+// generate writes a new private key, which becomes the signing key.
+func (s *KeySet) generate() error {
+	var private crypto.PrivateKey
+	var err error
+	switch s.alg {
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	id := time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix)
+
+	// Write then rename so other instances never read half a key
+	path := filepath.Join(s.dir, id+privateSuffix)
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	return s.Reload()
+}
+
+// prune deletes the private keys that were superseded more than retire ago.
+// Verification only keys are managed by hand and kept.
+func (s *KeySet) prune(retire time.Duration) error {
+	s.mu.RLock()
+	var private []*Key
+	for _, key := range s.keys {
+		if key.Private != nil {
+			private = append(private, key)
+		}
+	}
+	s.mu.RUnlock()
+
+	removed := false
+	for i := 0; i < len(private)-1; i++ {
+		if time.Since(private[i+1].Created) < retire {
+			continue
+		}
+		err := os.Remove(filepath.Join(s.dir, private[i].ID+privateSuffix))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		removed = true
+	}
+	if removed {
+		return s.Reload()
+	}
+	return nil
+}
+
+func parsePrivateKey(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if private, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, err
+		}
+	}
+
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		return &Key{Method: jwt.SigningMethodRS256, Private: k, Public: &k.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &Key{Method: jwt.SigningMethodEdDSA, Private: k, Public: k.Public()}, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", private)
+}
+
+func parsePublicKey(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+	public, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return &Key{Method: jwt.SigningMethodRS256, Public: k}, nil
+	case ed25519.PublicKey:
+		return &Key{Method: jwt.SigningMethodEdDSA, Public: k}, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", public)
+}
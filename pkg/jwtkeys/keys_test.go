@@ -0,0 +1,81 @@
+package jwtkeys
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	for _, alg := range []string{AlgRS256, AlgEdDSA} {
+		s, err := Load(t.TempDir(), alg)
+		if err != nil {
+			t.Fatalf("%s: Load: %v", alg, err)
+		}
+
+		signed, err := s.Sign(&jwt.StandardClaims{Subject: "user"})
+		if err != nil {
+			t.Fatalf("%s: Sign: %v", alg, err)
+		}
+		token, err := jwt.ParseWithClaims(signed, &jwt.StandardClaims{}, s.Keyfunc)
+		if err != nil || !token.Valid {
+			t.Fatalf("%s: token does not verify: %v", alg, err)
+		}
+		if token.Method.Alg() != alg {
+			t.Errorf("%s: token signed with %s", alg, token.Method.Alg())
+		}
+
+		jwks := s.JWKS()
+		if len(jwks) != 1 || jwks[0].Kid != token.Header["kid"] || jwks[0].Alg != alg {
+			t.Errorf("%s: JWKS = %+v", alg, jwks)
+		}
+	}
+}
+
+func TestRotateIfDue(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Load(dir, AlgEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := s.Sign(&jwt.StandardClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RotateIfDue(time.Hour, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.JWKS()) != 1 {
+		t.Fatal("rotated a key that is not due")
+	}
+
+	// Age the first key so it is due and retired as soon as it is replaced
+	past := time.Now().Add(-2 * time.Hour)
+	first := s.keys[0].ID
+	if err := os.Chtimes(filepath.Join(dir, first+privateSuffix), past, past); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RotateIfDue(time.Hour, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.JWKS()) != 2 {
+		t.Fatalf("expected the old key to verify until retired, got %+v", s.JWKS())
+	}
+	if _, err := jwt.ParseWithClaims(old, &jwt.StandardClaims{}, s.Keyfunc); err != nil {
+		t.Errorf("token of the old key does not verify: %v", err)
+	}
+
+	if err := s.RotateIfDue(time.Hour, 0); err != nil {
+		t.Fatal(err)
+	}
+	if jwks := s.JWKS(); len(jwks) != 1 || jwks[0].Kid == first {
+		t.Errorf("expected the old key to be retired, got %+v", jwks)
+	}
+	if _, err := jwt.ParseWithClaims(old, &jwt.StandardClaims{}, s.Keyfunc); err == nil {
+		t.Error("token of a retired key still verifies")
+	}
+}
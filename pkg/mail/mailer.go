@@ -0,0 +1,163 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"src/l"
+	"src/pkg/env"
+)
+
+// Message is a rendered mail, sent as text with an HTML alternative.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers messages. Handlers never call it directly, they queue
+// mails with Enqueue and SendPending hands them to the mailer after commit.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Drivers selected with MAIL_DRIVER.
+const (
+	DriverSMTP = "smtp"
+	DriverFile = "file"
+	DriverLog  = "log"
+)
+
+// NewMailer returns the mailer configured in the environment.
+func NewMailer(e *env.Env) (Mailer, error) {
+	switch e.MailDriver {
+	case DriverSMTP:
+		if e.SMTPHost == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required with MAIL_DRIVER=%s", DriverSMTP)
+		}
+		return &SMTPMailer{Host: e.SMTPHost, Port: e.SMTPPort, Username: e.SMTPUsername, Password: e.SMTPPassword, From: e.MailFrom}, nil
+	case DriverFile:
+		if err := os.MkdirAll(e.MailDir, 0o755); err != nil {
+			return nil, err
+		}
+		return &FileMailer{Dir: e.MailDir, From: e.MailFrom}, nil
+	case DriverLog:
+		return LogMailer{}, nil
+	}
+	return nil, fmt.Errorf("unknown MAIL_DRIVER %q", e.MailDriver)
+}
+
+// SMTPMailer sends through an SMTP server, authenticating when a username is
+// set. STARTTLS is used whenever the server offers it.
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	data, err := msg.bytes(m.From)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	from, err := netmail.ParseAddress(m.From)
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	return smtp.SendMail(addr, auth, from.Address, []string{msg.To}, data)
+}
+
+// FileMailer writes every message to an .eml file in Dir, to read the mails
+// sent by a local setup.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	data, err := msg.bytes(m.From)
+	if err != nil {
+		return err
+	}
+	name := time.Now().UTC().Format("20060102T150405.000000000") + ".eml"
+	return os.WriteFile(filepath.Join(m.Dir, name), data, 0o644)
+}
+
+// LogMailer only logs the text part of the messages.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	l.InfoF("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+	return nil
+}
+
+// bytes encodes the message as a multipart/alternative MIME message.
+func (msg Message) bytes(from string) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := "localhost"
+	if addr, err := netmail.ParseAddress(from); err == nil {
+		domain = addr.Address[strings.LastIndex(addr.Address, "@")+1:]
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "From: %s\r\n", from)
+	fmt.Fprintf(&out, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&out, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&out, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&out, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	fmt.Fprintf(&out, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&out, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", parts.Boundary())
+	out.Write(body.Bytes())
+	return out.Bytes(), nil
+}
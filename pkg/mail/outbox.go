@@ -0,0 +1,108 @@
+package mail
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+
+	"src/l"
+)
+
+// Mails are rendered and stored in the outbox by the transaction that causes
+// them, so they go out exactly when it commits. SendPending delivers them in
+// the background and retries failures with a growing delay.
+
+const (
+	maxAttempts = 8
+	sendBatch   = 50
+	retryDelay  = time.Minute     // doubled after every failed attempt
+	sendLease   = 5 * time.Minute // how long a claimed mail is left to its sender
+)
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Enqueue renders the template and queues the mail for delivery once db, a
+// transaction most of the time, commits.
+func Enqueue(ctx context.Context, db execer, name, to string, data any) error {
+	msg, err := Render(name, to, data)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO mail_outbox (template, recipient, subject, text_body, html_body)
+		VALUES ($1, $2, $3, $4, $5)
+	`, name, msg.To, msg.Subject, msg.Text, msg.HTML)
+	return err
+}
+
+// SendPending delivers the queued mails that are due. A batch is claimed by
+// leasing it for sendLease, so several instances can run it at once and a
+// sender that dies only delays its mails. No locks are held while sending.
+func SendPending(ctx context.Context, db *sql.DB, mailer Mailer) (int, error) {
+	rows, err := db.QueryContext(ctx, `
+		UPDATE mail_outbox SET attempts = attempts + 1, send_after = NOW() + $3 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM mail_outbox
+			WHERE sent_at IS NULL AND send_after <= NOW() AND attempts < $1
+			ORDER BY send_after
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, template, recipient, subject, text_body, html_body, attempts
+	`, maxAttempts, sendBatch, sendLease.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	type pending struct {
+		id       uuid.UUID
+		template string
+		attempts int // including this one
+		msg      Message
+	}
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.template, &p.msg.To, &p.msg.Subject, &p.msg.Text, &p.msg.HTML, &p.attempts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, p := range batch {
+		if sendErr := mailer.Send(ctx, p.msg); sendErr != nil {
+			l.ErrorF("Error sending %s mail %s: %v", p.template, p.id, sendErr)
+			_, err = db.ExecContext(ctx, `
+				UPDATE mail_outbox SET last_error = $2, send_after = NOW() + $3 * INTERVAL '1 second'
+				WHERE id = $1
+			`, p.id, sendErr.Error(), retryDelay.Seconds()*float64(int(1)<<(p.attempts-1)))
+		} else {
+			sent++
+			_, err = db.ExecContext(ctx, "UPDATE mail_outbox SET last_error = NULL, sent_at = NOW() WHERE id = $1", p.id)
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// PurgeSentMail deletes the mails sent before the given time.
+func PurgeSentMail(ctx context.Context, db *sql.DB, before time.Time) (int, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM mail_outbox WHERE sent_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
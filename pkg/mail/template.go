@@ -0,0 +1,111 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Templates, each with a <name>.txt defining the "subject" and the text part
+// and a <name>.html defining the "content" of layout.html.
+const (
+	TemplatePasswordReset     = "password_reset"
+	TemplateEmailVerification = "email_verification"
+	TemplateAccountLocked     = "account_locked"
+	TemplateMerchantInvite    = "merchant_invite"
+	TemplateOrderConfirmation = "order_confirmation"
+	TemplateOrderShipped      = "order_shipped"
+	TemplateOrderRefund       = "order_refund"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+var funcs = map[string]any{
+	"money": func(amount float64) string { return fmt.Sprintf("%.2f", amount) },
+	// shortID is how orders are referred to in mails
+	"shortID": func(id uuid.UUID) string { return strings.ToUpper(id.String()[:8]) },
+}
+
+type PasswordResetData struct {
+	Name      string
+	ResetURL  string
+	ExpiresAt time.Time
+}
+
+type EmailVerificationData struct {
+	Name      string
+	VerifyURL string
+	ExpiresAt time.Time
+}
+
+type AccountLockedData struct {
+	Name        string
+	LockedUntil time.Time
+	UnlockURL   string
+	ResetURL    string // to change a password that may have leaked
+}
+
+// MerchantInviteData invites someone to the team of a merchant. Name is empty
+// when the invitee has no account yet.
+type MerchantInviteData struct {
+	Name         string
+	InviterName  string
+	MerchantName string
+	Role         string
+	AcceptURL    string
+	ExpiresAt    time.Time
+}
+
+// OrderData is shared by the order mails. Items are the lines the mail is
+// about, Total their amount.
+type OrderData struct {
+	Name     string
+	OrderID  uuid.UUID
+	Items    []OrderLine
+	Total    float64
+	OrderURL string
+}
+
+type OrderLine struct {
+	Name     string
+	Quantity int
+	Amount   float64
+}
+
+// Render renders the template for the recipient.
+func Render(name, to string, data any) (Message, error) {
+	text, err := texttemplate.New(name+".txt").Funcs(funcs).ParseFS(templateFS, "templates/"+name+".txt")
+	if err != nil {
+		return Message{}, err
+	}
+	html, err := htmltemplate.New("layout.html").Funcs(funcs).ParseFS(templateFS, "templates/layout.html", "templates/"+name+".html")
+	if err != nil {
+		return Message{}, err
+	}
+
+	var subject, textBody, htmlBody bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := text.Execute(&textBody, data); err != nil {
+		return Message{}, err
+	}
+	if err := html.Execute(&htmlBody, data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		To: to,
+		// Names end up in subjects, keep them on a single header line
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Text:    strings.TrimSpace(textBody.String()) + "\n",
+		HTML:    htmlBody.String(),
+	}, nil
+}
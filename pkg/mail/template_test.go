@@ -0,0 +1,54 @@
+package mail
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRender(t *testing.T) {
+	order := OrderData{
+		Name:     "Ann",
+		OrderID:  uuid.MustParse("0a1b2c3d-0000-0000-0000-000000000000"),
+		Items:    []OrderLine{{Name: "Mug <large>", Quantity: 2, Amount: 19.5}},
+		Total:    19.5,
+		OrderURL: "https://shop.test/orders/1",
+	}
+	cases := map[string]any{
+		TemplatePasswordReset:     PasswordResetData{Name: "Ann", ResetURL: "https://shop.test/reset-password/abc", ExpiresAt: time.Now()},
+		TemplateEmailVerification: EmailVerificationData{Name: "Ann", VerifyURL: "https://shop.test/verify-email?token=abc", ExpiresAt: time.Now()},
+		TemplateAccountLocked:     AccountLockedData{Name: "Ann", LockedUntil: time.Now(), UnlockURL: "https://shop.test/unlock?token=abc", ResetURL: "https://shop.test/forgot-password"},
+		TemplateMerchantInvite:    MerchantInviteData{Name: "Ann", InviterName: "Bob", MerchantName: "Ann's\nShop", Role: "CATALOG EDITOR", AcceptURL: "https://shop.test/merchant/invite?token=abc", ExpiresAt: time.Now()},
+		TemplateOrderConfirmation: order,
+		TemplateOrderShipped:      order,
+		TemplateOrderRefund:       order,
+	}
+	for name, data := range cases {
+		msg, err := Render(name, "ann@example.com", data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if msg.Subject == "" || strings.ContainsAny(msg.Subject, "\r\n") {
+			t.Errorf("%s: subject %q", name, msg.Subject)
+		}
+		if !strings.Contains(msg.Text, "Hi Ann,") || !strings.Contains(msg.HTML, "Hi Ann,") {
+			t.Errorf("%s: greeting missing", name)
+		}
+	}
+
+	msg, err := Render(TemplateOrderConfirmation, "ann@example.com", order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(msg.Subject, "0A1B2C3D") {
+		t.Errorf("subject %q does not name the order", msg.Subject)
+	}
+	if !strings.Contains(msg.Text, "Mug <large> x 2: 19.50") {
+		t.Errorf("text part:\n%s", msg.Text)
+	}
+	if strings.Contains(msg.HTML, "<large>") || !strings.Contains(msg.HTML, "Mug &lt;large&gt;") {
+		t.Error("product names are not escaped in the HTML part")
+	}
+}
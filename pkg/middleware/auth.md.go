@@ -34,6 +34,7 @@ func AuthMiddleware(app *conf.Config) gin.HandlerFunc {
 		c.Set("firstname", claims.FirstName)
 		c.Set("lastname", claims.LastName)
 		c.Set("merchantID", claims.MerchantID)
+		c.Set("merchantRole", claims.MerchantRole)
 
 		c.Next()
 	}
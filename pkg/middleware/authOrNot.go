@@ -21,14 +21,35 @@ func AuthOrNotMiddleware(app *conf.Config) gin.HandlerFunc {
 				return
 			}
 
-			c.Set("userID", claims.Uid)
-			c.Set("role", claims.Role)
-			c.Set("email", claims.Email)
-			c.Set("firstname", claims.FirstName)
-			c.Set("lastname", claims.LastName)
-			c.Set("merchantID", claims.MerchantID)
+			setOptionalClaims(c, claims)
 		}
 
 		c.Next()
 	}
 }
+
+// OptionalAuthMiddleware identifies the user when the request carries a valid
+// token. Unlike AuthOrNotMiddleware it serves a bad or expired token as an
+// anonymous request, for public pages that only personalize.
+func OptionalAuthMiddleware(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if authHeader := c.GetHeader("Authorization"); authHeader != "" {
+			claims, err := ValidateToken(app, strings.TrimPrefix(authHeader, "Bearer "))
+			if err == nil {
+				setOptionalClaims(c, claims)
+			}
+		}
+
+		c.Next()
+	}
+}
+
+func setOptionalClaims(c *gin.Context, claims *SignedDetails) {
+	c.Set("userID", claims.Uid)
+	c.Set("role", claims.Role)
+	c.Set("email", claims.Email)
+	c.Set("firstname", claims.FirstName)
+	c.Set("lastname", claims.LastName)
+	c.Set("merchantID", claims.MerchantID)
+	c.Set("merchantRole", claims.MerchantRole)
+}
@@ -18,53 +18,69 @@ type SignedDetails struct {
 	Phone      string `json:"phone,omitempty"`
 	Role       any    `json:"role,omitempty"`
 	MerchantID string `json:"merchant_id,omitempty"`
+	// MerchantRole is the role of staff in their merchant, empty for owners
+	MerchantRole string `json:"merchant_role,omitempty"`
+	TokenType    string `json:"typ,omitempty"`
 	jwt.StandardClaims
 }
 
-func GenerateTokens(app *conf.Config, data SignedDetails) (token string, refreshToken string, err error) {
+// Token types. Access tokens authenticate requests, tokens of any other type
+// are refused by the auth middlewares.
+const (
+	TokenTypeAccess             = "access"
+	TokenTypeEmailVerification  = "email_verification"
+	TokenTypeTwoFactorChallenge = "2fa_challenge" // password checked, waiting for the second factor
+	TokenTypeTwoFactorSetup     = "2fa_setup"     // password checked, the role requires enrolling first
+	TokenTypeUnlock             = "unlock"        // mailed when an account gets locked
+	TokenTypePhoneSignup        = "phone_signup"  // phone verified, no account uses it yet
+)
+
+// GenerateAccessToken signs a short lived access token for the user. Refresh
+// tokens are opaque and stored server side, see the auth module.
+func GenerateAccessToken(app *conf.Config, data SignedDetails) (string, error) {
+	return GenerateToken(app, data, TokenTypeAccess, app.TokenLifetime)
+}
+
+// GenerateToken signs a token of the given type with the claims of data.
+func GenerateToken(app *conf.Config, data SignedDetails, tokenType string, lifetime time.Duration) (string, error) {
 	claims := SignedDetails{
-		Email:      data.Email,
-		FirstName:  data.FirstName,
-		LastName:   data.LastName,
-		Uid:        data.Uid,
-		Phone:      data.Phone,
-		Role:       data.Role,
-		MerchantID: data.MerchantID,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * app.TokenLifetime).Unix(),
-		},
-	}
-	refreshClaims := SignedDetails{
-		Email:      data.Email,
-		FirstName:  data.FirstName,
-		LastName:   data.LastName,
-		Uid:        data.Uid,
-		Phone:      data.Phone,
-		Role:       data.Role,
-		MerchantID: data.MerchantID,
+		Email:        data.Email,
+		FirstName:    data.FirstName,
+		LastName:     data.LastName,
+		Uid:          data.Uid,
+		Phone:        data.Phone,
+		Role:         data.Role,
+		MerchantID:   data.MerchantID,
+		MerchantRole: data.MerchantRole,
+		TokenType:    tokenType,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(72)).Unix(),
+			ExpiresAt: time.Now().Local().Add(lifetime).Unix(),
 		},
 	}
 
-	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(app.Env.SecretJWT))
-	if err != nil {
-		return "", "", err
-	}
-	refreshToken, err = jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString([]byte(app.Env.SecretJWT))
-	if err != nil {
-		return "", "", err
+	if app.Keys != nil {
+		return app.Keys.Sign(claims)
 	}
-
-	return token, refreshToken, nil
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(app.Env.SecretJWT))
 }
 
 func ValidateToken(app *conf.Config, signedToken string) (claims *SignedDetails, err error) {
+	return ValidateTokenOfType(app, signedToken, TokenTypeAccess)
+}
+
+// ValidateTokenOfType verifies the token and that it was issued as tokenType.
+func ValidateTokenOfType(app *conf.Config, signedToken, tokenType string) (claims *SignedDetails, err error) {
 	// l.InfoF("token %s", signedToken)
 	token, err := jwt.ParseWithClaims(
 		signedToken,
 		&SignedDetails{},
 		func(token *jwt.Token) (interface{}, error) {
+			if app.Keys != nil {
+				return app.Keys.Keyfunc(token)
+			}
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+			}
 			return []byte(app.Env.SecretJWT), nil
 		},
 	)
@@ -74,7 +90,7 @@ func ValidateToken(app *conf.Config, signedToken string) (claims *SignedDetails,
 	}
 
 	claims, ok := token.Claims.(*SignedDetails)
-	if !ok {
+	if !ok || claims.TokenType != tokenType {
 		return nil, errors.New("the token in invalid")
 	}
 
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	"src/pkg/conf"
+	"src/pkg/env"
+)
+
+func TestValidateTokenType(t *testing.T) {
+	app := &conf.Config{Env: &env.Env{SecretJWT: "secret"}, TokenLifetime: time.Minute}
+
+	token, err := GenerateAccessToken(app, SignedDetails{Uid: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := ValidateToken(app, token)
+	if err != nil {
+		t.Fatalf("access token refused: %v", err)
+	}
+	if claims.Uid != "user" {
+		t.Errorf("Uid = %q, want user", claims.Uid)
+	}
+
+	// Tokens signed before token types existed, or of another type, are refused
+	for _, typ := range []string{"", "refresh"} {
+		other, err := jwt.NewWithClaims(jwt.SigningMethodHS256, SignedDetails{
+			Uid:            "user",
+			TokenType:      typ,
+			StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Minute).Unix()},
+		}).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ValidateToken(app, other); err == nil {
+			t.Errorf("token of type %q accepted", typ)
+		}
+	}
+
+	verification, err := GenerateToken(app, SignedDetails{Uid: "user"}, TokenTypeEmailVerification, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateToken(app, verification); err == nil {
+		t.Error("email verification token accepted as access token")
+	}
+	if _, err := ValidateTokenOfType(app, verification, TokenTypeEmailVerification); err != nil {
+		t.Errorf("email verification token refused: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/permission"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequirePermission lets through the users holding one of the permissions,
+// or the ":own" form of an ownable one. Handlers of resources owned by
+// merchants then check the owner with Allowed or MerchantScope.
+//
+// The role and merchant role come from the access token, so changes take
+// effect when the token is refreshed. The permissions of the roles are read
+// from the database.
+func RequirePermission(app *conf.Config, perms ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("userID") == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Role not found"})
+			c.Abort()
+			return
+		}
+
+		granted, err := Permissions(c, app)
+		if err != nil {
+			l.ErrorF("Error loading permissions: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+			c.Abort()
+			return
+		}
+		for _, perm := range perms {
+			if granted.Has(perm) || permission.Ownable(perm) && granted.Has(permission.Own(perm)) {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		c.Abort()
+	}
+}
+
+// Permissions returns the permissions of the signed in user, granted by their
+// role and by their role in their merchant. They are loaded once per request.
+func Permissions(c *gin.Context, app *conf.Config) (permission.Set, error) {
+	if granted, ok := c.Get("permissions"); ok {
+		return granted.(permission.Set), nil
+	}
+	role, _ := c.Get("role")
+	roleStr, _ := role.(string)
+	granted, err := app.Permissions.Permissions(c, roleStr, c.GetString("merchantRole"))
+	if err != nil {
+		return nil, err
+	}
+	c.Set("permissions", granted)
+	return granted, nil
+}
+
+// HasPermission tells whether the user holds perm. It is false when the
+// permissions cannot be read.
+func HasPermission(c *gin.Context, app *conf.Config, perm string) bool {
+	granted, err := Permissions(c, app)
+	if err != nil {
+		l.ErrorF("Error loading permissions: %v", err)
+		return false
+	}
+	return granted.Has(perm)
+}
+
+// Allowed tells whether the user may use perm on a resource of the merchant:
+// with perm itself, or with its ":own" form when the user belongs to the
+// merchant. This is the ownership policy of every merchant resource.
+func Allowed(c *gin.Context, app *conf.Config, perm string, merchantID uuid.UUID) bool {
+	if HasPermission(c, app, perm) {
+		return true
+	}
+	own, ok := ownMerchant(c)
+	return ok && own == merchantID && HasPermission(c, app, permission.Own(perm))
+}
+
+// MerchantScope returns the merchant the user is limited to for perm, for
+// handlers listing resources: uuid.Nil with perm itself, the merchant of the
+// user with the ":own" form. ok is false without either.
+func MerchantScope(c *gin.Context, app *conf.Config, perm string) (merchantID uuid.UUID, ok bool) {
+	if HasPermission(c, app, perm) {
+		return uuid.Nil, true
+	}
+	own, ok := ownMerchant(c)
+	if !ok || !HasPermission(c, app, permission.Own(perm)) {
+		return uuid.Nil, false
+	}
+	return own, true
+}
+
+// CheckProductAccess verifies that the user may use perm on the product,
+// with Allowed on the merchant of the product. The error response is written
+// here, so callers just return when it reports false.
+func CheckProductAccess(c *gin.Context, app *conf.Config, perm string, productID uuid.UUID) bool {
+	var productMerchantID uuid.UUID
+	err := app.DB.QueryRowContext(c, "SELECT merchant_id FROM products WHERE id = $1 AND deleted_at IS NULL", productID).Scan(&productMerchantID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		} else {
+			l.ErrorF("Error checking product ownership: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify product"})
+		}
+		return false
+	}
+
+	if !Allowed(c, app, perm, productMerchantID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to manage this product"})
+		return false
+	}
+	return true
+}
+
+// ActorID returns the id of the signed in user, if any, for the audit
+// columns of the changes they make.
+func ActorID(c *gin.Context) uuid.NullUUID {
+	userID, err := uuid.Parse(c.GetString("userID"))
+	if err != nil {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: userID, Valid: true}
+}
+
+// ownMerchant returns the merchant the user owns or works for.
+func ownMerchant(c *gin.Context) (uuid.UUID, bool) {
+	merchantID, err := uuid.Parse(c.GetString("merchantID"))
+	return merchantID, err == nil && merchantID != uuid.Nil
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"src/pkg/conf"
+	"src/pkg/permission"
+)
+
+// testContext returns the context of a request by a user of the merchant,
+// with the permissions already loaded.
+func testContext(merchantID uuid.UUID, perms ...string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("userID", uuid.NewString())
+	if merchantID != uuid.Nil {
+		c.Set("merchantID", merchantID.String())
+	}
+	c.Set("permissions", permission.NewSet(perms...))
+	return c
+}
+
+func TestAllowed(t *testing.T) {
+	app := &conf.Config{}
+	mine, other := uuid.New(), uuid.New()
+
+	staff := testContext(mine, permission.Own(permission.ProductWrite))
+	if !Allowed(staff, app, permission.ProductWrite, mine) {
+		t.Error("staff refused a product of their merchant")
+	}
+	if Allowed(staff, app, permission.ProductWrite, other) {
+		t.Error("staff allowed a product of another merchant")
+	}
+	if Allowed(staff, app, permission.InventoryWrite, mine) {
+		t.Error("staff allowed without the permission")
+	}
+
+	admin := testContext(uuid.Nil, permission.All)
+	if !Allowed(admin, app, permission.ProductWrite, other) {
+		t.Error("admin refused")
+	}
+
+	// Without a merchant, the ":own" form covers nothing
+	customer := testContext(uuid.Nil, permission.Own(permission.ProductWrite))
+	if Allowed(customer, app, permission.ProductWrite, uuid.Nil) {
+		t.Error("user without merchant allowed")
+	}
+}
+
+func TestMerchantScope(t *testing.T) {
+	app := &conf.Config{}
+	mine := uuid.New()
+
+	if id, ok := MerchantScope(testContext(mine, permission.Own(permission.OrderRead)), app, permission.OrderRead); !ok || id != mine {
+		t.Errorf("staff scope = %v, %v", id, ok)
+	}
+	if id, ok := MerchantScope(testContext(mine, permission.OrderRead), app, permission.OrderRead); !ok || id != uuid.Nil {
+		t.Errorf("unscoped scope = %v, %v", id, ok)
+	}
+	if _, ok := MerchantScope(testContext(mine), app, permission.OrderRead); ok {
+		t.Error("scope without the permission")
+	}
+}
@@ -7,6 +7,10 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// RoleCheck lets through the users of the roles.
+//
+// Deprecated: use RequirePermission, which follows the roles managed by
+// admins.
 func RoleCheck(allowedRoles ...common.UserRole) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		role, exists := c.Get("role")
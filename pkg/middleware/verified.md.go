@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"src/l"
+	"src/pkg/conf"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// VerifiedEmail lets through the users who verified their email address. It
+// reads the database rather than the token so verifying takes effect at once.
+func VerifiedEmail(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID"})
+			c.Abort()
+			return
+		}
+
+		var verified bool
+		err = app.DB.QueryRowContext(c, "SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1", userID).Scan(&verified)
+		if err != nil {
+			l.ErrorF("Error checking email verification: %v", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+			c.Abort()
+			return
+		}
+		if !verified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Please verify your email address first"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
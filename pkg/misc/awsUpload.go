@@ -2,21 +2,30 @@ package misc
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"mime"
 	"mime/multipart"
+	"net/http"
+	"path"
 	"src/pkg/conf"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
-func S3Upload(file *multipart.FileHeader, config *conf.Config) (string, string, error) {
+func newS3Client(config *conf.Config) (*s3.S3, error) {
 	if config.Env.AWSAccessKeyID == "" {
 		log.Println("Missing AWS keys")
-		return "", "", fmt.Errorf("missing AWS keys")
+		return nil, fmt.Errorf("missing AWS keys")
 	}
 
 	sess, err := session.NewSession(&aws.Config{
@@ -25,33 +34,156 @@ func S3Upload(file *multipart.FileHeader, config *conf.Config) (string, string,
 		Credentials: credentials.NewStaticCredentials(config.Env.AWSAccessKeyID, config.Env.AWSSecretAccessKey, ""),
 	})
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 
-	s3Client := s3.New(sess)
+	return s3.New(sess), nil
+}
+
+// S3ObjectURL returns the public URL of an object stored under key.
+func S3ObjectURL(config *conf.Config, key string) string {
+	return fmt.Sprintf("https://%s.s3.amazonaws.com/%s", config.Env.AWSBucketName, key)
+}
+
+// ContentHash returns the hex encoded SHA-256 of data. It is used to build
+// object keys so that two different files can never overwrite each other.
+func ContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
 
+// ContentHashKey builds a key of the form prefix/ab/abcdef....ext for data.
+func ContentHashKey(prefix string, data []byte, ext string) string {
+	hash := ContentHash(data)
+	return path.Join(prefix, hash[:2], hash+strings.ToLower(ext))
+}
+
+// S3PutObject uploads body under key and returns the public URL of the object.
+func S3PutObject(config *conf.Config, key string, body []byte, contentType string) (string, error) {
+	s3Client, err := newS3Client(config)
+	if err != nil {
+		return "", err
+	}
+
+	params := &s3.PutObjectInput{
+		Bucket:      aws.String(config.Env.AWSBucketName),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	}
+
+	if _, err := s3Client.PutObject(params); err != nil {
+		return "", err
+	}
+
+	return S3ObjectURL(config, key), nil
+}
+
+// S3PutPrivateObject uploads body under key without public read access. The
+// object can only be fetched through a presigned URL.
+func S3PutPrivateObject(config *conf.Config, key string, body []byte, contentType string) error {
+	s3Client, err := newS3Client(config)
+	if err != nil {
+		return err
+	}
+
+	_, err = s3Client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(config.Env.AWSBucketName),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+		ACL:         aws.String(s3.ObjectCannedACLPrivate),
+	})
+	return err
+}
+
+// S3PresignGetObject returns a URL that downloads the object under key as
+// fileName until it expires.
+func S3PresignGetObject(config *conf.Config, key, fileName string, expires time.Duration) (string, error) {
+	s3Client, err := newS3Client(config)
+	if err != nil {
+		return "", err
+	}
+
+	req, _ := s3Client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket:                     aws.String(config.Env.AWSBucketName),
+		Key:                        aws.String(key),
+		ResponseContentDisposition: aws.String(mime.FormatMediaType("attachment", map[string]string{"filename": fileName})),
+	})
+	return req.Presign(expires)
+}
+
+// S3DeleteObjects removes the given keys from the bucket. Empty keys are ignored.
+func S3DeleteObjects(config *conf.Config, keys ...string) error {
+	objects := make([]*s3.ObjectIdentifier, 0, len(keys))
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+	}
+	if len(objects) == 0 {
+		return nil
+	}
+
+	s3Client, err := newS3Client(config)
+	if err != nil {
+		return err
+	}
+
+	_, err = s3Client.DeleteObjects(&s3.DeleteObjectsInput{
+		Bucket: aws.String(config.Env.AWSBucketName),
+		Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+	})
+	return err
+}
+
+// S3ObjectExists tells whether an object is stored under key.
+func S3ObjectExists(config *conf.Config, key string) (bool, error) {
+	s3Client, err := newS3Client(config)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = s3Client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(config.Env.AWSBucketName),
+		Key:    aws.String(key),
+	})
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ReadFormFile reads the whole content of an uploaded multipart file.
+func ReadFormFile(file *multipart.FileHeader) ([]byte, error) {
 	fileContent, err := file.Open()
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
 	defer fileContent.Close()
 
 	buffer := bytes.NewBuffer(nil)
 	if _, err := buffer.ReadFrom(fileContent); err != nil {
-		return "", "", err
+		return nil, err
 	}
+	return buffer.Bytes(), nil
+}
 
-	params := &s3.PutObjectInput{
-		Bucket:      aws.String(config.Env.AWSBucketName),
-		Key:         aws.String(file.Filename),
-		Body:        bytes.NewReader(buffer.Bytes()),
-		ContentType: aws.String(file.Header.Get("Content-Type")),
+// S3Upload stores an uploaded file under a content-hash key and returns the
+// public URL together with the object key.
+func S3Upload(file *multipart.FileHeader, config *conf.Config) (string, string, error) {
+	data, err := ReadFormFile(file)
+	if err != nil {
+		return "", "", err
 	}
 
-	result, err := s3Client.PutObject(params)
+	key := ContentHashKey("uploads", data, path.Ext(file.Filename))
+	url, err := S3PutObject(config, key, data, file.Header.Get("Content-Type"))
 	if err != nil {
 		return "", "", err
 	}
 
-	return fmt.Sprintf("https://%s.s3.amazonaws.com/%s", config.Env.AWSBucketName, file.Filename), *result.ETag, nil
+	return url, key, nil
 }
@@ -0,0 +1,173 @@
+package misc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"path"
+	"src/pkg/conf"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+)
+
+const (
+	// MaxImageDimension caps the WebP rendition of an uploaded image.
+	MaxImageDimension = 1600
+	// ThumbnailDimension is the longest side of a generated thumbnail.
+	ThumbnailDimension = 320
+	// MaxImagePixels caps the decoded size of an upload, since a small
+	// compressed file can decode to gigabytes.
+	MaxImagePixels = 40_000_000
+)
+
+var (
+	ErrUnsupportedImage = errors.New("unsupported image format")
+	ErrImageTooLarge    = errors.New("image dimensions are too large")
+)
+
+// ImageVariant is one stored rendition of an uploaded image.
+type ImageVariant struct {
+	Key         string
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// ProcessedImage holds every rendition generated for one upload. All keys
+// share the content hash of the original file.
+type ProcessedImage struct {
+	Hash      string
+	Original  ImageVariant
+	WebP      ImageVariant
+	Thumbnail ImageVariant
+}
+
+// Variants returns the renditions in upload order.
+func (p *ProcessedImage) Variants() []ImageVariant {
+	return []ImageVariant{p.Original, p.WebP, p.Thumbnail}
+}
+
+// Keys returns the object keys of every rendition.
+func (p *ProcessedImage) Keys() []string {
+	return []string{p.Original.Key, p.WebP.Key, p.Thumbnail.Key}
+}
+
+// ProcessImage decodes an uploaded jpeg/png/gif/webp file and produces a
+// resized WebP version and a JPEG thumbnail, keyed by the SHA-256 of data.
+func ProcessImage(data []byte, prefix string) (*ProcessedImage, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > MaxImagePixels/cfg.Height {
+		return nil, ErrImageTooLarge
+	}
+
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+
+	hash := ContentHash(data)
+	base := path.Join(prefix, hash[:2], hash)
+	bounds := src.Bounds()
+
+	processed := &ProcessedImage{
+		Hash: hash,
+		Original: ImageVariant{
+			Key:         base + "/original." + format,
+			Data:        data,
+			ContentType: "image/" + format,
+			Width:       bounds.Dx(),
+			Height:      bounds.Dy(),
+		},
+	}
+
+	large := resizeToFit(src, MaxImageDimension)
+	var webpBuf bytes.Buffer
+	if err := nativewebp.Encode(&webpBuf, large, nil); err != nil {
+		return nil, fmt.Errorf("encoding webp: %w", err)
+	}
+	processed.WebP = ImageVariant{
+		Key:         base + "/large.webp",
+		Data:        webpBuf.Bytes(),
+		ContentType: "image/webp",
+		Width:       large.Bounds().Dx(),
+		Height:      large.Bounds().Dy(),
+	}
+
+	thumb := resizeToFit(src, ThumbnailDimension)
+	var thumbBuf bytes.Buffer
+	if err := jpeg.Encode(&thumbBuf, thumb, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, fmt.Errorf("encoding thumbnail: %w", err)
+	}
+	processed.Thumbnail = ImageVariant{
+		Key:         base + "/thumb.jpg",
+		Data:        thumbBuf.Bytes(),
+		ContentType: "image/jpeg",
+		Width:       thumb.Bounds().Dx(),
+		Height:      thumb.Bounds().Dy(),
+	}
+
+	return processed, nil
+}
+
+// resizeToFit scales src down so that its longest side is at most maxSide.
+// Images that already fit are copied unchanged.
+func resizeToFit(src image.Image, maxSide int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w > maxSide || h > maxSide {
+		if w >= h {
+			h = h * maxSide / w
+			w = maxSide
+		} else {
+			w = w * maxSide / h
+			h = maxSide
+		}
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+	return dst
+}
+
+// UploadProcessedImage stores every rendition of p in S3.
+func UploadProcessedImage(config *conf.Config, p *ProcessedImage) error {
+	for _, variant := range p.Variants() {
+		if _, err := S3PutObject(config, variant.Key, variant.Data, variant.ContentType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreProcessedImage uploads again the renditions of p that are no longer
+// in S3, e.g. because a cleanup removed them after UploadProcessedImage.
+func RestoreProcessedImage(config *conf.Config, p *ProcessedImage) error {
+	for _, variant := range p.Variants() {
+		exists, err := S3ObjectExists(config, variant.Key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := S3PutObject(config, variant.Key, variant.Data, variant.ContentType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
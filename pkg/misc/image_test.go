@@ -0,0 +1,66 @@
+package misc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestProcessImage(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 2000, 1000))
+	for x := 0; x < 2000; x++ {
+		src.Set(x, x%1000, color.NRGBA{R: 200, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ProcessImage(buf.Bytes(), "products")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(got.Original.Key, "products/"+got.Hash[:2]+"/"+got.Hash) {
+		t.Errorf("unexpected key %s", got.Original.Key)
+	}
+	if got.WebP.Width != MaxImageDimension || got.WebP.Height != MaxImageDimension/2 {
+		t.Errorf("webp size %dx%d", got.WebP.Width, got.WebP.Height)
+	}
+	if got.Thumbnail.Width != ThumbnailDimension || got.Thumbnail.Height != ThumbnailDimension/2 {
+		t.Errorf("thumbnail size %dx%d", got.Thumbnail.Width, got.Thumbnail.Height)
+	}
+
+	again, _ := ProcessImage(buf.Bytes(), "products")
+	if again.Hash != got.Hash {
+		t.Errorf("hash is not stable")
+	}
+
+	if _, err := ProcessImage([]byte("not an image"), "products"); err != ErrUnsupportedImage {
+		t.Errorf("expected ErrUnsupportedImage, got %v", err)
+	}
+}
+
+func TestProcessImageTooLarge(t *testing.T) {
+	// Only the header of a 20000x20000 PNG: the size is refused before decoding
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], 20000)
+	binary.BigEndian.PutUint32(ihdr[4:], 20000)
+	ihdr[8], ihdr[9] = 8, 2 // 8 bit RGB
+	chunk := append([]byte("IHDR"), ihdr...)
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+
+	if _, err := ProcessImage(buf.Bytes(), "products"); err != ErrImageTooLarge {
+		t.Errorf("expected ErrImageTooLarge, got %v", err)
+	}
+}
@@ -5,12 +5,13 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"errors"
-	"fmt"
 	"net/http"
 	"src/common"
 	"src/l"
 	"src/pkg/conf"
+	"src/pkg/mail"
 	"src/pkg/middleware"
+	"src/pkg/module/product"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -46,41 +47,57 @@ func Login(app *conf.Config) gin.HandlerFunc {
 		}
 
 		var loggedInUser common.User
-		err := app.DB.QueryRowContext(c, "SELECT id, email, password, first_name, last_name, role FROM users WHERE email = $1", req.Email).Scan(&loggedInUser.ID, &loggedInUser.Email, &loggedInUser.Password, &loggedInUser.FirstName, &loggedInUser.LastName, &loggedInUser.Role)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"}) // Don't reveal email existence
-			} else {
-				l.DebugF("Database query error: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
-			}
+		err := app.DB.QueryRowContext(c, "SELECT id, email, password, first_name, last_name, role, email_verified_at FROM users WHERE email = $1", req.Email).Scan(&loggedInUser.ID, &loggedInUser.Email, &loggedInUser.Password, &loggedInUser.FirstName, &loggedInUser.LastName, &loggedInUser.Role, &loggedInUser.EmailVerifiedAt)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			l.DebugF("Database query error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+			return
+		}
+		// Unknown emails are throttled too, so the answers don't reveal which exist
+		known := err == nil
+		userID := uuid.NullUUID{UUID: loggedInUser.ID, Valid: known}
+
+		if !allowLoginAttempt(c, app, req.Email, userID) {
+			return
+		}
+
+		if !known {
+			loginFailed(c, app, req.Email, userID, attemptUnknownEmail)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"}) // Don't reveal email existence
 			return
 		}
 
 		err = bcrypt.CompareHashAndPassword([]byte(loggedInUser.Password), []byte(req.Password))
 		if err != nil {
+			loginFailed(c, app, req.Email, userID, attemptInvalidPassword)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 			return
 		}
 
-		merchantID := ""
-		err = app.DB.QueryRow("SELECT id FROM merchants WHERE user_id = $1", loggedInUser.ID).Scan(&merchantID)
-		if err != nil && !errors.Is(err, sql.ErrNoRows) { // Handle case where merchant might not exist
-			l.DebugF("Error checking for merchant: %s", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check merchant status"})
+		tokenType, stepToken, err := secondFactorStep(c, app, loggedInUser.ID, loggedInUser.Role)
+		if err != nil {
+			l.ErrorF("Error checking two-factor authentication: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
 			return
 		}
+		if tokenType != "" {
+			// The failures are only forgotten once the second factor is checked too,
+			// or a known password would allow endless guesses of the code
+			loginPassed(c, app, req.Email)
+			recordLoginAttempt(c, app, req.Email, userID, true, attemptNeedsTwoFactor)
+			respondSecondFactor(c, tokenType, stepToken)
+			return
+		}
+		loginSucceeded(c, app, req.Email, loggedInUser.ID, "")
 
-		sData := middleware.SignedDetails{
-			Email:      loggedInUser.Email,
-			FirstName:  loggedInUser.FirstName,
-			LastName:   loggedInUser.LastName,
-			Uid:        loggedInUser.ID.String(),
-			Role:       loggedInUser.Role,
-			MerchantID: merchantID, // Updated to pass merchantID directly
+		sData, err := loadSignedDetails(c, app.DB, loggedInUser.ID)
+		if err != nil {
+			l.ErrorF("Error loading user claims: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+			return
 		}
 
-		token, _, err := middleware.GenerateTokens(app, sData)
+		token, refreshToken, err := issueTokens(c, app, sData)
 
 		if err != nil {
 			l.DebugF("Error generating tokens: %v", err)
@@ -88,15 +105,19 @@ func Login(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
+		mergeVisitorHistory(c, app, loggedInUser.ID)
+
 		c.JSON(http.StatusOK, gin.H{
-			"success": true,
-			"token":   "Bearer " + token,
+			"success":      true,
+			"token":        "Bearer " + token,
+			"refreshToken": refreshToken,
 			"user": gin.H{
-				"id":        loggedInUser.ID,
-				"firstName": loggedInUser.FirstName,
-				"lastName":  loggedInUser.LastName,
-				"email":     loggedInUser.Email,
-				"role":      loggedInUser.Role,
+				"id":            loggedInUser.ID,
+				"firstName":     loggedInUser.FirstName,
+				"lastName":      loggedInUser.LastName,
+				"email":         loggedInUser.Email,
+				"role":          loggedInUser.Role,
+				"emailVerified": loggedInUser.EmailVerifiedAt.Valid,
 			},
 		})
 	}
@@ -148,7 +169,16 @@ func Register(app *conf.Config) gin.HandlerFunc {
 			Updated:   null.TimeFrom(time.Now()),
 		}
 
-		_, err = app.DB.ExecContext(c, `
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		_, err = tx.ExecContext(ctx, `
 			INSERT INTO users (id, email, first_name, last_name, password, role, provider, created, updated)
 			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 		`, newUser.ID, newUser.Email, newUser.FirstName, newUser.LastName, newUser.Password, newUser.Role, "email", newUser.Created, newUser.Updated)
@@ -159,6 +189,18 @@ func Register(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
+		if err := queueVerificationMail(ctx, tx, app, newUser.ID, newUser.Email, newUser.FirstName); err != nil {
+			l.ErrorF("Error queueing verification mail: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+			return
+		}
+
 		sData := middleware.SignedDetails{
 			Email:      newUser.Email,
 			FirstName:  newUser.FirstName,
@@ -168,7 +210,7 @@ func Register(app *conf.Config) gin.HandlerFunc {
 			MerchantID: "", //  no merchant ID during registration
 		}
 
-		token, _, err := middleware.GenerateTokens(app, sData)
+		token, refreshToken, err := issueTokens(c, app, sData)
 
 		if err != nil {
 			l.DebugF("Error generating token: %v", err)
@@ -176,15 +218,19 @@ func Register(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
+		mergeVisitorHistory(c, app, newUser.ID)
+
 		c.JSON(http.StatusOK, gin.H{
-			"success": true,
-			"token":   "Bearer " + token,
+			"success":      true,
+			"token":        "Bearer " + token,
+			"refreshToken": refreshToken,
 			"user": gin.H{
-				"id":        newUser.ID,
-				"firstName": newUser.FirstName,
-				"lastName":  newUser.LastName,
-				"email":     newUser.Email,
-				"role":      newUser.Role,
+				"id":            newUser.ID,
+				"firstName":     newUser.FirstName,
+				"lastName":      newUser.LastName,
+				"email":         newUser.Email,
+				"role":          newUser.Role,
+				"emailVerified": false,
 			},
 		})
 	}
@@ -201,8 +247,18 @@ func ForgotPassword(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
 		var userID uuid.UUID // Changed type here
-		err := app.DB.QueryRowContext(c, "SELECT id FROM users WHERE email = $1", req.Email).Scan(&userID)
+		var firstName string
+		err = tx.QueryRowContext(ctx, "SELECT id, COALESCE(first_name, '') FROM users WHERE email = $1", req.Email).Scan(&userID, &firstName)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				c.JSON(http.StatusNotFound, gin.H{"error": "No user found for this email address"})
@@ -216,7 +272,7 @@ func ForgotPassword(app *conf.Config) gin.HandlerFunc {
 		resetToken := generateResetToken()
 		expireTime := time.Now().Add(time.Hour)
 
-		_, err = app.DB.ExecContext(c, `
+		_, err = tx.ExecContext(ctx, `
             UPDATE users 
             SET reset_password_token = $1, reset_password_expires = $2
             WHERE id = $3
@@ -227,7 +283,22 @@ func ForgotPassword(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
-		fmt.Printf("Reset token: %s, User ID: %s, Expires at: %v\n", resetToken, userID, expireTime)
+		err = mail.Enqueue(ctx, tx, mail.TemplatePasswordReset, req.Email, mail.PasswordResetData{
+			Name:      firstName,
+			ResetURL:  app.Env.ClientURL + "/reset-password/" + resetToken,
+			ExpiresAt: expireTime,
+		})
+		if err != nil {
+			l.ErrorF("Error queueing reset mail: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send reset mail"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+			return
+		}
 
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
@@ -236,6 +307,14 @@ func ForgotPassword(app *conf.Config) gin.HandlerFunc {
 	}
 }
 
+// mergeVisitorHistory moves what the visitor browsed before signing in to the
+// user. Failing to do so does not fail the sign in.
+func mergeVisitorHistory(c *gin.Context, app *conf.Config, userID uuid.UUID) {
+	if err := product.MergeVisitorHistory(c, app.DB, userID); err != nil {
+		l.ErrorF("Error merging visitor history: %v", err)
+	}
+}
+
 func generateResetToken() string {
 	buffer := make([]byte, 48)
 	_, err := rand.Read(buffer)
@@ -268,7 +347,7 @@ func ResetPasswordFromToken(app *conf.Config) gin.HandlerFunc {
 			SELECT id, email, reset_password_expires 
 			FROM users
 			WHERE reset_password_token = $1
-		`, resetToken).Scan(&user)
+		`, resetToken).Scan(&user.ID, &user.Email, &user.ResetPasswordExpires)
 
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
@@ -294,7 +373,8 @@ func ResetPasswordFromToken(app *conf.Config) gin.HandlerFunc {
 
 		_, err = app.DB.ExecContext(c, `
 			UPDATE users 
-			SET password = $1, reset_password_token = NULL, reset_password_expires = NULL, updated = $2
+			SET password = $1, reset_password_token = NULL, reset_password_expires = NULL, updated = $2,
+				email_verified_at = COALESCE(email_verified_at, NOW()) -- the token came by mail
 			WHERE id = $3
 		`, string(hashedPassword), time.Now(), user.ID)
 
@@ -304,6 +384,14 @@ func ResetPasswordFromToken(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
+		// A reset usually means the password leaked, so end every session
+		if err := revokeUserSessions(c, app.DB, user.ID); err != nil {
+			l.ErrorF("Error revoking sessions: %v", err)
+		}
+		if err := app.LoginGuard.Unlock(c, user.Email); err != nil {
+			l.ErrorF("Error unlocking account: %v", err)
+		}
+
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Password reset successful"})
 	}
 }
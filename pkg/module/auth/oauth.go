@@ -0,0 +1,654 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/guregu/null/v5"
+	"golang.org/x/oauth2"
+
+	"src/common"
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/oauth"
+)
+
+// Users sign in with the providers of app.OAuth, see the oauth package. The
+// browser is redirected to the provider with a random state, kept hashed in
+// oauth_states along with the PKCE verifier and the nonce, and also set in a
+// cookie so the callback only completes in the browser that started it.
+// Signed in users link more providers from their profile the same way.
+// A completed sign in sends the browser back with a one-time code, which the
+// client exchanges for the tokens with ExchangeLoginCode. A completed link
+// sends back a code too, which only the user who started it can claim with
+// CompleteOAuthLink.
+
+const (
+	oauthStateLifetime = 10 * time.Minute
+	oauthStateCookie   = "oauth_state"
+	oauthCodeLifetime  = time.Minute
+)
+
+var (
+	errIdentityNoEmail    = errors.New("the provider did not share an email address")
+	errIdentityEmailTaken = errors.New("an account with the email exists")
+	errIdentityLinked     = errors.New("the identity is linked to another account")
+	errProviderLinked     = errors.New("another identity of the provider is linked")
+)
+
+type LoginCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type LinkCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type UserIdentity struct {
+	Provider string    `json:"provider"`
+	Email    string    `json:"email"`
+	Created  time.Time `json:"created"`
+}
+
+// oauthFlow is a sign in started with startOAuth.
+type oauthFlow struct {
+	verifier string
+	nonce    string
+	userID   uuid.NullUUID // the user linking the identity
+}
+
+// useProvider sets the provider param of routes without one, such as the
+// original Google routes.
+func useProvider(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Params = append(c.Params, gin.Param{Key: "provider", Value: name})
+	}
+}
+
+// startOAuth stores a new flow and returns its state and the authorization
+// URL of the provider.
+func startOAuth(ctx context.Context, app *conf.Config, provider oauth.Provider, userID uuid.NullUUID) (string, string, error) {
+	buffer := make([]byte, 48)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", "", err
+	}
+	state, nonce := hex.EncodeToString(buffer[:32]), hex.EncodeToString(buffer[32:])
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(ctx, state, verifier, nonce)
+	if err != nil {
+		return "", "", err
+	}
+	_, err = app.DB.ExecContext(ctx, `
+		INSERT INTO oauth_states (state_hash, provider, code_verifier, nonce, user_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, hashToken(state), provider.Name(), verifier, nonce, userID, time.Now().Add(oauthStateLifetime))
+	return state, authURL, err
+}
+
+// consumeOAuthState returns the flow of the state, which can only be used once.
+func consumeOAuthState(ctx context.Context, db *sql.DB, provider, state string) (oauthFlow, error) {
+	var flow oauthFlow
+	err := db.QueryRowContext(ctx, `
+		DELETE FROM oauth_states
+		WHERE state_hash = $1 AND provider = $2 AND expires_at > NOW()
+		RETURNING code_verifier, nonce, user_id
+	`, hashToken(state), provider).Scan(&flow.verifier, &flow.nonce, &flow.userID)
+	return flow, err
+}
+
+// oneTimeCode returns a random code for the callback to send back.
+func oneTimeCode() (string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buffer), nil
+}
+
+// issueLoginCode stores a one-time code that signs the user in.
+func issueLoginCode(ctx context.Context, db *sql.DB, userID uuid.UUID) (string, error) {
+	code, err := oneTimeCode()
+	if err != nil {
+		return "", err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO oauth_login_codes (code_hash, user_id, expires_at) VALUES ($1, $2, $3)
+	`, hashToken(code), userID, time.Now().Add(oauthCodeLifetime))
+	return code, err
+}
+
+// issueLinkCode stores the identity a linking flow brought back under a
+// one-time code the user who started the flow claims.
+func issueLinkCode(ctx context.Context, db *sql.DB, userID uuid.UUID, provider string, id oauth.Identity) (string, error) {
+	code, err := oneTimeCode()
+	if err != nil {
+		return "", err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO oauth_link_codes (code_hash, user_id, provider, subject, email, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, hashToken(code), userID, provider, id.Subject, id.Email, time.Now().Add(oauthCodeLifetime))
+	return code, err
+}
+
+// setStateCookie sets the state cookie, or clears it with an empty state.
+// Providers posting the callback, like Apple, need SameSite=None, which
+// browsers only keep on secure cookies.
+func setStateCookie(c *gin.Context, state string) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	if secure {
+		c.SetSameSite(http.SameSiteNoneMode)
+	}
+	maxAge := int(oauthStateLifetime.Seconds())
+	if state == "" {
+		maxAge = -1
+	}
+	c.SetCookie(oauthStateCookie, state, maxAge, "/", "", secure, true)
+}
+
+// providerColumn is the users.provider of accounts created with the provider.
+func providerColumn(name string) string {
+	switch name {
+	case "google":
+		return string(common.EmailProviderGoogle)
+	case "facebook":
+		return string(common.EmailProviderFacebook)
+	}
+	return name
+}
+
+// signInIdentity returns the user of the identity. Unknown identities are
+// linked to the account with their email when both the provider and the
+// account verified it, or get a new account.
+func signInIdentity(ctx context.Context, app *conf.Config, provider string, id oauth.Identity) (uuid.UUID, string, error) {
+	tx, err := app.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	defer tx.Rollback()
+
+	var userID uuid.UUID
+	var role string
+	err = tx.QueryRowContext(ctx, `
+		SELECT u.id, u.role FROM user_identities i
+		JOIN users u ON u.id = i.user_id
+		WHERE i.provider = $1 AND i.subject = $2
+	`, provider, id.Subject).Scan(&userID, &role)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, "", err
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		if id.Email == "" {
+			return uuid.Nil, "", errIdentityNoEmail
+		}
+		var verified bool
+		err = tx.QueryRowContext(ctx, "SELECT id, role, email_verified_at IS NOT NULL FROM users WHERE email = $1", id.Email).Scan(&userID, &role, &verified)
+		switch {
+		case err == nil && !id.EmailVerified:
+			// Anybody could claim the address at such a provider
+			return uuid.Nil, "", errIdentityEmailTaken
+		case err == nil && !verified:
+			// Whoever registered the address without confirming it may not own
+			// it, and would keep a way into the account of its owner
+			return uuid.Nil, "", errIdentityEmailTaken
+		case errors.Is(err, sql.ErrNoRows):
+			userID, role = uuid.New(), "ROLE USER"
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO users (id, email, first_name, last_name, avatar, role, provider, email_verified_at, created, updated)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, CASE WHEN $8 THEN NOW() END, NOW(), NOW())
+			`, userID, id.Email, id.GivenName, id.FamilyName, null.NewString(id.Picture, id.Picture != ""), role, providerColumn(provider), id.EmailVerified)
+			if err != nil {
+				return uuid.Nil, "", err
+			}
+			if !id.EmailVerified {
+				if err := queueVerificationMail(ctx, tx, app, userID, id.Email, id.GivenName); err != nil {
+					return uuid.Nil, "", err
+				}
+			}
+		case err != nil:
+			return uuid.Nil, "", err
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)", userID, provider, id.Subject, id.Email)
+		if err != nil {
+			return uuid.Nil, "", err
+		}
+	}
+
+	if id.EmailVerified {
+		_, err = tx.ExecContext(ctx, "UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()) WHERE id = $1 AND email = $2", userID, id.Email)
+		if err != nil {
+			return uuid.Nil, "", err
+		}
+	}
+
+	return userID, role, tx.Commit()
+}
+
+// linkIdentity links the identity to the user.
+func linkIdentity(ctx context.Context, app *conf.Config, userID uuid.UUID, provider string, id oauth.Identity) error {
+	tx, err := app.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var owner uuid.UUID
+	err = tx.QueryRowContext(ctx, "SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2", provider, id.Subject).Scan(&owner)
+	if err == nil {
+		if owner != userID {
+			return errIdentityLinked
+		}
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	var linked bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM user_identities WHERE user_id = $1 AND provider = $2)", userID, provider).Scan(&linked)
+	if err != nil {
+		return err
+	}
+	if linked {
+		return errProviderLinked
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)", userID, provider, id.Subject, id.Email)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// identityErrorCode is the error the client is redirected with.
+func identityErrorCode(err error) string {
+	switch {
+	case errors.Is(err, errIdentityNoEmail):
+		return "no_email"
+	case errors.Is(err, errIdentityEmailTaken):
+		return "account_exists"
+	case errors.Is(err, errIdentityLinked):
+		return "identity_linked"
+	case errors.Is(err, errProviderLinked):
+		return "provider_linked"
+	}
+	return "sign_in"
+}
+
+// OAuthProviders lists the providers users can sign in with.
+func OAuthProviders(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"providers": app.OAuth.Names()})
+	}
+}
+
+// OAuthStart redirects the browser to the provider.
+func OAuthStart(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider, ok := app.OAuth.Get(c.Param("provider"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown sign in provider"})
+			return
+		}
+
+		state, authURL, err := startOAuth(c, app, provider, uuid.NullUUID{})
+		if err != nil {
+			l.ErrorF("Error starting %s sign in: %v", provider.Name(), err)
+			c.Redirect(http.StatusTemporaryRedirect, app.Env.ClientURL+"/login?error=oauth_start")
+			return
+		}
+
+		setStateCookie(c, state)
+		c.Redirect(http.StatusTemporaryRedirect, authURL)
+	}
+}
+
+// OAuthCallback completes the flow the provider redirected back from: it
+// signs the user in, or links the identity when the flow was started by
+// OAuthLink. Some providers post the callback instead of redirecting.
+func OAuthCallback(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fail := func(page, code string) {
+			c.Redirect(http.StatusSeeOther, app.Env.ClientURL+page+"?error="+code)
+		}
+
+		provider, ok := app.OAuth.Get(c.Param("provider"))
+		if !ok {
+			fail("/login", "unknown_provider")
+			return
+		}
+
+		state := c.Request.FormValue("state")
+		flow, err := consumeOAuthState(c, app.DB, provider.Name(), state)
+		if errors.Is(err, sql.ErrNoRows) || state == "" {
+			fail("/login", "invalid_state")
+			return
+		}
+		if err != nil {
+			l.ErrorF("Error fetching oauth state: %v", err)
+			fail("/login", "sign_in")
+			return
+		}
+
+		page := "/login"
+		if flow.userID.Valid {
+			page = "/profile"
+		}
+		cookie, _ := c.Cookie(oauthStateCookie)
+		setStateCookie(c, "")
+		if cookie != state {
+			fail(page, "invalid_state")
+			return
+		}
+
+		if c.Request.FormValue("error") != "" {
+			fail(page, "oauth_denied")
+			return
+		}
+
+		identity, err := provider.Exchange(c, oauth.Callback{Code: c.Request.FormValue("code"), Verifier: flow.verifier, Nonce: flow.nonce})
+		if err != nil {
+			l.ErrorF("Error completing %s sign in: %v", provider.Name(), err)
+			fail(page, "oauth_exchange")
+			return
+		}
+
+		if flow.userID.Valid {
+			// The browser carries no session here, the signed in client claims
+			// the identity with CompleteOAuthLink
+			code, err := issueLinkCode(c, app.DB, flow.userID.UUID, provider.Name(), identity)
+			if err != nil {
+				l.ErrorF("Error issuing link code: %v", err)
+				fail(page, "sign_in")
+				return
+			}
+			c.Redirect(http.StatusSeeOther, app.Env.ClientURL+"/profile?"+url.Values{"linkCode": {code}, "provider": {provider.Name()}}.Encode())
+			return
+		}
+
+		userID, role, err := signInIdentity(c, app, provider.Name(), identity)
+		if err != nil {
+			if identityErrorCode(err) == "sign_in" {
+				l.ErrorF("Error signing in %s identity: %v", provider.Name(), err)
+			}
+			fail(page, identityErrorCode(err))
+			return
+		}
+
+		tokenType, stepToken, err := secondFactorStep(c, app, userID, role)
+		if err != nil {
+			l.ErrorF("Error checking two-factor authentication: %v", err)
+			fail(page, "two_factor")
+			return
+		}
+		if tokenType != "" {
+			recordLoginAttempt(c, app, identity.Email, uuid.NullUUID{UUID: userID, Valid: true}, true, attemptNeedsTwoFactor)
+			c.Redirect(http.StatusSeeOther, app.Env.ClientURL+"/auth/2fa?"+secondFactorQuery(tokenType, stepToken))
+			return
+		}
+		recordLoginAttempt(c, app, identity.Email, uuid.NullUUID{UUID: userID, Valid: true}, true, "")
+
+		code, err := issueLoginCode(c, app.DB, userID)
+		if err != nil {
+			l.ErrorF("Error issuing login code: %v", err)
+			fail(page, "generate_token")
+			return
+		}
+
+		c.Redirect(http.StatusSeeOther, app.Env.ClientURL+"/auth/success?"+url.Values{"code": {code}}.Encode())
+	}
+}
+
+// ExchangeLoginCode trades the code of a completed provider sign in for the
+// tokens. A code works once.
+func ExchangeLoginCode(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req LoginCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A code is required"})
+			return
+		}
+
+		var userID uuid.UUID
+		err := app.DB.QueryRowContext(c, `
+			DELETE FROM oauth_login_codes WHERE code_hash = $1 AND expires_at > NOW()
+			RETURNING user_id
+		`, hashToken(req.Code)).Scan(&userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired code"})
+			return
+		}
+		if err != nil {
+			l.ErrorF("Error consuming login code: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+			return
+		}
+
+		sData, err := loadSignedDetails(c, app.DB, userID)
+		if err != nil {
+			l.ErrorF("Error loading user claims: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+			return
+		}
+		token, refreshToken, err := issueTokens(c, app, sData)
+		if err != nil {
+			l.ErrorF("Error generating tokens: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		mergeVisitorHistory(c, app, userID)
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":      true,
+			"token":        "Bearer " + token,
+			"refreshToken": refreshToken,
+		})
+	}
+}
+
+// OAuthLink starts linking the provider to the signed in user. The client
+// sends the browser to the returned URL.
+func OAuthLink(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		provider, ok := app.OAuth.Get(c.Param("provider"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown sign in provider"})
+			return
+		}
+
+		state, authURL, err := startOAuth(c, app, provider, uuid.NullUUID{UUID: userID, Valid: true})
+		if err != nil {
+			l.ErrorF("Error starting %s link: %v", provider.Name(), err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start linking"})
+			return
+		}
+
+		setStateCookie(c, state)
+		c.JSON(http.StatusOK, gin.H{"success": true, "url": authURL})
+	}
+}
+
+// CompleteOAuthLink links the identity of the code the callback sent back, if
+// the signed in user is the one who started linking it.
+func CompleteOAuthLink(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		var req LinkCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A code is required"})
+			return
+		}
+
+		var owner uuid.UUID
+		var provider string
+		var identity oauth.Identity
+		err = app.DB.QueryRowContext(c, `
+			DELETE FROM oauth_link_codes WHERE code_hash = $1 AND expires_at > NOW()
+			RETURNING user_id, provider, subject, email
+		`, hashToken(req.Code)).Scan(&owner, &provider, &identity.Subject, &identity.Email)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired code"})
+			return
+		}
+		if err != nil {
+			l.ErrorF("Error consuming link code: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link account"})
+			return
+		}
+		if owner != userID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Linking was started by another account"})
+			return
+		}
+
+		err = linkIdentity(c, app, userID, provider, identity)
+		switch {
+		case errors.Is(err, errIdentityLinked):
+			c.JSON(http.StatusConflict, gin.H{"error": "This account is linked to another user"})
+			return
+		case errors.Is(err, errProviderLinked):
+			c.JSON(http.StatusConflict, gin.H{"error": "Another account of this provider is already linked"})
+			return
+		case err != nil:
+			l.ErrorF("Error linking %s identity: %v", provider, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link account"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Account linked", "provider": provider})
+	}
+}
+
+// OAuthUnlink removes the identity of the provider from the signed in user,
+// unless it is the only way left to sign in.
+func OAuthUnlink(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		provider := c.Param("provider")
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		var hasPassword, hasPhone bool
+		var others int
+		err = tx.QueryRowContext(ctx, `
+			SELECT password IS NOT NULL AND password <> '', phone_verified_at IS NOT NULL,
+				(SELECT COUNT(*) FROM user_identities WHERE user_id = $1 AND provider <> $2)
+			FROM users WHERE id = $1
+			FOR UPDATE
+		`, userID, provider).Scan(&hasPassword, &hasPhone, &others)
+		if err != nil {
+			l.ErrorF("Error fetching sign in methods: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink account"})
+			return
+		}
+		if !hasPassword && !hasPhone && others == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Set a password or link another account before unlinking the last one"})
+			return
+		}
+
+		res, err := tx.ExecContext(ctx, "DELETE FROM user_identities WHERE user_id = $1 AND provider = $2", userID, provider)
+		if err != nil {
+			l.ErrorF("Error unlinking identity: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink account"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No linked account of this provider"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlink account"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Account unlinked"})
+	}
+}
+
+// OAuthIdentities lists the identities linked to the signed in user.
+func OAuthIdentities(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		rows, err := app.DB.QueryContext(c, "SELECT provider, email, created FROM user_identities WHERE user_id = $1 ORDER BY created", userID)
+		if err != nil {
+			l.ErrorF("Error fetching identities: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch linked accounts"})
+			return
+		}
+		defer rows.Close()
+
+		identities := []UserIdentity{}
+		for rows.Next() {
+			var identity UserIdentity
+			if err := rows.Scan(&identity.Provider, &identity.Email, &identity.Created); err != nil {
+				l.ErrorF("Error scanning identity: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch linked accounts"})
+				return
+			}
+			identities = append(identities, identity)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"identities": identities, "providers": app.OAuth.Names()})
+	}
+}
+
+// PurgeOAuthStates deletes the sign ins that were never completed and the
+// login and link codes that were never exchanged.
+func PurgeOAuthStates(ctx context.Context, db *sql.DB) (int, error) {
+	purged := 0
+	for _, query := range []string{
+		"DELETE FROM oauth_states WHERE expires_at < NOW()",
+		"DELETE FROM oauth_login_codes WHERE expires_at < NOW()",
+		"DELETE FROM oauth_link_codes WHERE expires_at < NOW()",
+	} {
+		res, err := db.ExecContext(ctx, query)
+		if err != nil {
+			return purged, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return purged, err
+		}
+		purged += int(n)
+	}
+	return purged, nil
+}
@@ -0,0 +1,537 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/guregu/null/v5"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+
+	"src/common"
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/middleware"
+	"src/pkg/sms"
+)
+
+// Customers can sign in with a one time code sent to their phone instead of
+// a password. A verified number no account uses yet gets a signup token to
+// create one, and signed in users link a number to their account the same
+// way. Codes are stored hashed, expire after a few minutes and only allow a
+// few attempts.
+
+const (
+	phoneCodeLifetime    = 5 * time.Minute
+	phoneCodeAttempts    = 5 // wrong guesses before a code stops working
+	phoneCodeResendDelay = time.Minute
+	phoneCodesPerHour    = 5  // per number
+	phoneCodesPerIPHour  = 20 // per client, so nobody pays for texting random numbers
+	phoneSignupLifetime  = 15 * time.Minute
+)
+
+// Purposes of phone codes
+const (
+	phoneCodeLogin = "login"
+	phoneCodeLink  = "link"
+)
+
+const attemptInvalidPhoneCode = "invalid_phone_code"
+
+var (
+	errPhoneCodeRefused  = errors.New("invalid or expired code")
+	errTooManyPhoneCodes = errors.New("too many codes requested")
+)
+
+type PhoneCodeRequest struct {
+	Phone string `json:"phone" binding:"required"`
+}
+
+type PhoneVerifyRequest struct {
+	Phone string `json:"phone" binding:"required"`
+	Code  string `json:"code" binding:"required"`
+}
+
+type PhoneRegisterRequest struct {
+	SignupToken string `json:"signupToken" binding:"required"`
+	Email       string `json:"email" binding:"required"` // for receipts and order updates
+	FirstName   string `json:"firstName" binding:"required"`
+	LastName    string `json:"lastName" binding:"required"`
+}
+
+// sendPhoneCode texts a new code to the phone, replacing the codes sent
+// before for the same purpose. The limits are checked under a lock on the
+// phone and the client, so parallel requests cannot all pass them and each
+// send a text.
+func sendPhoneCode(c *gin.Context, app *conf.Config, phone, purpose string, userID uuid.NullUUID) error {
+	ctx := c.Request.Context()
+	tx, err := app.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		SELECT pg_advisory_xact_lock(hashtext(k)) FROM unnest($1::text[]) AS k ORDER BY k
+	`, pq.Array([]string{"phone_code:phone:" + phone, "phone_code:ip:" + c.ClientIP()}))
+	if err != nil {
+		return err
+	}
+
+	var sent, fromIP int
+	var last sql.NullTime
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FILTER (WHERE phone = $1), MAX(created) FILTER (WHERE phone = $1), COUNT(*) FILTER (WHERE client_ip = $2)
+		FROM phone_codes
+		WHERE (phone = $1 OR client_ip = $2) AND created > NOW() - INTERVAL '1 hour'
+	`, phone, c.ClientIP()).Scan(&sent, &last, &fromIP)
+	if err != nil {
+		return err
+	}
+	if sent >= phoneCodesPerHour || fromIP >= phoneCodesPerIPHour || (last.Valid && time.Since(last.Time) < phoneCodeResendDelay) {
+		return errTooManyPhoneCodes
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return err
+	}
+	code := fmt.Sprintf("%06d", n)
+	hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE phone_codes SET consumed_at = NOW() WHERE phone = $1 AND purpose = $2 AND consumed_at IS NULL", phone, purpose)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO phone_codes (phone, purpose, user_id, code_hash, client_ip, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, phone, purpose, userID, string(hash), c.ClientIP(), time.Now().Add(phoneCodeLifetime))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return app.SMS.Send(ctx, phone, fmt.Sprintf("%s is your verification code. It expires in %d minutes, don't share it with anyone.",
+		code, int(phoneCodeLifetime.Minutes())))
+}
+
+// checkPhoneCode consumes the latest code sent to the phone for the purpose.
+// Every check counts as an attempt, so a code can't be guessed.
+func checkPhoneCode(ctx context.Context, db *sql.DB, phone, purpose string, userID uuid.NullUUID, code string) error {
+	var id uuid.UUID
+	var hash string
+	err := db.QueryRowContext(ctx, `
+		UPDATE phone_codes SET attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM phone_codes
+			WHERE phone = $1 AND purpose = $2 AND user_id IS NOT DISTINCT FROM $3
+				AND consumed_at IS NULL AND expires_at > NOW()
+			ORDER BY created DESC
+			LIMIT 1
+		) AND attempts < $4
+		RETURNING id, code_hash
+	`, phone, purpose, userID, phoneCodeAttempts).Scan(&id, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errPhoneCodeRefused
+	}
+	if err != nil {
+		return err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(strings.TrimSpace(code))) != nil {
+		return errPhoneCodeRefused
+	}
+
+	// Only one of concurrent requests with the right code gets it
+	res, err := db.ExecContext(ctx, "UPDATE phone_codes SET consumed_at = NOW() WHERE id = $1 AND consumed_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errPhoneCodeRefused
+	}
+	return nil
+}
+
+// respondPhoneCodeError answers a failed sendPhoneCode.
+func respondPhoneCodeError(c *gin.Context, err error) {
+	if errors.Is(err, errTooManyPhoneCodes) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "A code was sent recently, please wait before asking for another one"})
+		return
+	}
+	l.ErrorF("Error sending phone code: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send code"})
+}
+
+// RequestPhoneCode texts a sign in code. The answer is the same whether an
+// account uses the number or not.
+func RequestPhoneCode(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req PhoneCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Phone number is required"})
+			return
+		}
+		phone, err := sms.Normalize(req.Phone, app.Env.SMSCountryCode)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number"})
+			return
+		}
+
+		if err := sendPhoneCode(c, app, phone, phoneCodeLogin, uuid.NullUUID{}); err != nil {
+			respondPhoneCodeError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":   true,
+			"message":   "A code is on its way",
+			"phone":     phone,
+			"expiresIn": int(phoneCodeLifetime.Seconds()),
+		})
+	}
+}
+
+// VerifyPhoneCode signs in the account of the phone. When there is none, it
+// returns a signup token for RegisterWithPhone instead.
+func VerifyPhoneCode(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req PhoneVerifyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Phone number and code are required"})
+			return
+		}
+		phone, err := sms.Normalize(req.Phone, app.Env.SMSCountryCode)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number"})
+			return
+		}
+
+		var user common.User
+		err = app.DB.QueryRowContext(c, "SELECT id, role, email_verified_at FROM users WHERE phone_number = $1 AND phone_verified_at IS NOT NULL", phone).
+			Scan(&user.ID, &user.Role, &user.EmailVerifiedAt)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			l.ErrorF("Error fetching user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+			return
+		}
+		known := err == nil
+		userID := uuid.NullUUID{UUID: user.ID, Valid: known}
+
+		if !allowLoginAttempt(c, app, phone, userID) {
+			return
+		}
+		err = checkPhoneCode(c, app.DB, phone, phoneCodeLogin, uuid.NullUUID{}, req.Code)
+		if errors.Is(err, errPhoneCodeRefused) {
+			loginFailed(c, app, phone, userID, attemptInvalidPhoneCode)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired code"})
+			return
+		}
+		if err != nil {
+			l.ErrorF("Error checking phone code: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+			return
+		}
+
+		if !known {
+			if err := app.LoginGuard.Succeed(c, phone, c.ClientIP()); err != nil {
+				l.ErrorF("Error resetting login throttle: %v", err)
+			}
+			signupToken, err := middleware.GenerateToken(app, middleware.SignedDetails{Phone: phone}, middleware.TokenTypePhoneSignup, phoneSignupLifetime)
+			if err != nil {
+				l.ErrorF("Error generating signup token: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"success": true, "signupRequired": true, "signupToken": signupToken})
+			return
+		}
+
+		tokenType, stepToken, err := secondFactorStep(c, app, user.ID, user.Role)
+		if err != nil {
+			l.ErrorF("Error checking two-factor authentication: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+			return
+		}
+		if tokenType != "" {
+			loginPassed(c, app, phone)
+			recordLoginAttempt(c, app, phone, userID, true, attemptNeedsTwoFactor)
+			respondSecondFactor(c, tokenType, stepToken)
+			return
+		}
+		loginSucceeded(c, app, phone, user.ID, "")
+
+		sData, err := loadSignedDetails(c, app.DB, user.ID)
+		if err != nil {
+			l.ErrorF("Error loading user claims: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+			return
+		}
+		token, refreshToken, err := issueTokens(c, app, sData)
+		if err != nil {
+			l.ErrorF("Error generating tokens: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		mergeVisitorHistory(c, app, user.ID)
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":      true,
+			"token":        "Bearer " + token,
+			"refreshToken": refreshToken,
+			"user": gin.H{
+				"id":            user.ID,
+				"firstName":     sData.FirstName,
+				"lastName":      sData.LastName,
+				"email":         sData.Email,
+				"phoneNumber":   sData.Phone,
+				"role":          sData.Role,
+				"emailVerified": user.EmailVerifiedAt.Valid,
+			},
+		})
+	}
+}
+
+// RegisterWithPhone creates an account for the phone verified by
+// VerifyPhoneCode. The email still has to be verified to order.
+func RegisterWithPhone(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req PhoneRegisterRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "All fields are required"})
+			return
+		}
+
+		claims, err := middleware.ValidateTokenOfType(app, req.SignupToken, middleware.TokenTypePhoneSignup)
+		if err != nil || claims.Phone == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Phone verification expired, please request a new code"})
+			return
+		}
+
+		newUser := common.User{
+			ID:          uuid.New(),
+			Email:       strings.TrimSpace(req.Email),
+			PhoneNumber: null.StringFrom(claims.Phone),
+			FirstName:   req.FirstName,
+			LastName:    req.LastName,
+			Role:        "ROLE USER",
+			Created:     time.Now(),
+			Updated:     null.TimeFrom(time.Now()),
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		var emailTaken, phoneTaken bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM users WHERE email = $1),
+				EXISTS (SELECT 1 FROM users WHERE phone_number = $2 AND phone_verified_at IS NOT NULL)
+		`, newUser.Email, claims.Phone).Scan(&emailTaken, &phoneTaken)
+		if err != nil {
+			l.ErrorF("Database error checking for existing user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check for existing user"})
+			return
+		}
+		if phoneTaken {
+			c.JSON(http.StatusConflict, gin.H{"error": "An account already uses this phone number, please sign in"})
+			return
+		}
+		if emailTaken {
+			// Its owner can sign in and link the phone instead
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Email address is already in use"})
+			return
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO users (id, email, phone_number, phone_verified_at, first_name, last_name, role, provider, created, updated)
+			VALUES ($1, $2, $3, NOW(), $4, $5, $6, $7, $8, $9)
+		`, newUser.ID, newUser.Email, newUser.PhoneNumber, newUser.FirstName, newUser.LastName, newUser.Role, "phone", newUser.Created, newUser.Updated)
+		if err != nil {
+			l.ErrorF("Error inserting user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+			return
+		}
+
+		if err := queueVerificationMail(ctx, tx, app, newUser.ID, newUser.Email, newUser.FirstName); err != nil {
+			l.ErrorF("Error queueing verification mail: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+			return
+		}
+
+		sData := middleware.SignedDetails{
+			Email:     newUser.Email,
+			FirstName: newUser.FirstName,
+			LastName:  newUser.LastName,
+			Uid:       newUser.ID.String(),
+			Phone:     claims.Phone,
+			Role:      newUser.Role,
+		}
+		token, refreshToken, err := issueTokens(c, app, sData)
+		if err != nil {
+			l.ErrorF("Error generating tokens: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		mergeVisitorHistory(c, app, newUser.ID)
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":      true,
+			"token":        "Bearer " + token,
+			"refreshToken": refreshToken,
+			"user": gin.H{
+				"id":            newUser.ID,
+				"firstName":     newUser.FirstName,
+				"lastName":      newUser.LastName,
+				"email":         newUser.Email,
+				"phoneNumber":   claims.Phone,
+				"role":          newUser.Role,
+				"emailVerified": false,
+			},
+		})
+	}
+}
+
+// RequestPhoneLinkCode texts a code to confirm the number the signed in user
+// wants to link.
+func RequestPhoneLinkCode(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		var req PhoneCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Phone number is required"})
+			return
+		}
+		phone, err := sms.Normalize(req.Phone, app.Env.SMSCountryCode)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number"})
+			return
+		}
+
+		taken, err := phoneLinkedElsewhere(c, app.DB, phone, userID)
+		if err != nil {
+			l.ErrorF("Error checking phone number: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send code"})
+			return
+		}
+		if taken {
+			c.JSON(http.StatusConflict, gin.H{"error": "This phone number is linked to another account"})
+			return
+		}
+
+		if err := sendPhoneCode(c, app, phone, phoneCodeLink, uuid.NullUUID{UUID: userID, Valid: true}); err != nil {
+			respondPhoneCodeError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":   true,
+			"message":   "A code is on its way",
+			"phone":     phone,
+			"expiresIn": int(phoneCodeLifetime.Seconds()),
+		})
+	}
+}
+
+// LinkPhone links the number confirmed with the code to the signed in user,
+// who can then sign in with it.
+func LinkPhone(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		var req PhoneVerifyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Phone number and code are required"})
+			return
+		}
+		phone, err := sms.Normalize(req.Phone, app.Env.SMSCountryCode)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number"})
+			return
+		}
+
+		err = checkPhoneCode(c, app.DB, phone, phoneCodeLink, uuid.NullUUID{UUID: userID, Valid: true}, req.Code)
+		if errors.Is(err, errPhoneCodeRefused) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired code"})
+			return
+		}
+		if err != nil {
+			l.ErrorF("Error checking phone code: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link phone number"})
+			return
+		}
+
+		// Another account may have linked it since the code was sent
+		taken, err := phoneLinkedElsewhere(c, app.DB, phone, userID)
+		if err != nil {
+			l.ErrorF("Error checking phone number: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link phone number"})
+			return
+		}
+		if taken {
+			c.JSON(http.StatusConflict, gin.H{"error": "This phone number is linked to another account"})
+			return
+		}
+
+		_, err = app.DB.ExecContext(c, "UPDATE users SET phone_number = $1, phone_verified_at = NOW(), updated = NOW() WHERE id = $2", phone, userID)
+		if err != nil {
+			l.ErrorF("Error linking phone number: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link phone number"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Phone number linked, you can now sign in with it", "phone": phone})
+	}
+}
+
+// phoneLinkedElsewhere reports whether another account signs in with the phone.
+func phoneLinkedElsewhere(ctx context.Context, db *sql.DB, phone string, userID uuid.UUID) (bool, error) {
+	var taken bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE phone_number = $1 AND phone_verified_at IS NOT NULL AND id <> $2)", phone, userID).Scan(&taken)
+	return taken, err
+}
+
+// PurgePhoneCodes deletes the codes sent before before. Recent ones are kept
+// for the rate limits.
+func PurgePhoneCodes(ctx context.Context, db *sql.DB, before time.Time) (int, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM phone_codes WHERE created < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -3,6 +3,7 @@ package auth
 import (
 	"src/pkg/conf"
 	"src/pkg/middleware"
+	"src/pkg/permission"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,11 +13,110 @@ func SetupRouter(path string, r *gin.RouterGroup, config *conf.Config) {
 	{
 		auth_route.POST("/login", Login(config))
 		auth_route.POST("/register", Register(config))
-		auth_route.GET("/google", GoogleLogin(config))
+		auth_route.GET("/google", useProvider("google"), OAuthStart(config))
 		auth_route.POST("/google", GoogleCallbackPOST(config))
-		auth_route.GET("/google/callback", GoogleCallback(config))
+		auth_route.GET("/google/callback", useProvider("google"), OAuthCallback(config))
 		auth_route.POST("/forgot", ForgotPassword(config))
 		auth_route.POST("/reset/:token", ResetPasswordFromToken(config))
+		auth_route.POST("/refresh", RefreshToken(config))
+		auth_route.POST("/logout", Logout(config))
+		auth_route.POST("/verify-email", VerifyEmail(config))
+		auth_route.POST("/unlock", UnlockAccount(config))
+		auth_route.POST("/phone/code", RequestPhoneCode(config))
+		auth_route.POST("/phone/verify", VerifyPhoneCode(config))
+		auth_route.POST("/phone/register", RegisterWithPhone(config))
+
+		auth_route.POST("/phone/link/code",
+			middleware.AuthMiddleware(config),
+			RequestPhoneLinkCode(config),
+		)
+
+		auth_route.POST("/phone/link",
+			middleware.AuthMiddleware(config),
+			LinkPhone(config),
+		)
+
+		auth_route.POST("/verify-email/resend",
+			middleware.AuthMiddleware(config),
+			ResendVerification(config),
+		)
+
+		auth_route.POST("/logout/all",
+			middleware.AuthMiddleware(config),
+			LogoutAll(config),
+		)
+
+		auth_route.POST("/2fa/verify", VerifyTwoFactor(config))
+
+		auth_route.GET("/2fa",
+			middleware.AuthMiddleware(config),
+			TwoFactorStatus(config),
+		)
+
+		// Signed in users, or users enrolling with the setup token of a sign in
+		auth_route.POST("/2fa/setup",
+			middleware.AuthOrNotMiddleware(config),
+			SetupTwoFactor(config),
+		)
+
+		auth_route.POST("/2fa/enable",
+			middleware.AuthOrNotMiddleware(config),
+			EnableTwoFactor(config),
+		)
+
+		auth_route.POST("/2fa/disable",
+			middleware.AuthMiddleware(config),
+			DisableTwoFactor(config),
+		)
+
+		auth_route.POST("/2fa/recovery-codes",
+			middleware.AuthMiddleware(config),
+			RegenerateRecoveryCodes(config),
+		)
+
+		auth_route.GET("/2fa/policy",
+			middleware.AuthMiddleware(config),
+			middleware.RequirePermission(config, permission.SecurityManage),
+			FetchTwoFactorPolicy(config),
+		)
+
+		auth_route.PUT("/2fa/policy",
+			middleware.AuthMiddleware(config),
+			middleware.RequirePermission(config, permission.SecurityManage),
+			UpdateTwoFactorPolicy(config),
+		)
+
+		auth_route.GET("/login-attempts",
+			middleware.AuthMiddleware(config),
+			middleware.RequirePermission(config, permission.SecurityManage),
+			ListLoginAttempts(config),
+		)
+
+		auth_route.GET("/oauth/providers", OAuthProviders(config))
+		auth_route.POST("/oauth/exchange", ExchangeLoginCode(config))
+		auth_route.GET("/oauth/:provider", OAuthStart(config))
+		auth_route.GET("/oauth/:provider/callback", OAuthCallback(config))
+		auth_route.POST("/oauth/:provider/callback", OAuthCallback(config))
+
+		auth_route.GET("/oauth/identities",
+			middleware.AuthMiddleware(config),
+			OAuthIdentities(config),
+		)
+
+		auth_route.POST("/oauth/:provider/link",
+			middleware.AuthMiddleware(config),
+			OAuthLink(config),
+		)
+
+		auth_route.POST("/oauth/link",
+			middleware.AuthMiddleware(config),
+			CompleteOAuthLink(config),
+		)
+
+		auth_route.DELETE("/oauth/:provider",
+			middleware.AuthMiddleware(config),
+			OAuthUnlink(config),
+		)
 
 		auth_route.POST("/reset",
 			middleware.AuthMiddleware(config),
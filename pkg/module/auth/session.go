@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/guregu/null/v5"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/jwtkeys"
+	"src/pkg/middleware"
+)
+
+// A sign in starts a family of refresh tokens. Every refresh revokes the
+// token it used and issues the next one of the family, so a revoked token
+// showing up again means it was stolen and the whole family is revoked.
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// hashToken is how refresh tokens are looked up; only the hash is stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueRefreshToken stores a new refresh token of the family and returns its
+// ID and value.
+func issueRefreshToken(c *gin.Context, db queryRower, app *conf.Config, userID, familyID uuid.UUID) (uuid.UUID, string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return uuid.Nil, "", err
+	}
+	token := hex.EncodeToString(buffer)
+
+	var tokenID uuid.UUID
+	err := db.QueryRowContext(c, `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at, user_agent, client_ip)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`, userID, familyID, hashToken(token), time.Now().Add(app.Env.RefreshTokenTTL), c.Request.UserAgent(), c.ClientIP()).Scan(&tokenID)
+	return tokenID, token, err
+}
+
+// issueTokens signs an access token and starts a new session for the user.
+func issueTokens(c *gin.Context, app *conf.Config, sData middleware.SignedDetails) (string, string, error) {
+	userID, err := uuid.Parse(sData.Uid)
+	if err != nil {
+		return "", "", err
+	}
+	accessToken, err := middleware.GenerateAccessToken(app, sData)
+	if err != nil {
+		return "", "", err
+	}
+	_, refreshToken, err := issueRefreshToken(c, app.DB, app, userID, uuid.New())
+	return accessToken, refreshToken, err
+}
+
+// loadSignedDetails reads the current claims of the user, so a refreshed
+// access token picks up role and merchant changes.
+func loadSignedDetails(ctx context.Context, db queryRower, userID uuid.UUID) (middleware.SignedDetails, error) {
+	sData := middleware.SignedDetails{Uid: userID.String()}
+	var role string
+	err := db.QueryRowContext(ctx, `
+		SELECT u.email, COALESCE(u.first_name, ''), COALESCE(u.last_name, ''), COALESCE(u.phone_number, ''), u.role,
+			COALESCE(m.id::text, s.merchant_id::text, ''), COALESCE(s.role, '')
+		FROM users u
+		LEFT JOIN merchants m ON m.user_id = u.id
+		LEFT JOIN merchant_staff s ON s.user_id = u.id
+		WHERE u.id = $1
+	`, userID).Scan(&sData.Email, &sData.FirstName, &sData.LastName, &sData.Phone, &role, &sData.MerchantID, &sData.MerchantRole)
+	sData.Role = role
+	return sData, err
+}
+
+// revokeUserSessions revokes every refresh token of the user.
+func revokeUserSessions(ctx context.Context, db execer, userID uuid.UUID) error {
+	_, err := db.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	return err
+}
+
+// revokeRoleSessions revokes every refresh token of the users of the role.
+func revokeRoleSessions(ctx context.Context, db execer, role string) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE revoked_at IS NULL AND user_id IN (SELECT id FROM users WHERE role = $1)
+	`, role)
+	return err
+}
+
+// RefreshToken trades a refresh token for a new access token and the next
+// refresh token of the session.
+func RefreshToken(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RefreshRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Refresh token is required"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		var tokenID, userID, familyID uuid.UUID
+		var expiresAt time.Time
+		var revokedAt null.Time
+		err = tx.QueryRowContext(ctx, `
+			SELECT id, user_id, family_id, expires_at, revoked_at FROM refresh_tokens
+			WHERE token_hash = $1
+			FOR UPDATE
+		`, hashToken(req.RefreshToken)).Scan(&tokenID, &userID, &familyID, &expiresAt, &revokedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		if err != nil {
+			l.ErrorF("Error fetching refresh token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+			return
+		}
+
+		if revokedAt.Valid {
+			l.InfoF("Refresh token %s of user %s was reused, revoking its session", tokenID, userID)
+			_, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL", familyID)
+			if err == nil {
+				err = tx.Commit()
+			}
+			if err != nil {
+				l.ErrorF("Error revoking refresh token family: %v", err)
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, please sign in again"})
+			return
+		}
+		if time.Now().After(expiresAt) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired, please sign in again"})
+			return
+		}
+
+		sData, err := loadSignedDetails(ctx, tx, userID)
+		if err != nil {
+			l.ErrorF("Error loading user claims: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+			return
+		}
+
+		nextID, refreshToken, err := issueRefreshToken(c, tx, app, userID, familyID)
+		if err != nil {
+			l.ErrorF("Error issuing refresh token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+			return
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = NOW(), replaced_by = $2 WHERE id = $1", tokenID, nextID); err != nil {
+			l.ErrorF("Error rotating refresh token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+			return
+		}
+
+		accessToken, err := middleware.GenerateAccessToken(app, sData)
+		if err != nil {
+			l.ErrorF("Error generating access token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":      true,
+			"token":        "Bearer " + accessToken,
+			"refreshToken": refreshToken,
+		})
+	}
+}
+
+// Logout ends the session of the given refresh token. Access tokens already
+// handed out stay valid until they expire.
+func Logout(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RefreshRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Refresh token is required"})
+			return
+		}
+
+		_, err := app.DB.ExecContext(c, `
+			UPDATE refresh_tokens SET revoked_at = NOW()
+			WHERE revoked_at IS NULL AND family_id = (SELECT family_id FROM refresh_tokens WHERE token_hash = $1)
+		`, hashToken(req.RefreshToken))
+		if err != nil {
+			l.ErrorF("Error revoking session: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Signed out"})
+	}
+}
+
+// LogoutAll ends every session of the signed in user.
+func LogoutAll(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		if err := revokeUserSessions(c, app.DB, userID); err != nil {
+			l.ErrorF("Error revoking sessions: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Signed out of all devices"})
+	}
+}
+
+// PurgeExpiredRefreshTokens deletes the refresh tokens that expired. Revoked
+// tokens are kept until then to detect reuse.
+func PurgeExpiredRefreshTokens(ctx context.Context, db *sql.DB) (int, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE expires_at < NOW()")
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// JWKS publishes the public keys access tokens are signed with, so other
+// services can verify them. The list is empty when tokens are signed with
+// the shared secret.
+func JWKS(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys := []jwtkeys.JWK{}
+		if app.Keys != nil {
+			keys = app.Keys.JWKS()
+		}
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, gin.H{"keys": keys})
+	}
+}
+
+// SignedTokenLifetime is how long the longest lived token signed here stays
+// valid, be it an access token or a link sent by mail. A retired signing key
+// has to verify tokens at least that long.
+func SignedTokenLifetime(accessTokenTTL time.Duration) time.Duration {
+	return max(accessTokenTTL, verificationLinkLifetime, unlockLinkLifetime,
+		setupLifetime, challengeLifetime, phoneSignupLifetime)
+}
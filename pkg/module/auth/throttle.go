@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/mail"
+	"src/pkg/middleware"
+	"src/pkg/throttle"
+)
+
+// Failed password and second factor checks go through app.LoginGuard, which
+// delays and eventually locks the account, see throttle. Every attempt is
+// kept in login_attempts for auditing.
+
+const unlockLinkLifetime = 24 * time.Hour
+
+// Reasons recorded with login attempts
+const (
+	attemptInvalidPassword = "invalid_password"
+	attemptUnknownEmail    = "unknown_email"
+	attemptInvalidCode     = "invalid_2fa"
+	attemptThrottled       = "throttled"
+	attemptLocked          = "locked"
+	attemptNeedsTwoFactor  = "2fa_required"
+)
+
+type LoginAttempt struct {
+	ID        uuid.UUID     `json:"id"`
+	Email     string        `json:"email"`
+	UserID    uuid.NullUUID `json:"userId"`
+	ClientIP  string        `json:"clientIp"`
+	UserAgent string        `json:"userAgent"`
+	Success   bool          `json:"success"`
+	Reason    string        `json:"reason"`
+	Created   time.Time     `json:"created"`
+}
+
+type UnlockAccountRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// recordLoginAttempt adds the attempt to the audit. Failing to record it
+// does not fail the sign in.
+func recordLoginAttempt(c *gin.Context, app *conf.Config, email string, userID uuid.NullUUID, success bool, reason string) {
+	_, err := app.DB.ExecContext(c, `
+		INSERT INTO login_attempts (email, user_id, client_ip, user_agent, success, reason)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, strings.ToLower(strings.TrimSpace(email)), userID, c.ClientIP(), c.Request.UserAgent(), success, reason)
+	if err != nil {
+		l.ErrorF("Error recording login attempt: %v", err)
+	}
+}
+
+// allowLoginAttempt answers 429 and returns false when the account or the
+// client has to wait before trying again. Otherwise the attempt is counted
+// until loginFailed, loginSucceeded or loginPassed settles it. A broken store
+// does not lock everybody out.
+func allowLoginAttempt(c *gin.Context, app *conf.Config, email string, userID uuid.NullUUID) bool {
+	wait, locked, err := app.LoginGuard.Check(c, email, c.ClientIP())
+	if err != nil {
+		l.ErrorF("Error checking login throttle: %v", err)
+		return true
+	}
+	if wait <= 0 {
+		return true
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	if locked {
+		recordLoginAttempt(c, app, email, userID, false, attemptLocked)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Your account is locked after too many failed attempts, check your email to unlock it", "locked": true})
+		return false
+	}
+	recordLoginAttempt(c, app, email, userID, false, attemptThrottled)
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed attempts, please try again later", "retryAfter": int(math.Ceil(wait.Seconds()))})
+	return false
+}
+
+// loginFailed records a failed attempt, which allowLoginAttempt already
+// counted. When it locks the account of a known user, they get a link to
+// unlock it.
+func loginFailed(c *gin.Context, app *conf.Config, email string, userID uuid.NullUUID, reason string) {
+	recordLoginAttempt(c, app, email, userID, false, reason)
+
+	lockedNow, err := app.LoginGuard.Fail(c, email)
+	if err != nil {
+		l.ErrorF("Error counting failed login: %v", err)
+		return
+	}
+	if !lockedNow || !userID.Valid {
+		return
+	}
+	if err := queueUnlockMail(c, app, userID.UUID); err != nil {
+		l.ErrorF("Error queueing unlock mail: %v", err)
+	}
+}
+
+// loginSucceeded forgets the failures of the account and records the attempt.
+func loginSucceeded(c *gin.Context, app *conf.Config, email string, userID uuid.UUID, reason string) {
+	if err := app.LoginGuard.Succeed(c, email, c.ClientIP()); err != nil {
+		l.ErrorF("Error resetting login throttle: %v", err)
+	}
+	recordLoginAttempt(c, app, email, uuid.NullUUID{UUID: userID, Valid: true}, true, reason)
+}
+
+// loginPassed takes back the attempt allowLoginAttempt counted when it was
+// right but does not finish the sign in, e.g. before the second factor.
+func loginPassed(c *gin.Context, app *conf.Config, email string) {
+	if err := app.LoginGuard.Release(c, email, c.ClientIP()); err != nil {
+		l.ErrorF("Error releasing login throttle: %v", err)
+	}
+}
+
+// queueUnlockMail signs an unlock link for the current email of the user.
+func queueUnlockMail(ctx context.Context, app *conf.Config, userID uuid.UUID) error {
+	var email, firstName string
+	err := app.DB.QueryRowContext(ctx, "SELECT email, COALESCE(first_name, '') FROM users WHERE id = $1", userID).Scan(&email, &firstName)
+	if err != nil {
+		return err
+	}
+
+	token, err := middleware.GenerateToken(app, middleware.SignedDetails{Uid: userID.String(), Email: email},
+		middleware.TokenTypeUnlock, unlockLinkLifetime)
+	if err != nil {
+		return err
+	}
+	return mail.Enqueue(ctx, app.DB, mail.TemplateAccountLocked, email, mail.AccountLockedData{
+		Name:        firstName,
+		LockedUntil: time.Now().Add(throttle.AccountPolicy.LockFor),
+		UnlockURL:   app.Env.ClientURL + "/unlock?token=" + url.QueryEscape(token),
+		ResetURL:    app.Env.ClientURL + "/forgot-password",
+	})
+}
+
+// UnlockAccount lifts the lock with the link mailed when it was set. A link
+// works once.
+func UnlockAccount(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req UnlockAccountRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Token is required"})
+			return
+		}
+
+		claims, err := middleware.ValidateTokenOfType(app, req.Token, middleware.TokenTypeUnlock)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired link"})
+			return
+		}
+		userID, err := uuid.Parse(claims.Uid)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired link"})
+			return
+		}
+
+		var exists bool
+		err = app.DB.QueryRowContext(c, "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND email = $2)", userID, claims.Email).Scan(&exists)
+		if err != nil {
+			l.ErrorF("Error fetching user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock account"})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired link"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		// Each link lifts one lock, a leaked one cannot keep lifting them
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO used_unlock_links (token_hash, expires_at) VALUES ($1, $2)
+			ON CONFLICT (token_hash) DO NOTHING
+		`, hashToken(req.Token), time.Unix(claims.ExpiresAt, 0))
+		if err != nil {
+			l.ErrorF("Error consuming unlock link: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock account"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired link"})
+			return
+		}
+
+		if err := app.LoginGuard.Unlock(ctx, claims.Email); err != nil {
+			l.ErrorF("Error unlocking account: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock account"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock account"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Your account is unlocked, you can sign in again"})
+	}
+}
+
+// ListLoginAttempts returns the sign in audit, newest first. Filters: email,
+// ip, userId and success. Phone sign ins are recorded with the phone number
+// as email.
+func ListLoginAttempts(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if err != nil || limit < 1 {
+			limit = 50
+		}
+
+		var userID uuid.NullUUID
+		if s := c.Query("userId"); s != "" {
+			id, err := uuid.Parse(s)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+				return
+			}
+			userID = uuid.NullUUID{UUID: id, Valid: true}
+		}
+		var success sql.NullBool
+		if s := c.Query("success"); s != "" {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid success filter"})
+				return
+			}
+			success = sql.NullBool{Bool: b, Valid: true}
+		}
+
+		rows, err := app.DB.QueryContext(c, `
+			SELECT id, email, user_id, client_ip, user_agent, success, reason, created
+			FROM login_attempts
+			WHERE ($1 = '' OR email = $1)
+				AND ($2 = '' OR client_ip = $2)
+				AND ($3::uuid IS NULL OR user_id = $3)
+				AND ($4::boolean IS NULL OR success = $4)
+			ORDER BY created DESC
+			LIMIT $5 OFFSET $6
+		`, strings.ToLower(strings.TrimSpace(c.Query("email"))), c.Query("ip"), userID, success, limit, (page-1)*limit)
+		if err != nil {
+			l.ErrorF("Error fetching login attempts: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch login attempts"})
+			return
+		}
+		defer rows.Close()
+
+		attempts := []LoginAttempt{}
+		for rows.Next() {
+			var a LoginAttempt
+			if err := rows.Scan(&a.ID, &a.Email, &a.UserID, &a.ClientIP, &a.UserAgent, &a.Success, &a.Reason, &a.Created); err != nil {
+				l.ErrorF("Error scanning login attempt: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch login attempts"})
+				return
+			}
+			attempts = append(attempts, a)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"attempts": attempts, "page": page, "limit": limit})
+	}
+}
+
+// PurgeLoginAttempts deletes the audit of attempts older than before.
+func PurgeLoginAttempts(ctx context.Context, db *sql.DB, before time.Time) (int, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM login_attempts WHERE created < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// PurgeUsedUnlockLinks forgets the used unlock links that expired anyway.
+func PurgeUsedUnlockLinks(ctx context.Context, db *sql.DB) (int, error) {
+	res, err := db.ExecContext(ctx, "DELETE FROM used_unlock_links WHERE expires_at < NOW()")
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,682 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/guregu/null/v5"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/middleware"
+	"src/pkg/totp"
+)
+
+// Users who enabled two-factor authentication get a short lived challenge
+// token from Login instead of a session, and trade it for one with a TOTP
+// code or one of their recovery codes. Users of a role the policy requires
+// it for, but who did not enroll yet, get a setup token that only lets them
+// enroll.
+
+const (
+	challengeLifetime = 5 * time.Minute
+	setupLifetime     = 15 * time.Minute
+	recoveryCodeCount = 10
+)
+
+var ErrSecondFactorRefused = errors.New("invalid two-factor code")
+
+type TwoFactorSetupRequest struct {
+	SetupToken string `json:"setupToken"` // when not signed in
+}
+
+type TwoFactorEnableRequest struct {
+	Code       string `json:"code" binding:"required"`
+	SetupToken string `json:"setupToken"`
+}
+
+type TwoFactorVerifyRequest struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recoveryCode"`
+}
+
+// TwoFactorCodeRequest confirms a sensitive change with a TOTP or recovery
+// code.
+type TwoFactorCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// twoFactorRequired tells whether the policy requires two-factor
+// authentication for the role.
+func twoFactorRequired(ctx context.Context, db queryRower, role string) (bool, error) {
+	var required bool
+	err := db.QueryRowContext(ctx, "SELECT required FROM two_factor_policy WHERE role = $1", role).Scan(&required)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return required, err
+}
+
+// secondFactorStep returns the token type and token the user needs to finish
+// signing in, or an empty type when the password is enough.
+func secondFactorStep(ctx context.Context, app *conf.Config, userID uuid.UUID, role string) (string, string, error) {
+	var enabled bool
+	err := app.DB.QueryRowContext(ctx, "SELECT totp_enabled_at IS NOT NULL FROM users WHERE id = $1", userID).Scan(&enabled)
+	if err != nil {
+		return "", "", err
+	}
+
+	tokenType, lifetime := middleware.TokenTypeTwoFactorChallenge, challengeLifetime
+	if !enabled {
+		required, err := twoFactorRequired(ctx, app.DB, role)
+		if err != nil || !required {
+			return "", "", err
+		}
+		tokenType, lifetime = middleware.TokenTypeTwoFactorSetup, setupLifetime
+	}
+
+	token, err := middleware.GenerateToken(app, middleware.SignedDetails{Uid: userID.String()}, tokenType, lifetime)
+	return tokenType, token, err
+}
+
+// respondSecondFactor answers a sign in that needs a second step.
+func respondSecondFactor(c *gin.Context, tokenType, token string) {
+	if tokenType == middleware.TokenTypeTwoFactorSetup {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":                  "Your account requires two-factor authentication, please set it up",
+			"twoFactorSetupRequired": true,
+			"setupToken":             token,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "twoFactorRequired": true, "challengeToken": token})
+}
+
+// secondFactorQuery is how the Google redirect hands the second step to the
+// client.
+func secondFactorQuery(tokenType, token string) string {
+	if tokenType == middleware.TokenTypeTwoFactorSetup {
+		return "setupToken=" + token
+	}
+	return "challengeToken=" + token
+}
+
+// normalizeRecoveryCode ignores case, dashes and spaces so codes can be typed
+// the way they read.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// checkSecondFactor consumes a TOTP code, or else a recovery code, of the
+// user. tx must hold the row lock of the user.
+func checkSecondFactor(ctx context.Context, tx *sql.Tx, userID uuid.UUID, code, recoveryCode string) error {
+	if code != "" {
+		var secret null.String
+		var lastStep int64
+		err := tx.QueryRowContext(ctx, "SELECT totp_secret, totp_last_step FROM users WHERE id = $1", userID).Scan(&secret, &lastStep)
+		if err != nil {
+			return err
+		}
+		step, ok := totp.Validate(secret.String, code, time.Now(), lastStep)
+		if !secret.Valid || !ok {
+			return ErrSecondFactorRefused
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE users SET totp_last_step = $2 WHERE id = $1", userID, step)
+		return err
+	}
+
+	if recoveryCode == "" {
+		return ErrSecondFactorRefused
+	}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE recovery_codes SET used_at = NOW()
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, hashToken(normalizeRecoveryCode(recoveryCode)))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrSecondFactorRefused
+	}
+	return nil
+}
+
+// generateRecoveryCodes replaces the recovery codes of the user. Only their
+// hashes are stored, the codes are shown once.
+func generateRecoveryCodes(ctx context.Context, tx *sql.Tx, userID uuid.UUID) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	for len(codes) < recoveryCodeCount {
+		buffer := make([]byte, 5)
+		if _, err := rand.Read(buffer); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(buffer)
+		code = code[:5] + "-" + code[5:]
+		if _, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, hashToken(normalizeRecoveryCode(code))); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// enrollingUser is the signed in user, or the user of the setup token given
+// at sign in.
+func enrollingUser(c *gin.Context, app *conf.Config, setupToken string) (uuid.UUID, bool, error) {
+	if userID, err := uuid.Parse(c.GetString("userID")); err == nil {
+		return userID, false, nil
+	}
+	claims, err := middleware.ValidateTokenOfType(app, setupToken, middleware.TokenTypeTwoFactorSetup)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+	userID, err := uuid.Parse(claims.Uid)
+	return userID, true, err
+}
+
+// TwoFactorStatus tells the signed in user whether 2FA is on.
+func TwoFactorStatus(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		var enabledAt null.Time
+		var role string
+		var codesLeft int
+		err = app.DB.QueryRowContext(c, `
+			SELECT u.totp_enabled_at, u.role,
+				(SELECT COUNT(*) FROM recovery_codes r WHERE r.user_id = u.id AND r.used_at IS NULL)
+			FROM users u
+			WHERE u.id = $1
+		`, userID).Scan(&enabledAt, &role, &codesLeft)
+		if err != nil {
+			l.ErrorF("Error fetching two-factor status: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch two-factor status"})
+			return
+		}
+		required, err := twoFactorRequired(c, app.DB, role)
+		if err != nil {
+			l.ErrorF("Error fetching two-factor policy: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch two-factor status"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"enabled":           enabledAt.Valid,
+			"enabledAt":         enabledAt,
+			"required":          required,
+			"recoveryCodesLeft": codesLeft,
+		})
+	}
+}
+
+// SetupTwoFactor starts enrolling: it returns a new secret and its otpauth
+// URI for the authenticator app. 2FA is only on once EnableTwoFactor confirms
+// a code of the secret.
+func SetupTwoFactor(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req TwoFactorSetupRequest
+		_ = c.ShouldBindJSON(&req) // the body is optional when signed in
+
+		userID, _, err := enrollingUser(c, app, req.SetupToken)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired setup token"})
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			l.ErrorF("Error generating TOTP secret: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set up two-factor authentication"})
+			return
+		}
+
+		var email string
+		err = app.DB.QueryRowContext(c, `
+			UPDATE users SET totp_pending_secret = $2
+			WHERE id = $1 AND totp_enabled_at IS NULL
+			RETURNING email
+		`, userID, secret).Scan(&email)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+			return
+		}
+		if err != nil {
+			l.ErrorF("Error saving TOTP secret: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set up two-factor authentication"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"secret":  secret,
+			"uri":     totp.URI(app.Env.TOTPIssuer, email, secret),
+		})
+	}
+}
+
+// EnableTwoFactor turns 2FA on with a code of the secret being enrolled and
+// returns the recovery codes. Enrolling with a setup token also signs in.
+func EnableTwoFactor(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req TwoFactorEnableRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Code is required"})
+			return
+		}
+
+		userID, viaSetup, err := enrollingUser(c, app, req.SetupToken)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired setup token"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		var pending null.String
+		var enabledAt null.Time
+		err = tx.QueryRowContext(ctx, "SELECT totp_pending_secret, totp_enabled_at FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&pending, &enabledAt)
+		if err != nil {
+			l.ErrorF("Error fetching TOTP secret: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+			return
+		}
+		if enabledAt.Valid {
+			c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+			return
+		}
+		if !pending.Valid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Set up two-factor authentication first"})
+			return
+		}
+		step, ok := totp.Validate(pending.String, req.Code, time.Now(), 0)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+			return
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE users SET totp_secret = totp_pending_secret, totp_pending_secret = NULL, totp_enabled_at = NOW(), totp_last_step = $2
+			WHERE id = $1
+		`, userID, step)
+		if err != nil {
+			l.ErrorF("Error enabling two-factor authentication: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+			return
+		}
+		codes, err := generateRecoveryCodes(ctx, tx, userID)
+		if err != nil {
+			l.ErrorF("Error generating recovery codes: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+			return
+		}
+
+		res := gin.H{"success": true, "message": "Two-factor authentication is enabled", "recoveryCodes": codes}
+		if viaSetup {
+			var token, refreshToken string
+			sData, err := loadSignedDetails(ctx, app.DB, userID)
+			if err == nil {
+				token, refreshToken, err = issueTokens(c, app, sData)
+			}
+			if err != nil {
+				l.ErrorF("Error generating tokens: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+				return
+			}
+			res["token"] = "Bearer " + token
+			res["refreshToken"] = refreshToken
+		}
+		c.JSON(http.StatusOK, res)
+	}
+}
+
+// VerifyTwoFactor finishes signing in with the second factor.
+func VerifyTwoFactor(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req TwoFactorVerifyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Challenge token is required"})
+			return
+		}
+
+		claims, err := middleware.ValidateTokenOfType(app, req.ChallengeToken, middleware.TokenTypeTwoFactorChallenge)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign in expired, please sign in again"})
+			return
+		}
+		userID, err := uuid.Parse(claims.Uid)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign in expired, please sign in again"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		var email string
+		if err := tx.QueryRowContext(ctx, "SELECT email FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&email); err != nil {
+			l.ErrorF("Error locking user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+			return
+		}
+		attemptUser := uuid.NullUUID{UUID: userID, Valid: true}
+		if !allowLoginAttempt(c, app, email, attemptUser) {
+			return
+		}
+		err = checkSecondFactor(ctx, tx, userID, req.Code, req.RecoveryCode)
+		if errors.Is(err, ErrSecondFactorRefused) {
+			loginFailed(c, app, email, attemptUser, attemptInvalidCode)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+		if err != nil {
+			l.ErrorF("Error checking second factor: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+			return
+		}
+
+		sData, err := loadSignedDetails(ctx, tx, userID)
+		if err != nil {
+			l.ErrorF("Error loading user claims: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+			return
+		}
+
+		loginSucceeded(c, app, email, userID, "")
+
+		token, refreshToken, err := issueTokens(c, app, sData)
+		if err != nil {
+			l.ErrorF("Error generating tokens: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+
+		mergeVisitorHistory(c, app, userID)
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":      true,
+			"token":        "Bearer " + token,
+			"refreshToken": refreshToken,
+			"user": gin.H{
+				"id":        userID,
+				"firstName": sData.FirstName,
+				"lastName":  sData.LastName,
+				"email":     sData.Email,
+				"role":      sData.Role,
+			},
+		})
+	}
+}
+
+// confirmSecondFactor checks the code of a TwoFactorCodeRequest for the
+// signed in user within tx, writing the error response when it fails. Wrong
+// codes are throttled like the ones of a sign in.
+func confirmSecondFactor(c *gin.Context, app *conf.Config, tx *sql.Tx, userID uuid.UUID) bool {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return false
+	}
+
+	var email string
+	var enabled bool
+	err := tx.QueryRowContext(c, "SELECT email, totp_enabled_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&email, &enabled)
+	if err != nil {
+		l.ErrorF("Error fetching user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return false
+	}
+	if !enabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is not enabled"})
+		return false
+	}
+
+	attemptUser := uuid.NullUUID{UUID: userID, Valid: true}
+	if !allowLoginAttempt(c, app, email, attemptUser) {
+		return false
+	}
+	err = checkSecondFactor(c, tx, userID, req.Code, req.RecoveryCode)
+	if errors.Is(err, ErrSecondFactorRefused) {
+		loginFailed(c, app, email, attemptUser, attemptInvalidCode)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return false
+	}
+	if err != nil {
+		l.ErrorF("Error checking second factor: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return false
+	}
+	loginPassed(c, app, email)
+	return true
+}
+
+// DisableTwoFactor turns 2FA off, unless the policy requires it for the role
+// of the user.
+func DisableTwoFactor(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		var role string
+		if err := tx.QueryRowContext(ctx, "SELECT role FROM users WHERE id = $1", userID).Scan(&role); err != nil {
+			l.ErrorF("Error fetching user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+			return
+		}
+		required, err := twoFactorRequired(ctx, tx, role)
+		if err != nil {
+			l.ErrorF("Error fetching two-factor policy: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+			return
+		}
+		if required {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for your account"})
+			return
+		}
+
+		if !confirmSecondFactor(c, app, tx, userID) {
+			return
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE users SET totp_secret = NULL, totp_pending_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0
+			WHERE id = $1
+		`, userID)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			l.ErrorF("Error disabling two-factor authentication: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Two-factor authentication is disabled"})
+	}
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the signed in user.
+func RegenerateRecoveryCodes(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		if !confirmSecondFactor(c, app, tx, userID) {
+			return
+		}
+
+		codes, err := generateRecoveryCodes(ctx, tx, userID)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			l.ErrorF("Error generating recovery codes: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "recoveryCodes": codes})
+	}
+}
+
+// FetchTwoFactorPolicy lists which roles must use 2FA.
+func FetchTwoFactorPolicy(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policy := make(map[string]bool)
+		rows, err := app.DB.QueryContext(c, `
+			SELECT r.name, COALESCE(p.required, FALSE)
+			FROM roles r
+			LEFT JOIN two_factor_policy p ON p.role = r.name
+			WHERE r.scope = 'global'
+		`)
+		if err != nil {
+			l.ErrorF("Error fetching two-factor policy: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch two-factor policy"})
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var role string
+			var required bool
+			if err := rows.Scan(&role, &required); err != nil {
+				l.ErrorF("Error scanning two-factor policy: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch two-factor policy"})
+				return
+			}
+			policy[role] = required
+		}
+
+		c.JSON(http.StatusOK, gin.H{"policy": policy})
+	}
+}
+
+// UpdateTwoFactorPolicy sets which roles must use 2FA, e.g.
+// {"ROLE ADMIN": true}. Users of a role that now requires it are signed out
+// of every device and asked to enroll the next time they sign in.
+func UpdateTwoFactorPolicy(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req map[string]bool
+		if err := c.ShouldBindJSON(&req); err != nil || len(req) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		for role, required := range req {
+			var exists bool
+			err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM roles WHERE name = $1 AND scope = 'global')", role).Scan(&exists)
+			if err != nil {
+				l.ErrorF("Error checking role: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update two-factor policy"})
+				return
+			}
+			if !exists {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role " + role})
+				return
+			}
+
+			wasRequired, err := twoFactorRequired(ctx, tx, role)
+			if err != nil {
+				l.ErrorF("Error fetching two-factor policy: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update two-factor policy"})
+				return
+			}
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO two_factor_policy (role, required, updated) VALUES ($1, $2, NOW())
+				ON CONFLICT (role) DO UPDATE SET required = EXCLUDED.required, updated = NOW()
+			`, role, required)
+			if err == nil && required && !wasRequired {
+				err = revokeRoleSessions(ctx, tx, role)
+			}
+			if err != nil {
+				l.ErrorF("Error updating two-factor policy: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update two-factor policy"})
+				return
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update two-factor policy"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Two-factor policy updated"})
+	}
+}
@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/mail"
+	"src/pkg/middleware"
+)
+
+// Accounts registered with a password start unverified and get a signed link
+// to confirm their address. Unverified users can sign in and browse but not
+// order or apply as a merchant, see middleware.VerifiedEmail.
+
+const (
+	verificationLinkLifetime = 48 * time.Hour
+	verificationResendDelay  = time.Minute // between two verification mails
+	verificationMailsPerDay  = 5
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// queueVerificationMail signs a verification link for the current email of
+// the user and queues it. The link stops working if the email changes.
+func queueVerificationMail(ctx context.Context, db execer, app *conf.Config, userID uuid.UUID, email, name string) error {
+	token, err := middleware.GenerateToken(app, middleware.SignedDetails{Uid: userID.String(), Email: email},
+		middleware.TokenTypeEmailVerification, verificationLinkLifetime)
+	if err != nil {
+		return err
+	}
+	return mail.Enqueue(ctx, db, mail.TemplateEmailVerification, email, mail.EmailVerificationData{
+		Name:      name,
+		VerifyURL: app.Env.ClientURL + "/verify-email?token=" + url.QueryEscape(token),
+		ExpiresAt: time.Now().Add(verificationLinkLifetime),
+	})
+}
+
+// markEmailVerified verifies the email of the user, keeping the first time
+// it was verified.
+func markEmailVerified(ctx context.Context, db execer, userID uuid.UUID) error {
+	_, err := db.ExecContext(ctx, "UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()) WHERE id = $1", userID)
+	return err
+}
+
+// VerifyEmail confirms the email address of the link.
+func VerifyEmail(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req VerifyEmailRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Token is required"})
+			return
+		}
+
+		claims, err := middleware.ValidateTokenOfType(app, req.Token, middleware.TokenTypeEmailVerification)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired link"})
+			return
+		}
+		userID, err := uuid.Parse(claims.Uid)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired link"})
+			return
+		}
+
+		res, err := app.DB.ExecContext(c, `
+			UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW())
+			WHERE id = $1 AND email = $2
+		`, userID, claims.Email)
+		if err != nil {
+			l.ErrorF("Error verifying email: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired link"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Your email address is verified"})
+	}
+}
+
+// ResendVerification sends a new verification link to the signed in user.
+func ResendVerification(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		var email, firstName string
+		var verified bool
+		err = app.DB.QueryRowContext(c, "SELECT email, COALESCE(first_name, ''), email_verified_at IS NOT NULL FROM users WHERE id = $1", userID).
+			Scan(&email, &firstName, &verified)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			l.ErrorF("Error fetching user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification mail"})
+			return
+		}
+		if verified {
+			c.JSON(http.StatusConflict, gin.H{"error": "Your email address is already verified"})
+			return
+		}
+
+		// The outbox already records every verification mail sent
+		var sent int
+		var last sql.NullTime
+		err = app.DB.QueryRowContext(c, `
+			SELECT COUNT(*), MAX(created) FROM mail_outbox
+			WHERE template = $1 AND recipient = $2 AND created > NOW() - INTERVAL '1 day'
+		`, mail.TemplateEmailVerification, email).Scan(&sent, &last)
+		if err != nil {
+			l.ErrorF("Error counting verification mails: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification mail"})
+			return
+		}
+		if sent >= verificationMailsPerDay || (last.Valid && time.Since(last.Time) < verificationResendDelay) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "A verification mail was sent recently, please check your inbox or try again later"})
+			return
+		}
+
+		if err := queueVerificationMail(c, app.DB, app, userID, email, firstName); err != nil {
+			l.ErrorF("Error queueing verification mail: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send verification mail"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "A new verification link is on its way"})
+	}
+}
@@ -57,7 +57,7 @@ func ListBrands(app *conf.Config) gin.HandlerFunc {
 		query := `
 		SELECT 
 			id, name, slug, image, content_type, description, is_active, updated, created
-		FROM brands WHERE is_active = TRUE
+		FROM brands WHERE is_active = TRUE AND deleted_at IS NULL
 		`
 		rows, err := app.DB.QueryContext(c, query)
 		if err != nil {
@@ -101,9 +101,7 @@ func GetBrandByID(app *conf.Config) gin.HandlerFunc {
 			SELECT
 				id, name, slug, image, content_type, description, is_active, updated, created
 			FROM brands
-			WHERE id = $1
-
-
+			WHERE id = $1 AND deleted_at IS NULL
 		`
 		err = app.DB.QueryRowContext(c, query, brandID).Scan(&fetchedBrand.ID, &fetchedBrand.Name, &fetchedBrand.Slug, &fetchedBrand.Image, &fetchedBrand.ContentType, &fetchedBrand.Description, &fetchedBrand.IsActive, &fetchedBrand.Updated, &fetchedBrand.Created)
 		if err != nil {
@@ -123,7 +121,7 @@ func GetBrandByID(app *conf.Config) gin.HandlerFunc {
 
 func ListSelectBrands(app *conf.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		rows, err := app.DB.QueryContext(c, "SELECT id, name FROM brands") // Select only necessary fields
+		rows, err := app.DB.QueryContext(c, "SELECT id, name FROM brands WHERE deleted_at IS NULL") // Select only necessary fields
 		if err != nil {
 			l.ErrorF("Error querying brands: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch brands"})
@@ -214,7 +212,7 @@ func UpdateBrand(app *conf.Config) gin.HandlerFunc {
 		}
 
 		// Add brandID for WHERE clause
-		updateQuery := fmt.Sprintf("UPDATE brands SET %s WHERE id = $%d",
+		updateQuery := fmt.Sprintf("UPDATE brands SET %s WHERE id = $%d AND deleted_at IS NULL",
 			strings.Join(updateFields, ", "), len(args)+1)
 		args = append(args, brandID)
 
@@ -279,7 +277,7 @@ func UpdateBrandActive(app *conf.Config) gin.HandlerFunc {
 		_, err = app.DB.ExecContext(c, `
 			UPDATE brands 
 			SET is_active = $1, updated = $2  
-			WHERE id = $3
+			WHERE id = $3 AND deleted_at IS NULL
 		`, updateBrand.IsActive, time.Now(), brandID)
 		if err != nil {
 			l.DebugF("Error updating brand status: %v", err)
@@ -301,7 +299,15 @@ func DeleteBrand(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
-		result, err := app.DB.ExecContext(c, "DELETE FROM brands WHERE id = $1", brandID)
+		// Deleted brands stay in the trash until purged, products keep theirs
+		var deletedBy uuid.NullUUID
+		if userID, err := uuid.Parse(c.GetString("userID")); err == nil {
+			deletedBy = uuid.NullUUID{UUID: userID, Valid: true}
+		}
+		result, err := app.DB.ExecContext(c, `
+			UPDATE brands SET deleted_at = NOW(), deleted_by = $1, updated = NOW()
+			WHERE id = $2 AND deleted_at IS NULL
+		`, deletedBy, brandID)
 		if err != nil {
 			l.DebugF("Error deleting brand: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete brand"})
@@ -320,7 +326,7 @@ func DeleteBrand(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Brand deleted successfully"})
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Brand moved to trash"})
 
 	}
 }
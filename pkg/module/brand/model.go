@@ -27,3 +27,13 @@ type BrandUpdate struct {
 	Description *string `db:"description" json:"description"`
 	IsActive    *bool   `db:"is_active" json:"isActive"`
 }
+
+// TrashedBrand is a deleted brand waiting to be restored or purged.
+type TrashedBrand struct {
+	ID         uuid.UUID     `json:"_id"`
+	Name       string        `json:"name"`
+	Slug       string        `json:"slug"`
+	DeletedAt  time.Time     `json:"deletedAt"`
+	DeletedBy  uuid.NullUUID `json:"deletedBy"`
+	PurgeAfter time.Time     `json:"purgeAfter"`
+}
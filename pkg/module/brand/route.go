@@ -1,9 +1,9 @@
 package brand
 
 import (
-	"src/common"
 	"src/pkg/conf"
 	"src/pkg/middleware"
+	"src/pkg/permission"
 
 	"github.com/gin-gonic/gin"
 )
@@ -14,9 +14,14 @@ func SetupRouter(path string, r *gin.RouterGroup, config *conf.Config) {
 	{
 		brand_route.POST("/add",
 			middleware.AuthMiddleware(config),
-			middleware.RoleCheck(common.RoleAdmin, common.RoleMerchant),
+			middleware.RequirePermission(config, permission.BrandWrite),
 			AddBrand(config)) // Done
 
+		brand_route.GET("/trash",
+			middleware.AuthMiddleware(config),
+			middleware.RequirePermission(config, permission.BrandRestore),
+			FetchBrandTrash(config))
+
 		brand_route.GET("/list", ListBrands(config))              // Done
 		brand_route.GET("", ListBrands(config))                   // Done
 		brand_route.GET("/:id", GetBrandByID(config))             // Done
@@ -24,17 +29,22 @@ func SetupRouter(path string, r *gin.RouterGroup, config *conf.Config) {
 
 		brand_route.PUT("/:id",
 			middleware.AuthMiddleware(config),
-			middleware.RoleCheck(common.RoleAdmin, common.RoleMerchant),
+			middleware.RequirePermission(config, permission.BrandWrite),
 			UpdateBrand(config)) // Done
 
 		brand_route.PUT("/:id/active",
 			middleware.AuthMiddleware(config),
-			middleware.RoleCheck(common.RoleAdmin, common.RoleMerchant),
+			middleware.RequirePermission(config, permission.BrandWrite),
 			UpdateBrandActive(config)) // Done
 
 		brand_route.DELETE("/delete/:id",
 			middleware.AuthMiddleware(config),
-			middleware.RoleCheck(common.RoleAdmin, common.RoleMerchant),
+			middleware.RequirePermission(config, permission.BrandWrite),
 			DeleteBrand(config)) // Done
+
+		brand_route.POST("/:id/restore",
+			middleware.AuthMiddleware(config),
+			middleware.RequirePermission(config, permission.BrandRestore),
+			RestoreBrand(config))
 	}
 }
@@ -0,0 +1,94 @@
+package brand
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"src/l"
+	"src/pkg/conf"
+)
+
+func FetchBrandTrash(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit < 1 {
+			limit = 20
+		}
+
+		rows, err := app.DB.QueryContext(c, `
+			SELECT id, name, slug, deleted_at, deleted_by
+			FROM brands
+			WHERE deleted_at IS NOT NULL
+			ORDER BY deleted_at DESC
+			LIMIT $1 OFFSET $2
+		`, limit, (page-1)*limit)
+		if err != nil {
+			l.ErrorF("Error fetching deleted brands: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
+			return
+		}
+		defer rows.Close()
+
+		retention := time.Duration(app.Env.TrashRetentionDays) * 24 * time.Hour
+		brands := []TrashedBrand{}
+		for rows.Next() {
+			var b TrashedBrand
+			if err := rows.Scan(&b.ID, &b.Name, &b.Slug, &b.DeletedAt, &b.DeletedBy); err != nil {
+				l.ErrorF("Error scanning deleted brand: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
+				return
+			}
+			b.PurgeAfter = b.DeletedAt.Add(retention)
+			brands = append(brands, b)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"brands": brands, "page": page, "limit": limit})
+	}
+}
+
+func RestoreBrand(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		brandID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid brand ID"})
+			return
+		}
+
+		result, err := app.DB.ExecContext(c, `
+			UPDATE brands SET deleted_at = NULL, deleted_by = NULL, updated = NOW()
+			WHERE id = $1 AND deleted_at IS NOT NULL
+		`, brandID)
+		if err != nil {
+			l.ErrorF("Error restoring brand: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore brand"})
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Brand not found in trash"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Brand restored successfully"})
+	}
+}
+
+// PurgeDeletedBrands removes the brands deleted before the given time. Their
+// products are left without a brand.
+func PurgeDeletedBrands(ctx context.Context, db *sql.DB, before time.Time) (int, error) {
+	result, err := db.ExecContext(ctx, "DELETE FROM brands WHERE deleted_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
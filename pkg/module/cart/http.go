@@ -38,6 +38,17 @@ func CreateCart(app *conf.Config) gin.HandlerFunc {
 		}
 		defer tx.Rollback() // Defer rollback in case of any errors
 
+		listed, err := isProductListed(ctx, tx, cartProduct.ProductID)
+		if err != nil {
+			l.ErrorF("Error checking product: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify product existence"})
+			return
+		}
+		if !listed {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Product not found"})
+			return
+		}
+
 		newCartID := uuid.New()
 		_, err = tx.ExecContext(ctx, "INSERT INTO carts (id, user_id, created, updated) VALUES ($1, $2, $3, $4)", newCartID, userID, time.Now(), time.Now())
 		if err != nil {
@@ -50,7 +61,7 @@ func CreateCart(app *conf.Config) gin.HandlerFunc {
 
 		_, err = tx.ExecContext(ctx, `
 			INSERT INTO cart_items (id, cart_id, product_id, quantity, purchase_price, created, updated)
-			SELECT $1, $2, $3, $4, p.price, $5, $6  -- Get price directly from products table
+			SELECT $1, $2, $3, $4, `+product.EffectivePriceSQL("p")+`, $5, $6  -- Current price, including running sales
 			FROM products p
 			WHERE p.id = $3
 		`, newCartItemID, newCartID, cartProduct.ProductID, cartProduct.Quantity, time.Now(), time.Now())
@@ -170,6 +181,17 @@ func AddProductToCart(app *conf.Config) gin.HandlerFunc {
 		}
 		defer tx.Rollback() // Defer rollback
 
+		listed, err := isProductListed(ctx, tx, cartItem.ProductID)
+		if err != nil {
+			l.ErrorF("Error checking product: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify product existence"})
+			return
+		}
+		if !listed {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Product not found"})
+			return
+		}
+
 		// Check if cart exists and belongs to the user
 		var cartExists bool
 
@@ -203,7 +225,7 @@ func AddProductToCart(app *conf.Config) gin.HandlerFunc {
 			newCartItemID := uuid.New()
 			_, err = tx.ExecContext(ctx, `
 				INSERT INTO cart_items (id, cart_id, product_id, quantity, purchase_price, created, updated)
-				SELECT $1, $2, $3, $4, p.price, $5, $6 
+				SELECT $1, $2, $3, $4, `+product.EffectivePriceSQL("p")+`, $5, $6
 				FROM products p
 				WHERE p.id = $3
 			`, newCartItemID, cartID, cartItem.ProductID, cartItem.Quantity, time.Now(), time.Now())
@@ -335,7 +357,7 @@ func AddProductToCartV2(app *conf.Config) gin.HandlerFunc {
 
 		var productExists bool
 
-		err = app.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM products WHERE id = $1)", cartItem.ProductID).Scan(&productExists)
+		err = app.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM products WHERE id = $1 AND status = $2 AND deleted_at IS NULL)", cartItem.ProductID, product.StatusPublished).Scan(&productExists)
 		if err != nil {
 
 			l.ErrorF("Error checking product existence: %v", err)
@@ -374,7 +396,7 @@ func AddProductToCartV2(app *conf.Config) gin.HandlerFunc {
 
 			// Insert cart item
 			_, err = tx.ExecContext(ctx, `INSERT INTO cart_items (cart_id, product_id, quantity, purchase_price, created, updated)
-											SELECT $1, $2, $3, p.price, $4, $5
+											SELECT $1, $2, $3, `+product.EffectivePriceSQL("p")+`, $4, $5
 											FROM products p
 											WHERE p.id = $2`, cartID, cartItem.ProductID, cartItem.Quantity, time.Now(), time.Now())
 
@@ -422,7 +444,7 @@ func AddProductToCartV2(app *conf.Config) gin.HandlerFunc {
 
 					_, err = tx.ExecContext(ctx, `
 						INSERT INTO cart_items (cart_id, product_id, quantity, purchase_price, created, updated)
-						SELECT $1, $2, $3, p.price, $4, $5
+						SELECT $1, $2, $3, `+product.EffectivePriceSQL("p")+`, $4, $5
 						FROM products p
 						WHERE p.id = $2
 					`, cartID, cartItem.ProductID, cartItem.Quantity, time.Now(), time.Now())
@@ -542,7 +564,7 @@ func GetCartByCartID(app *conf.Config) gin.HandlerFunc {
 
 		rows, err := tx.QueryContext(ctx, `
 			SELECT 
-				p.id, p.sku, p.name, p.slug, p.image_url, p.description AS product_quantity, p.price,
+				p.id, p.sku, p.name, p.slug, p.image_url, p.description AS product_quantity, `+product.EffectivePriceSQL("p")+` AS price,
 				ci.quantity AS cart_item_quantity
 			FROM products p
 			JOIN cart_items ci ON p.id = ci.product_id
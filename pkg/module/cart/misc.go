@@ -6,6 +6,13 @@ import (
 	"errors"
 
 	"github.com/google/uuid"
+
+	"src/pkg/module/product"
+)
+
+var (
+	ErrProductNotFound = errors.New("product not found")
+	ErrCartNotOwned    = errors.New("cart does not belong to the user")
 )
 
 // checkCartOwnership validates cart access rights
@@ -32,18 +39,26 @@ func checkCartOwnership(tx *sql.Tx, ctx context.Context, cartID uuid.UUID, userI
 	return !dbUserID.Valid, nil
 }
 
+// isProductListed reports whether the product is published and so can be
+// bought.
+func isProductListed(ctx context.Context, tx *sql.Tx, productID uuid.UUID) (bool, error) {
+	var listed bool
+	err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM products WHERE id = $1 AND status = $2 AND deleted_at IS NULL)", productID, product.StatusPublished).Scan(&listed)
+	return listed, err
+}
+
 // updateCartItem handles item insertion/update
 func updateCartItem(tx *sql.Tx, ctx context.Context, cartID, productID uuid.UUID, quantity int, action string) error {
 	// Verify product exists and get price
 	var price float64
 	err := tx.QueryRowContext(ctx,
-		"SELECT price FROM products WHERE id = $1",
-		productID,
+		"SELECT "+product.EffectivePriceSQL("p")+" FROM products p WHERE p.id = $1 AND p.status = $2 AND p.deleted_at IS NULL",
+		productID, product.StatusPublished,
 	).Scan(&price)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return errors.New("product not found")
+			return ErrProductNotFound
 		}
 		return err
 	}
@@ -76,9 +91,42 @@ func updateCartItem(tx *sql.Tx, ctx context.Context, cartID, productID uuid.UUID
 
 		_, err = tx.ExecContext(ctx, `
 			UPDATE cart_items 
-			SET quantity = $1, updated = NOW()
+			SET quantity = $1, purchase_price = $4, updated = NOW()
 			WHERE cart_id = $2 AND product_id = $3
-		`, newQty, cartID, productID)
+		`, newQty, cartID, productID, price)
 		return err
 	}
 }
+
+// AddToUserCart adds quantity units of the product to the user's cart, or to
+// a new cart when cartID is nil, and returns the cart used.
+func AddToUserCart(ctx context.Context, tx *sql.Tx, cartID, userID, productID uuid.UUID, quantity int) (uuid.UUID, error) {
+	if cartID == uuid.Nil {
+		cartID = uuid.New()
+		if _, err := tx.ExecContext(ctx, "INSERT INTO carts (id, user_id) VALUES ($1, $2)", cartID, userID); err != nil {
+			return uuid.Nil, err
+		}
+	} else {
+		owned, err := checkCartOwnership(tx, ctx, cartID, userID)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		if !owned {
+			return uuid.Nil, ErrCartNotOwned
+		}
+	}
+	return cartID, updateCartItem(tx, ctx, cartID, productID, quantity, "increment")
+}
+
+// RefreshCartPrices updates the purchase price of every item in the cart to
+// the current effective product price, so sales that started or ended since
+// the item was added are picked up.
+func RefreshCartPrices(ctx context.Context, tx *sql.Tx, cartID uuid.UUID) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE cart_items ci
+		SET purchase_price = `+product.EffectivePriceSQL("p")+`, updated = NOW()
+		FROM products p
+		WHERE p.id = ci.product_id AND ci.cart_id = $1
+			AND ci.purchase_price <> `+product.EffectivePriceSQL("p"), cartID)
+	return err
+}
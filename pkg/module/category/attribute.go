@@ -0,0 +1,367 @@
+package category
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/guregu/null/v5"
+	"github.com/lib/pq"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/misc"
+)
+
+const attributeColumns = `ca.id, ca.category_id, ca.name, ca.slug, ca.type, ca.unit, ca.allowed_values, ca.is_filterable,
+	ca.is_required, ca.sort_order, ca.updated, ca.created`
+
+// Queryer is implemented by both *sql.DB and *sql.Tx
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func scanAttribute(row interface{ Scan(...any) error }, attribute *Attribute) error {
+	return row.Scan(&attribute.ID, &attribute.CategoryID, &attribute.Name, &attribute.Slug, &attribute.Type, &attribute.Unit,
+		pq.Array(&attribute.AllowedValues), &attribute.IsFilterable, &attribute.IsRequired, &attribute.SortOrder,
+		&attribute.Updated, &attribute.Created)
+}
+
+func queryAttributes(ctx context.Context, db Queryer, query string, args ...any) ([]Attribute, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attributes := []Attribute{}
+	for rows.Next() {
+		var attribute Attribute
+		if err := scanAttribute(rows, &attribute); err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, attribute)
+	}
+	return attributes, rows.Err()
+}
+
+// CategoryAttributes returns the attributes defined on the given categories
+// and on all of their ancestors.
+func CategoryAttributes(ctx context.Context, db Queryer, categoryIDs []uuid.UUID) ([]Attribute, error) {
+	return queryAttributes(ctx, db, `
+		SELECT `+attributeColumns+`
+		FROM category_attributes ca
+		WHERE ca.category_id IN (
+			SELECT anc.id FROM categories c
+			JOIN categories anc ON c.path LIKE anc.path || '%'
+			WHERE c.id = ANY($1) AND c.deleted_at IS NULL AND anc.deleted_at IS NULL
+		)
+		ORDER BY ca.sort_order, ca.name
+	`, pq.Array(categoryIDs))
+}
+
+// ProductAttributes returns the attributes that apply to a product through
+// the categories it belongs to.
+func ProductAttributes(ctx context.Context, db Queryer, productID uuid.UUID) ([]Attribute, error) {
+	return queryAttributes(ctx, db, `
+		SELECT `+attributeColumns+`
+		FROM category_attributes ca
+		WHERE ca.category_id IN (
+			SELECT anc.id FROM product_categories pc
+			JOIN categories c ON c.id = pc.category_id
+			JOIN categories anc ON c.path LIKE anc.path || '%'
+			WHERE pc.product_id = $1 AND c.deleted_at IS NULL AND anc.deleted_at IS NULL
+		)
+		ORDER BY ca.sort_order, ca.name
+	`, productID)
+}
+
+// Validate converts a decoded JSON value into a typed attribute value.
+func (a Attribute) Validate(value interface{}) (AttributeValue, error) {
+	result := AttributeValue{AttributeID: a.ID}
+
+	switch a.Type {
+	case AttributeText:
+		s, ok := value.(string)
+		if !ok {
+			return result, fmt.Errorf("%s must be a text value", a.Name)
+		}
+		result.Text = null.StringFrom(strings.TrimSpace(s))
+	case AttributeEnum:
+		s, ok := value.(string)
+		if !ok || !slices.Contains(a.AllowedValues, s) {
+			return result, fmt.Errorf("%s must be one of %s", a.Name, strings.Join(a.AllowedValues, ", "))
+		}
+		result.Text = null.StringFrom(s)
+	case AttributeNumber:
+		n, ok := value.(float64)
+		if !ok {
+			return result, fmt.Errorf("%s must be a number", a.Name)
+		}
+		result.Number = null.FloatFrom(n)
+	case AttributeBoolean:
+		b, ok := value.(bool)
+		if !ok {
+			return result, fmt.Errorf("%s must be true or false", a.Name)
+		}
+		result.Boolean = null.BoolFrom(b)
+	default:
+		return result, fmt.Errorf("%s has an unknown type", a.Name)
+	}
+	return result, nil
+}
+
+// ValidateAttributes checks product attribute values keyed by attribute slug
+// against the schema. A nil value clears the attribute. With requireAll set,
+// every required attribute of the schema has to be present.
+func ValidateAttributes(schema []Attribute, values map[string]interface{}, requireAll bool) ([]AttributeValue, []uuid.UUID, error) {
+	bySlug := make(map[string]Attribute, len(schema))
+	for _, attribute := range schema {
+		bySlug[attribute.Slug] = attribute
+	}
+
+	set := []AttributeValue{}
+	cleared := []uuid.UUID{}
+	for slug, value := range values {
+		attribute, ok := bySlug[slug]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown attribute %q for the product categories", slug)
+		}
+		if value == nil {
+			if attribute.IsRequired {
+				return nil, nil, fmt.Errorf("%s is required", attribute.Name)
+			}
+			cleared = append(cleared, attribute.ID)
+			continue
+		}
+		v, err := attribute.Validate(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		set = append(set, v)
+	}
+
+	if requireAll {
+		for _, attribute := range schema {
+			if _, ok := values[attribute.Slug]; attribute.IsRequired && !ok {
+				return nil, nil, fmt.Errorf("%s is required", attribute.Name)
+			}
+		}
+	}
+	return set, cleared, nil
+}
+
+// validateAttributeDefinition normalizes an attribute before it is stored.
+func validateAttributeDefinition(attribute *Attribute) error {
+	switch attribute.Type {
+	case AttributeEnum:
+		if len(attribute.AllowedValues) == 0 {
+			return errors.New("enum attributes need allowed values")
+		}
+	case AttributeText, AttributeNumber, AttributeBoolean:
+		attribute.AllowedValues = []string{}
+	default:
+		return errors.New("type must be one of text, number, boolean or enum")
+	}
+	if attribute.Slug == "" {
+		attribute.Slug = misc.GenerateSlug(attribute.Name)
+	}
+	return nil
+}
+
+func ListCategoryAttributes(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		categoryID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+			return
+		}
+
+		attributes, err := CategoryAttributes(c, app.DB, []uuid.UUID{categoryID})
+		if err != nil {
+			l.ErrorF("Error querying category attributes: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attributes"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"attributes": attributes})
+	}
+}
+
+func AddCategoryAttribute(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		categoryID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+			return
+		}
+
+		var req Attribute
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+		if err := validateAttributeDefinition(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var path string
+		err = app.DB.QueryRowContext(c, "SELECT path FROM categories WHERE id = $1 AND deleted_at IS NULL", categoryID).Scan(&path)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Category not found"})
+			} else {
+				l.ErrorF("Failed to fetch category: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch category"})
+			}
+			return
+		}
+
+		// A slug has to be unique along the whole branch, otherwise product
+		// values could not be told apart.
+		var slugInUse bool
+		err = app.DB.QueryRowContext(c, `
+			SELECT EXISTS(
+				SELECT 1 FROM category_attributes ca
+				JOIN categories c ON c.id = ca.category_id
+				WHERE ca.slug = $1 AND ($2 LIKE c.path || '%' OR c.path LIKE $2 || '%')
+			)
+		`, req.Slug, path).Scan(&slugInUse)
+		if err != nil {
+			l.ErrorF("Error checking attribute slug: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add attribute"})
+			return
+		}
+		if slugInUse {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Attribute slug already in use in this category branch"})
+			return
+		}
+
+		req.ID = uuid.New()
+		req.CategoryID = categoryID
+		req.Created = time.Now()
+		req.Updated = req.Created
+
+		_, err = app.DB.ExecContext(c, `
+			INSERT INTO category_attributes (id, category_id, name, slug, type, unit, allowed_values, is_filterable, is_required, sort_order, updated, created)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		`, req.ID, req.CategoryID, req.Name, req.Slug, req.Type, req.Unit, pq.Array(req.AllowedValues), req.IsFilterable,
+			req.IsRequired, req.SortOrder, req.Updated, req.Created)
+		if err != nil {
+			l.ErrorF("Error inserting category attribute: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add attribute"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Attribute added successfully", "attribute": req})
+	}
+}
+
+func UpdateCategoryAttribute(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		attributeID, err := uuid.Parse(c.Param("attributeId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attribute ID"})
+			return
+		}
+
+		var req AttributeUpdate
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+
+		updateQuery := "UPDATE category_attributes ca SET updated = $1"
+		args := []interface{}{time.Now()}
+		argIndex := 2
+
+		if req.Name != nil {
+			updateQuery += fmt.Sprintf(", name = $%d", argIndex)
+			args = append(args, *req.Name)
+			argIndex++
+		}
+		if req.Unit != nil {
+			updateQuery += fmt.Sprintf(", unit = $%d", argIndex)
+			args = append(args, *req.Unit)
+			argIndex++
+		}
+		if req.AllowedValues != nil {
+			// Only enum attributes keep a list of allowed values
+			updateQuery += fmt.Sprintf(", allowed_values = CASE WHEN type = 'enum' THEN $%d::text[] ELSE allowed_values END", argIndex)
+			args = append(args, pq.Array(*req.AllowedValues))
+			argIndex++
+		}
+		if req.IsFilterable != nil {
+			updateQuery += fmt.Sprintf(", is_filterable = $%d", argIndex)
+			args = append(args, *req.IsFilterable)
+			argIndex++
+		}
+		if req.IsRequired != nil {
+			updateQuery += fmt.Sprintf(", is_required = $%d", argIndex)
+			args = append(args, *req.IsRequired)
+			argIndex++
+		}
+		if req.SortOrder != nil {
+			updateQuery += fmt.Sprintf(", sort_order = $%d", argIndex)
+			args = append(args, *req.SortOrder)
+			argIndex++
+		}
+
+		updateQuery += fmt.Sprintf(" WHERE id = $%d RETURNING "+attributeColumns, argIndex)
+		args = append(args, attributeID)
+
+		var attribute Attribute
+		err = scanAttribute(app.DB.QueryRowContext(c, updateQuery, args...), &attribute)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Attribute not found"})
+			} else {
+				l.ErrorF("Error updating category attribute: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update attribute"})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Attribute updated successfully", "attribute": attribute})
+	}
+}
+
+func DeleteCategoryAttribute(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		attributeID, err := uuid.Parse(c.Param("attributeId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attribute ID"})
+			return
+		}
+
+		// Product values are removed together with the attribute (ON DELETE CASCADE)
+		res, err := app.DB.ExecContext(c, "DELETE FROM category_attributes WHERE id = $1", attributeID)
+		if err != nil {
+			l.ErrorF("Error deleting category attribute: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete attribute"})
+			return
+		}
+
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			l.ErrorF("Error getting rows affected: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete attribute"})
+			return
+		}
+		if rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Attribute not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Attribute deleted successfully"})
+	}
+}
@@ -0,0 +1,41 @@
+package category
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestValidateAttributes(t *testing.T) {
+	schema := []Attribute{
+		{ID: uuid.New(), Name: "RAM", Slug: "ram", Type: AttributeNumber, IsRequired: true},
+		{ID: uuid.New(), Name: "Material", Slug: "material", Type: AttributeEnum, AllowedValues: []string{"Cotton", "Wool"}},
+		{ID: uuid.New(), Name: "Waterproof", Slug: "waterproof", Type: AttributeBoolean},
+	}
+
+	set, cleared, err := ValidateAttributes(schema, map[string]interface{}{"ram": 8.0, "material": "Cotton", "waterproof": nil}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set) != 2 || len(cleared) != 1 || cleared[0] != schema[2].ID {
+		t.Errorf("unexpected result %v %v", set, cleared)
+	}
+
+	bad := []map[string]interface{}{
+		{"ram": "8GB"},
+		{"ram": 8.0, "material": "Silk"},
+		{"ram": 8.0, "colour": "red"},
+		{"material": "Wool"}, // ram is required
+		{"ram": nil},
+	}
+	for _, values := range bad {
+		if _, _, err := ValidateAttributes(schema, values, true); err == nil {
+			t.Errorf("expected %v to be rejected", values)
+		}
+	}
+
+	// Partial updates do not need every required attribute
+	if _, _, err := ValidateAttributes(schema, map[string]interface{}{"waterproof": true}, false); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -31,10 +32,42 @@ func AddCategory(app *conf.Config) gin.HandlerFunc {
 
 		newCategoryID := uuid.New()
 
-		_, err := app.DB.ExecContext(c, `
-			INSERT INTO categories (id, name, slug, description, is_active, updated, created)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)
-		`, newCategoryID, req.Name, req.Slug, req.Description, req.IsActive, time.Now(), time.Now())
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Failed to begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		// Same lock as MoveCategory, so the parent path cannot change before the insert
+		if _, err := tx.ExecContext(ctx, "LOCK TABLE categories IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+			l.ErrorF("Failed to lock categories: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add category"})
+			return
+		}
+
+		parentPath := "/"
+		if req.ParentID.Valid {
+			err := tx.QueryRowContext(ctx, "SELECT path FROM categories WHERE id = $1 AND deleted_at IS NULL", req.ParentID.UUID).Scan(&parentPath)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					c.JSON(http.StatusNotFound, gin.H{"error": "Parent category not found"})
+				} else {
+					l.ErrorF("Failed to fetch parent category: %v", err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch parent category"})
+				}
+				return
+			}
+		}
+		req.Path = childPath(parentPath, newCategoryID)
+		req.Depth = strings.Count(req.Path, "/") - 2
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO categories (id, name, slug, description, is_active, parent_id, path, depth, updated, created)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		`, newCategoryID, req.Name, req.Slug, req.Description, req.IsActive, req.ParentID, req.Path, req.Depth, time.Now(), time.Now())
 
 		if err != nil {
 			l.DebugF("Error inserting category: %v", err)
@@ -42,6 +75,12 @@ func AddCategory(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Failed to commit transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add category"})
+			return
+		}
+
 		req.ID = newCategoryID
 
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Category added successfully", "category": req})
@@ -52,8 +91,8 @@ func ListCategories(app *conf.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		rows, err := app.DB.QueryContext(c, `SELECT 
-			id, name, slug, description, is_active, updated, created  
-			FROM categories WHERE is_active = TRUE`)
+			`+categoryColumns+`
+			FROM categories WHERE is_active = TRUE AND deleted_at IS NULL ORDER BY path`)
 		if err != nil {
 			l.DebugF("Error querying categories: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
@@ -64,7 +103,7 @@ func ListCategories(app *conf.Config) gin.HandlerFunc {
 		categories := []Category{}
 		for rows.Next() {
 			var category Category
-			if err := rows.Scan(&category.ID, &category.Name, &category.Slug, &category.Description, &category.IsActive, &category.Updated, &category.Created); err != nil {
+			if err := scanCategory(rows, &category); err != nil {
 				l.DebugF("Error scanning category: %v", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
 				return
@@ -80,7 +119,7 @@ func ListCategories(app *conf.Config) gin.HandlerFunc {
 func FetchCategories(app *conf.Config) gin.HandlerFunc { // ... similar to ListCategories, remove is_active = TRUE filter }
 	return func(c *gin.Context) {
 
-		rows, err := app.DB.QueryContext(c, "SELECT id, name, slug, description, is_active, updated, created FROM categories")
+		rows, err := app.DB.QueryContext(c, "SELECT "+categoryColumns+" FROM categories WHERE deleted_at IS NULL ORDER BY path")
 		if err != nil {
 			l.DebugF("Error querying categories: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
@@ -91,7 +130,7 @@ func FetchCategories(app *conf.Config) gin.HandlerFunc { // ... similar to ListC
 		categories := []Category{}
 		for rows.Next() {
 			var category Category
-			if err := rows.Scan(&category.ID, &category.Name, &category.Slug, &category.Description, &category.IsActive, &category.Updated, &category.Created); err != nil {
+			if err := scanCategory(rows, &category); err != nil {
 				l.DebugF("Error scanning category: %v", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
 				return
@@ -116,8 +155,7 @@ func FetchCategory(app *conf.Config) gin.HandlerFunc {
 
 		var category Category
 
-		err = app.DB.QueryRowContext(c, "SELECT id, name, slug, description, is_active, updated, created FROM categories WHERE id = $1", categoryID).
-			Scan(&category.ID, &category.Name, &category.Slug, &category.Description, &category.IsActive, &category.Updated, &category.Created)
+		err = scanCategory(app.DB.QueryRowContext(c, "SELECT "+categoryColumns+" FROM categories WHERE id = $1 AND deleted_at IS NULL", categoryID), &category)
 		if err != nil {
 
 			if errors.Is(err, sql.ErrNoRows) { // Correct error check
@@ -200,7 +238,7 @@ func UpdateCategory(app *conf.Config) gin.HandlerFunc {
 			args = append(args, *updateData.IsActive)
 		}
 
-		updateQuery += " WHERE id = $6"
+		updateQuery += " WHERE id = $6 AND deleted_at IS NULL"
 		args = append(args, categoryID)
 
 		_, err = tx.ExecContext(ctx, updateQuery, args...)
@@ -245,7 +283,7 @@ func UpdateCategoryStatus(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
-		_, err = app.DB.ExecContext(c, "UPDATE categories SET is_active = $1, updated = $2 WHERE id = $3", req.IsActive, time.Now(), categoryID)
+		_, err = app.DB.ExecContext(c, "UPDATE categories SET is_active = $1, updated = $2 WHERE id = $3 AND deleted_at IS NULL", req.IsActive, time.Now(), categoryID)
 		if err != nil {
 			l.ErrorF("Failed to update category status: %v", err) // Log the error
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update category status"})
@@ -278,21 +316,47 @@ func DeleteCategory(app *conf.Config) gin.HandlerFunc {
 
 		defer tx.Rollback()
 
-		_, err = tx.ExecContext(ctx, "DELETE FROM product_categories WHERE category_id = $1", categoryID) // Delete related products from junction table first.
+		// Sub categories are moved up to the parent of the deleted category
+		var path string
+		var depth int
+		var parentID uuid.NullUUID
+		err = tx.QueryRowContext(ctx, "SELECT path, depth, parent_id FROM categories WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", categoryID).Scan(&path, &depth, &parentID)
 		if err != nil {
-
-			l.ErrorF("Failed to delete category from junction table: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category from products"})
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Category not found"})
+			} else {
+				l.ErrorF("Failed to fetch category: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch category"})
+			}
 			return
+		}
 
+		_, err = tx.ExecContext(ctx, "UPDATE categories SET parent_id = $1 WHERE parent_id = $2", parentID, categoryID)
+		if err != nil {
+			l.ErrorF("Failed to reparent sub categories: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
+			return
 		}
 
-		res, err := tx.ExecContext(ctx, "DELETE FROM categories WHERE id = $1", categoryID)
+		if err := moveSubtree(ctx, tx, path, strings.TrimSuffix(path, categoryID.String()+"/")); err != nil {
+			l.ErrorF("Failed to update sub category paths: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
+			return
+		}
 
+		// The category keeps its own place in the tree and its products, so a
+		// restore puts it back as a leaf under the same parent
+		var deletedBy uuid.NullUUID
+		if userID, err := uuid.Parse(c.GetString("userID")); err == nil {
+			deletedBy = uuid.NullUUID{UUID: userID, Valid: true}
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE categories SET path = $1, depth = $2, deleted_at = NOW(), deleted_by = $3, updated = NOW()
+			WHERE id = $4
+		`, path, depth, deletedBy, categoryID)
 		if err != nil {
 			l.DebugF("Failed to delete category: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category"})
-
 			return
 		}
 
@@ -303,20 +367,7 @@ func DeleteCategory(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
-		rowsAffected, err := res.RowsAffected()
-
-		if rowsAffected == 0 {
-
-			c.JSON(http.StatusNotFound, gin.H{"message": "Category not found"})
-			return
-		}
-		if err != nil {
-
-			l.DebugF("Error getting rows affected: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get rows affected"})
-			return
-		}
-		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Category has been deleted successfully!"})
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Category moved to trash"})
 	}
 
 }
@@ -344,7 +395,7 @@ func AddProductToCategory(app *conf.Config) gin.HandlerFunc {
 
 		// Check if product exists
 		var productID uuid.UUID
-		err = app.DB.QueryRowContext(c, "SELECT id FROM products WHERE id = $1", productId).Scan(&productID)
+		err = app.DB.QueryRowContext(c, "SELECT id FROM products WHERE id = $1 AND deleted_at IS NULL", productId).Scan(&productID)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
@@ -359,7 +410,7 @@ func AddProductToCategory(app *conf.Config) gin.HandlerFunc {
 		var categoryID []uuid.UUID
 		query := `
 		select id from categories 
-			where id = any($1::uuid[])
+			where id = any($1::uuid[]) and deleted_at is null
 		`
 		// l.DebugF("caregories, %#v", req.Categories)
 		rows, err := app.DB.QueryContext(c, query, pq.Array(req.Categories))
@@ -450,6 +501,23 @@ func RemoveProductFromCategory(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
+		// Drop attribute values that no longer apply through any remaining category
+		_, err = app.DB.ExecContext(c, `
+			DELETE FROM product_attribute_values pav
+			WHERE pav.product_id = $1 AND pav.attribute_id NOT IN (
+				SELECT ca.id FROM category_attributes ca
+				JOIN categories anc ON anc.id = ca.category_id
+				JOIN categories c ON c.path LIKE anc.path || '%'
+				JOIN product_categories pc ON pc.category_id = c.id
+				WHERE pc.product_id = $1
+			)
+		`, productId)
+		if err != nil {
+			l.ErrorF("Failed to remove product attribute values: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove product from category"})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Product removed from category"})
 	}
 }
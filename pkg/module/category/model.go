@@ -4,16 +4,20 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/guregu/null/v5"
 )
 
 type Category struct {
-	ID          uuid.UUID `db:"id" json:"Id"`
-	Name        string    `db:"name" json:"name" binding:"required"`
-	Slug        string    `db:"slug" json:"slug"`
-	Description string    `db:"description" json:"description,omitempty"`
-	IsActive    bool      `db:"is_active" json:"isActive,omitempty"`
-	Updated     time.Time `db:"updated" json:"updated,omitempty"`
-	Created     time.Time `db:"created" json:"created,omitempty"`
+	ID          uuid.UUID     `db:"id" json:"Id"`
+	Name        string        `db:"name" json:"name" binding:"required"`
+	Slug        string        `db:"slug" json:"slug"`
+	Description string        `db:"description" json:"description,omitempty"`
+	IsActive    bool          `db:"is_active" json:"isActive,omitempty"`
+	ParentID    uuid.NullUUID `db:"parent_id" json:"parentId"`
+	Path        string        `db:"path" json:"-"`
+	Depth       int           `db:"depth" json:"depth"`
+	Updated     time.Time     `db:"updated" json:"updated,omitempty"`
+	Created     time.Time     `db:"created" json:"created,omitempty"`
 }
 
 type CategoryUpdate struct { // Struct for partial updates
@@ -22,3 +26,69 @@ type CategoryUpdate struct { // Struct for partial updates
 	Description *string `json:"description"`
 	IsActive    *bool   `json:"isActive"`
 }
+
+// CategoryNode is a category together with its sub categories.
+type CategoryNode struct {
+	Category
+	Children []*CategoryNode `json:"children"`
+}
+
+// TrashedCategory is a deleted category waiting to be restored or purged.
+type TrashedCategory struct {
+	ID         uuid.UUID     `json:"Id"`
+	Name       string        `json:"name"`
+	Slug       string        `json:"slug"`
+	ParentID   uuid.NullUUID `json:"parentId"`
+	DeletedAt  time.Time     `json:"deletedAt"`
+	DeletedBy  uuid.NullUUID `json:"deletedBy"`
+	PurgeAfter time.Time     `json:"purgeAfter"`
+}
+
+type MoveCategoryRequest struct {
+	ParentID uuid.NullUUID `json:"parentId"` // null moves the category to the top level
+}
+
+type AttributeType string
+
+const (
+	AttributeText    AttributeType = "text"
+	AttributeNumber  AttributeType = "number"
+	AttributeBoolean AttributeType = "boolean"
+	AttributeEnum    AttributeType = "enum"
+)
+
+// Attribute describes a specification products of a category can carry,
+// e.g. "RAM" measured in "GB". Sub categories inherit the attributes of
+// their ancestors.
+type Attribute struct {
+	ID            uuid.UUID     `db:"id" json:"id"`
+	CategoryID    uuid.UUID     `db:"category_id" json:"categoryId"`
+	Name          string        `db:"name" json:"name" binding:"required"`
+	Slug          string        `db:"slug" json:"slug"`
+	Type          AttributeType `db:"type" json:"type" binding:"required"`
+	Unit          string        `db:"unit" json:"unit"`
+	AllowedValues []string      `db:"allowed_values" json:"allowedValues"`
+	IsFilterable  bool          `db:"is_filterable" json:"isFilterable"`
+	IsRequired    bool          `db:"is_required" json:"isRequired"`
+	SortOrder     int           `db:"sort_order" json:"sortOrder"`
+	Updated       time.Time     `db:"updated" json:"updated,omitempty"`
+	Created       time.Time     `db:"created" json:"created,omitempty"`
+}
+
+type AttributeUpdate struct { // Type and slug are fixed once products use the attribute
+	Name          *string   `json:"name"`
+	Unit          *string   `json:"unit"`
+	AllowedValues *[]string `json:"allowedValues"`
+	IsFilterable  *bool     `json:"isFilterable"`
+	IsRequired    *bool     `json:"isRequired"`
+	SortOrder     *int      `json:"sortOrder"`
+}
+
+// AttributeValue is a validated product value for one attribute. Exactly one
+// of the value fields is set, matching the attribute type.
+type AttributeValue struct {
+	AttributeID uuid.UUID
+	Text        null.String
+	Number      null.Float
+	Boolean     null.Bool
+}
@@ -1,9 +1,9 @@
 package category
 
 import (
-	"src/common"
 	"src/pkg/conf"
 	"src/pkg/middleware"
+	"src/pkg/permission"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,7 +13,7 @@ func SetupRoute(path string, r *gin.RouterGroup, app *conf.Config) {
 	{
 		category_route.POST("/add",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleAdmin),
+			middleware.RequirePermission(app, permission.CategoryWrite),
 			AddCategory(app))
 
 		category_route.GET("/list",
@@ -21,31 +21,67 @@ func SetupRoute(path string, r *gin.RouterGroup, app *conf.Config) {
 
 		category_route.GET("", FetchCategories(app))
 
+		category_route.GET("/trash",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.CategoryWrite),
+			FetchCategoryTrash(app))
+
+		category_route.GET("/tree", CategoryTree(app))
+
+		category_route.GET("/:id/breadcrumb", CategoryBreadcrumb(app))
+
+		category_route.GET("/:id/attributes", ListCategoryAttributes(app))
+
+		category_route.POST("/:id/attributes",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.CategoryWrite),
+			AddCategoryAttribute(app))
+
+		category_route.PUT("/attributes/:attributeId",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.CategoryWrite),
+			UpdateCategoryAttribute(app))
+
+		category_route.DELETE("/attributes/:attributeId",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.CategoryWrite),
+			DeleteCategoryAttribute(app))
+
 		category_route.GET("/:id", FetchCategory(app))
 
 		category_route.PUT("/:id",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleAdmin),
+			middleware.RequirePermission(app, permission.CategoryWrite),
 			UpdateCategory(app))
 
+		category_route.PUT("/:id/parent",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.CategoryWrite),
+			MoveCategory(app))
+
 		category_route.PUT("/:id/active",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleAdmin),
+			middleware.RequirePermission(app, permission.CategoryWrite),
 			UpdateCategoryStatus(app))
 
 		category_route.DELETE("/delete/:id",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleAdmin),
+			middleware.RequirePermission(app, permission.CategoryWrite),
 			DeleteCategory(app))
 
+		category_route.POST("/:id/restore",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.CategoryWrite),
+			RestoreCategory(app))
+
 		category_route.PUT("/product/:product_id/add",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleAdmin),
+			middleware.RequirePermission(app, permission.CategoryWrite),
 			AddProductToCategory(app))
 
 		category_route.DELETE("/:category_id/product/:product_id",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleAdmin),
+			middleware.RequirePermission(app, permission.CategoryWrite),
 			RemoveProductFromCategory(app),
 		)
 
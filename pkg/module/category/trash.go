@@ -0,0 +1,136 @@
+package category
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"src/l"
+	"src/pkg/conf"
+)
+
+func FetchCategoryTrash(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit < 1 {
+			limit = 20
+		}
+
+		rows, err := app.DB.QueryContext(c, `
+			SELECT id, name, slug, parent_id, deleted_at, deleted_by
+			FROM categories
+			WHERE deleted_at IS NOT NULL
+			ORDER BY deleted_at DESC
+			LIMIT $1 OFFSET $2
+		`, limit, (page-1)*limit)
+		if err != nil {
+			l.ErrorF("Error fetching deleted categories: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
+			return
+		}
+		defer rows.Close()
+
+		retention := time.Duration(app.Env.TrashRetentionDays) * 24 * time.Hour
+		categories := []TrashedCategory{}
+		for rows.Next() {
+			var category TrashedCategory
+			if err := rows.Scan(&category.ID, &category.Name, &category.Slug, &category.ParentID, &category.DeletedAt, &category.DeletedBy); err != nil {
+				l.ErrorF("Error scanning deleted category: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
+				return
+			}
+			category.PurgeAfter = category.DeletedAt.Add(retention)
+			categories = append(categories, category)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"categories": categories, "page": page, "limit": limit})
+	}
+}
+
+// RestoreCategory puts a deleted category back as a leaf under its parent.
+// Sub categories it had before were moved up on delete and stay where they are.
+func RestoreCategory(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		categoryID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Failed to begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		var parentID uuid.NullUUID
+		err = tx.QueryRowContext(ctx, "SELECT parent_id FROM categories WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE", categoryID).Scan(&parentID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Category not found in trash"})
+			} else {
+				l.ErrorF("Failed to fetch deleted category: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore category"})
+			}
+			return
+		}
+
+		parentPath := "/"
+		if parentID.Valid {
+			err = tx.QueryRowContext(ctx, "SELECT path FROM categories WHERE id = $1 AND deleted_at IS NULL FOR SHARE", parentID.UUID).Scan(&parentPath)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					c.JSON(http.StatusConflict, gin.H{"error": "Restore the parent category first"})
+				} else {
+					l.ErrorF("Failed to fetch parent category: %v", err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore category"})
+				}
+				return
+			}
+		}
+		path := childPath(parentPath, categoryID)
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE categories SET path = $1, depth = $2, deleted_at = NULL, deleted_by = NULL, updated = NOW()
+			WHERE id = $3
+		`, path, strings.Count(path, "/")-2, categoryID)
+		if err != nil {
+			l.ErrorF("Error restoring category: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore category"})
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Category restored successfully"})
+	}
+}
+
+// PurgeDeletedCategories removes the categories deleted before the given
+// time together with their attributes and product links.
+func PurgeDeletedCategories(ctx context.Context, db *sql.DB, before time.Time) (int, error) {
+	result, err := db.ExecContext(ctx, "DELETE FROM categories WHERE deleted_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,259 @@
+package category
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"src/l"
+	"src/pkg/conf"
+)
+
+// Categories form a tree through parent_id. Every row also keeps a
+// materialized path of its ancestor ids ("/<root>/<child>/<self>/") so that a
+// whole subtree can be selected with a single prefix match.
+
+const categoryColumns = "id, name, slug, description, is_active, parent_id, path, depth, updated, created"
+
+func scanCategory(row interface{ Scan(...any) error }, category *Category) error {
+	return row.Scan(&category.ID, &category.Name, &category.Slug, &category.Description, &category.IsActive,
+		&category.ParentID, &category.Path, &category.Depth, &category.Updated, &category.Created)
+}
+
+// childPath returns the materialized path of a category placed under parentPath.
+func childPath(parentPath string, id uuid.UUID) string {
+	if parentPath == "" {
+		parentPath = "/"
+	}
+	return parentPath + id.String() + "/"
+}
+
+// pathIDs returns the ids stored in a materialized path, root first.
+func pathIDs(path string) ([]uuid.UUID, error) {
+	ids := []uuid.UUID{}
+	for _, part := range strings.Split(strings.Trim(path, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		id, err := uuid.Parse(part)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// buildTree nests categories under their parents. Categories whose parent is
+// not part of the given list are left out, so hiding a category hides its
+// subtree as well.
+func buildTree(categories []Category) []*CategoryNode {
+	nodes := make(map[uuid.UUID]*CategoryNode, len(categories))
+	for _, category := range categories {
+		nodes[category.ID] = &CategoryNode{Category: category, Children: []*CategoryNode{}}
+	}
+
+	roots := []*CategoryNode{}
+	for _, category := range categories {
+		node := nodes[category.ID]
+		if !category.ParentID.Valid {
+			roots = append(roots, node)
+			continue
+		}
+		if parent, ok := nodes[category.ParentID.UUID]; ok {
+			parent.Children = append(parent.Children, node)
+		}
+	}
+	return roots
+}
+
+// SubtreeFilter is an SQL condition matching category ids that are the
+// category with the slug in placeholder or any of its descendants.
+func SubtreeFilter(column string, placeholder string) string {
+	return column + ` IN (
+		SELECT sub.id FROM categories sub
+		JOIN categories root ON sub.path LIKE root.path || '%'
+		WHERE root.slug = ` + placeholder + ` AND root.deleted_at IS NULL AND sub.deleted_at IS NULL)`
+}
+
+// moveSubtree rewrites the path and depth of a category and all of its
+// descendants after the category got a new parent.
+func moveSubtree(ctx context.Context, tx *sql.Tx, oldPath, newPath string) error {
+	depthDelta := strings.Count(newPath, "/") - strings.Count(oldPath, "/")
+	_, err := tx.ExecContext(ctx, `
+		UPDATE categories
+		SET path = $1 || substring(path from length($2) + 1), depth = depth + $3, updated = $4
+		WHERE path LIKE $2 || '%'
+	`, newPath, oldPath, depthDelta, time.Now())
+	return err
+}
+
+func CategoryTree(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rows, err := app.DB.QueryContext(c, "SELECT "+categoryColumns+" FROM categories WHERE is_active = TRUE AND deleted_at IS NULL ORDER BY depth, name")
+		if err != nil {
+			l.DebugF("Error querying categories: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+			return
+		}
+		defer rows.Close()
+
+		categories := []Category{}
+		for rows.Next() {
+			var category Category
+			if err := scanCategory(rows, &category); err != nil {
+				l.DebugF("Error scanning category: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+				return
+			}
+			categories = append(categories, category)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"categories": buildTree(categories)})
+	}
+}
+
+// CategoryBreadcrumb returns the chain of categories from the root down to
+// the requested one. The category can be given by id or by slug.
+func CategoryBreadcrumb(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idOrSlug := c.Param("id")
+
+		query := "SELECT path FROM categories WHERE slug = $1 AND deleted_at IS NULL"
+		var arg interface{} = idOrSlug
+		if categoryID, err := uuid.Parse(idOrSlug); err == nil {
+			query = "SELECT path FROM categories WHERE id = $1 AND deleted_at IS NULL"
+			arg = categoryID
+		}
+
+		var path string
+		err := app.DB.QueryRowContext(c, query, arg).Scan(&path)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Category not found"})
+			} else {
+				l.ErrorF("Failed to fetch category: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch category"})
+			}
+			return
+		}
+
+		ids, err := pathIDs(path)
+		if err != nil {
+			l.ErrorF("Invalid category path %q: %v", path, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch category"})
+			return
+		}
+
+		rows, err := app.DB.QueryContext(c, "SELECT "+categoryColumns+" FROM categories WHERE id = ANY($1) ORDER BY depth", pq.Array(ids))
+		if err != nil {
+			l.ErrorF("Error querying categories: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+			return
+		}
+		defer rows.Close()
+
+		breadcrumb := []Category{}
+		for rows.Next() {
+			var category Category
+			if err := scanCategory(rows, &category); err != nil {
+				l.ErrorF("Error scanning category: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories"})
+				return
+			}
+			breadcrumb = append(breadcrumb, category)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"breadcrumb": breadcrumb})
+	}
+}
+
+func MoveCategory(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		categoryID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID"})
+			return
+		}
+
+		var req MoveCategoryRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Failed to begin transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		// Lock the whole table so concurrent moves cannot create a cycle
+		if _, err := tx.ExecContext(ctx, "LOCK TABLE categories IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+			l.ErrorF("Failed to lock categories: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move category"})
+			return
+		}
+
+		var oldPath string
+		err = tx.QueryRowContext(ctx, "SELECT path FROM categories WHERE id = $1 AND deleted_at IS NULL", categoryID).Scan(&oldPath)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Category not found"})
+			} else {
+				l.ErrorF("Failed to fetch category: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch category"})
+			}
+			return
+		}
+
+		parentPath := "/"
+		if req.ParentID.Valid {
+			err = tx.QueryRowContext(ctx, "SELECT path FROM categories WHERE id = $1 AND deleted_at IS NULL", req.ParentID.UUID).Scan(&parentPath)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					c.JSON(http.StatusNotFound, gin.H{"error": "Parent category not found"})
+				} else {
+					l.ErrorF("Failed to fetch parent category: %v", err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch parent category"})
+				}
+				return
+			}
+			if strings.HasPrefix(parentPath, oldPath) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "A category cannot be moved below itself"})
+				return
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE categories SET parent_id = $1 WHERE id = $2", req.ParentID, categoryID)
+		if err != nil {
+			l.ErrorF("Error updating category parent: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move category"})
+			return
+		}
+
+		if err := moveSubtree(ctx, tx, oldPath, childPath(parentPath, categoryID)); err != nil {
+			l.ErrorF("Error updating category paths: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move category"})
+			return
+		}
+
+		if err = tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Category moved successfully"})
+	}
+}
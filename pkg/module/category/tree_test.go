@@ -0,0 +1,36 @@
+package category
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestBuildTree(t *testing.T) {
+	root, child, grandchild, orphan := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	rootPath := childPath("", root)
+	childP := childPath(rootPath, child)
+
+	categories := []Category{
+		{ID: root, Path: rootPath},
+		{ID: child, ParentID: uuid.NullUUID{UUID: root, Valid: true}, Path: childP},
+		{ID: grandchild, ParentID: uuid.NullUUID{UUID: child, Valid: true}, Path: childPath(childP, grandchild)},
+		{ID: orphan, ParentID: uuid.NullUUID{UUID: uuid.New(), Valid: true}},
+	}
+
+	tree := buildTree(categories)
+	if len(tree) != 1 || tree[0].ID != root {
+		t.Fatalf("expected a single root, got %d", len(tree))
+	}
+	if len(tree[0].Children) != 1 || len(tree[0].Children[0].Children) != 1 {
+		t.Fatalf("unexpected nesting")
+	}
+
+	ids, err := pathIDs(categories[2].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 3 || ids[0] != root || ids[2] != grandchild {
+		t.Errorf("unexpected path ids %v", ids)
+	}
+}
@@ -0,0 +1,514 @@
+package contact
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/middleware"
+	"src/pkg/permission"
+)
+
+// SubmitContact opens a ticket from the contact form. Guests get an access
+// token to follow the ticket; signed in users see it in their tickets.
+func SubmitContact(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ContactRequest
+		if err := c.ShouldBind(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var userID uuid.NullUUID
+		if id, err := uuid.Parse(c.GetString("userID")); err == nil {
+			userID = uuid.NullUUID{UUID: id, Valid: true}
+			if req.Email == "" {
+				req.Email = c.GetString("email")
+			}
+			if req.Name == "" {
+				req.Name = strings.TrimSpace(c.GetString("firstname") + " " + c.GetString("lastname"))
+			}
+		}
+		if req.Email == "" || req.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Name and email are required"})
+			return
+		}
+
+		var orderID, productID uuid.NullUUID
+		if req.OrderID != "" {
+			id, err := uuid.Parse(req.OrderID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+				return
+			}
+			if !userID.Valid {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Sign in to ask about an order"})
+				return
+			}
+			orderID = uuid.NullUUID{UUID: id, Valid: true}
+		}
+		if req.ProductID != "" {
+			id, err := uuid.Parse(req.ProductID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+				return
+			}
+			productID = uuid.NullUUID{UUID: id, Valid: true}
+		}
+
+		files, ok := attachmentFiles(c)
+		if !ok {
+			return
+		}
+
+		ctx := c.Request.Context()
+		// Attachments are stored first and removed again unless the ticket
+		// is committed
+		uploads, err := uploadAttachments(app, files)
+		saved := false
+		defer func() {
+			if !saved {
+				discardAttachments(context.WithoutCancel(ctx), app, uploads)
+			}
+		}()
+		if err != nil {
+			l.ErrorF("Error uploading attachments: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+			return
+		}
+
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		limited, err := rateLimited(ctx, tx, c.ClientIP(), req.Email)
+		if err != nil {
+			l.ErrorF("Error checking contact rate limit: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+			return
+		}
+		if limited {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many messages, please try again later"})
+			return
+		}
+
+		if orderID.Valid {
+			var owned bool
+			err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM orders WHERE id = $1 AND user_id = $2)", orderID.UUID, userID.UUID).Scan(&owned)
+			if err != nil {
+				l.ErrorF("Error checking order: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+				return
+			}
+			if !owned {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+				return
+			}
+		}
+		if productID.Valid {
+			var exists bool
+			err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM products WHERE id = $1 AND deleted_at IS NULL)", productID.UUID).Scan(&exists)
+			if err != nil {
+				l.ErrorF("Error checking product: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+				return
+			}
+			if !exists {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+				return
+			}
+		}
+
+		merchantID, err := ticketMerchant(ctx, tx, orderID, productID)
+		if err != nil {
+			l.ErrorF("Error finding ticket merchant: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+			return
+		}
+
+		token, err := generateAccessToken()
+		if err != nil {
+			l.ErrorF("Error generating ticket token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+			return
+		}
+
+		var ticket Ticket
+		err = scanTicket(tx.QueryRowContext(ctx, `
+			INSERT INTO support_tickets AS t (user_id, name, email, subject, order_id, product_id, merchant_id, access_token, client_ip)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING `+ticketColumns,
+			userID, req.Name, req.Email, req.Subject, orderID, productID, merchantID, token, c.ClientIP()), &ticket)
+		if err != nil {
+			l.ErrorF("Error creating ticket: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+			return
+		}
+
+		if err := addMessage(ctx, tx, ticket.ID, userID, AuthorCustomer, req.Message, uploads); err != nil {
+			l.ErrorF("Error adding ticket message: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+			return
+		}
+		saved = true
+
+		c.JSON(http.StatusCreated, gin.H{
+			"success":     true,
+			"message":     "Your message has been sent",
+			"ticket":      ticket,
+			"accessToken": ticket.AccessToken,
+		})
+	}
+}
+
+// ListMyTickets returns the tickets of the signed in user, most recently
+// active first.
+func ListMyTickets(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit < 1 {
+			limit = 20
+		}
+
+		rows, err := app.DB.QueryContext(c, `
+			SELECT `+ticketColumns+` FROM support_tickets t
+			WHERE t.user_id = $1
+			ORDER BY t.last_message_at DESC
+			LIMIT $2 OFFSET $3
+		`, userID, limit, (page-1)*limit)
+		if err != nil {
+			l.ErrorF("Error fetching tickets: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tickets"})
+			return
+		}
+		defer rows.Close()
+
+		tickets := []Ticket{}
+		for rows.Next() {
+			var t Ticket
+			if err := scanTicket(rows, &t); err != nil {
+				l.ErrorF("Error scanning ticket: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tickets"})
+				return
+			}
+			tickets = append(tickets, t)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"tickets": tickets, "page": page, "limit": limit})
+	}
+}
+
+// Inbox lists tickets for support staff. Merchants only see the tickets
+// about their products and orders. Filters: status, assignee (me, none or a
+// user ID), orderId, productId, merchantId (admins) and q, which searches the
+// subject, name and email.
+func Inbox(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit < 1 {
+			limit = 20
+		}
+
+		status := TicketStatus(c.Query("status"))
+		if status != "" && !status.Valid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+			return
+		}
+
+		var merchantID, assigneeID, orderID, productID uuid.NullUUID
+		scope, ok := middleware.MerchantScope(c, app, permission.SupportReply)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
+			return
+		}
+		if scope != uuid.Nil {
+			merchantID = uuid.NullUUID{UUID: scope, Valid: true}
+		} else if merchantID, err = parseOptionalUUID(c.Query("merchantId")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
+			return
+		}
+
+		unassigned := false
+		switch assignee := c.Query("assignee"); assignee {
+		case "me":
+			assigneeID = uuid.NullUUID{UUID: userID, Valid: true}
+		case "none":
+			unassigned = true
+		default:
+			if assigneeID, err = parseOptionalUUID(assignee); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid assignee"})
+				return
+			}
+		}
+		if orderID, err = parseOptionalUUID(c.Query("orderId")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+			return
+		}
+		if productID, err = parseOptionalUUID(c.Query("productId")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		rows, err := app.DB.QueryContext(c, `
+			SELECT `+ticketColumns+` FROM support_tickets t
+			WHERE ($1::uuid IS NULL OR t.merchant_id = $1)
+				AND ($2 = '' OR t.status = $2)
+				AND ($3::uuid IS NULL OR t.assignee_id = $3)
+				AND (NOT $4 OR t.assignee_id IS NULL)
+				AND ($5::uuid IS NULL OR t.order_id = $5)
+				AND ($6::uuid IS NULL OR t.product_id = $6)
+				AND ($7 = '' OR t.subject ILIKE '%' || $7 || '%' OR t.name ILIKE '%' || $7 || '%' OR t.email ILIKE '%' || $7 || '%')
+			ORDER BY t.last_message_at DESC
+			LIMIT $8 OFFSET $9
+		`, merchantID, status, assigneeID, unassigned, orderID, productID, strings.TrimSpace(c.Query("q")), limit, (page-1)*limit)
+		if err != nil {
+			l.ErrorF("Error fetching inbox: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tickets"})
+			return
+		}
+		defer rows.Close()
+
+		tickets := []Ticket{}
+		for rows.Next() {
+			var t Ticket
+			if err := scanTicket(rows, &t); err != nil {
+				l.ErrorF("Error scanning ticket: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tickets"})
+				return
+			}
+			tickets = append(tickets, t)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"tickets": tickets, "page": page, "limit": limit})
+	}
+}
+
+// FetchTicket returns a ticket with its conversation.
+func FetchTicket(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ticket, _, ok := ticketAccess(c, app)
+		if !ok {
+			return
+		}
+
+		messages, err := fetchMessages(c, app, ticket.ID)
+		if err != nil {
+			l.ErrorF("Error fetching ticket messages: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ticket"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"ticket": ticket, "messages": messages})
+	}
+}
+
+// ReplyToTicket adds a message to the conversation. A customer reply reopens
+// the ticket, a support reply marks it as waiting for the customer.
+func ReplyToTicket(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ticket, role, ok := ticketAccess(c, app)
+		if !ok {
+			return
+		}
+
+		var req ReplyRequest
+		if err := c.ShouldBind(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		status, ok := statusAfterReply(ticket.Status, role)
+		if !ok {
+			c.JSON(http.StatusConflict, gin.H{"error": "Ticket is closed"})
+			return
+		}
+
+		files, ok := attachmentFiles(c)
+		if !ok {
+			return
+		}
+
+		var authorID uuid.NullUUID
+		if id, err := uuid.Parse(c.GetString("userID")); err == nil {
+			authorID = uuid.NullUUID{UUID: id, Valid: true}
+		}
+
+		ctx := c.Request.Context()
+		uploads, err := uploadAttachments(app, files)
+		saved := false
+		defer func() {
+			if !saved {
+				discardAttachments(context.WithoutCancel(ctx), app, uploads)
+			}
+		}()
+		if err != nil {
+			l.ErrorF("Error uploading attachments: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send reply"})
+			return
+		}
+
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		// Replies through the ticket link have no account to throttle
+		if !authorID.Valid {
+			limited, err := guestRepliesLimited(ctx, tx, ticket.ID)
+			if err != nil {
+				l.ErrorF("Error checking reply rate limit: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send reply"})
+				return
+			}
+			if limited {
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many messages, please try again later"})
+				return
+			}
+		}
+
+		if err := addMessage(ctx, tx, ticket.ID, authorID, role, req.Message, uploads); err != nil {
+			l.ErrorF("Error adding ticket message: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send reply"})
+			return
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE support_tickets SET status = $1, last_message_at = NOW(), updated = NOW()
+			WHERE id = $2
+		`, status, ticket.ID)
+		if err != nil {
+			l.ErrorF("Error updating ticket: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send reply"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send reply"})
+			return
+		}
+		saved = true
+
+		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Reply sent", "status": status})
+	}
+}
+
+// UpdateTicket changes the status or the assignee of a ticket. Tickets can
+// be assigned to admins or to the user of the ticket's merchant.
+func UpdateTicket(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ticket, role, ok := ticketAccess(c, app)
+		if !ok {
+			return
+		}
+		if role == AuthorCustomer {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+
+		var req UpdateTicketRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		status, assigneeID := ticket.Status, ticket.AssigneeID
+		if req.Status != nil {
+			if !req.Status.Valid() {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+				return
+			}
+			status = *req.Status
+		}
+		if req.AssigneeID != nil {
+			id, err := parseOptionalUUID(*req.AssigneeID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid assignee"})
+				return
+			}
+			if id.Valid {
+				var allowed bool
+				err := app.DB.QueryRowContext(c, `
+					SELECT EXISTS(
+						SELECT 1 FROM users u
+						LEFT JOIN merchants m ON m.user_id = u.id
+						LEFT JOIN merchant_staff s ON s.user_id = u.id
+						JOIN role_permissions rp ON rp.role = u.role OR rp.role = s.role
+						WHERE u.id = $1 AND (
+							rp.permission IN ($2, $3)
+							OR (rp.permission = $4 AND COALESCE(m.id, s.merchant_id) = $5)
+						)
+					)
+				`, id.UUID, permission.All, permission.SupportReply, permission.Own(permission.SupportReply), ticket.MerchantID).Scan(&allowed)
+				if err != nil {
+					l.ErrorF("Error checking assignee: %v", err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update ticket"})
+					return
+				}
+				if !allowed {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "The ticket cannot be assigned to this user"})
+					return
+				}
+			}
+			assigneeID = id
+		}
+
+		_, err := app.DB.ExecContext(c, `
+			UPDATE support_tickets SET status = $1, assignee_id = $2, updated = NOW()
+			WHERE id = $3
+		`, status, assigneeID, ticket.ID)
+		if err != nil {
+			l.ErrorF("Error updating ticket: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update ticket"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Ticket updated"})
+	}
+}
+
+// parseOptionalUUID parses s, treating an empty string as no ID.
+func parseOptionalUUID(s string) (uuid.NullUUID, error) {
+	if s == "" {
+		return uuid.NullUUID{}, nil
+	}
+	id, err := uuid.Parse(s)
+	return uuid.NullUUID{UUID: id, Valid: err == nil}, err
+}
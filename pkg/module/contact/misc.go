@@ -0,0 +1,304 @@
+package contact
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/middleware"
+	"src/pkg/misc"
+	"src/pkg/permission"
+)
+
+const (
+	contactRateLimit  = 5 // tickets a client can open per contactRateWindow
+	contactRateWindow = time.Hour
+	guestReplyLimit   = 10 // replies a ticket link can post per contactRateWindow
+
+	maxAttachments       = 3
+	maxAttachmentSize    = 10 << 20 // 10MB
+	attachmentKeyPrefix  = "private/support"
+	attachmentURLExpires = 15 * time.Minute
+)
+
+const ticketColumns = "t.id, t.user_id, t.name, t.email, t.subject, t.order_id, t.product_id, t.merchant_id, t.status, t.assignee_id, t.access_token, t.last_message_at, t.updated, t.created"
+
+func scanTicket(row interface{ Scan(...any) error }, t *Ticket) error {
+	return row.Scan(&t.ID, &t.UserID, &t.Name, &t.Email, &t.Subject, &t.OrderID, &t.ProductID, &t.MerchantID,
+		&t.Status, &t.AssigneeID, &t.AccessToken, &t.LastMessageAt, &t.Updated, &t.Created)
+}
+
+// statusAfterReply returns the status a ticket moves to when author adds a
+// message, and false when the ticket takes no more replies.
+func statusAfterReply(current TicketStatus, author AuthorRole) (TicketStatus, bool) {
+	if current == StatusClosed {
+		return current, false
+	}
+	if author == AuthorCustomer {
+		return StatusOpen, true
+	}
+	return StatusPending, true
+}
+
+// ticketAccess loads the ticket in the id path parameter and returns the side
+// the caller takes in its conversation. Guests prove they own a ticket with
+// the token query parameter. It writes the error response itself.
+func ticketAccess(c *gin.Context, app *conf.Config) (Ticket, AuthorRole, bool) {
+	var t Ticket
+	ticketID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ticket ID"})
+		return t, "", false
+	}
+
+	err = scanTicket(app.DB.QueryRowContext(c, "SELECT "+ticketColumns+" FROM support_tickets t WHERE t.id = $1", ticketID), &t)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		l.ErrorF("Error fetching ticket: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ticket"})
+		return t, "", false
+	}
+
+	if err == nil {
+		if middleware.HasPermission(c, app, permission.SupportReply) {
+			return t, AuthorAdmin, true
+		}
+		if t.MerchantID.Valid && middleware.Allowed(c, app, permission.SupportReply, t.MerchantID.UUID) {
+			return t, AuthorMerchant, true
+		}
+		if userID, err := uuid.Parse(c.GetString("userID")); err == nil && t.UserID.Valid && t.UserID.UUID == userID {
+			return t, AuthorCustomer, true
+		}
+		if token := c.Query("token"); token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t.AccessToken)) == 1 {
+			return t, AuthorCustomer, true
+		}
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{"error": "Ticket not found"})
+	return t, "", false
+}
+
+// rateLimited reports whether the client or the email address opened too
+// many tickets recently. The keys stay locked until tx ends, so parallel
+// requests cannot all pass the check before any ticket is inserted.
+func rateLimited(ctx context.Context, tx *sql.Tx, clientIP, email string) (bool, error) {
+	_, err := tx.ExecContext(ctx, `
+		SELECT pg_advisory_xact_lock(hashtext(k)) FROM unnest($1::text[]) AS k ORDER BY k
+	`, pq.Array([]string{"contact:ip:" + clientIP, "contact:email:" + strings.ToLower(email)}))
+	if err != nil {
+		return false, err
+	}
+
+	var count int
+	err = tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM support_tickets
+		WHERE created > $3 AND (client_ip = $1 OR LOWER(email) = LOWER($2))
+	`, clientIP, email, time.Now().Add(-contactRateWindow)).Scan(&count)
+	return count >= contactRateLimit, err
+}
+
+// guestRepliesLimited reports whether a ticket took too many customer replies
+// recently. It guards replies made with the ticket link instead of an
+// account, and locks the ticket until tx ends.
+func guestRepliesLimited(ctx context.Context, tx *sql.Tx, ticketID uuid.UUID) (bool, error) {
+	var count int
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM support_tickets WHERE id = $1 FOR UPDATE", ticketID); err != nil {
+		return false, err
+	}
+	err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM support_messages
+		WHERE ticket_id = $1 AND author_role = $2 AND created > $3
+	`, ticketID, AuthorCustomer, time.Now().Add(-contactRateWindow)).Scan(&count)
+	return count >= guestReplyLimit, err
+}
+
+// ticketMerchant picks the merchant that can answer a ticket: the one selling
+// the product, otherwise the only merchant of the order.
+func ticketMerchant(ctx context.Context, tx *sql.Tx, orderID, productID uuid.NullUUID) (uuid.NullUUID, error) {
+	var merchantID uuid.NullUUID
+	if productID.Valid {
+		err := tx.QueryRowContext(ctx, "SELECT merchant_id FROM products WHERE id = $1", productID.UUID).Scan(&merchantID)
+		return merchantID, err
+	}
+	if orderID.Valid {
+		err := tx.QueryRowContext(ctx, `
+			SELECT CASE WHEN COUNT(DISTINCT merchant_id) = 1 THEN (ARRAY_AGG(merchant_id))[1] END
+			FROM order_items WHERE order_id = $1 AND merchant_id IS NOT NULL
+		`, orderID.UUID).Scan(&merchantID)
+		return merchantID, err
+	}
+	return merchantID, nil
+}
+
+// attachmentFiles returns the files attached to a multipart request. Plain
+// form and JSON requests have none. It writes the error response itself.
+func attachmentFiles(c *gin.Context) ([]*multipart.FileHeader, bool) {
+	form, err := c.MultipartForm()
+	if errors.Is(err, http.ErrNotMultipart) {
+		return nil, true
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid multipart form"})
+		return nil, false
+	}
+
+	files := form.File["attachments"]
+	if len(files) > maxAttachments {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("You can attach at most %d files", maxAttachments)})
+		return nil, false
+	}
+	for _, file := range files {
+		if file.Size > maxAttachmentSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Attachments should be less than 10MB"})
+			return nil, false
+		}
+	}
+	return files, true
+}
+
+// upload is an attachment stored before its message is saved.
+type upload struct {
+	key         string
+	fileName    string
+	contentType string
+	size        int
+}
+
+// uploadAttachments stores the files ahead of the transaction that saves
+// their message. Callers discard them unless that transaction commits.
+func uploadAttachments(app *conf.Config, files []*multipart.FileHeader) ([]upload, error) {
+	uploads := make([]upload, 0, len(files))
+	for _, file := range files {
+		data, err := misc.ReadFormFile(file)
+		if err != nil {
+			return uploads, err
+		}
+		contentType := file.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		key := misc.ContentHashKey(attachmentKeyPrefix, data, path.Ext(file.Filename))
+		if err := misc.S3PutPrivateObject(app, key, data, contentType); err != nil {
+			return uploads, err
+		}
+		uploads = append(uploads, upload{key: key, fileName: path.Base(file.Filename), contentType: contentType, size: len(data)})
+	}
+	return uploads, nil
+}
+
+// discardAttachments removes uploads whose message was not saved, keeping
+// the objects another attachment still points at.
+func discardAttachments(ctx context.Context, app *conf.Config, uploads []upload) {
+	for _, u := range uploads {
+		var stillUsed bool
+		if err := app.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM support_attachments WHERE file_key = $1)", u.key).Scan(&stillUsed); err != nil {
+			l.ErrorF("Error checking attachment references: %v", err)
+			continue
+		}
+		if stillUsed {
+			continue
+		}
+		if err := misc.S3DeleteObjects(app, u.key); err != nil {
+			l.ErrorF("Failed to delete attachment %s: %v", u.key, err)
+		}
+	}
+}
+
+// addMessage stores a message with its uploaded attachments.
+func addMessage(ctx context.Context, tx *sql.Tx, ticketID uuid.UUID, authorID uuid.NullUUID, role AuthorRole, body string, uploads []upload) error {
+	var messageID uuid.UUID
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO support_messages (ticket_id, author_id, author_role, body)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, ticketID, authorID, role, body).Scan(&messageID)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range uploads {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO support_attachments (message_id, file_key, file_name, content_type, size_bytes)
+			VALUES ($1, $2, $3, $4, $5)
+		`, messageID, u.key, u.fileName, u.contentType, u.size)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchMessages returns the conversation of a ticket, oldest first, with
+// download links for the attachments.
+func fetchMessages(ctx context.Context, app *conf.Config, ticketID uuid.UUID) ([]Message, error) {
+	rows, err := app.DB.QueryContext(ctx, `
+		SELECT id, ticket_id, author_id, author_role, body, created
+		FROM support_messages WHERE ticket_id = $1
+		ORDER BY created
+	`, ticketID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	index := make(map[uuid.UUID]int)
+	for rows.Next() {
+		m := Message{Attachments: []Attachment{}}
+		if err := rows.Scan(&m.ID, &m.TicketID, &m.AuthorID, &m.AuthorRole, &m.Body, &m.Created); err != nil {
+			return nil, err
+		}
+		index[m.ID] = len(messages)
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	attRows, err := app.DB.QueryContext(ctx, `
+		SELECT a.id, a.message_id, a.file_key, a.file_name, a.content_type, a.size_bytes, a.created
+		FROM support_attachments a
+		JOIN support_messages m ON m.id = a.message_id
+		WHERE m.ticket_id = $1
+		ORDER BY a.created
+	`, ticketID)
+	if err != nil {
+		return nil, err
+	}
+	defer attRows.Close()
+
+	for attRows.Next() {
+		var a Attachment
+		if err := attRows.Scan(&a.ID, &a.MessageID, &a.FileKey, &a.FileName, &a.ContentType, &a.SizeBytes, &a.Created); err != nil {
+			return nil, err
+		}
+		if a.URL, err = misc.S3PresignGetObject(app, a.FileKey, a.FileName, attachmentURLExpires); err != nil {
+			return nil, err
+		}
+		i := index[a.MessageID]
+		messages[i].Attachments = append(messages[i].Attachments, a)
+	}
+	return messages, attRows.Err()
+}
+
+func generateAccessToken() (string, error) {
+	buffer := make([]byte, 24)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buffer), nil
+}
@@ -0,0 +1,26 @@
+package contact
+
+import "testing"
+
+func TestStatusAfterReply(t *testing.T) {
+	tests := []struct {
+		current TicketStatus
+		author  AuthorRole
+		want    TicketStatus
+		ok      bool
+	}{
+		{StatusOpen, AuthorCustomer, StatusOpen, true},
+		{StatusPending, AuthorCustomer, StatusOpen, true},
+		{StatusResolved, AuthorCustomer, StatusOpen, true},
+		{StatusOpen, AuthorAdmin, StatusPending, true},
+		{StatusOpen, AuthorMerchant, StatusPending, true},
+		{StatusClosed, AuthorCustomer, StatusClosed, false},
+		{StatusClosed, AuthorAdmin, StatusClosed, false},
+	}
+	for _, tt := range tests {
+		got, ok := statusAfterReply(tt.current, tt.author)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("statusAfterReply(%s, %s) = %s, %v, want %s, %v", tt.current, tt.author, got, ok, tt.want, tt.ok)
+		}
+	}
+}
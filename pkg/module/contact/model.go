@@ -6,12 +6,91 @@ import (
 	"github.com/google/uuid"
 )
 
-// Contact represents the contact model
-type Contact struct {
-	ID      uuid.UUID `bson:"_id,omitempty" json:"_id,omitempty"`
-	Name    string    `bson:"name,omitempty" json:"name,omitempty"`
-	Email   string    `bson:"email,omitempty" json:"email,omitempty"`
-	Message string    `bson:"message,omitempty" json:"message,omitempty"`
-	Updated time.Time `bson:"updated,omitempty" json:"updated,omitempty"`
-	Created time.Time `bson:"created,omitempty" json:"created,omitempty"`
+type TicketStatus string
+
+const (
+	StatusOpen     TicketStatus = "open"     // waiting for support
+	StatusPending  TicketStatus = "pending"  // waiting for the customer
+	StatusResolved TicketStatus = "resolved" // reopened when the customer replies
+	StatusClosed   TicketStatus = "closed"   // no more replies
+)
+
+func (s TicketStatus) Valid() bool {
+	switch s {
+	case StatusOpen, StatusPending, StatusResolved, StatusClosed:
+		return true
+	}
+	return false
+}
+
+// AuthorRole is the side of the conversation a message comes from.
+type AuthorRole string
+
+const (
+	AuthorCustomer AuthorRole = "customer"
+	AuthorAdmin    AuthorRole = "admin"
+	AuthorMerchant AuthorRole = "merchant"
+)
+
+// Ticket is a support request sent through the contact form.
+type Ticket struct {
+	ID            uuid.UUID     `db:"id" json:"_id"`
+	UserID        uuid.NullUUID `db:"user_id" json:"userId"` // empty for guests
+	Name          string        `db:"name" json:"name"`
+	Email         string        `db:"email" json:"email"`
+	Subject       string        `db:"subject" json:"subject"`
+	OrderID       uuid.NullUUID `db:"order_id" json:"orderId"`
+	ProductID     uuid.NullUUID `db:"product_id" json:"productId"`
+	MerchantID    uuid.NullUUID `db:"merchant_id" json:"merchantId"`
+	Status        TicketStatus  `db:"status" json:"status"`
+	AssigneeID    uuid.NullUUID `db:"assignee_id" json:"assigneeId"`
+	AccessToken   string        `db:"access_token" json:"-"`
+	LastMessageAt time.Time     `db:"last_message_at" json:"lastMessageAt"`
+	Updated       time.Time     `db:"updated" json:"updated"`
+	Created       time.Time     `db:"created" json:"created"`
+}
+
+type Message struct {
+	ID          uuid.UUID     `db:"id" json:"_id"`
+	TicketID    uuid.UUID     `db:"ticket_id" json:"ticketId"`
+	AuthorID    uuid.NullUUID `db:"author_id" json:"authorId"`
+	AuthorRole  AuthorRole    `db:"author_role" json:"authorRole"`
+	Body        string        `db:"body" json:"body"`
+	Attachments []Attachment  `json:"attachments"`
+	Created     time.Time     `db:"created" json:"created"`
+}
+
+// Attachment is a file sent with a message. URL is a short lived download
+// link since attachments are stored privately.
+type Attachment struct {
+	ID          uuid.UUID `db:"id" json:"_id"`
+	MessageID   uuid.UUID `db:"message_id" json:"messageId"`
+	FileKey     string    `db:"file_key" json:"-"`
+	FileName    string    `db:"file_name" json:"fileName"`
+	ContentType string    `db:"content_type" json:"contentType"`
+	SizeBytes   int64     `db:"size_bytes" json:"sizeBytes"`
+	URL         string    `json:"url"`
+	Created     time.Time `db:"created" json:"created"`
+}
+
+// ContactRequest is the contact form. It is sent as a multipart form so
+// files can be attached; name and email default to the signed in user.
+type ContactRequest struct {
+	Name      string `form:"name" binding:"max=255"`
+	Email     string `form:"email" binding:"omitempty,email,max=255"`
+	Subject   string `form:"subject" binding:"required,max=255"`
+	Message   string `form:"message" binding:"required"`
+	OrderID   string `form:"orderId"`
+	ProductID string `form:"productId"`
+}
+
+type ReplyRequest struct {
+	Message string `form:"message" binding:"required"`
+}
+
+// UpdateTicketRequest changes the status or the assignee of a ticket. An
+// empty assignee unassigns the ticket.
+type UpdateTicketRequest struct {
+	Status     *TicketStatus `json:"status"`
+	AssigneeID *string       `json:"assigneeId"`
 }
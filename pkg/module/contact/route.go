@@ -0,0 +1,40 @@
+package contact
+
+import (
+	"src/pkg/conf"
+	"src/pkg/middleware"
+	"src/pkg/permission"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SetupRouter(path string, r *gin.RouterGroup, app *conf.Config) {
+	contactRoute := r.Group(path)
+	{
+		contactRoute.POST("",
+			middleware.AuthOrNotMiddleware(app),
+			SubmitContact(app))
+
+		contactRoute.GET("/tickets",
+			middleware.AuthMiddleware(app),
+			ListMyTickets(app))
+
+		contactRoute.GET("/inbox",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.SupportReply),
+			Inbox(app))
+
+		contactRoute.GET("/tickets/:id",
+			middleware.AuthOrNotMiddleware(app),
+			FetchTicket(app))
+
+		contactRoute.POST("/tickets/:id/messages",
+			middleware.AuthOrNotMiddleware(app),
+			ReplyToTicket(app))
+
+		contactRoute.PUT("/tickets/:id",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.SupportReply),
+			UpdateTicket(app))
+	}
+}
@@ -0,0 +1,265 @@
+package inventory
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/middleware"
+	"src/pkg/permission"
+)
+
+// checkStocked rejects products that do not keep stock of their own, like
+// digital products and bundles.
+func checkStocked(c *gin.Context, app *conf.Config, productID uuid.UUID) bool {
+	var productType string
+	if err := app.DB.QueryRowContext(c, "SELECT product_type FROM products WHERE id = $1", productID).Scan(&productType); err != nil {
+		l.ErrorF("Error fetching product type: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify product"})
+		return false
+	}
+	if productType != "physical" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only physical products keep their own stock"})
+		return false
+	}
+	return true
+}
+
+func FetchStock(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("productId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		if !middleware.CheckProductAccess(c, app, permission.InventoryWrite, productID) {
+			return
+		}
+
+		var stock StockLevel
+		err = app.DB.QueryRowContext(c, `
+			SELECT p.id, p.name, p.sku, p.quantity, p.reserved_quantity, p.low_stock_threshold,
+				COALESCE(SUM(sm.quantity), 0), COALESCE(SUM(sm.reserved_quantity), 0)
+			FROM products p
+			LEFT JOIN stock_movements sm ON sm.product_id = p.id
+			WHERE p.id = $1 AND p.deleted_at IS NULL
+			GROUP BY p.id
+		`, productID).Scan(&stock.ProductID, &stock.Name, &stock.SKU, &stock.OnHand, &stock.Reserved, &stock.LowStockThreshold,
+			&stock.LedgerOnHand, &stock.LedgerReserved)
+		if err != nil {
+			l.ErrorF("Error fetching stock: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock"})
+			return
+		}
+		stock.Available = stock.OnHand - stock.Reserved
+
+		if stock.Locations, err = fetchLocationStock(c, app, productID); err != nil {
+			l.ErrorF("Error fetching location stock: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"stock":      stock,
+			"consistent": stock.OnHand == stock.LedgerOnHand && stock.Reserved == stock.LedgerReserved,
+		})
+	}
+}
+
+func AdjustStock(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("productId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		if !middleware.CheckProductAccess(c, app, permission.InventoryWrite, productID) || !checkStocked(c, app, productID) {
+			return
+		}
+
+		var req AdjustStockRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		// Order related movements are only written by checkout and payments
+		switch req.Type {
+		case MovementReceived, MovementReturned, MovementAdjusted:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "type must be received, returned or adjusted"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error starting transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		if req.LocationID != uuid.Nil {
+			if err := checkLocation(ctx, tx, productID, req.LocationID); err != nil {
+				if errors.Is(err, ErrUnknownLocation) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown location"})
+				} else {
+					l.ErrorF("Error checking location: %v", err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to adjust stock"})
+				}
+				return
+			}
+		}
+
+		err = Record(ctx, tx, productID, req.LocationID, req.Type, req.Quantity, req.Reason, uuid.NullUUID{}, middleware.ActorID(c))
+		if err != nil {
+			if errors.Is(err, ErrInsufficientStock) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Not enough stock for this adjustment"})
+			} else {
+				l.ErrorF("Error recording stock movement: %v", err)
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to adjust stock"})
+			}
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Stock adjusted successfully"})
+	}
+}
+
+func FetchStockMovements(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("productId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		if !middleware.CheckProductAccess(c, app, permission.InventoryWrite, productID) {
+			return
+		}
+
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit < 1 {
+			limit = 20
+		}
+
+		rows, err := app.DB.QueryContext(c, `
+			SELECT id, product_id, location_id, movement_type, quantity, reserved_quantity, reason, order_id, actor_id, created
+			FROM stock_movements
+			WHERE product_id = $1
+			ORDER BY created DESC
+			LIMIT $2 OFFSET $3
+		`, productID, limit, (page-1)*limit)
+		if err != nil {
+			l.ErrorF("Error fetching stock movements: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock movements"})
+			return
+		}
+		defer rows.Close()
+
+		movements := []Movement{}
+		for rows.Next() {
+			var m Movement
+			if err := rows.Scan(&m.ID, &m.ProductID, &m.LocationID, &m.Type, &m.Quantity, &m.ReservedQuantity, &m.Reason, &m.OrderID, &m.ActorID, &m.Created); err != nil {
+				l.ErrorF("Error scanning stock movement: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stock movements"})
+				return
+			}
+			movements = append(movements, m)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"movements": movements, "page": page, "limit": limit})
+	}
+}
+
+func UpdateLowStockThreshold(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("productId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		if !middleware.CheckProductAccess(c, app, permission.InventoryWrite, productID) {
+			return
+		}
+
+		var req ThresholdRequest
+		if err := c.ShouldBindJSON(&req); err != nil || *req.Threshold < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "threshold must be zero or more"})
+			return
+		}
+
+		_, err = app.DB.ExecContext(c, "UPDATE products SET low_stock_threshold = $1 WHERE id = $2", *req.Threshold, productID)
+		if err != nil {
+			l.ErrorF("Error updating low stock threshold: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update threshold"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Low stock threshold updated"})
+	}
+}
+
+// LowStockReport lists products whose available stock is at or below their
+// threshold. Merchants only see their own products.
+func LowStockReport(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := `
+			SELECT id, name, sku, quantity, reserved_quantity, low_stock_threshold
+			FROM products
+			WHERE deleted_at IS NULL AND low_stock_threshold > 0 AND quantity - reserved_quantity <= low_stock_threshold`
+		args := []interface{}{}
+
+		merchantID, ok := middleware.MerchantScope(c, app, permission.InventoryWrite)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
+			return
+		}
+		if merchantID != uuid.Nil {
+			query += " AND merchant_id = $1"
+			args = append(args, merchantID)
+		}
+		query += " ORDER BY quantity - reserved_quantity, name"
+
+		rows, err := app.DB.QueryContext(c, query, args...)
+		if err != nil {
+			l.ErrorF("Error fetching low stock report: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch low stock report"})
+			return
+		}
+		defer rows.Close()
+
+		products := []StockLevel{}
+		for rows.Next() {
+			var stock StockLevel
+			if err := rows.Scan(&stock.ProductID, &stock.Name, &stock.SKU, &stock.OnHand, &stock.Reserved, &stock.LowStockThreshold); err != nil {
+				l.ErrorF("Error scanning low stock product: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch low stock report"})
+				return
+			}
+			stock.Available = stock.OnHand - stock.Reserved
+			products = append(products, stock)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"products": products})
+	}
+}
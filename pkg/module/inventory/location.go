@@ -0,0 +1,535 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/middleware"
+	"src/pkg/permission"
+)
+
+var ErrUnknownLocation = errors.New("location does not belong to the product's merchant")
+
+const locationColumns = "id, merchant_id, name, address_line1, city, state, country, zip_code, is_default, is_active, updated, created"
+
+func scanLocation(row interface{ Scan(...any) error }) (Location, error) {
+	var loc Location
+	err := row.Scan(&loc.ID, &loc.MerchantID, &loc.Name, &loc.AddressLine1, &loc.City, &loc.State, &loc.Country,
+		&loc.ZipCode, &loc.IsDefault, &loc.IsActive, &loc.Updated, &loc.Created)
+	return loc, err
+}
+
+// DefaultLocation returns the default location of the product's merchant,
+// creating it for merchants that have none yet.
+func DefaultLocation(ctx context.Context, tx *sql.Tx, productID uuid.UUID) (uuid.UUID, error) {
+	var locationID uuid.UUID
+	err := tx.QueryRowContext(ctx, `
+		SELECT ml.id FROM merchant_locations ml
+		JOIN products p ON p.merchant_id = ml.merchant_id
+		WHERE p.id = $1 AND ml.is_default
+	`, productID).Scan(&locationID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return locationID, err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO merchant_locations (merchant_id, name, is_default)
+		SELECT merchant_id, 'Default', TRUE FROM products WHERE id = $1 AND merchant_id IS NOT NULL
+		RETURNING id
+	`, productID).Scan(&locationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, fmt.Errorf("product %s has no merchant", productID)
+	}
+	return locationID, err
+}
+
+// checkLocation returns ErrUnknownLocation unless the location belongs to
+// the merchant of the product.
+func checkLocation(ctx context.Context, tx *sql.Tx, productID, locationID uuid.UUID) error {
+	var ok bool
+	err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM merchant_locations ml
+			JOIN products p ON p.merchant_id = ml.merchant_id
+			WHERE p.id = $1 AND ml.id = $2
+		)
+	`, productID, locationID).Scan(&ok)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrUnknownLocation
+	}
+	return nil
+}
+
+// distance ranks how far a location is from the delivery address using
+// pincode prefixes (sorting district, region, zone) and the state. Lower is
+// closer.
+func distance(zipCode, state string, dest Destination) int {
+	zipCode, destZip := strings.TrimSpace(zipCode), strings.TrimSpace(dest.ZipCode)
+	shared := 0
+	for shared < len(zipCode) && shared < len(destZip) && zipCode[shared] == destZip[shared] {
+		shared++
+	}
+
+	switch {
+	case zipCode != "" && zipCode == destZip:
+		return 0
+	case shared >= 3:
+		return 1
+	case shared == 2:
+		return 2
+	case state != "" && strings.EqualFold(strings.TrimSpace(state), strings.TrimSpace(dest.State)):
+		return 3
+	case shared == 1:
+		return 4
+	default:
+		return 5
+	}
+}
+
+type candidate struct {
+	LocationID uuid.UUID
+	Distance   int
+	Available  int
+	IsDefault  bool
+}
+
+// pickLocation chooses the closest location that can ship the whole
+// quantity. Ties go to the location with more stock, then the default one.
+func pickLocation(candidates []candidate, quantity int) (uuid.UUID, bool) {
+	var best *candidate
+	for i := range candidates {
+		cand := &candidates[i]
+		if cand.Available < quantity {
+			continue
+		}
+		if best == nil ||
+			cand.Distance < best.Distance ||
+			(cand.Distance == best.Distance && cand.Available > best.Available) ||
+			(cand.Distance == best.Distance && cand.Available == best.Available && cand.IsDefault && !best.IsDefault) {
+			best = cand
+		}
+	}
+	if best == nil {
+		return uuid.Nil, false
+	}
+	return best.LocationID, true
+}
+
+// ReserveOrderItem picks the location that fulfils an order item and
+// reserves the units there. It returns the chosen location.
+func ReserveOrderItem(ctx context.Context, tx *sql.Tx, orderID, productID uuid.UUID, quantity int, dest Destination, actorID uuid.NullUUID) (uuid.UUID, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT ml.id, ml.zip_code, ml.state, ml.is_default, ls.quantity - ls.reserved_quantity
+		FROM location_stock ls
+		JOIN merchant_locations ml ON ml.id = ls.location_id
+		WHERE ls.product_id = $1 AND ml.is_active
+		ORDER BY ml.created
+		FOR UPDATE OF ls
+	`, productID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	var candidates []candidate
+	for rows.Next() {
+		var cand candidate
+		var zipCode, state string
+		if err := rows.Scan(&cand.LocationID, &zipCode, &state, &cand.IsDefault, &cand.Available); err != nil {
+			rows.Close()
+			return uuid.Nil, err
+		}
+		cand.Distance = distance(zipCode, state, dest)
+		candidates = append(candidates, cand)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return uuid.Nil, err
+	}
+
+	locationID, ok := pickLocation(candidates, quantity)
+	if !ok {
+		return uuid.Nil, ErrInsufficientStock
+	}
+
+	order := uuid.NullUUID{UUID: orderID, Valid: true}
+	if err := Record(ctx, tx, productID, locationID, MovementReserved, quantity, "Order placed", order, actorID); err != nil {
+		return uuid.Nil, err
+	}
+	return locationID, nil
+}
+
+// fetchLocationStock returns the stock of a product per location.
+func fetchLocationStock(c *gin.Context, app *conf.Config, productID uuid.UUID) ([]LocationStock, error) {
+	rows, err := app.DB.QueryContext(c, `
+		SELECT ml.id, ml.name, ls.product_id, ls.quantity, ls.reserved_quantity
+		FROM location_stock ls
+		JOIN merchant_locations ml ON ml.id = ls.location_id
+		WHERE ls.product_id = $1
+		ORDER BY ml.is_default DESC, ml.name
+	`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stock := []LocationStock{}
+	for rows.Next() {
+		var s LocationStock
+		if err := rows.Scan(&s.LocationID, &s.LocationName, &s.ProductID, &s.OnHand, &s.Reserved); err != nil {
+			return nil, err
+		}
+		s.Available = s.OnHand - s.Reserved
+		stock = append(stock, s)
+	}
+	return stock, rows.Err()
+}
+
+// merchantScope returns the merchant whose locations are managed: users with
+// inventory:write name the merchant, the others work on their own.
+func merchantScope(c *gin.Context, app *conf.Config, requested uuid.UUID) (uuid.UUID, bool) {
+	merchantID, ok := middleware.MerchantScope(c, app, permission.InventoryWrite)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
+		return uuid.Nil, false
+	}
+	if merchantID != uuid.Nil {
+		return merchantID, true
+	}
+	if requested == uuid.Nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "merchantId is required"})
+		return uuid.Nil, false
+	}
+	return requested, true
+}
+
+// checkLocationAccess loads a location the current user may manage. The
+// error response is written here.
+func checkLocationAccess(c *gin.Context, app *conf.Config) (Location, bool) {
+	locationID, err := uuid.Parse(c.Param("locationId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid location ID"})
+		return Location{}, false
+	}
+
+	loc, err := scanLocation(app.DB.QueryRowContext(c, "SELECT "+locationColumns+" FROM merchant_locations WHERE id = $1", locationID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		} else {
+			l.ErrorF("Error fetching location: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch location"})
+		}
+		return Location{}, false
+	}
+
+	if !middleware.Allowed(c, app, permission.InventoryWrite, loc.MerchantID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to manage this location"})
+		return Location{}, false
+	}
+	return loc, true
+}
+
+func ListLocations(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requested, _ := uuid.Parse(c.Query("merchantId"))
+		merchantID, ok := merchantScope(c, app, requested)
+		if !ok {
+			return
+		}
+
+		rows, err := app.DB.QueryContext(c, "SELECT "+locationColumns+" FROM merchant_locations WHERE merchant_id = $1 ORDER BY is_default DESC, name", merchantID)
+		if err != nil {
+			l.ErrorF("Error fetching locations: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch locations"})
+			return
+		}
+		defer rows.Close()
+
+		locations := []Location{}
+		for rows.Next() {
+			loc, err := scanLocation(rows)
+			if err != nil {
+				l.ErrorF("Error scanning location: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch locations"})
+				return
+			}
+			locations = append(locations, loc)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"locations": locations})
+	}
+}
+
+func AddLocation(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req AddLocationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		merchantID, ok := merchantScope(c, app, req.MerchantID)
+		if !ok {
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error starting transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		// The first location of a merchant is its default one
+		var count int
+		if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM merchant_locations WHERE merchant_id = $1", merchantID).Scan(&count); err != nil {
+			l.ErrorF("Error counting locations: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add location"})
+			return
+		}
+		isDefault := req.IsDefault || count == 0
+		if isDefault {
+			if _, err := tx.ExecContext(ctx, "UPDATE merchant_locations SET is_default = FALSE WHERE merchant_id = $1 AND is_default", merchantID); err != nil {
+				l.ErrorF("Error clearing default location: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add location"})
+				return
+			}
+		}
+
+		loc, err := scanLocation(tx.QueryRowContext(ctx, `
+			INSERT INTO merchant_locations (id, merchant_id, name, address_line1, city, state, country, zip_code, is_default)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING `+locationColumns,
+			uuid.New(), merchantID, req.Name, req.AddressLine1, req.City, req.State, req.Country, strings.TrimSpace(req.ZipCode), isDefault))
+		if err != nil {
+			l.ErrorF("Error adding location: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add location"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Location added successfully", "location": loc})
+	}
+}
+
+func UpdateLocation(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		loc, ok := checkLocationAccess(c, app)
+		if !ok {
+			return
+		}
+
+		var req LocationUpdate
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if loc.IsDefault && ((req.IsDefault != nil && !*req.IsDefault) || (req.IsActive != nil && !*req.IsActive)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Make another location the default first"})
+			return
+		}
+
+		updateQuery := "UPDATE merchant_locations SET updated = $1"
+		args := []interface{}{time.Now()}
+		argIndex := 2
+
+		fields := []struct {
+			column string
+			value  interface{}
+			set    bool
+		}{
+			{"name", req.Name, req.Name != nil},
+			{"address_line1", req.AddressLine1, req.AddressLine1 != nil},
+			{"city", req.City, req.City != nil},
+			{"state", req.State, req.State != nil},
+			{"country", req.Country, req.Country != nil},
+			{"zip_code", req.ZipCode, req.ZipCode != nil},
+			{"is_default", req.IsDefault, req.IsDefault != nil},
+			{"is_active", req.IsActive, req.IsActive != nil},
+		}
+		for _, f := range fields {
+			if !f.set {
+				continue
+			}
+			updateQuery += fmt.Sprintf(", %s = $%d", f.column, argIndex)
+			args = append(args, f.value)
+			argIndex++
+		}
+		updateQuery += fmt.Sprintf(" WHERE id = $%d RETURNING %s", argIndex, locationColumns)
+		args = append(args, loc.ID)
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error starting transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		if req.IsDefault != nil && *req.IsDefault && !loc.IsDefault {
+			if _, err := tx.ExecContext(ctx, "UPDATE merchant_locations SET is_default = FALSE WHERE merchant_id = $1 AND is_default", loc.MerchantID); err != nil {
+				l.ErrorF("Error clearing default location: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update location"})
+				return
+			}
+		}
+
+		loc, err = scanLocation(tx.QueryRowContext(ctx, updateQuery, args...))
+		if err != nil {
+			l.ErrorF("Error updating location: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update location"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Location updated successfully", "location": loc})
+	}
+}
+
+func DeleteLocation(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		loc, ok := checkLocationAccess(c, app)
+		if !ok {
+			return
+		}
+		if loc.IsDefault {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The default location cannot be deleted"})
+			return
+		}
+
+		// Stock has to be transferred away first so nothing gets lost
+		var holding bool
+		err := app.DB.QueryRowContext(c, `
+			SELECT EXISTS (SELECT 1 FROM location_stock WHERE location_id = $1 AND (quantity <> 0 OR reserved_quantity <> 0))
+		`, loc.ID).Scan(&holding)
+		if err != nil {
+			l.ErrorF("Error checking location stock: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete location"})
+			return
+		}
+		if holding {
+			c.JSON(http.StatusConflict, gin.H{"error": "Location still holds stock"})
+			return
+		}
+
+		if _, err := app.DB.ExecContext(c, "DELETE FROM merchant_locations WHERE id = $1", loc.ID); err != nil {
+			l.ErrorF("Error deleting location: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete location"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Location deleted successfully"})
+	}
+}
+
+func FetchLocationStock(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		loc, ok := checkLocationAccess(c, app)
+		if !ok {
+			return
+		}
+
+		rows, err := app.DB.QueryContext(c, `
+			SELECT ls.product_id, p.name, ls.quantity, ls.reserved_quantity
+			FROM location_stock ls
+			JOIN products p ON p.id = ls.product_id
+			WHERE ls.location_id = $1 AND p.deleted_at IS NULL
+			ORDER BY p.name
+		`, loc.ID)
+		if err != nil {
+			l.ErrorF("Error fetching location stock: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch location stock"})
+			return
+		}
+		defer rows.Close()
+
+		stock := []LocationStock{}
+		for rows.Next() {
+			s := LocationStock{LocationID: loc.ID, LocationName: loc.Name}
+			if err := rows.Scan(&s.ProductID, &s.ProductName, &s.OnHand, &s.Reserved); err != nil {
+				l.ErrorF("Error scanning location stock: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch location stock"})
+				return
+			}
+			s.Available = s.OnHand - s.Reserved
+			stock = append(stock, s)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"location": loc, "stock": stock})
+	}
+}
+
+func TransferStock(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req TransferRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.FromLocationID == req.ToLocationID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Source and destination must differ"})
+			return
+		}
+
+		if !middleware.CheckProductAccess(c, app, permission.InventoryWrite, req.ProductID) || !checkStocked(c, app, req.ProductID) {
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error starting transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		transferID, err := Transfer(ctx, tx, req.ProductID, req.FromLocationID, req.ToLocationID, req.Quantity, req.Reason, middleware.ActorID(c))
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrUnknownLocation):
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown location"})
+			case errors.Is(err, ErrInsufficientStock):
+				c.JSON(http.StatusConflict, gin.H{"error": "Not enough available stock at the source location"})
+			default:
+				l.ErrorF("Error transferring stock: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer stock"})
+			}
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Stock transferred successfully", "transfer_id": transferID})
+	}
+}
@@ -0,0 +1,55 @@
+package inventory
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDistance(t *testing.T) {
+	dest := Destination{ZipCode: "560034", State: "Karnataka"}
+	tests := []struct {
+		zipCode, state string
+		want           int
+	}{
+		{"560034", "Karnataka", 0},
+		{"560001", "Karnataka", 1},
+		{"561203", "Karnataka", 2},
+		{"583101", "karnataka ", 3},
+		{"500001", "Telangana", 4},
+		{"110001", "Delhi", 5},
+		{"", "", 5},
+	}
+
+	for _, tt := range tests {
+		if got := distance(tt.zipCode, tt.state, dest); got != tt.want {
+			t.Errorf("distance(%q, %q) = %d, want %d", tt.zipCode, tt.state, got, tt.want)
+		}
+	}
+}
+
+func TestPickLocation(t *testing.T) {
+	near, far, other := uuid.New(), uuid.New(), uuid.New()
+
+	tests := []struct {
+		name       string
+		candidates []candidate
+		quantity   int
+		want       uuid.UUID
+		ok         bool
+	}{
+		{"closest with stock", []candidate{{far, 5, 10, true}, {near, 1, 3, false}}, 2, near, true},
+		{"closest lacks stock", []candidate{{far, 5, 10, true}, {near, 1, 1, false}}, 2, far, true},
+		{"tie on distance takes more stock", []candidate{{near, 2, 3, false}, {other, 2, 8, false}}, 2, other, true},
+		{"tie on stock takes default", []candidate{{near, 2, 3, false}, {other, 2, 3, true}}, 2, other, true},
+		{"nothing can ship", []candidate{{near, 0, 1, true}}, 2, uuid.Nil, false},
+		{"no locations", nil, 1, uuid.Nil, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := pickLocation(tt.candidates, tt.quantity)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: pickLocation() = %v, %v, want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
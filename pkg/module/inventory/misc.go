@@ -0,0 +1,242 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// deltas returns how a movement of n units changes the on-hand and the
+// reserved stock. Only adjustments may carry a negative quantity.
+func deltas(t MovementType, n int) (onHand int, reserved int, err error) {
+	if n == 0 || (n < 0 && t != MovementAdjusted) {
+		return 0, 0, fmt.Errorf("invalid quantity %d for %s movement", n, t)
+	}
+
+	switch t {
+	case MovementReceived, MovementReturned, MovementAdjusted:
+		return n, 0, nil
+	case MovementSold:
+		return -n, -n, nil
+	case MovementReserved:
+		return 0, n, nil
+	case MovementReleased:
+		return 0, -n, nil
+	case MovementTransferred:
+		return 0, 0, errors.New("transfers are booked with Transfer")
+	default:
+		return 0, 0, fmt.Errorf("unknown movement type %q", t)
+	}
+}
+
+// Record writes a movement of n units at a location to the ledger and updates
+// the cached stock of the location and the product. A nil locationID books the
+// movement at the default location of the product's merchant. Movements that
+// would make the available stock negative fail with ErrInsufficientStock;
+// callers roll back tx.
+func Record(ctx context.Context, tx *sql.Tx, productID, locationID uuid.UUID, t MovementType, n int, reason string, orderID, actorID uuid.NullUUID) error {
+	onHand, reserved, err := deltas(t, n)
+	if err != nil {
+		return err
+	}
+
+	if locationID == uuid.Nil {
+		if locationID, err = DefaultLocation(ctx, tx, productID); err != nil {
+			return err
+		}
+	}
+
+	if err := changeLocationStock(ctx, tx, productID, locationID, onHand, reserved, onHand-reserved < 0); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE products
+		SET quantity = quantity + $1, reserved_quantity = reserved_quantity + $2, updated = $3
+		WHERE id = $4
+	`, onHand, reserved, time.Now(), productID)
+	if err != nil {
+		return err
+	}
+
+	return insertMovement(ctx, tx, productID, locationID, t, onHand, reserved, reason, orderID, actorID)
+}
+
+// changeLocationStock applies the deltas to the stock of a product at a
+// location. When checkAvailable is set the available stock must stay covered.
+func changeLocationStock(ctx context.Context, tx *sql.Tx, productID, locationID uuid.UUID, onHand, reserved int, checkAvailable bool) error {
+	var newOnHand, newReserved int
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO location_stock (location_id, product_id, quantity, reserved_quantity, updated)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (location_id, product_id) DO UPDATE
+		SET quantity = location_stock.quantity + EXCLUDED.quantity,
+			reserved_quantity = location_stock.reserved_quantity + EXCLUDED.reserved_quantity,
+			updated = EXCLUDED.updated
+		RETURNING quantity, reserved_quantity
+	`, locationID, productID, onHand, reserved, time.Now()).Scan(&newOnHand, &newReserved)
+	if err != nil {
+		return err
+	}
+
+	// Stock coming back is always accepted, anything else must stay covered
+	if checkAvailable && newOnHand-newReserved < 0 {
+		return ErrInsufficientStock
+	}
+	if newReserved < 0 || newOnHand < 0 {
+		return ErrInsufficientStock
+	}
+	return nil
+}
+
+func insertMovement(ctx context.Context, tx *sql.Tx, productID, locationID uuid.UUID, t MovementType, onHand, reserved int, reason string, orderID, actorID uuid.NullUUID) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO stock_movements (id, product_id, location_id, movement_type, quantity, reserved_quantity, reason, order_id, actor_id, created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, uuid.New(), productID, locationID, t, onHand, reserved, reason, orderID, actorID, time.Now())
+	return err
+}
+
+// Transfer moves n on-hand units of a product between two locations of its
+// merchant. The product totals do not change.
+func Transfer(ctx context.Context, tx *sql.Tx, productID, from, to uuid.UUID, n int, reason string, actorID uuid.NullUUID) (uuid.UUID, error) {
+	if n <= 0 || from == to {
+		return uuid.Nil, fmt.Errorf("invalid transfer of %d units", n)
+	}
+	for _, locationID := range []uuid.UUID{from, to} {
+		if err := checkLocation(ctx, tx, productID, locationID); err != nil {
+			return uuid.Nil, err
+		}
+	}
+
+	if err := changeLocationStock(ctx, tx, productID, from, -n, 0, true); err != nil {
+		return uuid.Nil, err
+	}
+	if err := changeLocationStock(ctx, tx, productID, to, n, 0, false); err != nil {
+		return uuid.Nil, err
+	}
+
+	transferID := uuid.New()
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO stock_transfers (id, product_id, from_location_id, to_location_id, quantity, reason, actor_id, created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, transferID, productID, from, to, n, reason, actorID, time.Now())
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if err := insertMovement(ctx, tx, productID, from, MovementTransferred, -n, 0, reason, uuid.NullUUID{}, actorID); err != nil {
+		return uuid.Nil, err
+	}
+	if err := insertMovement(ctx, tx, productID, to, MovementTransferred, n, 0, reason, uuid.NullUUID{}, actorID); err != nil {
+		return uuid.Nil, err
+	}
+	return transferID, nil
+}
+
+type stockKey struct {
+	ProductID  uuid.UUID
+	LocationID uuid.UUID
+}
+
+// outstandingReservations returns, per product and location, the units still
+// reserved for an order.
+func outstandingReservations(ctx context.Context, tx *sql.Tx, orderID uuid.UUID) (map[stockKey]int, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT product_id, location_id, SUM(reserved_quantity)
+		FROM stock_movements
+		WHERE order_id = $1 AND location_id IS NOT NULL
+		GROUP BY product_id, location_id
+		HAVING SUM(reserved_quantity) > 0
+	`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reserved := make(map[stockKey]int)
+	for rows.Next() {
+		var key stockKey
+		var quantity int
+		if err := rows.Scan(&key.ProductID, &key.LocationID, &quantity); err != nil {
+			return nil, err
+		}
+		reserved[key] = quantity
+	}
+	return reserved, rows.Err()
+}
+
+// SellOrder turns every open reservation of a paid order into a sale. It is
+// safe to call more than once for the same order.
+func SellOrder(ctx context.Context, tx *sql.Tx, orderID uuid.UUID) error {
+	reserved, err := outstandingReservations(ctx, tx, orderID)
+	if err != nil {
+		return err
+	}
+	order := uuid.NullUUID{UUID: orderID, Valid: true}
+	for key, quantity := range reserved {
+		if err := Record(ctx, tx, key.ProductID, key.LocationID, MovementSold, quantity, "Order paid", order, uuid.NullUUID{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReleaseOrder gives back every open reservation of an order.
+func ReleaseOrder(ctx context.Context, tx *sql.Tx, orderID uuid.UUID, reason string, actorID uuid.NullUUID) error {
+	reserved, err := outstandingReservations(ctx, tx, orderID)
+	if err != nil {
+		return err
+	}
+	order := uuid.NullUUID{UUID: orderID, Valid: true}
+	for key, quantity := range reserved {
+		if err := Record(ctx, tx, key.ProductID, key.LocationID, MovementReleased, quantity, reason, order, actorID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CancelOrderItem puts the units of a cancelled order item back at the
+// location that fulfils it: units that are still reserved are released,
+// units already sold are returned.
+func CancelOrderItem(ctx context.Context, tx *sql.Tx, orderID, productID, locationID uuid.UUID, quantity int, actorID uuid.NullUUID) error {
+	reserved, err := outstandingReservations(ctx, tx, orderID)
+	if err != nil {
+		return err
+	}
+	order := uuid.NullUUID{UUID: orderID, Valid: true}
+
+	// Without a recorded location use the one the order booked the product
+	// at, items ordered before locations existed were served from the default
+	if locationID == uuid.Nil {
+		err := tx.QueryRowContext(ctx, `
+			SELECT location_id FROM stock_movements
+			WHERE order_id = $1 AND product_id = $2 AND location_id IS NOT NULL
+			ORDER BY created DESC LIMIT 1
+		`, orderID, productID).Scan(&locationID)
+		if errors.Is(err, sql.ErrNoRows) {
+			locationID, err = DefaultLocation(ctx, tx, productID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	release := min(reserved[stockKey{productID, locationID}], quantity)
+	if release > 0 {
+		if err := Record(ctx, tx, productID, locationID, MovementReleased, release, "Order item cancelled", order, actorID); err != nil {
+			return err
+		}
+	}
+	if quantity-release > 0 {
+		return Record(ctx, tx, productID, locationID, MovementReturned, quantity-release, "Order item cancelled", order, actorID)
+	}
+	return nil
+}
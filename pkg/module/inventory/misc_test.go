@@ -0,0 +1,34 @@
+package inventory
+
+import "testing"
+
+func TestDeltas(t *testing.T) {
+	tests := []struct {
+		movement MovementType
+		n        int
+		onHand   int
+		reserved int
+		wantErr  bool
+	}{
+		{MovementReceived, 5, 5, 0, false},
+		{MovementReserved, 2, 0, 2, false},
+		{MovementSold, 2, -2, -2, false},
+		{MovementReleased, 2, 0, -2, false},
+		{MovementReturned, 1, 1, 0, false},
+		{MovementAdjusted, -3, -3, 0, false},
+		{MovementReceived, -3, 0, 0, true},
+		{MovementSold, 0, 0, 0, true},
+		{MovementType("lost"), 1, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		onHand, reserved, err := deltas(tt.movement, tt.n)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("deltas(%s, %d) error = %v, wantErr %v", tt.movement, tt.n, err, tt.wantErr)
+			continue
+		}
+		if onHand != tt.onHand || reserved != tt.reserved {
+			t.Errorf("deltas(%s, %d) = %d, %d, want %d, %d", tt.movement, tt.n, onHand, reserved, tt.onHand, tt.reserved)
+		}
+	}
+}
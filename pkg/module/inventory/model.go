@@ -0,0 +1,124 @@
+package inventory
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type MovementType string
+
+const (
+	MovementReceived MovementType = "received" // stock arrived at the merchant
+	MovementSold     MovementType = "sold"     // a reservation was paid for
+	MovementReserved MovementType = "reserved" // held for a placed order
+	MovementReleased MovementType = "released" // reservation given back, e.g. cancelled order
+	MovementReturned MovementType = "returned" // sold stock came back
+	MovementAdjusted MovementType = "adjusted" // manual correction, may be negative
+
+	MovementTransferred MovementType = "transferred" // moved between locations of a merchant
+)
+
+type Movement struct {
+	ID               uuid.UUID     `db:"id" json:"_id"`
+	ProductID        uuid.UUID     `db:"product_id" json:"productId"`
+	LocationID       uuid.NullUUID `db:"location_id" json:"locationId"`
+	Type             MovementType  `db:"movement_type" json:"type"`
+	Quantity         int           `db:"quantity" json:"quantity"`                  // change of on-hand stock
+	ReservedQuantity int           `db:"reserved_quantity" json:"reservedQuantity"` // change of reserved stock
+	Reason           string        `db:"reason" json:"reason"`
+	OrderID          uuid.NullUUID `db:"order_id" json:"orderId"`
+	ActorID          uuid.NullUUID `db:"actor_id" json:"actorId"`
+	Created          time.Time     `db:"created" json:"created"`
+}
+
+// StockLevel is the current stock of a product. OnHand and Reserved are the
+// cached values on products, Ledger* are summed up from stock_movements.
+type StockLevel struct {
+	ProductID         uuid.UUID `json:"productId"`
+	Name              string    `json:"name"`
+	SKU               string    `json:"sku"`
+	OnHand            int       `json:"onHand"`
+	Reserved          int       `json:"reserved"`
+	Available         int       `json:"available"`
+	LowStockThreshold int       `json:"lowStockThreshold"`
+	LedgerOnHand      int       `json:"ledgerOnHand,omitempty"`
+	LedgerReserved    int       `json:"ledgerReserved,omitempty"`
+
+	Locations []LocationStock `json:"locations,omitempty"`
+}
+
+type AdjustStockRequest struct {
+	Type       MovementType `json:"type" binding:"required"` // received, returned or adjusted
+	Quantity   int          `json:"quantity" binding:"required"`
+	Reason     string       `json:"reason" binding:"required"`
+	LocationID uuid.UUID    `json:"locationId"` // default location when empty
+}
+
+type ThresholdRequest struct {
+	Threshold *int `json:"threshold" binding:"required"`
+}
+
+// Location is a warehouse or store a merchant ships from.
+type Location struct {
+	ID           uuid.UUID `db:"id" json:"_id"`
+	MerchantID   uuid.UUID `db:"merchant_id" json:"merchantId"`
+	Name         string    `db:"name" json:"name"`
+	AddressLine1 string    `db:"address_line1" json:"addressLine1"`
+	City         string    `db:"city" json:"city"`
+	State        string    `db:"state" json:"state"`
+	Country      string    `db:"country" json:"country"`
+	ZipCode      string    `db:"zip_code" json:"zipCode"`
+	IsDefault    bool      `db:"is_default" json:"isDefault"`
+	IsActive     bool      `db:"is_active" json:"isActive"`
+	Updated      time.Time `db:"updated" json:"updated"`
+	Created      time.Time `db:"created" json:"created"`
+}
+
+type AddLocationRequest struct {
+	MerchantID   uuid.UUID `json:"merchantId"` // only used by admins
+	Name         string    `json:"name" binding:"required"`
+	AddressLine1 string    `json:"addressLine1"`
+	City         string    `json:"city"`
+	State        string    `json:"state" binding:"required"`
+	Country      string    `json:"country"`
+	ZipCode      string    `json:"zipCode" binding:"required"`
+	IsDefault    bool      `json:"isDefault"`
+}
+
+type LocationUpdate struct {
+	Name         *string `json:"name"`
+	AddressLine1 *string `json:"addressLine1"`
+	City         *string `json:"city"`
+	State        *string `json:"state"`
+	Country      *string `json:"country"`
+	ZipCode      *string `json:"zipCode"`
+	IsDefault    *bool   `json:"isDefault"`
+	IsActive     *bool   `json:"isActive"`
+}
+
+// LocationStock is the stock of a product at one location.
+type LocationStock struct {
+	LocationID   uuid.UUID `json:"locationId"`
+	LocationName string    `json:"locationName"`
+	ProductID    uuid.UUID `json:"productId"`
+	ProductName  string    `json:"productName,omitempty"`
+	OnHand       int       `json:"onHand"`
+	Reserved     int       `json:"reserved"`
+	Available    int       `json:"available"`
+}
+
+type TransferRequest struct {
+	ProductID      uuid.UUID `json:"productId" binding:"required"`
+	FromLocationID uuid.UUID `json:"fromLocationId" binding:"required"`
+	ToLocationID   uuid.UUID `json:"toLocationId" binding:"required"`
+	Quantity       int       `json:"quantity" binding:"required,gt=0"`
+	Reason         string    `json:"reason"`
+}
+
+// Destination is where an order is delivered to, used to pick the closest
+// location that can fulfil an item.
+type Destination struct {
+	ZipCode string
+	State   string
+}
@@ -0,0 +1,38 @@
+package inventory
+
+import (
+	"src/pkg/conf"
+	"src/pkg/middleware"
+	"src/pkg/permission"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SetupRouter(path string, r *gin.RouterGroup, app *conf.Config) {
+	inventory_route := r.Group(path,
+		middleware.AuthMiddleware(app),
+		middleware.RequirePermission(app, permission.InventoryWrite))
+	{
+		inventory_route.GET("/low-stock", LowStockReport(app))
+
+		inventory_route.GET("/locations", ListLocations(app))
+
+		inventory_route.POST("/locations", AddLocation(app))
+
+		inventory_route.PUT("/locations/:locationId", UpdateLocation(app))
+
+		inventory_route.DELETE("/locations/:locationId", DeleteLocation(app))
+
+		inventory_route.GET("/locations/:locationId/stock", FetchLocationStock(app))
+
+		inventory_route.POST("/transfers", TransferStock(app))
+
+		inventory_route.GET("/:productId", FetchStock(app))
+
+		inventory_route.GET("/:productId/movements", FetchStockMovements(app))
+
+		inventory_route.POST("/:productId/adjust", AdjustStock(app))
+
+		inventory_route.PUT("/:productId/threshold", UpdateLowStockThreshold(app))
+	}
+}
@@ -2,6 +2,8 @@ package merchant
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"math"
 	"net/http"
 	"strconv"
@@ -13,6 +15,8 @@ import (
 	"src/common"
 	"src/l"
 	"src/pkg/conf"
+	"src/pkg/middleware"
+	"src/pkg/permission"
 )
 
 type MerchantAdd struct {
@@ -67,6 +71,19 @@ func AddMerchant(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
+		// Staff work for a single merchant
+		var isStaff bool
+		err = app.DB.QueryRowContext(c, "SELECT EXISTS(SELECT 1 FROM merchant_staff WHERE user_id = $1)", userID).Scan(&isStaff)
+		if err != nil {
+			l.ErrorF("Error checking merchant staff: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add merchant"})
+			return
+		}
+		if isStaff {
+			c.JSON(http.StatusConflict, gin.H{"error": "You already work for a merchant"})
+			return
+		}
+
 		newMerchantID := uuid.New()
 
 		_, err = app.DB.ExecContext(c, `
@@ -206,20 +223,6 @@ func FetchAllMerchants(app *conf.Config) gin.HandlerFunc {
 func DisableMerchantAccount(app *conf.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
-		authUserIDStr := c.GetString("userID") // Assuming you're using a middleware to set this
-		authUserID, err := uuid.Parse(authUserIDStr)
-
-		if err != nil {
-
-			l.DebugF("Error: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-			return
-
-		}
-
-		userRoleStr := c.MustGet("role").(string)
-		userRole := common.GetUserRole(userRoleStr)
-
 		merchantIDStr := c.Param("id")
 		merchantID, err := uuid.Parse(merchantIDStr)
 
@@ -240,12 +243,10 @@ func DisableMerchantAccount(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
-		// Authorization Check. Put these checks first for clarity and early exit
-		if userRole != common.RoleAdmin { // Admins can disable any account
-			if authUserID != merchantID { // Non-admins can only disable their own account
-				c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized"})
-				return
-			}
+		// Merchants can only disable their own account
+		if !middleware.Allowed(c, app, permission.MerchantManage, merchantID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized"})
+			return
 		}
 
 		ctx := context.Background()
@@ -284,6 +285,28 @@ func ApproveMerchant(app *conf.Config) gin.HandlerFunc {
 		}
 		defer tx.Rollback()
 
+		// Only users who verified their email can become merchants
+		var verified bool
+		err = tx.QueryRowContext(c, `
+			SELECT u.email_verified_at IS NOT NULL
+			FROM merchants m
+			JOIN users u ON u.id = m.user_id
+			WHERE m.id = $1
+		`, merchantID).Scan(&verified)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Merchant not found"})
+			return
+		}
+		if err != nil {
+			l.DebugF("Error checking merchant user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve merchant"})
+			return
+		}
+		if !verified {
+			c.JSON(http.StatusConflict, gin.H{"error": "The merchant's user has not verified their email address yet"})
+			return
+		}
+
 		_, err = tx.ExecContext(c, `
             UPDATE merchants 
             SET status = $1, is_active = $2, updated = $3 
@@ -308,6 +331,19 @@ func ApproveMerchant(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
+		// What the owner may do on the merchant comes with the owner role
+		_, err = tx.ExecContext(c, `
+			INSERT INTO merchant_staff (user_id, merchant_id, role)
+			SELECT user_id, id, $1 FROM merchants WHERE id = $2
+			ON CONFLICT (user_id) DO NOTHING
+		`, ownerRole, merchantID)
+
+		if err != nil {
+			l.DebugF("Error adding merchant owner: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve merchant"})
+			return
+		}
+
 		if err := tx.Commit(); err != nil {
 			l.DebugF("Error committing transaction: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
@@ -0,0 +1,352 @@
+package merchant
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/mail"
+)
+
+// inviteLifetime is how long an invite can be accepted.
+const inviteLifetime = 7 * 24 * time.Hour
+
+// Invite asks the user with Email to join the merchant with Role. Only the
+// hash of its token is stored; the token is mailed.
+type Invite struct {
+	ID        uuid.UUID     `json:"id"`
+	Email     string        `json:"email"`
+	Role      string        `json:"role"`
+	InvitedBy uuid.NullUUID `json:"invitedBy"`
+	ExpiresAt time.Time     `json:"expiresAt"`
+	Created   time.Time     `json:"created"`
+}
+
+type InviteRequest struct {
+	Email string `json:"email" binding:"required"`
+	Role  string `json:"role" binding:"required"`
+}
+
+type AcceptInviteRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+func generateInviteToken() (string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buffer), nil
+}
+
+func hashInviteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateInvite mails an invite to join the merchant. Inviting an email again
+// replaces its pending invite, with a new link.
+func CreateInvite(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		merchantID, ok := staffMerchant(c, app)
+		if !ok {
+			return
+		}
+
+		var req InviteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		// Only the bare address is kept, "Ann <ann@example.com>" invites ann@example.com
+		address, err := netmail.ParseAddress(strings.TrimSpace(req.Email))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+			return
+		}
+		email := address.Address
+		if !checkStaffRole(c, app, req.Role) {
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send the invite"})
+			return
+		}
+		defer tx.Rollback()
+
+		var merchantName string
+		var isMember bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT m.name, EXISTS(
+				SELECT 1 FROM merchant_staff s JOIN users u ON u.id = s.user_id
+				WHERE s.merchant_id = m.id AND LOWER(u.email) = LOWER($2)
+			)
+			FROM merchants m WHERE m.id = $1
+		`, merchantID, email).Scan(&merchantName, &isMember)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Merchant not found"})
+			return
+		}
+		if err != nil {
+			l.ErrorF("Error fetching merchant: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send the invite"})
+			return
+		}
+		if isMember {
+			c.JSON(http.StatusConflict, gin.H{"error": "This user is already a member of the merchant"})
+			return
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE merchant_invites SET revoked_at = NOW()
+			WHERE merchant_id = $1 AND LOWER(email) = LOWER($2) AND accepted_at IS NULL AND revoked_at IS NULL
+		`, merchantID, email)
+		if err != nil {
+			l.ErrorF("Error revoking previous invite: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send the invite"})
+			return
+		}
+
+		token, err := generateInviteToken()
+		if err != nil {
+			l.ErrorF("Error generating invite token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send the invite"})
+			return
+		}
+
+		invitedBy, _ := uuid.Parse(c.GetString("userID"))
+		expiresAt := time.Now().Add(inviteLifetime)
+		var inviteID uuid.UUID
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO merchant_invites (merchant_id, email, role, token_hash, invited_by, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING id
+		`, merchantID, email, req.Role, hashInviteToken(token), invitedBy, expiresAt).Scan(&inviteID)
+		if err != nil {
+			l.ErrorF("Error creating invite: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send the invite"})
+			return
+		}
+
+		var name, inviterName string
+		err = tx.QueryRowContext(ctx, `
+			SELECT COALESCE((SELECT first_name FROM users WHERE LOWER(email) = LOWER($2) LIMIT 1), ''),
+				COALESCE(NULLIF(TRIM(CONCAT(first_name, ' ', last_name)), ''), email)
+			FROM users WHERE id = $1
+		`, invitedBy, email).Scan(&name, &inviterName)
+		if err != nil {
+			l.ErrorF("Error fetching inviter: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send the invite"})
+			return
+		}
+
+		// Queued with the invite so the mail only goes out once it exists
+		err = mail.Enqueue(ctx, tx, mail.TemplateMerchantInvite, email, mail.MerchantInviteData{
+			Name:         name,
+			InviterName:  inviterName,
+			MerchantName: merchantName,
+			Role:         req.Role,
+			AcceptURL:    app.Env.ClientURL + "/merchant/invite?token=" + url.QueryEscape(token),
+			ExpiresAt:    expiresAt,
+		})
+		if err != nil {
+			l.ErrorF("Error queueing invite mail: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send the invite"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send the invite"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Invite sent", "inviteId": inviteID})
+	}
+}
+
+// ListInvites lists the pending invites of the merchant, expired ones
+// included so they can be sent again.
+func ListInvites(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		merchantID, ok := staffMerchant(c, app)
+		if !ok {
+			return
+		}
+
+		rows, err := app.DB.QueryContext(c, `
+			SELECT id, email, role, invited_by, expires_at, created
+			FROM merchant_invites
+			WHERE merchant_id = $1 AND accepted_at IS NULL AND revoked_at IS NULL
+			ORDER BY created DESC
+		`, merchantID)
+		if err != nil {
+			l.ErrorF("Error fetching invites: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invites"})
+			return
+		}
+		defer rows.Close()
+
+		invites := []Invite{}
+		for rows.Next() {
+			var i Invite
+			if err := rows.Scan(&i.ID, &i.Email, &i.Role, &i.InvitedBy, &i.ExpiresAt, &i.Created); err != nil {
+				l.ErrorF("Error scanning invite: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invites"})
+				return
+			}
+			invites = append(invites, i)
+		}
+		if err := rows.Err(); err != nil {
+			l.ErrorF("Error fetching invites: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invites"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"invites": invites})
+	}
+}
+
+func RevokeInvite(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		merchantID, ok := staffMerchant(c, app)
+		if !ok {
+			return
+		}
+		inviteID, err := uuid.Parse(c.Param("inviteId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invite ID"})
+			return
+		}
+
+		res, err := app.DB.ExecContext(c, `
+			UPDATE merchant_invites SET revoked_at = NOW()
+			WHERE id = $1 AND merchant_id = $2 AND accepted_at IS NULL AND revoked_at IS NULL
+		`, inviteID, merchantID)
+		if err != nil {
+			l.ErrorF("Error revoking invite: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke the invite"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Invite revoked"})
+	}
+}
+
+// AcceptInvite makes the signed in user a member of the merchant of the
+// invite, when it was sent to their email address. The membership shows in
+// the access token once it is refreshed.
+func AcceptInvite(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req AcceptInviteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		userID, _ := uuid.Parse(c.GetString("userID"))
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept the invite"})
+			return
+		}
+		defer tx.Rollback()
+
+		var inviteID, merchantID uuid.UUID
+		var email, role, merchantName string
+		var invitedBy uuid.NullUUID
+		err = tx.QueryRowContext(ctx, `
+			SELECT i.id, i.merchant_id, i.email, i.role, i.invited_by, m.name
+			FROM merchant_invites i
+			JOIN merchants m ON m.id = i.merchant_id
+			WHERE i.token_hash = $1 AND i.accepted_at IS NULL AND i.revoked_at IS NULL AND i.expires_at > NOW()
+			FOR UPDATE OF i
+		`, hashInviteToken(req.Token)).Scan(&inviteID, &merchantID, &email, &role, &invitedBy, &merchantName)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired invite"})
+			return
+		}
+		if err != nil {
+			l.ErrorF("Error fetching invite: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept the invite"})
+			return
+		}
+
+		var userEmail string
+		var ownsMerchant bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT u.email, EXISTS(SELECT 1 FROM merchants m WHERE m.user_id = u.id)
+			FROM users u WHERE u.id = $1
+		`, userID).Scan(&userEmail, &ownsMerchant)
+		if err != nil {
+			l.ErrorF("Error fetching user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept the invite"})
+			return
+		}
+		if !strings.EqualFold(userEmail, email) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This invite was sent to another email address"})
+			return
+		}
+		// An owner leaves by transferring the ownership first
+		if ownsMerchant {
+			c.JSON(http.StatusConflict, gin.H{"error": "You already own a merchant"})
+			return
+		}
+
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO merchant_staff (user_id, merchant_id, role, added_by) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id) DO NOTHING
+		`, userID, merchantID, role, invitedBy)
+		if err != nil {
+			l.ErrorF("Error adding staff: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept the invite"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "You already work for a merchant"})
+			return
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE merchant_invites SET accepted_at = NOW(), accepted_by = $1 WHERE id = $2", userID, inviteID)
+		if err != nil {
+			l.ErrorF("Error accepting invite: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept the invite"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept the invite"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":      true,
+			"message":      "You joined " + merchantName,
+			"merchantId":   merchantID,
+			"merchantRole": role,
+		})
+	}
+}
@@ -1,9 +1,9 @@
 package merchant
 
 import (
-	"src/common"
 	"src/pkg/conf"
 	"src/pkg/middleware"
+	"src/pkg/permission"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,27 +13,72 @@ func SetupRouter(path string, r *gin.RouterGroup, app *conf.Config) {
 	{
 		merchant.POST("/add",
 			middleware.AuthMiddleware(app),
+			middleware.VerifiedEmail(app),
 			AddMerchant(app))
 
 		merchant.GET("/search",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleAdmin),
+			middleware.RequirePermission(app, permission.MerchantManage),
 			SearchMerchants(app))
 
 		merchant.GET("",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleAdmin),
+			middleware.RequirePermission(app, permission.MerchantManage),
 			FetchAllMerchants(app))
 
 		merchant.PUT("/:id/active",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleAdmin, common.RoleMerchant),
+			middleware.RequirePermission(app, permission.MerchantManage),
 			DisableMerchantAccount(app))
 
 		merchant.PUT("/approve/:id",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleAdmin),
+			middleware.RequirePermission(app, permission.MerchantManage),
 			ApproveMerchant(app))
 
+		merchant.GET("/:id/staff",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.StaffManage),
+			ListStaff(app))
+
+		merchant.GET("/:id/staff/roles",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.StaffManage),
+			ListStaffRoles(app))
+
+		merchant.GET("/:id/invites",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.StaffManage),
+			ListInvites(app))
+
+		merchant.POST("/:id/invites",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.StaffManage),
+			CreateInvite(app))
+
+		merchant.DELETE("/:id/invites/:inviteId",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.StaffManage),
+			RevokeInvite(app))
+
+		merchant.POST("/invites/accept",
+			middleware.AuthMiddleware(app),
+			middleware.VerifiedEmail(app),
+			AcceptInvite(app))
+
+		merchant.POST("/:id/transfer",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.MerchantManage),
+			TransferOwnership(app))
+
+		merchant.PUT("/:id/staff/:userId",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.StaffManage),
+			UpdateStaff(app))
+
+		merchant.DELETE("/:id/staff/:userId",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.StaffManage),
+			RemoveStaff(app))
 	}
 }
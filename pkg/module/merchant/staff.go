@@ -0,0 +1,343 @@
+package merchant
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"src/common"
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/middleware"
+	"src/pkg/permission"
+)
+
+// Staff are the members of a merchant, its owner included, with a role of
+// the merchant scope. Users join through invites and belong to a single
+// merchant. Changes take effect when their access token is refreshed.
+type Staff struct {
+	UserID    uuid.UUID     `json:"userId"`
+	Email     string        `json:"email"`
+	FirstName string        `json:"firstName"`
+	LastName  string        `json:"lastName"`
+	Role      string        `json:"role"`
+	AddedBy   uuid.NullUUID `json:"addedBy"`
+	Created   time.Time     `json:"created"`
+}
+
+type UpdateStaffRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type TransferOwnershipRequest struct {
+	UserID uuid.UUID `json:"userId" binding:"required"`
+}
+
+// ownerRole is the merchant role of merchants.user_id. It is only given and
+// taken by transferring the ownership; the previous owner stays a manager.
+const (
+	ownerRole   = "MERCHANT OWNER"
+	managerRole = "MERCHANT MANAGER"
+)
+
+// staffMerchant returns the merchant of the path the user may manage the
+// staff of. The error response is written here.
+func staffMerchant(c *gin.Context, app *conf.Config) (uuid.UUID, bool) {
+	merchantID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
+		return uuid.Nil, false
+	}
+	if !middleware.Allowed(c, app, permission.StaffManage, merchantID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to manage the staff of this merchant"})
+		return uuid.Nil, false
+	}
+	return merchantID, true
+}
+
+// checkStaffRole writes an error response unless role is a merchant role
+// members can be given.
+func checkStaffRole(c *gin.Context, app *conf.Config, role string) bool {
+	var valid bool
+	err := app.DB.QueryRowContext(c, "SELECT EXISTS(SELECT 1 FROM roles WHERE name = $1 AND scope = 'merchant' AND name <> $2)", role, ownerRole).Scan(&valid)
+	if err != nil {
+		l.ErrorF("Error checking staff role: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check role"})
+		return false
+	}
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown staff role"})
+		return false
+	}
+	return true
+}
+
+func ListStaff(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		merchantID, ok := staffMerchant(c, app)
+		if !ok {
+			return
+		}
+
+		rows, err := app.DB.QueryContext(c, `
+			SELECT s.user_id, u.email, COALESCE(u.first_name, ''), COALESCE(u.last_name, ''), s.role, s.added_by, s.created
+			FROM merchant_staff s
+			JOIN users u ON u.id = s.user_id
+			WHERE s.merchant_id = $1
+			ORDER BY s.created
+		`, merchantID)
+		if err != nil {
+			l.ErrorF("Error fetching staff: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch staff"})
+			return
+		}
+		defer rows.Close()
+
+		staff := []Staff{}
+		for rows.Next() {
+			var s Staff
+			if err := rows.Scan(&s.UserID, &s.Email, &s.FirstName, &s.LastName, &s.Role, &s.AddedBy, &s.Created); err != nil {
+				l.ErrorF("Error scanning staff: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch staff"})
+				return
+			}
+			staff = append(staff, s)
+		}
+		if err := rows.Err(); err != nil {
+			l.ErrorF("Error fetching staff: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch staff"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"staff": staff})
+	}
+}
+
+// ListStaffRoles lists the roles members can be given.
+func ListStaffRoles(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := staffMerchant(c, app); !ok {
+			return
+		}
+
+		rows, err := app.DB.QueryContext(c, `
+			SELECT r.name, r.description, COALESCE(ARRAY_AGG(rp.permission ORDER BY rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}')
+			FROM roles r
+			LEFT JOIN role_permissions rp ON rp.role = r.name
+			WHERE r.scope = 'merchant' AND r.name <> $1
+			GROUP BY r.name, r.description
+			ORDER BY r.name
+		`, ownerRole)
+		if err != nil {
+			l.ErrorF("Error fetching staff roles: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch roles"})
+			return
+		}
+		defer rows.Close()
+
+		type staffRole struct {
+			Name        string   `json:"name"`
+			Description string   `json:"description"`
+			Permissions []string `json:"permissions"`
+		}
+		roles := []staffRole{}
+		for rows.Next() {
+			var r staffRole
+			if err := rows.Scan(&r.Name, &r.Description, pq.Array(&r.Permissions)); err != nil {
+				l.ErrorF("Error scanning staff role: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch roles"})
+				return
+			}
+			roles = append(roles, r)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"roles": roles})
+	}
+}
+
+// checkNotOwner writes an error response unless userID is a member of the
+// merchant other than its owner.
+func checkNotOwner(c *gin.Context, app *conf.Config, merchantID, userID uuid.UUID) bool {
+	var role string
+	err := app.DB.QueryRowContext(c, "SELECT role FROM merchant_staff WHERE merchant_id = $1 AND user_id = $2", merchantID, userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Staff not found"})
+		return false
+	}
+	if err != nil {
+		l.ErrorF("Error fetching staff: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch staff"})
+		return false
+	}
+	if role == ownerRole {
+		c.JSON(http.StatusConflict, gin.H{"error": "The owner can only change by transferring the ownership"})
+		return false
+	}
+	return true
+}
+
+func UpdateStaff(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		merchantID, ok := staffMerchant(c, app)
+		if !ok {
+			return
+		}
+		userID, err := uuid.Parse(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		var req UpdateStaffRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if !checkStaffRole(c, app, req.Role) || !checkNotOwner(c, app, merchantID, userID) {
+			return
+		}
+
+		res, err := app.DB.ExecContext(c, "UPDATE merchant_staff SET role = $1 WHERE merchant_id = $2 AND user_id = $3 AND role <> $4", req.Role, merchantID, userID, ownerRole)
+		if err != nil {
+			l.ErrorF("Error updating staff: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update staff"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Staff not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Staff updated"})
+	}
+}
+
+func RemoveStaff(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		merchantID, ok := staffMerchant(c, app)
+		if !ok {
+			return
+		}
+		userID, err := uuid.Parse(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		if !checkNotOwner(c, app, merchantID, userID) {
+			return
+		}
+
+		res, err := app.DB.ExecContext(c, "DELETE FROM merchant_staff WHERE merchant_id = $1 AND user_id = $2 AND role <> $3", merchantID, userID, ownerRole)
+		if err != nil {
+			l.ErrorF("Error removing staff: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove staff"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Staff not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Staff removed"})
+	}
+}
+
+// TransferOwnership makes a member the owner of the merchant. The previous
+// owner stays a manager, and the global merchant role moves with the
+// ownership unless the users hold another one.
+func TransferOwnership(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		merchantID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
+			return
+		}
+		// Managers hold staff:manage:own but not merchant:manage:own
+		if !middleware.Allowed(c, app, permission.MerchantManage, merchantID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can transfer the ownership"})
+			return
+		}
+
+		var req TransferOwnershipRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer the ownership"})
+			return
+		}
+		defer tx.Rollback()
+
+		var ownerID uuid.UUID
+		err = tx.QueryRowContext(ctx, "SELECT user_id FROM merchants WHERE id = $1 FOR UPDATE", merchantID).Scan(&ownerID)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Merchant not found"})
+			return
+		}
+		if err != nil {
+			l.ErrorF("Error fetching merchant: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer the ownership"})
+			return
+		}
+		if ownerID == req.UserID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This user already owns the merchant"})
+			return
+		}
+
+		res, err := tx.ExecContext(ctx, "UPDATE merchant_staff SET role = $1 WHERE merchant_id = $2 AND user_id = $3", ownerRole, merchantID, req.UserID)
+		if err != nil {
+			l.ErrorF("Error updating new owner: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer the ownership"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The new owner must be a member of the merchant"})
+			return
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE merchants SET user_id = $1, updated = $2 WHERE id = $3", req.UserID, time.Now(), merchantID); err != nil {
+			l.ErrorF("Error updating merchant owner: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer the ownership"})
+			return
+		}
+
+		// The previous owner of a merchant that was never approved is no member
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO merchant_staff (user_id, merchant_id, role) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id) DO UPDATE SET role = EXCLUDED.role WHERE merchant_staff.merchant_id = EXCLUDED.merchant_id
+		`, ownerID, merchantID, managerRole)
+		if err != nil {
+			l.ErrorF("Error updating previous owner: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer the ownership"})
+			return
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE users SET role = CASE WHEN id = $1 THEN $2 ELSE $3 END
+			WHERE (id = $1 AND role = $3) OR (id = $4 AND role = $2)
+		`, req.UserID, common.RoleMerchant, "ROLE USER", ownerID)
+		if err != nil {
+			l.ErrorF("Error updating user roles: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer the ownership"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer the ownership"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Ownership transferred"})
+	}
+}
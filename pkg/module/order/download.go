@@ -0,0 +1,170 @@
+package order
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/misc"
+)
+
+// downloadLinkLifetime is how long a signed download link stays valid.
+const downloadLinkLifetime = 15 * time.Minute
+
+const downloadGrantQuery = `
+	SELECT g.id, g.order_id, g.product_id, p.name, g.file_id, pf.file_name, pf.size_bytes,
+		g.download_limit, g.download_count, g.expires_at, g.created
+	FROM download_grants g
+	JOIN products p ON p.id = g.product_id
+	JOIN product_files pf ON pf.id = g.file_id
+	WHERE g.user_id = $1`
+
+func fetchDownloadGrants(c *gin.Context, app *conf.Config, query string, args ...interface{}) ([]DownloadGrant, error) {
+	rows, err := app.DB.QueryContext(c, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := []DownloadGrant{}
+	for rows.Next() {
+		var g DownloadGrant
+		if err := rows.Scan(&g.ID, &g.OrderID, &g.ProductID, &g.ProductName, &g.FileID, &g.FileName, &g.SizeBytes,
+			&g.DownloadLimit, &g.DownloadCount, &g.ExpiresAt, &g.Created); err != nil {
+			return nil, err
+		}
+		if g.DownloadLimit > 0 {
+			g.RemainingDownloads = max(g.DownloadLimit-g.DownloadCount, 0)
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// FetchUserDownloads lists every file the current user has bought.
+func FetchUserDownloads(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := getUserID(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		grants, err := fetchDownloadGrants(c, app, downloadGrantQuery+" ORDER BY g.created DESC", userID)
+		if err != nil {
+			l.ErrorF("Error fetching downloads: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch downloads"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"downloads": grants})
+	}
+}
+
+func FetchOrderDownloads(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderID, err := uuid.Parse(c.Param("orderId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+			return
+		}
+
+		userID, err := getUserID(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		grants, err := fetchDownloadGrants(c, app, downloadGrantQuery+" AND g.order_id = $2 ORDER BY p.name, pf.file_name", userID, orderID)
+		if err != nil {
+			l.ErrorF("Error fetching order downloads: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch downloads"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"downloads": grants})
+	}
+}
+
+// CreateDownloadLink counts a download and returns a short lived signed URL
+// to the file.
+func CreateDownloadLink(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		grantID, err := uuid.Parse(c.Param("grantId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid download ID"})
+			return
+		}
+
+		userID, err := getUserID(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		// The download is only counted once the link is signed
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error starting transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create download link"})
+			return
+		}
+		defer tx.Rollback()
+
+		var fileKey, fileName string
+		err = tx.QueryRowContext(ctx, `
+			UPDATE download_grants g
+			SET download_count = g.download_count + 1, last_downloaded = NOW()
+			FROM product_files pf
+			WHERE g.id = $1 AND g.user_id = $2 AND pf.id = g.file_id
+				AND (g.download_limit = 0 OR g.download_count < g.download_limit)
+				AND (g.expires_at IS NULL OR g.expires_at > NOW())
+			RETURNING pf.file_key, pf.file_name
+		`, grantID, userID).Scan(&fileKey, &fileName)
+		if errors.Is(err, sql.ErrNoRows) {
+			var exists bool
+			if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM download_grants WHERE id = $1 AND user_id = $2)", grantID, userID).Scan(&exists); err != nil {
+				l.ErrorF("Error fetching download: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create download link"})
+				return
+			}
+			if !exists {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Download not found"})
+				return
+			}
+			c.JSON(http.StatusForbidden, gin.H{"error": "Download limit reached or download expired"})
+			return
+		}
+		if err != nil {
+			l.ErrorF("Error counting download: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create download link"})
+			return
+		}
+
+		url, err := misc.S3PresignGetObject(app, fileKey, fileName, downloadLinkLifetime)
+		if err != nil {
+			l.ErrorF("Error signing download link: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create download link"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create download link"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"url":       url,
+			"fileName":  fileName,
+			"expiresAt": time.Now().Add(downloadLinkLifetime),
+		})
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"math"
 	"net/http"
 	"os"
@@ -14,13 +13,19 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/guregu/null/v5"
+	"github.com/lib/pq"
 
-	"src/common"
 	"src/l"
 	"src/pkg/conf"
+	"src/pkg/mail"
+	"src/pkg/middleware"
+	"src/pkg/module/address"
 	"src/pkg/module/cart"
+	"src/pkg/module/inventory"
 	"src/pkg/module/payment"
 	"src/pkg/module/product"
+	"src/pkg/permission"
 )
 
 // Model Structs
@@ -101,9 +106,18 @@ func AddOrderWithCartItemAndAddress(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
+		if unlisted := unlistedProducts(cartItems); len(unlisted) > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Some products in your cart are no longer available", "productIds": unlisted})
+			return
+		}
+
 		total := calculateTotal(cartItems)
 
-		if !verifyAddressOwnership(ctx, tx, req.Address.ID, userID) {
+		// Nothing is shipped when the cart only holds digital products
+		digitalOnly := isDigitalOnly(cartItems)
+		addressID := uuid.NullUUID{UUID: req.Address.ID, Valid: !digitalOnly}
+
+		if !digitalOnly && !verifyAddressOwnership(ctx, tx, req.Address.ID, userID) {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "You are not authorized to use this address."})
 			return
 		}
@@ -113,12 +127,45 @@ func AddOrderWithCartItemAndAddress(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
-		newOrderID, err := createOrder(ctx, tx, req, userID, total)
+		// Only the last checkout of the cart keeps its stock
+		if err := releasePendingOrders(ctx, tx, req.CartID, userID); err != nil {
+			l.ErrorF("Failed to release previous checkout: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+			return
+		}
+
+		newOrderID, err := createOrder(ctx, tx, req.CartID, addressID, userID, total)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
 			return
 		}
 
+		if err := snapshotOrderItems(ctx, tx, newOrderID, req.CartID, app.Env.TaxRate); err != nil {
+			l.ErrorF("Failed to save order items: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+			return
+		}
+
+		var dest inventory.Destination
+		if !digitalOnly {
+			dest, err = fetchDestination(ctx, tx, req.Address.ID)
+			if err != nil {
+				l.ErrorF("Failed to fetch delivery address: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+				return
+			}
+		}
+
+		if err := reserveStock(ctx, tx, newOrderID, userID, dest, cartItems); err != nil {
+			if errors.Is(err, inventory.ErrInsufficientStock) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Not enough stock for one or more items in your cart"})
+				return
+			}
+			l.ErrorF("Failed to reserve stock: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+			return
+		}
+
 		razorpayOrderID, providerData, err := initiatePayment(total, newOrderID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to initiate payment"})
@@ -157,10 +204,15 @@ func beginTransaction(ctx context.Context, app *conf.Config) (*sql.Tx, error) {
 }
 
 func fetchCartItems(ctx context.Context, tx *sql.Tx, cartID uuid.UUID) ([]cart.CartItem, error) {
+	// Charge the price valid right now, not the one from when the item was added
+	if err := cart.RefreshCartPrices(ctx, tx, cartID); err != nil {
+		return nil, err
+	}
+
 	rows, err := tx.QueryContext(ctx, `
-        SELECT ci.product_id, ci.quantity, p.price
+        SELECT ci.product_id, ci.quantity, ci.purchase_price, p.product_type, p.status, p.deleted_at
         FROM cart_items ci
-        JOIN products p ON ci.product_id = p.id
+        JOIN products p ON p.id = ci.product_id
         WHERE ci.cart_id = $1
     `, cartID)
 	if err != nil {
@@ -172,15 +224,41 @@ func fetchCartItems(ctx context.Context, tx *sql.Tx, cartID uuid.UUID) ([]cart.C
 	for rows.Next() {
 		var cartItem cart.CartItem
 		var price float64
-		if err := rows.Scan(&cartItem.ProductID, &cartItem.Quantity, &price); err != nil {
+		var productType product.ProductType
+		var status product.ProductStatus
+		var deletedAt null.Time
+		if err := rows.Scan(&cartItem.ProductID, &cartItem.Quantity, &price, &productType, &status, &deletedAt); err != nil {
 			return nil, err
 		}
 		cartItem.PurchasePrice = price
+		cartItem.Product = &product.Product{ID: cartItem.ProductID, Type: productType, Status: status, DeletedAt: deletedAt}
 		cartItems = append(cartItems, cartItem)
 	}
 	return cartItems, nil
 }
 
+// unlistedProducts returns the products in the cart that were taken off the
+// store or deleted since they were added.
+func unlistedProducts(cartItems []cart.CartItem) []uuid.UUID {
+	var unlisted []uuid.UUID
+	for _, item := range cartItems {
+		if item.Product != nil && (item.Product.Status != product.StatusPublished || item.Product.DeletedAt.Valid) {
+			unlisted = append(unlisted, item.ProductID)
+		}
+	}
+	return unlisted
+}
+
+// isDigitalOnly reports whether none of the items has to be shipped.
+func isDigitalOnly(cartItems []cart.CartItem) bool {
+	for _, item := range cartItems {
+		if item.Product == nil || item.Product.Type != product.ProductDigital {
+			return false
+		}
+	}
+	return len(cartItems) > 0
+}
+
 func calculateTotal(cartItems []cart.CartItem) float64 {
 	total := 0.0
 	for _, item := range cartItems {
@@ -201,15 +279,196 @@ func verifyCartOwnership(ctx context.Context, tx *sql.Tx, cartID, userID uuid.UU
 	return err == nil && cartExists
 }
 
-func createOrder(ctx context.Context, tx *sql.Tx, req AddOrder2Request, userID uuid.UUID, total float64) (uuid.UUID, error) {
+func createOrder(ctx context.Context, tx *sql.Tx, cartID uuid.UUID, addressID uuid.NullUUID, userID uuid.UUID, total float64) (uuid.UUID, error) {
 	newOrderID := uuid.New()
 	_, err := tx.ExecContext(ctx, `
-        INSERT INTO orders (id, cart_id, user_id, address_id, total, created)
-        VALUES ($1, $2, $3, $4, $5, $6)
-    `, newOrderID, req.CartID, userID, req.Address.ID, total, time.Now())
+        INSERT INTO orders (id, cart_id, user_id, address_id, total, reserved_until, created)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+    `, newOrderID, cartID, userID, addressID, total, time.Now().Add(reservationLifetime), time.Now())
 	return newOrderID, err
 }
 
+// snapshotOrderItems copies the lines of the cart into the order together with
+// everything needed to show them later, including what each bundle contains.
+// Taxable products are charged taxRate.
+func snapshotOrderItems(ctx context.Context, tx *sql.Tx, orderID, cartID uuid.UUID, taxRate float64) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO order_items (order_id, product_id, product_type, name, sku, image_url, quantity, purchase_price,
+			tax_rate, merchant_id, merchant_name, brand_id, brand_name, status)
+		SELECT $1, p.id, p.product_type, p.name, p.sku, p.image_url, ci.quantity, ci.purchase_price,
+			CASE WHEN p.taxable THEN $3 ELSE 0 END, p.merchant_id, COALESCE(m.name, ''), p.brand_id, b.name, $4
+		FROM cart_items ci
+		JOIN products p ON p.id = ci.product_id
+		LEFT JOIN merchants m ON m.id = p.merchant_id
+		LEFT JOIN brands b ON b.id = p.brand_id
+		WHERE ci.cart_id = $2
+	`, orderID, cartID, taxRate, cart.NotProcessed)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO order_item_components (order_item_id, component_id, name, sku, quantity)
+		SELECT oi.id, p.id, p.name, p.sku, bc.quantity
+		FROM order_items oi
+		JOIN bundle_components bc ON bc.bundle_id = oi.product_id
+		JOIN products p ON p.id = bc.component_id
+		WHERE oi.order_id = $1 AND oi.product_type = $2
+	`, orderID, product.ProductBundle)
+	return err
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// fetchItemComponents returns the components the given bundle lines were sold
+// with, keyed by order item. Quantities are per bundle.
+func fetchItemComponents(ctx context.Context, db queryer, itemIDs []uuid.UUID) (map[uuid.UUID][]ItemComponent, error) {
+	components := make(map[uuid.UUID][]ItemComponent)
+	if len(itemIDs) == 0 {
+		return components, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT order_item_id, component_id, name, sku, quantity
+		FROM order_item_components
+		WHERE order_item_id = ANY($1)
+		ORDER BY name
+	`, pq.Array(itemIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var itemID uuid.UUID
+		var comp ItemComponent
+		if err := rows.Scan(&itemID, &comp.ComponentID, &comp.Name, &comp.SKU, &comp.Quantity); err != nil {
+			return nil, err
+		}
+		components[itemID] = append(components[itemID], comp)
+	}
+	return components, rows.Err()
+}
+
+const orderItemColumns = `id, order_id, product_id, product_type, name, sku, image_url, quantity, purchase_price,
+	tax_rate, merchant_id, merchant_name, brand_id, brand_name, status, location_id, updated, created`
+
+func scanOrderItem(row interface{ Scan(...any) error }, item *OrderItem) error {
+	return row.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.ProductType, &item.Name, &item.SKU, &item.ImageURL,
+		&item.Quantity, &item.PurchasePrice, &item.TaxRate, &item.MerchantID, &item.MerchantName, &item.BrandID,
+		&item.BrandName, &item.Status, &item.LocationID, &item.UpdatedAt, &item.CreatedAt)
+}
+
+func fetchOrderItems(ctx context.Context, tx *sql.Tx, orderID uuid.UUID) ([]OrderItem, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT "+orderItemColumns+" FROM order_items WHERE order_id = $1 ORDER BY created, name", orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []OrderItem{}
+	for rows.Next() {
+		var item OrderItem
+		if err := scanOrderItem(rows, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func fetchDestination(ctx context.Context, tx *sql.Tx, addressID uuid.UUID) (inventory.Destination, error) {
+	var dest inventory.Destination
+	err := tx.QueryRowContext(ctx, "SELECT zip_code, state FROM addresses WHERE id = $1", addressID).Scan(&dest.ZipCode, &dest.State)
+	return dest, err
+}
+
+// reserveStock holds the ordered units until the payment is captured. Each
+// item is reserved at the location picked to ship it, which is recorded on
+// the order item. Bundles reserve their components, which may ship from
+// different locations.
+func reserveStock(ctx context.Context, tx *sql.Tx, orderID, userID uuid.UUID, dest inventory.Destination, cartItems []cart.CartItem) error {
+	var bundleIDs []uuid.UUID
+	for _, item := range cartItems {
+		if item.Product != nil && item.Product.Type == product.ProductBundle {
+			bundleIDs = append(bundleIDs, item.ProductID)
+		}
+	}
+	components, err := product.FetchBundleComponents(ctx, tx, bundleIDs)
+	if err != nil {
+		return err
+	}
+
+	actor := uuid.NullUUID{UUID: userID, Valid: true}
+	for _, item := range cartItems {
+		productType := product.ProductPhysical
+		if item.Product != nil {
+			productType = item.Product.Type
+		}
+
+		switch productType {
+		case product.ProductDigital:
+			// Digital products are not kept in stock
+			continue
+		case product.ProductBundle:
+			if len(components[item.ProductID]) == 0 {
+				return inventory.ErrInsufficientStock
+			}
+			for _, comp := range components[item.ProductID] {
+				_, err := inventory.ReserveOrderItem(ctx, tx, orderID, comp.ComponentID, item.Quantity*comp.Quantity, dest, actor)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		locationID, err := inventory.ReserveOrderItem(ctx, tx, orderID, item.ProductID, item.Quantity, dest, actor)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE order_items SET location_id = $1 WHERE order_id = $2 AND product_id = $3", locationID, orderID, item.ProductID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restockOrderItem puts the units of a cancelled order item back into stock.
+func restockOrderItem(ctx context.Context, tx *sql.Tx, item OrderItem, actor uuid.NullUUID) error {
+	// A purged product has no stock left to return to
+	if !item.ProductID.Valid {
+		return nil
+	}
+
+	switch item.ProductType {
+	case product.ProductDigital:
+		return nil
+	case product.ProductBundle:
+		// Return what was reserved, even if the bundle changed since
+		components, err := fetchItemComponents(ctx, tx, []uuid.UUID{item.ID})
+		if err != nil {
+			return err
+		}
+		for _, comp := range components[item.ID] {
+			if !comp.ComponentID.Valid {
+				continue
+			}
+			err := inventory.CancelOrderItem(ctx, tx, item.OrderID, comp.ComponentID.UUID, uuid.Nil, item.Quantity*comp.Quantity, actor)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return inventory.CancelOrderItem(ctx, tx, item.OrderID, item.ProductID.UUID, item.LocationID.UUID, item.Quantity, actor)
+	}
+}
+
 func initiatePayment(total float64, orderID uuid.UUID) (string, interface{}, error) {
 	newReceiptID := uuid.New()
 	return payment.Executerazorpay(total, newReceiptID, orderID.String())
@@ -257,17 +516,13 @@ func SearchOrders(app *conf.Config) gin.HandlerFunc { // Updated SearchOrders fu
 			return
 		}
 
-		userRoleStr := c.MustGet("role").(string)
-
-		userRole := common.GetUserRole(userRoleStr)
-
 		query := "SELECT * FROM orders WHERE id = $1" // Start with the most specific filter
 
 		var args []interface{}
 		args = append(args, orderID)
 
-		if userRole != common.RoleAdmin {
-			query += " AND user_id = $2" // Non-admins can only see their own orders
+		if !middleware.HasPermission(c, app, permission.OrderRead) {
+			query += " AND user_id = $2" // Customers can only see their own orders
 			args = append(args, userID)
 		}
 
@@ -474,10 +729,6 @@ func FetchOrder(app *conf.Config) gin.HandlerFunc { // Updated FetchOrder
 			return
 		}
 
-		userRoleStr := c.MustGet("role").(string)
-
-		userRole := common.GetUserRole(userRoleStr)
-
 		// Use a transaction for consistent reads
 
 		ctx := context.Background()
@@ -510,7 +761,7 @@ func FetchOrder(app *conf.Config) gin.HandlerFunc { // Updated FetchOrder
 
 		}
 
-		if userRole != common.RoleAdmin && order.UserID != userID {
+		if order.UserID != userID && !middleware.HasPermission(c, app, permission.OrderRead) {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 			return
 
@@ -530,7 +781,11 @@ func FetchOrder(app *conf.Config) gin.HandlerFunc { // Updated FetchOrder
 
 		orderInfo.Created = order.Created
 
-		err = tx.QueryRowContext(ctx, `SELECT * from addresses WHERE id=$1`, order.AddressID).Scan(&orderInfo.Address.ID, &orderInfo.Address.UserID, &orderInfo.Address.AddressLine1, &orderInfo.Address.AddressLine2, &orderInfo.Address.City, &orderInfo.Address.State, &orderInfo.Address.Country, &orderInfo.Address.ZipCode, &orderInfo.Address.IsDefault, &orderInfo.Address.Updated, &orderInfo.Address.Created)
+		// Digital only orders have no delivery address
+		if order.AddressID.Valid {
+			orderInfo.Address = &address.Address{}
+			err = tx.QueryRowContext(ctx, `SELECT * from addresses WHERE id=$1`, order.AddressID).Scan(&orderInfo.Address.ID, &orderInfo.Address.UserID, &orderInfo.Address.AddressLine1, &orderInfo.Address.AddressLine2, &orderInfo.Address.City, &orderInfo.Address.State, &orderInfo.Address.Country, &orderInfo.Address.ZipCode, &orderInfo.Address.IsDefault, &orderInfo.Address.Updated, &orderInfo.Address.Created)
+		}
 
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
@@ -545,58 +800,29 @@ func FetchOrder(app *conf.Config) gin.HandlerFunc { // Updated FetchOrder
 
 		}
 
-		// Fetch associated cart items
-		rows, err := tx.QueryContext(ctx, `
-			SELECT ci.product_id, ci.quantity, ci.purchase_price, ci.status
-			FROM cart_items ci
-			WHERE ci.cart_id = $1
-		`, order.CartID)
+		// Lines are rendered from the checkout snapshot, never from the products
+		orderInfo.Items, err = fetchOrderItems(ctx, tx, order.ID)
 		if err != nil {
-
-			l.DebugF("Error fetching cart items: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch cart items"})
-
+			l.ErrorF("Failed to fetch order items: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order items"})
 			return
 		}
-		rows.Close()
-
-		// defer rows.Close()                            // Close rows to free resources
-		orderInfo.Products = make([]cart.CartItem, 0) // Initialize to empty slice
-
-		for rows.Next() {
-
-			var cartItem cart.CartItem
-			err = rows.Scan(&cartItem.ProductID, &cartItem.Quantity, &cartItem.PurchasePrice, &cartItem.Status)
-
-			if err != nil {
-				l.DebugF("Failed to scan cart item: %v", err)                                             // Detailed error message
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve cart details"}) // Generic error for security
-
-				return
-
+		var bundleItemIDs []uuid.UUID
+		for _, item := range orderInfo.Items {
+			if item.ProductType == product.ProductBundle {
+				bundleItemIDs = append(bundleItemIDs, item.ID)
 			}
-			l.DebugF("Fetching product details for product ID: %s", cartItem.ProductID)
-			var product product.Product
-			query := "SELECT id, sku, name, slug, image_url, description, quantity, price, taxable, is_active, brand_id, merchant_id, updated, created FROM products WHERE id = $1"
-
-			err = tx.QueryRowContext(ctx, query, cartItem.ProductID).Scan(
-				&product.ID, &product.SKU, &product.Name, &product.Slug, &product.ImageURL, &product.ImageKey,
-				&product.Description, &product.Quantity, &product.Price, &product.Taxable, &product.IsActive,
-				&product.BrandID, &product.MerchantID, &product.Updated, &product.Created,
-			)
-			if err != nil {
-				if errors.Is(err, sql.ErrNoRows) {
-					c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Product with ID %s not found in cart", cartItem.ProductID)}) // More informative error
-				} else {
-					l.ErrorF("Failed to fetch product: %#v", err)                                          // Log with more context
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product data"}) // Generic message for security
-				}
-				return
-			}
-			cartItem.Product = &product
-			orderInfo.Products = append(orderInfo.Products, cartItem)
-
 		}
+		components, err := fetchItemComponents(ctx, tx, bundleItemIDs)
+		if err != nil {
+			l.ErrorF("Failed to fetch bundle components: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch order items"})
+			return
+		}
+		for i := range orderInfo.Items {
+			orderInfo.Items[i].Components = components[orderInfo.Items[i].ID]
+		}
+		orderInfo.Products = orderInfo.Items
 
 		c.JSON(http.StatusOK, gin.H{"order": orderInfo})
 
@@ -640,6 +866,32 @@ func CancelOrder(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
+		// Rendered now, the order is gone once the mail goes out. Items cancelled
+		// before were refunded already.
+		items, err := fetchOrderItems(ctx, tx, orderID)
+		if err == nil {
+			var refunded []OrderItem
+			for _, item := range items {
+				if item.Status != cart.Cancelled {
+					refunded = append(refunded, item)
+				}
+			}
+			err = queueRefundMail(ctx, tx, app, orderID, refunded)
+		}
+		if err != nil {
+			l.ErrorF("Failed to queue refund mail: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel order"})
+			return
+		}
+
+		// Reserved stock goes back before the order and its ledger link disappear
+		err = inventory.ReleaseOrder(ctx, tx, orderID, "Order cancelled", uuid.NullUUID{UUID: userID, Valid: true})
+		if err != nil {
+			l.ErrorF("Failed to release reserved stock: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to release reserved stock"})
+			return
+		}
+
 		// Use ExecContext within the transaction for all DELETE operations:
 
 		_, err = tx.ExecContext(ctx, "DELETE FROM cart_items WHERE cart_id = $1", cartID)
@@ -694,11 +946,6 @@ func UpdateItemStatus(app *conf.Config) gin.HandlerFunc { // Updated UpdateItemS
 			return
 		}
 
-		userIDStr := c.GetString("userID")
-		userID, _ := uuid.Parse(userIDStr)
-		userRoleStr := c.MustGet("role").(string)
-		userRole := common.GetUserRole(userRoleStr)
-
 		status := req.Status // Use status from request directly
 		ctx := context.Background()
 		tx, err := app.DB.BeginTx(ctx, nil) // Start transaction
@@ -714,13 +961,7 @@ func UpdateItemStatus(app *conf.Config) gin.HandlerFunc { // Updated UpdateItemS
 
 		// Check if the order item exists and get details for authorization and updates
 		var orderItem OrderItem
-		err = tx.QueryRowContext(ctx, `
-			SELECT oi.order_id, oi.product_id, oi.quantity, o.user_id  -- Select necessary fields
-			FROM order_items oi  -- Correct table name
-			JOIN orders o ON oi.order_id = o.id  -- Assuming you have an orders table with user_id
-			WHERE oi.id = $1  -- Correct where condition
-		`, orderItemID).Scan(&orderItem.OrderID, &orderItem.ProductID, &orderItem.Quantity, &userID) // Get user ID for verification
-
+		err = scanOrderItem(tx.QueryRowContext(ctx, "SELECT "+orderItemColumns+" FROM order_items WHERE id = $1 FOR UPDATE", orderItemID), &orderItem)
 		if err != nil {
 
 			if errors.Is(err, sql.ErrNoRows) {
@@ -734,51 +975,13 @@ func UpdateItemStatus(app *conf.Config) gin.HandlerFunc { // Updated UpdateItemS
 
 		}
 
-		// Authorization Check: Ensure current user is authorized
-		if userRole == common.RoleMerchant {
-
-			var merchantID uuid.UUID
-
-			err = tx.QueryRowContext(ctx, `SELECT merchant_id from products where id=$1`, orderItem.ProductID).Scan(&merchantID)
-
-			if err != nil {
-				l.DebugF("Error getting merchant ID: %v", err) // Log with detail
-
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve merchant"}) // Return a more generic error
-
-				return
-
-			}
-
-			var authMerchant common.Merchant
-			err = tx.QueryRowContext(ctx, "SELECT user_id FROM merchants WHERE id = $1", merchantID).Scan(&authMerchant.UserID)
-
-			if err != nil {
-				l.ErrorF("Error fetching merchant details: %v", err)                                 // Log for debug
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify merchant."}) // More generic message
-
-				return
-
-			}
-
-			if authMerchant.UserID != userID {
-
-				c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to update this order item."}) // Correct status code and more specific error
-
-				return
-
-			}
-
-		} else if userRole != common.RoleAdmin { // Admins can change any order status
-
-			c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized access."}) // More specific message
-
+		// Merchants only handle the items they sold
+		if !middleware.Allowed(c, app, permission.OrderFulfill, orderItem.MerchantID.UUID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to update this order item."})
 			return
-
 		}
 
-		// Update the cart_items.status within the transaction.  Add error handling.
-		_, err = tx.ExecContext(ctx, "UPDATE cart_items SET status = $1, updated = $2 WHERE id = $3", status, time.Now(), orderItemID)
+		_, err = tx.ExecContext(ctx, "UPDATE order_items SET status = $1, updated = $2 WHERE id = $3", status, time.Now(), orderItemID)
 
 		if err != nil {
 
@@ -788,14 +991,19 @@ func UpdateItemStatus(app *conf.Config) gin.HandlerFunc { // Updated UpdateItemS
 			return
 		}
 
-		if status == cart.Cancelled { // Use the enum from the correct package
+		if status == cart.Shipped && orderItem.Status != cart.Shipped {
+			if err := queueOrderMail(ctx, tx, app, mail.TemplateOrderShipped, orderItem.OrderID, []OrderItem{orderItem}); err != nil {
+				l.ErrorF("Failed to queue shipping mail: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order item status"})
+				return
+			}
+		}
+
+		if status == cart.Cancelled && orderItem.Status != cart.Cancelled { // Units of an item go back only once
 
-			// Update product quantity
-			_, err = tx.ExecContext(ctx, `
-                UPDATE products 
-                SET quantity = quantity + $1 
-                WHERE id = $2
-            `, orderItem.Quantity, orderItem.ProductID) // Use tx.ExecContext and correct query
+			// Put the units back into stock
+			actor, _ := uuid.Parse(c.GetString("userID"))
+			err = restockOrderItem(ctx, tx, orderItem, uuid.NullUUID{UUID: actor, Valid: actor != uuid.Nil})
 			if err != nil {
 
 				l.DebugF("Failed to update product quantity: %v", err)                                      // Log error
@@ -805,13 +1013,19 @@ func UpdateItemStatus(app *conf.Config) gin.HandlerFunc { // Updated UpdateItemS
 
 			}
 
+			if err := queueRefundMail(ctx, tx, app, orderItem.OrderID, []OrderItem{orderItem}); err != nil {
+				l.ErrorF("Failed to queue refund mail: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order item status"})
+				return
+			}
+
 			// Check if all items are cancelled
 
 			var activeOrderItemsCount int
 
 			err = tx.QueryRowContext(ctx, `
-				SELECT COUNT(*) FROM cart_items WHERE cart_id = $1 AND status != $2
-			`, req.CartID, cart.Cancelled).Scan(&activeOrderItemsCount) // Use $1 and req.CartID.
+				SELECT COUNT(*) FROM order_items WHERE order_id = $1 AND status != $2
+			`, orderItem.OrderID, cart.Cancelled).Scan(&activeOrderItemsCount)
 
 			if err != nil {
 
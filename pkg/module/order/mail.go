@@ -0,0 +1,52 @@
+package order
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+
+	"src/pkg/conf"
+	"src/pkg/mail"
+	"src/pkg/module/payment"
+)
+
+// queueOrderMail queues a mail about some items of the order to the buyer.
+func queueOrderMail(ctx context.Context, tx *sql.Tx, app *conf.Config, template string, orderID uuid.UUID, items []OrderItem) error {
+	data := mail.OrderData{OrderID: orderID, OrderURL: app.Env.ClientURL + "/orders/" + orderID.String()}
+	var email string
+	err := tx.QueryRowContext(ctx, `
+		SELECT u.email, COALESCE(u.first_name, '')
+		FROM orders o
+		JOIN users u ON u.id = o.user_id
+		WHERE o.id = $1
+	`, orderID).Scan(&email, &data.Name)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		amount := item.PurchasePrice * float64(item.Quantity)
+		data.Items = append(data.Items, mail.OrderLine{Name: item.Name, Quantity: item.Quantity, Amount: amount})
+		data.Total += amount
+	}
+	return mail.Enqueue(ctx, tx, template, email, data)
+}
+
+// orderPaid tells whether the payment of the order was captured, so cancelled
+// items are refunded.
+func orderPaid(ctx context.Context, tx *sql.Tx, orderID uuid.UUID) (bool, error) {
+	var paid bool
+	err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM receipts WHERE order_id = $1 AND payment_status = $2)", orderID, payment.PaymentStatusCaptured).Scan(&paid)
+	return paid, err
+}
+
+// queueRefundMail tells the buyer the cancelled items of a paid order are
+// refunded. Nothing is sent for unpaid orders.
+func queueRefundMail(ctx context.Context, tx *sql.Tx, app *conf.Config, orderID uuid.UUID, items []OrderItem) error {
+	paid, err := orderPaid(ctx, tx, orderID)
+	if err != nil || !paid || len(items) == 0 {
+		return err
+	}
+	return queueOrderMail(ctx, tx, app, mail.TemplateOrderRefund, orderID, items)
+}
@@ -0,0 +1,174 @@
+package order
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/middleware"
+	"src/pkg/module/payment"
+	"src/pkg/module/product"
+	"src/pkg/permission"
+)
+
+// FetchMerchantOrders lists the part of every paid order a merchant has to
+// fulfil. Bundle lines are expanded into the components to pick and pack.
+func FetchMerchantOrders(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Users seeing the orders of every merchant may name one, or list
+		// them all with uuid.Nil
+		merchantID, ok := middleware.MerchantScope(c, app, permission.OrderRead)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
+			return
+		}
+		if merchantID == uuid.Nil && c.Query("merchantId") != "" {
+			id, err := uuid.Parse(c.Query("merchantId"))
+			if err != nil || id == uuid.Nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
+				return
+			}
+			merchantID = id
+		}
+
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+		if err != nil || limit < 1 {
+			limit = 10
+		}
+
+		rows, err := app.DB.QueryContext(c, `
+			SELECT o.id, o.user_id, o.address_id, o.created
+			FROM orders o
+			WHERE EXISTS (
+				SELECT 1 FROM order_items oi
+				WHERE oi.order_id = o.id AND ($1 = $4 OR oi.merchant_id = $1)
+			) AND EXISTS (
+				SELECT 1 FROM receipts r WHERE r.order_id = o.id AND r.payment_status = $5
+			)
+			ORDER BY o.created DESC
+			LIMIT $2 OFFSET $3
+		`, merchantID, limit, (page-1)*limit, uuid.Nil, payment.PaymentStatusCaptured)
+		if err != nil {
+			l.ErrorF("Error fetching merchant orders: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
+			return
+		}
+		defer rows.Close()
+
+		subOrders := []SubOrder{}
+		index := make(map[uuid.UUID]int)
+		var orderIDs []uuid.UUID
+		for rows.Next() {
+			so := SubOrder{Items: []SubOrderItem{}}
+			if err := rows.Scan(&so.OrderID, &so.UserID, &so.AddressID, &so.Created); err != nil {
+				l.ErrorF("Error scanning merchant order: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
+				return
+			}
+			index[so.OrderID] = len(subOrders)
+			orderIDs = append(orderIDs, so.OrderID)
+			subOrders = append(subOrders, so)
+		}
+		if len(orderIDs) == 0 {
+			c.JSON(http.StatusOK, gin.H{"orders": subOrders})
+			return
+		}
+
+		itemRows, err := app.DB.QueryContext(c, `
+			SELECT order_id, id, merchant_id, product_id, name, sku, product_type, quantity, purchase_price, status, location_id
+			FROM order_items
+			WHERE order_id = ANY($1) AND ($2 = $3 OR merchant_id = $2)
+			ORDER BY name
+		`, pq.Array(orderIDs), merchantID, uuid.Nil)
+		if err != nil {
+			l.ErrorF("Error fetching merchant order items: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
+			return
+		}
+		defer itemRows.Close()
+
+		var bundleItemIDs []uuid.UUID
+		for itemRows.Next() {
+			var orderID uuid.UUID
+			var item SubOrderItem
+			if err := itemRows.Scan(&orderID, &item.ID, &item.MerchantID, &item.ProductID, &item.Name, &item.SKU, &item.Type, &item.Quantity,
+				&item.PurchasePrice, &item.Status, &item.LocationID); err != nil {
+				l.ErrorF("Error scanning merchant order item: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
+				return
+			}
+			if item.Type == product.ProductBundle {
+				bundleItemIDs = append(bundleItemIDs, item.ID)
+			}
+			so := &subOrders[index[orderID]]
+			so.Items = append(so.Items, item)
+		}
+
+		components, err := fetchItemComponents(c, app.DB, bundleItemIDs)
+		if err != nil {
+			l.ErrorF("Error fetching bundle components: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
+			return
+		}
+
+		// Components of a bundle are routed one by one, the ledger knows where
+		locations, err := fetchReservedLocations(c, app, orderIDs)
+		if err != nil {
+			l.ErrorF("Error fetching reserved locations: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
+			return
+		}
+
+		for i := range subOrders {
+			for j := range subOrders[i].Items {
+				item := &subOrders[i].Items[j]
+				for _, comp := range components[item.ID] {
+					item.Components = append(item.Components, SubOrderComponent{
+						ProductID:  comp.ComponentID,
+						Name:       comp.Name,
+						SKU:        comp.SKU,
+						Quantity:   item.Quantity * comp.Quantity,
+						LocationID: locations[[2]uuid.UUID{subOrders[i].OrderID, comp.ComponentID.UUID}],
+					})
+				}
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"orders": subOrders, "page": page, "limit": limit})
+	}
+}
+
+// fetchReservedLocations returns where the products of the orders were
+// reserved, keyed by order and product.
+func fetchReservedLocations(c *gin.Context, app *conf.Config, orderIDs []uuid.UUID) (map[[2]uuid.UUID]uuid.NullUUID, error) {
+	rows, err := app.DB.QueryContext(c, `
+		SELECT DISTINCT ON (order_id, product_id) order_id, product_id, location_id
+		FROM stock_movements
+		WHERE order_id = ANY($1) AND movement_type = 'reserved'
+		ORDER BY order_id, product_id, created DESC
+	`, pq.Array(orderIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locations := make(map[[2]uuid.UUID]uuid.NullUUID)
+	for rows.Next() {
+		var orderID, productID uuid.UUID
+		var locationID uuid.NullUUID
+		if err := rows.Scan(&orderID, &productID, &locationID); err != nil {
+			return nil, err
+		}
+		locations[[2]uuid.UUID{orderID, productID}] = locationID
+	}
+	return locations, rows.Err()
+}
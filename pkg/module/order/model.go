@@ -3,29 +3,45 @@ package order
 import (
 	"src/pkg/module/address"
 	"src/pkg/module/cart"
+	"src/pkg/module/product"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/guregu/null/v5"
 	"github.com/lib/pq"
 )
 
 type Order struct {
-	ID        uuid.UUID   `db:"id" json:"_id"`
-	CartID    uuid.UUID   `db:"cart_id" json:"cartId"`
-	UserID    uuid.UUID   `db:"user_id" json:"userId"`
-	AddressID uuid.UUID   `db:"address_id" json:"addressId"`
-	Total     float64     `db:"total" json:"total"`
-	Updated   pq.NullTime `db:"updated" json:"updated"`
-	Created   time.Time   `db:"created" json:"created"`
+	ID        uuid.UUID     `db:"id" json:"_id"`
+	CartID    uuid.UUID     `db:"cart_id" json:"cartId"`
+	UserID    uuid.UUID     `db:"user_id" json:"userId"`
+	AddressID uuid.NullUUID `db:"address_id" json:"addressId"` // empty for digital only orders
+	Total     float64       `db:"total" json:"total"`
+	Updated   pq.NullTime   `db:"updated" json:"updated"`
+	Created   time.Time     `db:"created" json:"created"`
 }
 
+// OrderItem is a line of an order as it was bought. Everything but the
+// status and location is copied from the product at checkout and never
+// changes afterwards.
 type OrderItem struct {
 	ID            uuid.UUID           `db:"id" json:"_id"`
 	OrderID       uuid.UUID           `db:"order_id" json:"orderId"`
-	ProductID     uuid.UUID           `db:"product_id" json:"productId"`
+	ProductID     uuid.NullUUID       `db:"product_id" json:"productId"` // empty once the product is purged
+	ProductType   product.ProductType `db:"product_type" json:"type"`
+	Name          string              `db:"name" json:"name"`
+	SKU           string              `db:"sku" json:"sku"`
+	ImageURL      null.String         `db:"image_url" json:"imageUrl"`
 	Quantity      int                 `db:"quantity" json:"quantity"`
-	PurchasePrice float64             `db:"purchase_price" json:"purchasePrice"`
-	Status        cart.CartItemStatus `db:"status" json:"status"` // Assuming CartItemStatus is defined similarly in cart2
+	PurchasePrice float64             `db:"purchase_price" json:"purchasePrice"` // per unit
+	TaxRate       float64             `db:"tax_rate" json:"taxRate"`             // percent
+	MerchantID    uuid.NullUUID       `db:"merchant_id" json:"merchantId"`
+	MerchantName  string              `db:"merchant_name" json:"merchantName"`
+	BrandID       uuid.NullUUID       `db:"brand_id" json:"brandId"`
+	BrandName     null.String         `db:"brand_name" json:"brandName"`
+	Status        cart.CartItemStatus `db:"status" json:"status"`
+	LocationID    uuid.NullUUID       `db:"location_id" json:"locationId"` // merchant location fulfilling the item
+	Components    []ItemComponent     `json:"components,omitempty"`        // bundles only
 	UpdatedAt     time.Time           `db:"updated" json:"updated"`
 	CreatedAt     time.Time           `db:"created" json:"created"`
 }
@@ -58,14 +74,17 @@ type OrderItem struct {
 // }
 
 type OrderInfo struct { // Struct for fetching additional details
-	ID       uuid.UUID       `json:"_id"`
-	CartID   uuid.UUID       `json:"cartId"`
-	UserID   uuid.UUID       `json:"userId"`
-	Total    float64         `json:"total"`
-	Updated  pq.NullTime     `json:"updated"`
-	Created  time.Time       `json:"created"`
-	Address  address.Address `json:"address"`
-	Products []cart.CartItem `json:"products"`
+	ID      uuid.UUID        `json:"_id"`
+	CartID  uuid.UUID        `json:"cartId"`
+	UserID  uuid.UUID        `json:"userId"`
+	Total   float64          `json:"total"`
+	Updated pq.NullTime      `json:"updated"`
+	Created time.Time        `json:"created"`
+	Address *address.Address `json:"address"` // nil for digital only orders
+	Items   []OrderItem      `json:"items"`
+
+	// Deprecated: older clients read the lines from products, same as Items.
+	Products []OrderItem `json:"products"`
 }
 
 // // Request Structs
@@ -87,3 +106,61 @@ type UpdateOrderItemStatusRequest struct {
 	CartID  uuid.UUID           `json:"cartId" binding:"required"`
 	Status  cart.CartItemStatus `json:"status"`
 }
+
+// DownloadGrant is a file of a digital product the user has paid for.
+type DownloadGrant struct {
+	ID                 uuid.UUID `json:"_id"`
+	OrderID            uuid.UUID `json:"orderId"`
+	ProductID          uuid.UUID `json:"productId"`
+	ProductName        string    `json:"productName"`
+	FileID             uuid.UUID `json:"fileId"`
+	FileName           string    `json:"fileName"`
+	SizeBytes          int64     `json:"sizeBytes"`
+	DownloadLimit      int       `json:"downloadLimit"` // 0 is unlimited
+	DownloadCount      int       `json:"downloadCount"`
+	RemainingDownloads int       `json:"remainingDownloads,omitempty"`
+	ExpiresAt          null.Time `json:"expiresAt"`
+	Created            time.Time `json:"created"`
+}
+
+// SubOrder is the part of an order a single merchant fulfils.
+type SubOrder struct {
+	OrderID   uuid.UUID      `json:"orderId"`
+	UserID    uuid.UUID      `json:"userId"`
+	AddressID uuid.NullUUID  `json:"addressId"`
+	Created   time.Time      `json:"created"`
+	Items     []SubOrderItem `json:"items"`
+}
+
+type SubOrderItem struct {
+	ID            uuid.UUID           `json:"_id"`
+	MerchantID    uuid.NullUUID       `json:"merchantId"`
+	ProductID     uuid.NullUUID       `json:"productId"`
+	Name          string              `json:"name"`
+	SKU           string              `json:"sku"`
+	Type          product.ProductType `json:"type"`
+	Quantity      int                 `json:"quantity"`
+	PurchasePrice float64             `json:"purchasePrice"`
+	Status        cart.CartItemStatus `json:"status"`
+	LocationID    uuid.NullUUID       `json:"locationId"`
+	Components    []SubOrderComponent `json:"components,omitempty"` // bundles only
+}
+
+// ItemComponent is a product a bundle line was sold with. Quantity is per
+// bundle.
+type ItemComponent struct {
+	ComponentID uuid.NullUUID `json:"productId"` // empty once the product is purged
+	Name        string        `json:"name"`
+	SKU         string        `json:"sku"`
+	Quantity    int           `json:"quantity"`
+}
+
+// SubOrderComponent is a product to ship for a bundle line. Quantity is the
+// total for the line, not per bundle.
+type SubOrderComponent struct {
+	ProductID  uuid.NullUUID `json:"productId"`
+	Name       string        `json:"name"`
+	SKU        string        `json:"sku"`
+	Quantity   int           `json:"quantity"`
+	LocationID uuid.NullUUID `json:"locationId"`
+}
@@ -0,0 +1,134 @@
+package order
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"src/l"
+	"src/pkg/module/inventory"
+	"src/pkg/module/payment"
+)
+
+// Checkout reserves the stock of an order until reserved_until. When the
+// payment is not captured by then ReleaseExpiredReservations gives it back,
+// and checking out the same cart again gives back that of the previous try.
+
+const reservationLifetime = 30 * time.Minute
+
+// releasePendingOrders gives back the stock reserved by the unpaid orders of
+// the cart. The cart stays locked until tx ends, so concurrent checkouts of
+// it cannot both keep their reservations.
+func releasePendingOrders(ctx context.Context, tx *sql.Tx, cartID, userID uuid.UUID) error {
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM carts WHERE id = $1 FOR UPDATE", cartID); err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT o.id FROM orders o
+		WHERE o.cart_id = $1 AND o.user_id = $2 AND o.reserved_until IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM receipts r WHERE r.order_id = o.id AND r.payment_status = $3)
+		FOR UPDATE OF o
+	`, cartID, userID, payment.PaymentStatusCaptured)
+	if err != nil {
+		return err
+	}
+	var orderIDs []uuid.UUID
+	for rows.Next() {
+		var orderID uuid.UUID
+		if err := rows.Scan(&orderID); err != nil {
+			rows.Close()
+			return err
+		}
+		orderIDs = append(orderIDs, orderID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	actor := uuid.NullUUID{UUID: userID, Valid: true}
+	for _, orderID := range orderIDs {
+		if err := releaseReservation(ctx, tx, orderID, "Checked out again", actor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseReservation gives back the stock of an order and clears its deadline.
+func releaseReservation(ctx context.Context, tx *sql.Tx, orderID uuid.UUID, reason string, actorID uuid.NullUUID) error {
+	if err := inventory.ReleaseOrder(ctx, tx, orderID, reason, actorID); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "UPDATE orders SET reserved_until = NULL, updated = NOW() WHERE id = $1", orderID)
+	return err
+}
+
+// ReleaseExpiredReservations gives back the stock of the orders that were not
+// paid in time, and returns how many there were.
+func ReleaseExpiredReservations(ctx context.Context, db *sql.DB) (int, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id FROM orders WHERE reserved_until < NOW()")
+	if err != nil {
+		return 0, err
+	}
+	var orderIDs []uuid.UUID
+	for rows.Next() {
+		var orderID uuid.UUID
+		if err := rows.Scan(&orderID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		orderIDs = append(orderIDs, orderID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, orderID := range orderIDs {
+		ok, err := releaseExpiredOrder(ctx, db, orderID)
+		if err != nil {
+			l.ErrorF("Error releasing stock of order %s: %v", orderID, err)
+			continue
+		}
+		if ok {
+			released++
+		}
+	}
+	return released, nil
+}
+
+// releaseExpiredOrder releases one order in its own transaction, unless its
+// payment was captured or it was released meanwhile. The payment webhook
+// clears the deadline under the same row lock.
+func releaseExpiredOrder(ctx context.Context, db *sql.DB, orderID uuid.UUID) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var expired bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(o.reserved_until < NOW(), FALSE)
+			AND NOT EXISTS (SELECT 1 FROM receipts r WHERE r.order_id = o.id AND r.payment_status = $2)
+		FROM orders o WHERE o.id = $1
+		FOR UPDATE
+	`, orderID, payment.PaymentStatusCaptured).Scan(&expired)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil || !expired {
+		return false, err
+	}
+
+	if err := releaseReservation(ctx, tx, orderID, "Order not paid in time", uuid.NullUUID{}); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -1,9 +1,9 @@
 package order
 
 import (
-	"src/common"
 	"src/pkg/conf"
 	"src/pkg/middleware"
+	"src/pkg/permission"
 
 	"github.com/gin-gonic/gin"
 )
@@ -14,6 +14,7 @@ func SetupRoute(path string, r *gin.RouterGroup, app *conf.Config) {
 	{
 		order_route.POST("/add",
 			middleware.AuthMiddleware(app),
+			middleware.VerifiedEmail(app),
 			AddOrderWithCartItemAndAddress(app))
 
 		order_route.GET("/search",
@@ -28,17 +29,34 @@ func SetupRoute(path string, r *gin.RouterGroup, app *conf.Config) {
 			middleware.AuthMiddleware(app),
 			FetchUserOrders(app))
 
+		order_route.GET("/merchant",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.OrderRead),
+			FetchMerchantOrders(app))
+
+		order_route.GET("/downloads",
+			middleware.AuthMiddleware(app),
+			FetchUserDownloads(app))
+
+		order_route.POST("/downloads/:grantId/link",
+			middleware.AuthMiddleware(app),
+			CreateDownloadLink(app))
+
 		order_route.GET("/:orderId",
 			middleware.AuthMiddleware(app),
 			FetchOrder(app))
 
+		order_route.GET("/:orderId/downloads",
+			middleware.AuthMiddleware(app),
+			FetchOrderDownloads(app))
+
 		order_route.DELETE("/cancel/:orderId",
 			middleware.AuthMiddleware(app),
 			CancelOrder(app))
 
 		order_route.PUT("/status/item/:itemId",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleAdmin, common.RoleMerchant),
+			middleware.RequirePermission(app, permission.OrderFulfill),
 			UpdateItemStatus(app))
 
 	}
@@ -9,6 +9,8 @@ import (
 	"os"
 	"src/l"
 	"src/pkg/conf"
+	"src/pkg/module/inventory"
+	"src/pkg/module/product"
 	"time"
 
 	cashfree "github.com/cashfree/cashfree-pg/v4"
@@ -292,9 +294,17 @@ func handleRazorPayWebhook(app *conf.Config) gin.HandlerFunc {
             SET payment_status = $1,
                 updated = $2,
                 provider_data = $3
-            WHERE order_id = $4
+            WHERE order_id = $4 AND payment_status <> $1
         `
-		_, err = app.DB.ExecContext(c, updateQuery,
+		tx, err := app.DB.BeginTx(c, nil)
+		if err != nil {
+			l.DebugF("Error starting transaction: %s", err.Error())
+			c.JSON(500, gin.H{"error": "internal server error"})
+			return
+		}
+		defer tx.Rollback()
+
+		res, err := tx.ExecContext(c, updateQuery,
 			PaymentStatusCaptured,
 			time.Now().UTC(),
 			providerDataJSON,
@@ -305,6 +315,48 @@ func handleRazorPayWebhook(app *conf.Config) gin.HandlerFunc {
 			c.JSON(500, gin.H{"error": "internal server error"})
 			return
 		}
+		// Providers deliver webhooks more than once, only the first capture
+		// sells the stock and sends the confirmation
+		if n, err := res.RowsAffected(); err != nil {
+			l.DebugF("Error updating receipt: %s", err.Error())
+			c.JSON(500, gin.H{"error": "internal server error"})
+			return
+		} else if n == 0 {
+			c.JSON(200, gin.H{"status": "success"})
+			return
+		}
+
+		// Reserved stock of the order is sold now, and no longer expires. The
+		// row lock keeps the expiry job from releasing it meanwhile.
+		if _, err := tx.ExecContext(c, "UPDATE orders SET reserved_until = NULL WHERE id = $1", orderUUID); err != nil {
+			l.DebugF("Error clearing stock reservation: %s", err.Error())
+			c.JSON(500, gin.H{"error": "internal server error"})
+			return
+		}
+		if err := inventory.SellOrder(c, tx, orderUUID); err != nil {
+			l.DebugF("Error booking sold stock: %s", err.Error())
+			c.JSON(500, gin.H{"error": "internal server error"})
+			return
+		}
+
+		// Buyers of digital products can download them from now on
+		if err := product.GrantDownloads(c, tx, orderUUID); err != nil {
+			l.DebugF("Error granting downloads: %s", err.Error())
+			c.JSON(500, gin.H{"error": "internal server error"})
+			return
+		}
+
+		if err := queueOrderConfirmation(c, tx, app, orderUUID); err != nil {
+			l.DebugF("Error queueing order confirmation: %s", err.Error())
+			c.JSON(500, gin.H{"error": "internal server error"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.DebugF("Error committing transaction: %s", err.Error())
+			c.JSON(500, gin.H{"error": "internal server error"})
+			return
+		}
 
 		c.JSON(200, gin.H{"status": "success"})
 	}
@@ -0,0 +1,49 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+
+	"src/pkg/conf"
+	"src/pkg/mail"
+)
+
+// queueOrderConfirmation queues the mail telling the buyer the order is paid.
+func queueOrderConfirmation(ctx context.Context, tx *sql.Tx, app *conf.Config, orderID uuid.UUID) error {
+	data := mail.OrderData{OrderID: orderID, OrderURL: app.Env.ClientURL + "/orders/" + orderID.String()}
+	var email string
+	err := tx.QueryRowContext(ctx, `
+		SELECT u.email, COALESCE(u.first_name, ''), o.total
+		FROM orders o
+		JOIN users u ON u.id = o.user_id
+		WHERE o.id = $1
+	`, orderID).Scan(&email, &data.Name, &data.Total)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT name, quantity, purchase_price * quantity
+		FROM order_items
+		WHERE order_id = $1
+		ORDER BY created, name
+	`, orderID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var line mail.OrderLine
+		if err := rows.Scan(&line.Name, &line.Quantity, &line.Amount); err != nil {
+			return err
+		}
+		data.Items = append(data.Items, line)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return mail.Enqueue(ctx, tx, mail.TemplateOrderConfirmation, email, data)
+}
@@ -0,0 +1,243 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/guregu/null/v5"
+	"github.com/lib/pq"
+
+	category "src/pkg/module/category"
+)
+
+// fetchProductAttributes returns the specification values of the given products.
+func fetchProductAttributes(ctx context.Context, db queryer, productIDs []uuid.UUID) (map[uuid.UUID][]ProductAttribute, error) {
+	attributes := make(map[uuid.UUID][]ProductAttribute)
+	if len(productIDs) == 0 {
+		return attributes, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT pav.product_id, ca.id, ca.name, ca.slug, ca.type, ca.unit, ca.is_filterable,
+			pav.value_text, pav.value_number, pav.value_boolean
+		FROM product_attribute_values pav
+		JOIN category_attributes ca ON ca.id = pav.attribute_id
+		WHERE pav.product_id = ANY($1)
+		ORDER BY ca.sort_order, ca.name
+	`, pq.Array(productIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var productID uuid.UUID
+		var attribute ProductAttribute
+		var text null.String
+		var number null.Float
+		var boolean null.Bool
+		err := rows.Scan(&productID, &attribute.AttributeID, &attribute.Name, &attribute.Slug, &attribute.Type, &attribute.Unit,
+			&attribute.IsFilterable, &text, &number, &boolean)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case number.Valid:
+			attribute.Value = number.Float64
+		case boolean.Valid:
+			attribute.Value = boolean.Bool
+		default:
+			attribute.Value = text.String
+		}
+		attributes[productID] = append(attributes[productID], attribute)
+	}
+	return attributes, rows.Err()
+}
+
+// saveProductAttributes stores validated attribute values and removes cleared ones.
+func saveProductAttributes(ctx context.Context, tx *sql.Tx, productID uuid.UUID, set []category.AttributeValue, cleared []uuid.UUID) error {
+	for _, value := range set {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO product_attribute_values (product_id, attribute_id, value_text, value_number, value_boolean, updated)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (product_id, attribute_id) DO UPDATE
+			SET value_text = EXCLUDED.value_text, value_number = EXCLUDED.value_number,
+				value_boolean = EXCLUDED.value_boolean, updated = EXCLUDED.updated
+		`, productID, value.AttributeID, value.Text, value.Number, value.Boolean, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(cleared) > 0 {
+		_, err := tx.ExecContext(ctx, "DELETE FROM product_attribute_values WHERE product_id = $1 AND attribute_id = ANY($2)",
+			productID, pq.Array(cleared))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkCategoryChange validates moving the product to categoryID, with the
+// attribute changes of the same edit, against the schema of the new category
+// as AddProduct does. Current values the new category defines are kept. It
+// returns the values to store, or why the move is refused.
+func checkCategoryChange(ctx context.Context, db queryer, productID, categoryID uuid.UUID, changes map[string]interface{}) ([]category.AttributeValue, string, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM categories WHERE id = $1 AND deleted_at IS NULL)", categoryID).Scan(&exists)
+	if err != nil {
+		return nil, "", err
+	}
+	if !exists {
+		return nil, "Category not found", nil
+	}
+
+	schema, err := category.CategoryAttributes(ctx, db, []uuid.UUID{categoryID})
+	if err != nil {
+		return nil, "", err
+	}
+	current, err := fetchProductAttributes(ctx, db, []uuid.UUID{productID})
+	if err != nil {
+		return nil, "", err
+	}
+
+	values := make(map[string]interface{})
+	for _, attribute := range current[productID] {
+		for _, defined := range schema {
+			if defined.ID == attribute.AttributeID {
+				values[attribute.Slug] = attribute.Value
+			}
+		}
+	}
+	for slug, value := range changes {
+		values[slug] = value
+	}
+
+	set, _, err := category.ValidateAttributes(schema, values, true)
+	if err != nil {
+		return nil, err.Error(), nil
+	}
+	return set, "", nil
+}
+
+// moveProductCategory makes categoryID the only category of the product and
+// replaces its attribute values with those checkCategoryChange returned, so
+// values the new category does not define are dropped.
+func moveProductCategory(ctx context.Context, tx *sql.Tx, productID, categoryID uuid.UUID, values []category.AttributeValue) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM product_categories WHERE product_id = $1", productID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO product_categories (product_id, category_id) VALUES ($1, $2)", productID, categoryID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM product_attribute_values WHERE product_id = $1", productID); err != nil {
+		return err
+	}
+	return saveProductAttributes(ctx, tx, productID, values, nil)
+}
+
+// attributeFilterClauses turns attr[<slug>]=<values> query parameters into
+// SQL conditions on products p. Values are comma separated; a value of the
+// form "min..max" (either side optional) matches a numeric range.
+func attributeFilterClauses(filters map[string]string, argIndex int) (string, []interface{}, int, error) {
+	slugs := make([]string, 0, len(filters))
+	for slug := range filters {
+		slugs = append(slugs, slug)
+	}
+	sort.Strings(slugs) // keep the generated query stable
+
+	clauses := ""
+	args := []interface{}{}
+	for _, slug := range slugs {
+		raw := strings.TrimSpace(filters[slug])
+		if raw == "" {
+			continue
+		}
+
+		slugIndex := argIndex
+		args = append(args, slug)
+		argIndex++
+
+		var condition string
+		if minStr, maxStr, isRange := strings.Cut(raw, ".."); isRange {
+			condition = "pav.value_number IS NOT NULL"
+			if minStr != "" {
+				n, err := strconv.ParseFloat(minStr, 64)
+				if err != nil {
+					return "", nil, argIndex, fmt.Errorf("invalid range for attribute %s", slug)
+				}
+				condition += fmt.Sprintf(" AND pav.value_number >= $%d", argIndex)
+				args = append(args, n)
+				argIndex++
+			}
+			if maxStr != "" {
+				n, err := strconv.ParseFloat(maxStr, 64)
+				if err != nil {
+					return "", nil, argIndex, fmt.Errorf("invalid range for attribute %s", slug)
+				}
+				condition += fmt.Sprintf(" AND pav.value_number <= $%d", argIndex)
+				args = append(args, n)
+				argIndex++
+			}
+		} else {
+			values := strings.Split(raw, ",")
+			for i := range values {
+				values[i] = strings.TrimSpace(values[i])
+			}
+			condition = fmt.Sprintf("(pav.value_text = ANY($%d) OR pav.value_boolean::text = ANY($%d))", argIndex, argIndex)
+			args = append(args, pq.Array(values))
+			argIndex++
+		}
+
+		clauses += fmt.Sprintf(` AND p.id IN (
+			SELECT pav.product_id FROM product_attribute_values pav
+			JOIN category_attributes ca ON ca.id = pav.attribute_id
+			WHERE ca.is_filterable AND ca.slug = $%d AND %s)`, slugIndex, condition)
+	}
+	return clauses, args, argIndex, nil
+}
+
+// fetchAttributeFacets counts the values of filterable attributes among the
+// products matched by where, so the storefront can render filter options.
+func fetchAttributeFacets(ctx context.Context, db queryer, where string, args []interface{}) ([]AttributeFacet, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT ca.slug, ca.name, ca.type, ca.unit,
+			COALESCE(pav.value_text, pav.value_number::text, pav.value_boolean::text) AS value,
+			COUNT(DISTINCT pav.product_id)
+		FROM product_attribute_values pav
+		JOIN category_attributes ca ON ca.id = pav.attribute_id
+		WHERE ca.is_filterable AND pav.product_id IN (SELECT p.id FROM products p`+where+`)
+		GROUP BY ca.slug, ca.name, ca.type, ca.unit, value
+		ORDER BY ca.name, value
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facets := []AttributeFacet{}
+	index := make(map[string]int)
+	for rows.Next() {
+		var facet AttributeFacet
+		var value FacetValue
+		if err := rows.Scan(&facet.Slug, &facet.Name, &facet.Type, &facet.Unit, &value.Value, &value.Count); err != nil {
+			return nil, err
+		}
+		i, ok := index[facet.Slug]
+		if !ok {
+			i = len(facets)
+			index[facet.Slug] = i
+			facets = append(facets, facet)
+		}
+		facets[i].Values = append(facets[i].Values, value)
+	}
+	return facets, rows.Err()
+}
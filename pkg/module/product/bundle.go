@@ -0,0 +1,240 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/middleware"
+	"src/pkg/permission"
+)
+
+// availableSQL returns the expression for the units of the product aliased
+// alias that can be sold right now. Bundles are limited by their scarcest
+// component.
+func availableSQL(alias string) string {
+	return `CASE WHEN ` + alias + `.product_type = 'bundle' THEN COALESCE((
+		SELECT MIN((cp.quantity - cp.reserved_quantity) / bc.quantity)
+		FROM bundle_components bc
+		JOIN products cp ON cp.id = bc.component_id
+		WHERE bc.bundle_id = ` + alias + `.id), 0)
+		ELSE ` + alias + `.quantity - ` + alias + `.reserved_quantity END`
+}
+
+// InStockSQL returns the condition for whether the product aliased alias can
+// be bought right now. Digital products never run out.
+func InStockSQL(alias string) string {
+	return `(` + alias + `.product_type = 'digital' OR ` + availableSQL(alias) + ` > 0)`
+}
+
+// FetchBundleComponents returns the components of the given bundles.
+func FetchBundleComponents(ctx context.Context, db queryer, bundleIDs []uuid.UUID) (map[uuid.UUID][]BundleComponent, error) {
+	components := make(map[uuid.UUID][]BundleComponent)
+	if len(bundleIDs) == 0 {
+		return components, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT bc.bundle_id, p.id, p.name, p.sku, p.slug, bc.quantity, p.quantity - p.reserved_quantity
+		FROM bundle_components bc
+		JOIN products p ON p.id = bc.component_id
+		WHERE bc.bundle_id = ANY($1)
+		ORDER BY p.name
+	`, pq.Array(bundleIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bundleID uuid.UUID
+		var comp BundleComponent
+		if err := rows.Scan(&bundleID, &comp.ComponentID, &comp.Name, &comp.SKU, &comp.Slug, &comp.Quantity, &comp.Available); err != nil {
+			return nil, err
+		}
+		components[bundleID] = append(components[bundleID], comp)
+	}
+	return components, rows.Err()
+}
+
+// fetchBundle checks access to the bundle product in the request.
+func fetchBundle(c *gin.Context, app *conf.Config) (uuid.UUID, bool) {
+	productID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return uuid.Nil, false
+	}
+
+	if !middleware.CheckProductAccess(c, app, permission.ProductWrite, productID) {
+		return uuid.Nil, false
+	}
+
+	var productType ProductType
+	err = app.DB.QueryRowContext(c, "SELECT product_type FROM products WHERE id = $1", productID).Scan(&productType)
+	if err != nil {
+		l.ErrorF("Error fetching product type: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return uuid.Nil, false
+	}
+	if productType != ProductBundle {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product is not a bundle"})
+		return uuid.Nil, false
+	}
+	return productID, true
+}
+
+func ListBundleComponents(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bundleID, ok := fetchBundle(c, app)
+		if !ok {
+			return
+		}
+
+		components, err := FetchBundleComponents(c, app.DB, []uuid.UUID{bundleID})
+		if err != nil {
+			l.ErrorF("Error fetching bundle components: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bundle components"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"components": append([]BundleComponent{}, components[bundleID]...)})
+	}
+}
+
+// checkBundleComponents returns why the components cannot make up the
+// bundle, or an empty string when they can. Components must be physical
+// products of the bundle's merchant.
+func checkBundleComponents(ctx context.Context, db queryer, bundleID uuid.UUID, components []BundleComponentInput) (string, error) {
+	if len(components) == 0 {
+		return "A bundle needs at least one component", nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(components))
+	seen := make(map[uuid.UUID]bool, len(components))
+	for _, comp := range components {
+		if seen[comp.ComponentID] {
+			return "Each component can only be listed once", nil
+		}
+		seen[comp.ComponentID] = true
+		ids = append(ids, comp.ComponentID)
+	}
+
+	var valid int
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM products
+		WHERE id = ANY($1) AND product_type = $3 AND deleted_at IS NULL
+			AND merchant_id = (SELECT merchant_id FROM products WHERE id = $2)
+	`, pq.Array(ids), bundleID, ProductPhysical).Scan(&valid)
+	if err != nil {
+		return "", err
+	}
+	if valid != len(ids) {
+		return "Components must be your own physical products", nil
+	}
+	return "", nil
+}
+
+func replaceBundleComponents(ctx context.Context, tx *sql.Tx, bundleID uuid.UUID, components []BundleComponentInput) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM bundle_components WHERE bundle_id = $1", bundleID); err != nil {
+		return err
+	}
+	for _, comp := range components {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO bundle_components (bundle_id, component_id, quantity) VALUES ($1, $2, $3)
+		`, bundleID, comp.ComponentID, comp.Quantity)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetBundleComponents replaces the components of a bundle. On a published
+// bundle a merchant's change waits for review.
+func SetBundleComponents(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bundleID, ok := fetchBundle(c, app)
+		if !ok {
+			return
+		}
+
+		var req SetBundleComponentsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error starting transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		held, err := heldForReview(c, app, tx, bundleID)
+		if err != nil {
+			l.ErrorF("Error fetching product status: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update bundle"})
+			return
+		}
+		if held {
+			revision, problem, err := holdListingChanges(c, tx, bundleID, ListingChanges{Components: &req.Components})
+			if err != nil {
+				l.ErrorF("Error submitting product revision: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update bundle"})
+				return
+			}
+			if problem != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				l.ErrorF("Error committing transaction: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"success": true, "message": "Bundle changes are waiting for review", "revision": revision})
+			return
+		}
+
+		problem, err := checkBundleComponents(ctx, tx, bundleID, req.Components)
+		if err != nil {
+			l.ErrorF("Error checking bundle components: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update bundle"})
+			return
+		}
+		if problem != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+			return
+		}
+
+		if err := replaceBundleComponents(ctx, tx, bundleID, req.Components); err != nil {
+			l.ErrorF("Error replacing bundle components: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update bundle"})
+			return
+		}
+
+		components, err := FetchBundleComponents(ctx, tx, []uuid.UUID{bundleID})
+		if err != nil {
+			l.ErrorF("Error fetching bundle components: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update bundle"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Bundle updated successfully", "components": components[bundleID]})
+	}
+}
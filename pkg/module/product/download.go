@@ -0,0 +1,450 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/middleware"
+	"src/pkg/misc"
+	"src/pkg/permission"
+)
+
+const (
+	maxProductFileSize   = 100 << 20 // 100MB
+	maxFilesPerUpload    = 5
+	productFileKeyPrefix = "private/product-files"
+	productFileColumns   = "id, product_id, file_key, file_name, content_type, size_bytes, staged, created"
+)
+
+func scanProductFile(row interface{ Scan(...any) error }, f *ProductFile) error {
+	return row.Scan(&f.ID, &f.ProductID, &f.FileKey, &f.FileName, &f.ContentType, &f.SizeBytes, &f.Staged, &f.Created)
+}
+
+// GrantDownloads gives the buyer of a paid order access to the files of every
+// digital product in it. Calling it again for the same order is a no-op.
+func GrantDownloads(ctx context.Context, tx *sql.Tx, orderID uuid.UUID) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO download_grants (order_id, user_id, product_id, file_id, download_limit, expires_at)
+		SELECT o.id, o.user_id, p.id, pf.id, p.download_limit,
+			CASE WHEN p.download_expiry_days > 0 THEN NOW() + make_interval(days => p.download_expiry_days) END
+		FROM orders o
+		JOIN order_items oi ON oi.order_id = o.id AND oi.product_type = $2
+		JOIN products p ON p.id = oi.product_id
+		JOIN product_files pf ON pf.product_id = p.id AND NOT pf.staged
+		WHERE o.id = $1 AND oi.status <> 'Cancelled'
+		ON CONFLICT (order_id, file_id) DO NOTHING
+	`, orderID, ProductDigital)
+	return err
+}
+
+func fetchDigitalProduct(c *gin.Context, app *conf.Config) (uuid.UUID, bool) {
+	productID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return uuid.Nil, false
+	}
+
+	if !middleware.CheckProductAccess(c, app, permission.ProductWrite, productID) {
+		return uuid.Nil, false
+	}
+
+	var productType ProductType
+	if err := app.DB.QueryRowContext(c, "SELECT product_type FROM products WHERE id = $1", productID).Scan(&productType); err != nil {
+		l.ErrorF("Error fetching product type: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		return uuid.Nil, false
+	}
+	if productType != ProductDigital {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Files can only be attached to digital products"})
+		return uuid.Nil, false
+	}
+	return productID, true
+}
+
+// proposedFiles returns the files of a product as the pending revision would
+// leave them.
+func proposedFiles(ctx context.Context, db queryer, productID uuid.UUID) ([]uuid.UUID, error) {
+	changes, err := pendingChanges(ctx, db, productID)
+	if err != nil {
+		return nil, err
+	}
+	if changes.Files != nil {
+		return slices.Clone(*changes.Files), nil
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT id FROM product_files WHERE product_id = $1 AND NOT staged ORDER BY created", productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		files = append(files, id)
+	}
+	return files, rows.Err()
+}
+
+// checkFileChanges returns why the product cannot be left with just the given
+// files, or an empty string when it can.
+func checkFileChanges(ctx context.Context, db queryer, productID uuid.UUID, files []uuid.UUID) (string, error) {
+	if len(files) == 0 {
+		return "A digital product needs at least one file", nil
+	}
+
+	var known int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM product_files WHERE product_id = $1 AND id = ANY($2)", productID, pq.Array(files)).Scan(&known)
+	if err != nil {
+		return "", err
+	}
+	if known != len(files) {
+		return "Some files do not belong to this product", nil
+	}
+
+	// Buyers keep access to what they paid for
+	var sold bool
+	err = db.QueryRowContext(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM product_files pf
+			JOIN download_grants g ON g.file_id = pf.id
+			WHERE pf.product_id = $1 AND NOT (pf.id = ANY($2))
+		)
+	`, productID, pq.Array(files)).Scan(&sold)
+	if err != nil {
+		return "", err
+	}
+	if sold {
+		return "File has already been sold and cannot be deleted", nil
+	}
+	return "", nil
+}
+
+// applyFiles leaves the product with just the given files and returns the
+// ones it dropped.
+func applyFiles(ctx context.Context, tx *sql.Tx, productID uuid.UUID, files []uuid.UUID) ([]ProductFile, error) {
+	rows, err := tx.QueryContext(ctx, `
+		DELETE FROM product_files WHERE product_id = $1 AND NOT (id = ANY($2))
+		RETURNING `+productFileColumns, productID, pq.Array(files))
+	if err != nil {
+		return nil, err
+	}
+	var dropped []ProductFile
+	for rows.Next() {
+		var f ProductFile
+		if err := scanProductFile(rows, &f); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		dropped = append(dropped, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE product_files SET staged = FALSE WHERE product_id = $1 AND id = ANY($2)", productID, pq.Array(files))
+	return dropped, err
+}
+
+// cleanupFileObjects removes the stored objects of deleted files unless the
+// same content is still attached to another product.
+func cleanupFileObjects(ctx context.Context, app *conf.Config, files []ProductFile) {
+	for _, f := range files {
+		var stillUsed bool
+		if err := app.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM product_files WHERE file_key = $1)", f.FileKey).Scan(&stillUsed); err != nil {
+			l.ErrorF("Error checking file references: %v", err)
+			continue
+		}
+		if stillUsed {
+			continue
+		}
+		if err := misc.S3DeleteObjects(app, f.FileKey); err != nil {
+			l.ErrorF("Failed to delete file object %s: %v", f.FileKey, err)
+		}
+	}
+}
+
+func AddProductFiles(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, ok := fetchDigitalProduct(c, app)
+		if !ok {
+			return
+		}
+
+		form, err := c.MultipartForm()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid multipart form"})
+			return
+		}
+		files := form.File["files"]
+		if len(files) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "At least one file is required"})
+			return
+		}
+		if len(files) > maxFilesPerUpload {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("You can upload at most %d files at once", maxFilesPerUpload)})
+			return
+		}
+
+		for _, file := range files {
+			if file.Size > maxProductFileSize {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "File size should be less than 100MB"})
+				return
+			}
+		}
+
+		// Files are uploaded before the rows are written, and removed again
+		// unless the rows are committed
+		uploaded := make([]ProductFile, 0, len(files))
+		saved := false
+		defer func() {
+			if !saved {
+				cleanupFileObjects(context.WithoutCancel(c.Request.Context()), app, uploaded)
+			}
+		}()
+		for _, file := range files {
+			data, err := misc.ReadFormFile(file)
+			if err != nil {
+				l.ErrorF("Error reading file: %v", err)
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+				return
+			}
+
+			contentType := file.Header.Get("Content-Type")
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			key := misc.ContentHashKey(productFileKeyPrefix, data, path.Ext(file.Filename))
+			if err := misc.S3PutPrivateObject(app, key, data, contentType); err != nil {
+				l.ErrorF("File upload failed: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "File upload failed"})
+				return
+			}
+			uploaded = append(uploaded, ProductFile{
+				ProductID:   productID,
+				FileKey:     key,
+				FileName:    path.Base(file.Filename),
+				ContentType: contentType,
+				SizeBytes:   int64(len(data)),
+			})
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error starting transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		// Files added to a published product reach buyers once reviewed
+		held, err := heldForReview(c, app, tx, productID)
+		if err != nil {
+			l.ErrorF("Error fetching product status: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add files"})
+			return
+		}
+
+		added := make([]ProductFile, 0, len(uploaded))
+		for _, f := range uploaded {
+			err := scanProductFile(tx.QueryRowContext(ctx, `
+				INSERT INTO product_files (id, product_id, file_key, file_name, content_type, size_bytes, staged)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+				RETURNING `+productFileColumns,
+				uuid.New(), f.ProductID, f.FileKey, f.FileName, f.ContentType, f.SizeBytes, held), &f)
+			if err != nil {
+				l.ErrorF("Error adding product file: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add files"})
+				return
+			}
+			added = append(added, f)
+		}
+
+		var revision *ProductRevision
+		if held {
+			files, err := proposedFiles(ctx, tx, productID)
+			if err != nil {
+				l.ErrorF("Error fetching product files: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add files"})
+				return
+			}
+			for _, f := range added {
+				files = append(files, f.ID)
+			}
+			submitted, problem, err := holdListingChanges(c, tx, productID, ListingChanges{Files: &files})
+			if err != nil {
+				l.ErrorF("Error submitting product revision: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add files"})
+				return
+			}
+			if problem != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+				return
+			}
+			revision = &submitted
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+		saved = true
+
+		if revision != nil {
+			c.JSON(http.StatusOK, gin.H{"success": true, "message": "Files added, they are waiting for review", "files": added, "revision": revision})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Files added successfully", "files": added})
+	}
+}
+
+func ListProductFiles(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, ok := fetchDigitalProduct(c, app)
+		if !ok {
+			return
+		}
+
+		rows, err := app.DB.QueryContext(c, "SELECT "+productFileColumns+" FROM product_files WHERE product_id = $1 ORDER BY created", productID)
+		if err != nil {
+			l.ErrorF("Error fetching product files: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+			return
+		}
+		defer rows.Close()
+
+		files := []ProductFile{}
+		for rows.Next() {
+			var f ProductFile
+			if err := scanProductFile(rows, &f); err != nil {
+				l.ErrorF("Error scanning product file: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+				return
+			}
+			files = append(files, f)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"files": files})
+	}
+}
+
+func DeleteProductFile(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, ok := fetchDigitalProduct(c, app)
+		if !ok {
+			return
+		}
+		fileID, err := uuid.Parse(c.Param("fileId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+			return
+		}
+
+		// Buyers keep access to what they paid for
+		var sold bool
+		err = app.DB.QueryRowContext(c, "SELECT EXISTS(SELECT 1 FROM download_grants WHERE file_id = $1)", fileID).Scan(&sold)
+		if err != nil {
+			l.ErrorF("Error checking download grants: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+			return
+		}
+		if sold {
+			c.JSON(http.StatusConflict, gin.H{"error": "File has already been sold and cannot be deleted"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error starting transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		held, err := heldForReview(c, app, tx, productID)
+		if err != nil {
+			l.ErrorF("Error fetching product status: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+			return
+		}
+
+		// A published product keeps offering the file until the removal is
+		// reviewed, uploads that never went live are dropped right away
+		var revision *ProductRevision
+		if held {
+			files, err := proposedFiles(ctx, tx, productID)
+			if err != nil {
+				l.ErrorF("Error fetching product files: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+				return
+			}
+			i := slices.Index(files, fileID)
+			if i < 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+				return
+			}
+			files = slices.Delete(files, i, i+1)
+			submitted, problem, err := holdListingChanges(c, tx, productID, ListingChanges{Files: &files})
+			if err != nil {
+				l.ErrorF("Error submitting product revision: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+				return
+			}
+			if problem != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+				return
+			}
+			revision = &submitted
+		}
+
+		var f ProductFile
+		query := "DELETE FROM product_files WHERE id = $1 AND product_id = $2 RETURNING " + productFileColumns
+		if held {
+			query = "DELETE FROM product_files WHERE id = $1 AND product_id = $2 AND staged RETURNING " + productFileColumns
+		}
+		err = scanProductFile(tx.QueryRowContext(ctx, query, fileID, productID), &f)
+		deleted := err == nil
+		if errors.Is(err, sql.ErrNoRows) && !held {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			l.ErrorF("Error deleting product file: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+		if deleted {
+			cleanupFileObjects(context.WithoutCancel(ctx), app, []ProductFile{f})
+		}
+
+		if revision != nil {
+			c.JSON(http.StatusOK, gin.H{"success": true, "message": "File removed, the change is waiting for review", "revision": revision})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "File deleted successfully"})
+	}
+}
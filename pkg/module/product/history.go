@@ -0,0 +1,125 @@
+package product
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"src/l"
+	"src/pkg/conf"
+)
+
+// viewerFilter is the condition selecting the views of the current viewer.
+func viewerFilter(userID, visitorID uuid.NullUUID) (string, uuid.UUID) {
+	if userID.Valid {
+		return "user_id = $1", userID.UUID
+	}
+	return "visitor_id = $1 AND user_id IS NULL", visitorID.UUID
+}
+
+// FetchRecentlyViewed lists the products the signed in user or the anonymous
+// visitor looked at, most recent first.
+func FetchRecentlyViewed(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit < 1 || limit > 100 {
+			limit = 20
+		}
+
+		products := []ViewedProduct{}
+		userID, visitorID := viewer(c)
+		if !userID.Valid && !visitorID.Valid {
+			c.JSON(http.StatusOK, gin.H{"products": products})
+			return
+		}
+
+		filter, id := viewerFilter(userID, visitorID)
+		rows, err := app.DB.QueryContext(c, `
+			SELECT p.id, p.name, p.slug, p.image_url, `+EffectivePriceSQL("p")+`, v.viewed
+			FROM (
+				SELECT product_id, MAX(created) AS viewed FROM product_views
+				WHERE `+filter+`
+				GROUP BY product_id
+			) v
+			JOIN products p ON p.id = v.product_id
+			WHERE p.status = 'published' AND p.deleted_at IS NULL
+			ORDER BY v.viewed DESC
+			LIMIT $2
+		`, id, limit)
+		if err != nil {
+			l.ErrorF("Error fetching recently viewed products: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch recently viewed products"})
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var p ViewedProduct
+			if err := rows.Scan(&p.ID, &p.Name, &p.Slug, &p.ImageURL, &p.Price, &p.ViewedAt); err != nil {
+				l.ErrorF("Error scanning viewed product: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch recently viewed products"})
+				return
+			}
+			products = append(products, p)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"products": products})
+	}
+}
+
+// ClearRecentlyViewed forgets every product view of the current viewer.
+func ClearRecentlyViewed(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, visitorID := viewer(c)
+		if !userID.Valid && !visitorID.Valid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Sign in or send " + VisitorHeader + " to clear history"})
+			return
+		}
+
+		filter, id := viewerFilter(userID, visitorID)
+		if _, err := app.DB.ExecContext(c, "DELETE FROM product_views WHERE "+filter, id); err != nil {
+			l.ErrorF("Error clearing product views: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear history"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "History cleared"})
+	}
+}
+
+// MergeVisitorHistory hands the anonymous history of the visitor identified
+// by the request over to the user who just signed in.
+func MergeVisitorHistory(c *gin.Context, db *sql.DB, userID uuid.UUID) error {
+	visitorID, err := uuid.Parse(c.GetHeader(VisitorHeader))
+	if err != nil {
+		return nil
+	}
+	_, err = db.ExecContext(c, `
+		UPDATE product_views SET user_id = $1, visitor_id = NULL
+		WHERE visitor_id = $2 AND user_id IS NULL
+	`, userID, visitorID)
+	return err
+}
+
+// MergeRecentlyViewed merges the visitor history into the signed in user's,
+// for sign ins that could not send the visitor id, like OAuth redirects.
+func MergeRecentlyViewed(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		if err := MergeVisitorHistory(c, app.DB, userID); err != nil {
+			l.ErrorF("Error merging product views: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge history"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "History merged"})
+	}
+}
@@ -0,0 +1,39 @@
+package product
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestViewer(t *testing.T) {
+	userID, visitorID := uuid.New(), uuid.New()
+	tests := []struct {
+		name                  string
+		user, visitor         string
+		wantUser, wantVisitor uuid.NullUUID
+	}{
+		{"anonymous", "", "", uuid.NullUUID{}, uuid.NullUUID{}},
+		{"visitor", "", visitorID.String(), uuid.NullUUID{}, uuid.NullUUID{UUID: visitorID, Valid: true}},
+		{"user wins over visitor", userID.String(), visitorID.String(), uuid.NullUUID{UUID: userID, Valid: true}, uuid.NullUUID{}},
+		{"malformed visitor", "", "not-a-uuid", uuid.NullUUID{}, uuid.NullUUID{}},
+	}
+
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/product/recent", nil)
+		if tt.user != "" {
+			c.Set("userID", tt.user)
+		}
+		if tt.visitor != "" {
+			c.Request.Header.Set(VisitorHeader, tt.visitor)
+		}
+
+		gotUser, gotVisitor := viewer(c)
+		if gotUser != tt.wantUser || gotVisitor != tt.wantVisitor {
+			t.Errorf("%s: viewer() = %v, %v, want %v, %v", tt.name, gotUser, gotVisitor, tt.wantUser, tt.wantVisitor)
+		}
+	}
+}
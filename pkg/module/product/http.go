@@ -3,6 +3,7 @@ package product
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -11,14 +12,17 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/guregu/null/v5"
 	"github.com/lib/pq"
 
-	"src/common"
 	"src/l"
 	"src/pkg/conf"
+	"src/pkg/middleware"
 	"src/pkg/misc"
 	"src/pkg/module/brand"
 	category "src/pkg/module/category"
+	"src/pkg/module/inventory"
+	"src/pkg/permission"
 )
 
 func GetProductBySlug(app *conf.Config) gin.HandlerFunc {
@@ -28,12 +32,12 @@ func GetProductBySlug(app *conf.Config) gin.HandlerFunc {
 		var product Product // Use your Product struct
 		query := `
 		SELECT 
-			id, sku, name, slug, image_url, image_key, description, quantity, price, taxable, is_active, brand_id, merchant_id, updated, created, merchant_id
-		FROM products WHERE slug = $1 AND is_active = TRUE
+			id, sku, name, slug, image_url, image_key, description, ` + availableSQL("products") + `, price, taxable, is_active, brand_id, merchant_id, updated, created, product_type
+		FROM products WHERE slug = $1 AND status = 'published' AND deleted_at IS NULL
 		`
 		err := app.DB.QueryRowContext(c, query, slug).Scan(
 			&product.ID, &product.SKU, &product.Name, &product.Slug, &product.ImageURL, &product.ImageKey, &product.Description,
-			&product.Quantity, &product.Price, &product.Taxable, &product.IsActive, &product.BrandID, &product.MerchantID, &product.Updated, &product.Created, &product.MerchantID)
+			&product.Quantity, &product.Price, &product.Taxable, &product.IsActive, &product.BrandID, &product.MerchantID, &product.Updated, &product.Created, &product.Type)
 
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
@@ -45,11 +49,18 @@ func GetProductBySlug(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
+		// Views feed "customers also viewed"; losing one is not worth failing the page
+		if userID, visitorID := viewer(c); userID.Valid || visitorID.Valid {
+			if err := recordProductView(c, app.DB, product.ID, userID, visitorID); err != nil {
+				l.ErrorF("Error recording product view: %v", err)
+			}
+		}
+
 		query = `
 		SELECT id, name, slug
 		FROM categories
 		JOIN product_categories ON categories.id = product_categories.category_id
-		WHERE product_categories.product_id = $1;
+		WHERE product_categories.product_id = $1 AND categories.deleted_at IS NULL;
 		`
 		rows, err := app.DB.QueryContext(c, query, product.ID)
 		if err != nil {
@@ -70,20 +81,54 @@ func GetProductBySlug(app *conf.Config) gin.HandlerFunc {
 			}
 			categories = append(categories, cat)
 		}
+
+		images, err := fetchProductImages(c, app.DB, []uuid.UUID{product.ID})
+		if err != nil {
+			l.ErrorF("Error querying product images: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+			return
+		}
+
+		attributes, err := fetchProductAttributes(c, app.DB, []uuid.UUID{product.ID})
+		if err != nil {
+			l.ErrorF("Error querying product attributes: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attributes"})
+			return
+		}
+
+		pricing, prices, err := fetchPricing(c, app.DB, []uuid.UUID{product.ID})
+		if err != nil {
+			l.ErrorF("Error querying product pricing: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch prices"})
+			return
+		}
+
+		components, err := FetchBundleComponents(c, app.DB, []uuid.UUID{product.ID})
+		if err != nil {
+			l.ErrorF("Error querying bundle components: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bundle components"})
+			return
+		}
+
 		var product_request = GetProduct{
 			ID:          product.ID,
 			SKU:         product.SKU,
 			Name:        product.Name,
 			Slug:        product.Slug,
-			ImageURL:    []string{},
+			ImageURL:    imageURLs(images[product.ID]),
 			Description: product.Description,
 			Quantity:    product.Quantity,
-			Price:       product.Price,
+			Price:       prices[product.ID],
+			Pricing:     pricing[product.ID],
 			Taxable:     product.Taxable,
 			IsActive:    product.IsActive,
 			Brand:       brand.Brand{ID: product.BrandID.UUID},
+			Images:      images[product.ID],
+			Attributes:  attributes[product.ID],
 			Categories:  categories,
+			Components:  components[product.ID],
 			MerchantID:  product.MerchantID,
+			Type:        product.Type,
 			Created:     product.Created,
 			Updated:     product.Updated.Time,
 		}
@@ -105,9 +150,9 @@ func SearchProductsByName(app *conf.Config) gin.HandlerFunc {
 		offset := (page - 1) * limit
 
 		rows, err := app.DB.QueryContext(c, `
-			SELECT id, sku, name, slug, image_url, description, quantity, price, merchant_id, created, updated
+			SELECT id, sku, name, slug, image_url, description, `+availableSQL("products")+`, price, merchant_id, product_type, created, updated
 			FROM products
-			WHERE name ILIKE $1 AND is_active = TRUE
+			WHERE name ILIKE $1 AND status = 'published' AND deleted_at IS NULL
 			LIMIT $2 OFFSET $3
 		`, "%"+productName+"%", limit, offset) // Case-insensitive search with ILIKE and wildcards
 
@@ -124,7 +169,7 @@ func SearchProductsByName(app *conf.Config) gin.HandlerFunc {
 		products := []Product{} // Initialize an empty slice to avoid null in the response
 		for rows.Next() {
 			var product Product
-			err := rows.Scan(&product.ID, &product.SKU, &product.Name, &product.Slug, &product.ImageURL, &product.Description, &product.Quantity, &product.Price, &product.MerchantID, &product.Created, &product.Updated)
+			err := rows.Scan(&product.ID, &product.SKU, &product.Name, &product.Slug, &product.ImageURL, &product.Description, &product.Quantity, &product.Price, &product.MerchantID, &product.Type, &product.Created, &product.Updated)
 
 			if err != nil {
 				l.ErrorF("Error scanning product: %v", err)
@@ -145,7 +190,7 @@ func SearchProductsByName(app *conf.Config) gin.HandlerFunc {
 				SELECT c.id, c.name, c.slug, pc.product_id as product_id
 				FROM categories c
 				JOIN product_categories pc ON c.id = pc.category_id
-				WHERE pc.product_id = ANY($1)
+				WHERE pc.product_id = ANY($1) AND c.deleted_at IS NULL
 			`, pq.Array(productIds)) // Use ANY() to match multiple values
 
 			if err != nil {
@@ -170,21 +215,46 @@ func SearchProductsByName(app *conf.Config) gin.HandlerFunc {
 
 		}
 
+		images, err := fetchProductImages(c, app.DB, productIds)
+		if err != nil {
+			l.ErrorF("Error querying product images: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+			return
+		}
+
+		attributes, err := fetchProductAttributes(c, app.DB, productIds)
+		if err != nil {
+			l.ErrorF("Error querying product attributes: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attributes"})
+			return
+		}
+
+		pricing, prices, err := fetchPricing(c, app.DB, productIds)
+		if err != nil {
+			l.ErrorF("Error querying product pricing: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch prices"})
+			return
+		}
+
 		for _, product := range products {
 			getProduct := GetProduct{
 				ID:          product.ID,
 				SKU:         product.SKU,
 				Name:        product.Name,
 				Slug:        product.Slug,
-				ImageURL:    []string{},
+				ImageURL:    imageURLs(images[product.ID]),
 				Description: product.Description,
 				Quantity:    product.Quantity,
-				Price:       product.Price,
+				Price:       prices[product.ID],
+				Pricing:     pricing[product.ID],
 				Taxable:     product.Taxable,
 				IsActive:    product.IsActive,
 				Brand:       brand.Brand{ID: product.BrandID.UUID},
+				Images:      images[product.ID],
+				Attributes:  attributes[product.ID],
 				Categories:  categories[product.ID],
 				MerchantID:  product.MerchantID,
+				Type:        product.Type,
 				Created:     product.Created,
 				Updated:     product.Updated.Time,
 			}
@@ -206,12 +276,7 @@ func FetchStoreProductsByFilters(app *conf.Config) gin.HandlerFunc {
 		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
 
-		query := `
-		SELECT p.id, p.sku, p.name, p.slug, p.image_url, p.description, p.quantity, p.price, p.taxable, p.is_active, p.brand_id, p.merchant_id, p.updated, p.created
-		FROM products p
-		LEFT JOIN product_categories pc ON p.id = pc.product_id
-		LEFT JOIN categories c ON pc.category_id = c.id
-		WHERE p.is_active = true` // Base query
+		where := " WHERE p.status = 'published' AND p.deleted_at IS NULL" // Base filter, shared with the facet query
 
 		args := []interface{}{}
 		argIndex := 1
@@ -222,7 +287,7 @@ func FetchStoreProductsByFilters(app *conf.Config) gin.HandlerFunc {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'min' price"})
 				return
 			}
-			query += fmt.Sprintf(" AND p.price >= $%d", argIndex)
+			where += fmt.Sprintf(" AND "+EffectivePriceSQL("p")+" >= $%d", argIndex)
 			args = append(args, minPrice)
 			argIndex++
 		}
@@ -233,7 +298,7 @@ func FetchStoreProductsByFilters(app *conf.Config) gin.HandlerFunc {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid max price"})
 				return
 			}
-			query += fmt.Sprintf(" AND p.price <= $%d", argIndex)
+			where += fmt.Sprintf(" AND "+EffectivePriceSQL("p")+" <= $%d", argIndex)
 			args = append(args, maxPrice)
 			argIndex++
 		}
@@ -244,17 +309,38 @@ func FetchStoreProductsByFilters(app *conf.Config) gin.HandlerFunc {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid minimum rating value"})
 				return
 			}
-			query += fmt.Sprintf(` AND p.id IN (SELECT product_id FROM reviews GROUP BY product_id HAVING AVG(rating) >= $%d)`, argIndex)
+			where += fmt.Sprintf(` AND p.id IN (SELECT product_id FROM reviews GROUP BY product_id HAVING AVG(rating) >= $%d)`, argIndex)
 			args = append(args, rating)
 			argIndex++
 		}
 
 		if categorySlug != "" && categorySlug != "all" {
-			query += fmt.Sprintf(" AND c.slug = $%d", argIndex)
+			// A parent category also lists the products of all its sub categories
+			where += " AND p.id IN (SELECT pc.product_id FROM product_categories pc WHERE " +
+				category.SubtreeFilter("pc.category_id", fmt.Sprintf("$%d", argIndex)) + ")"
 			args = append(args, categorySlug)
 			argIndex++
 		}
 
+		attributeClauses, attributeArgs, argIndex, err := attributeFilterClauses(c.QueryMap("attr"), argIndex)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		where += attributeClauses
+		args = append(args, attributeArgs...)
+
+		facets, err := fetchAttributeFacets(c, app.DB, where, args)
+		if err != nil {
+			l.ErrorF("Error querying attribute facets: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+			return
+		}
+
+		query := `
+		SELECT p.id, p.sku, p.name, p.slug, p.image_url, p.description, ` + availableSQL("p") + `, p.price, p.taxable, p.is_active, p.brand_id, p.merchant_id, p.product_type, p.updated, p.created
+		FROM products p` + where
+
 		// Add sorting and pagination (ORDER BY, LIMIT, OFFSET)
 		query += fmt.Sprintf(" ORDER BY p.created DESC LIMIT $%d OFFSET $%d", argIndex, argIndex+1)
 		args = append(args, limit, (page-1)*limit)
@@ -279,7 +365,7 @@ func FetchStoreProductsByFilters(app *conf.Config) gin.HandlerFunc {
 			var product Product
 
 			err := rows.Scan(
-				&product.ID, &product.SKU, &product.Name, &product.Slug, &product.ImageURL, &product.Description, &product.Quantity, &product.Price, &product.Taxable, &product.IsActive, &product.BrandID, &product.MerchantID, &product.Updated, &product.Created)
+				&product.ID, &product.SKU, &product.Name, &product.Slug, &product.ImageURL, &product.Description, &product.Quantity, &product.Price, &product.Taxable, &product.IsActive, &product.BrandID, &product.MerchantID, &product.Type, &product.Updated, &product.Created)
 
 			if err != nil {
 				l.DebugF("Error scanning products: %v", err)
@@ -296,7 +382,7 @@ func FetchStoreProductsByFilters(app *conf.Config) gin.HandlerFunc {
 			SELECT id, name, slug, product_id
 			FROM categories
 			JOIN product_categories ON categories.id = product_categories.category_id
-			WHERE product_categories.product_id = ANY($1)
+			WHERE product_categories.product_id = ANY($1) AND categories.deleted_at IS NULL
 			`
 			rows, err := app.DB.QueryContext(c, query, pq.Array(productIds))
 			if err != nil {
@@ -321,6 +407,27 @@ func FetchStoreProductsByFilters(app *conf.Config) gin.HandlerFunc {
 
 		}
 
+		images, err := fetchProductImages(c, app.DB, productIds)
+		if err != nil {
+			l.ErrorF("Error querying product images: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+			return
+		}
+
+		attributes, err := fetchProductAttributes(c, app.DB, productIds)
+		if err != nil {
+			l.ErrorF("Error querying product attributes: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attributes"})
+			return
+		}
+
+		pricing, prices, err := fetchPricing(c, app.DB, productIds)
+		if err != nil {
+			l.ErrorF("Error querying product pricing: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch prices"})
+			return
+		}
+
 		var getProducts []GetProduct
 
 		for _, product := range products {
@@ -329,22 +436,26 @@ func FetchStoreProductsByFilters(app *conf.Config) gin.HandlerFunc {
 				SKU:         product.SKU,
 				Name:        product.Name,
 				Slug:        product.Slug,
-				ImageURL:    []string{},
+				ImageURL:    imageURLs(images[product.ID]),
 				Description: product.Description,
 				Quantity:    product.Quantity,
-				Price:       product.Price,
+				Price:       prices[product.ID],
+				Pricing:     pricing[product.ID],
 				Taxable:     product.Taxable,
 				IsActive:    product.IsActive,
 				Brand:       brand.Brand{ID: product.BrandID.UUID},
+				Images:      images[product.ID],
+				Attributes:  attributes[product.ID],
 				Categories:  categories[product.ID],
 				MerchantID:  product.MerchantID,
+				Type:        product.Type,
 				Created:     product.Created,
 				Updated:     product.Updated.Time,
 			}
 			getProducts = append(getProducts, getProduct)
 		}
 
-		c.JSON(http.StatusOK, gin.H{"products": getProducts})
+		c.JSON(http.StatusOK, gin.H{"products": getProducts, "facets": facets})
 
 	}
 
@@ -352,7 +463,7 @@ func FetchStoreProductsByFilters(app *conf.Config) gin.HandlerFunc {
 
 func FetchProductNames(app *conf.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		rows, err := app.DB.QueryContext(c, "SELECT id, name FROM products") // Select only id and name
+		rows, err := app.DB.QueryContext(c, "SELECT id, name FROM products WHERE status = 'published' AND deleted_at IS NULL") // Select only id and name
 		if err != nil {
 			l.DebugF("Error querying products: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product names"})
@@ -393,10 +504,72 @@ func AddProduct(app *conf.Config) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if input.Quantity < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "quantity cannot be negative"})
+			return
+		}
+		switch input.Type {
+		case "":
+			input.Type = ProductPhysical
+		case ProductPhysical, ProductDigital:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "type must be physical or digital"})
+			return
+		}
+		if input.DownloadLimit < 0 || input.DownloadExpiryDays < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "download limits cannot be negative"})
+			return
+		}
+		// Files and components are added after the product exists
+		if input.IsActive && input.Type != ProductPhysical {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Digital products and bundles can go live once their files or components are added"})
+			return
+		}
 		slug := misc.GenerateSlug(input.Name)
 
-		var imageUrl, imageKey string
 		var err error
+
+		// Attribute values are checked against the schema of the chosen category
+		var attributeValues []category.AttributeValue
+		if input.CategoryID != uuid.Nil || input.Attributes != "" {
+			values := map[string]interface{}{}
+			if input.Attributes != "" {
+				if err := json.Unmarshal([]byte(input.Attributes), &values); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "attributes must be a JSON object"})
+					return
+				}
+			}
+
+			schema := []category.Attribute{}
+			if input.CategoryID != uuid.Nil {
+				var categoryExists bool
+				err = app.DB.QueryRowContext(c, "SELECT EXISTS(SELECT 1 FROM categories WHERE id = $1 AND deleted_at IS NULL)", input.CategoryID).Scan(&categoryExists)
+				if err != nil {
+					l.ErrorF("Failed to check category: %v", err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check category"})
+					return
+				}
+				if !categoryExists {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Category not found"})
+					return
+				}
+
+				schema, err = category.CategoryAttributes(c, app.DB, []uuid.UUID{input.CategoryID})
+				if err != nil {
+					l.ErrorF("Failed to fetch category attributes: %v", err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch category attributes"})
+					return
+				}
+			}
+
+			attributeValues, _, err = category.ValidateAttributes(schema, values, true)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		var image *misc.ProcessedImage
 		file, _ := c.FormFile("image")
 		if file != nil {
 			if file.Size > 2<<20 { // 2MB
@@ -404,14 +577,41 @@ func AddProduct(app *conf.Config) gin.HandlerFunc {
 				return
 			}
 			l.DebugF("%#v", file)
-			imageUrl, imageKey, err = misc.S3Upload(file, app)
+			data, err := misc.ReadFormFile(file)
 			if err != nil {
+				l.ErrorF("Error reading image: %v", err)
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read image"})
+				return
+			}
+			image, err = processAndUploadImage(app, data)
+			if err != nil {
+				if errors.Is(err, misc.ErrUnsupportedImage) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported image format"})
+					return
+				}
+				if errors.Is(err, misc.ErrImageTooLarge) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Image dimensions are too large"})
+					return
+				}
 				l.ErrorF("Image upload failed: %v", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Image upload failed"}) // More specific error message
 				return
 			}
 		}
 
+		// The image is removed again unless the product is saved
+		saved := false
+		defer func() {
+			if image != nil && !saved {
+				discardUploads(context.WithoutCancel(c.Request.Context()), app, []*misc.ProcessedImage{image})
+			}
+		}()
+
+		if input.CompareAt != 0 && !validCompareAt(input.Price, null.FloatFrom(input.CompareAt)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "compareAtPrice must be higher than the price"})
+			return
+		}
+
 		var skuCount int
 		err = app.DB.QueryRowContext(c, "SELECT COUNT(*) FROM products WHERE sku = $1", input.SKU).Scan(&skuCount)
 		if err != nil {
@@ -442,10 +642,10 @@ func AddProduct(app *conf.Config) gin.HandlerFunc {
 		args := []interface{}{newProductID, input.SKU, input.Name, slug, time.Now(), time.Now()}
 		argIndex := 7
 
-		if imageUrl != "" {
+		if image != nil {
 			query += ", image_url, image_key"
 			values += fmt.Sprintf(", $%d, $%d", argIndex, argIndex+1)
-			args = append(args, imageUrl, imageKey)
+			args = append(args, misc.S3ObjectURL(app, image.Original.Key), image.Original.Key)
 			argIndex += 2
 		}
 		if input.Description != "" {
@@ -454,28 +654,26 @@ func AddProduct(app *conf.Config) gin.HandlerFunc {
 			args = append(args, input.Description)
 			argIndex++
 		}
-		if input.Quantity != 0 {
-			query += ", quantity"
-			values += fmt.Sprintf(", $%d", argIndex)
-			args = append(args, input.Quantity)
-			argIndex++
-		}
 		if input.Price != 0 {
 			query += ", price"
 			values += fmt.Sprintf(", $%d", argIndex)
 			args = append(args, input.Price)
 			argIndex++
 		}
-		if input.Taxable {
-			query += ", taxable"
+		query += ", product_type, download_limit, download_expiry_days"
+		values += fmt.Sprintf(", $%d, $%d, $%d", argIndex, argIndex+1, argIndex+2)
+		args = append(args, input.Type, input.DownloadLimit, input.DownloadExpiryDays)
+		argIndex += 3
+		if input.CompareAt != 0 {
+			query += ", compare_at_price"
 			values += fmt.Sprintf(", $%d", argIndex)
-			args = append(args, input.Taxable)
+			args = append(args, input.CompareAt)
 			argIndex++
 		}
-		if input.IsActive {
-			query += ", is_active"
+		if input.Taxable {
+			query += ", taxable"
 			values += fmt.Sprintf(", $%d", argIndex)
-			args = append(args, input.IsActive)
+			args = append(args, input.Taxable)
 			argIndex++
 		}
 		if input.BrandID != uuid.Nil {
@@ -484,7 +682,6 @@ func AddProduct(app *conf.Config) gin.HandlerFunc {
 			args = append(args, input.BrandID)
 			argIndex++
 		}
-		// TODO add category ID
 		query += ", merchant_id) "
 		values += fmt.Sprintf(", $%d)", argIndex)
 		args = append(args, merchantID)
@@ -492,79 +689,98 @@ func AddProduct(app *conf.Config) gin.HandlerFunc {
 		query += values
 		l.Debug(merchantIDStr)
 		l.DebugF("%v", merchantID)
-		_, err = app.DB.ExecContext(c, query, args...)
+
+		tx, err := app.DB.BeginTx(c, nil)
+		if err != nil {
+			l.ErrorF("Error starting transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		_, err = tx.ExecContext(c, query, args...)
 		if err != nil {
 			l.ErrorF("Failed to insert product: %v", err) // Log the error
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add product"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{
-			"success":    true,
-			"message":    "Product added successfully!",
-			"product_id": newProductID, // Return the product ID
-		})
-	}
-}
-
-func FetchProducts(app *conf.Config) gin.HandlerFunc {
-	return func(c *gin.Context) {
-
-		role, exists := c.Get("role")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		if status := initialStatus(c, app, input.IsActive); status != StatusDraft {
+			if err := setStatus(c, tx, newProductID, StatusDraft, status, "", middleware.ActorID(c)); err != nil {
+				l.ErrorF("Failed to set product status: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add product"})
+				return
+			}
+		}
 
+		if err := recordPriceChange(c, tx, newProductID, PriceChangeInitial, nil, middleware.ActorID(c)); err != nil {
+			l.ErrorF("Failed to record price history: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add product"})
 			return
-
 		}
 
-		userRole := common.GetUserRole(role)
-
-		// userIDStr := c.GetString("userID")
-		// userID, err := uuid.Parse(userIDStr)
-
-		// if err != nil {
+		// Digital products are never out of stock
+		if input.Quantity > 0 && input.Type == ProductPhysical {
+			err = inventory.Record(c, tx, newProductID, uuid.Nil, inventory.MovementReceived, input.Quantity, "Initial stock", uuid.NullUUID{}, middleware.ActorID(c))
+			if err != nil {
+				l.ErrorF("Failed to record initial stock: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add product"})
+				return
+			}
+		}
 
-		// 	l.DebugF("Error parsing merchant ID: %v", err) // Log the parsing error
-		// 	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-		// 	return
-		// }
-		merchantIDStr, exists := c.MustGet("merchantID").(string)
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-			return
+		if input.CategoryID != uuid.Nil {
+			_, err = tx.ExecContext(c, "INSERT INTO product_categories (product_id, category_id) VALUES ($1, $2)", newProductID, input.CategoryID)
+			if err != nil {
+				l.ErrorF("Failed to add product to category: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add product"})
+				return
+			}
 		}
 
-		merchantID, err := uuid.Parse(merchantIDStr)
-		if err != nil {
-			l.DebugF("Invalid merchant ID: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
+		if err := saveProductAttributes(c, tx, newProductID, attributeValues, nil); err != nil {
+			l.ErrorF("Failed to save product attributes: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add product"})
 			return
 		}
 
-		var rows *sql.Rows
-		if userRole == common.RoleMerchant {
-			// Fetch products only for this merchant
-			query := `
-			SELECT id, sku, name, slug, image_url, image_key, description, quantity, price, taxable, is_active, brand_id, merchant_id, updated, created
-			FROM products WHERE merchant_id = $1
-			`
-			rows, err = app.DB.QueryContext(c, query, merchantID)
+		if image != nil {
+			if _, err := insertProductImage(c, tx, newProductID, image, app, input.Name, true, false); err != nil {
+				l.ErrorF("Failed to insert product image: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add product"})
+				return
+			}
+		}
 
-		} else if userRole == common.RoleAdmin { // Add an admin case
-			query := `
-			SELECT id, sku, name, slug, image_url, image_key, description, quantity, price, taxable, is_active, brand_id, merchant_id, updated, created
-			FROM products
-			`
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+		saved = true
 
-			rows, err = app.DB.QueryContext(c, query) // Fetch all product details
+		c.JSON(http.StatusOK, gin.H{
+			"success":    true,
+			"message":    "Product added successfully!",
+			"product_id": newProductID, // Return the product ID
+		})
+	}
+}
 
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+func FetchProducts(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// uuid.Nil lists the products of every merchant
+		merchantID, ok := middleware.MerchantScope(c, app, permission.ProductWrite)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
 			return
-
 		}
 
+		query := `
+		SELECT id, sku, name, slug, image_url, image_key, description, quantity, price, taxable, is_active, status, status_reason, brand_id, merchant_id, updated, created
+		FROM products WHERE deleted_at IS NULL AND ($1 = $2 OR merchant_id = $1) AND ($3 = '' OR status = $3)
+		`
+		rows, err := app.DB.QueryContext(c, query, merchantID, uuid.Nil, c.Query("status"))
 		if err != nil {
 
 			l.DebugF("Error querying products: %v", err) // Log the actual database error for better debugging
@@ -579,7 +795,7 @@ func FetchProducts(app *conf.Config) gin.HandlerFunc {
 			var product Product
 
 			err := rows.Scan(
-				&product.ID, &product.SKU, &product.Name, &product.Slug, &product.ImageURL, &product.ImageKey, &product.Description, &product.Quantity, &product.Price, &product.Taxable, &product.IsActive, &product.BrandID, &product.MerchantID, &product.Updated, &product.Created)
+				&product.ID, &product.SKU, &product.Name, &product.Slug, &product.ImageURL, &product.ImageKey, &product.Description, &product.Quantity, &product.Price, &product.Taxable, &product.IsActive, &product.Status, &product.Reason, &product.BrandID, &product.MerchantID, &product.Updated, &product.Created)
 
 			if err != nil {
 				l.DebugF("Error scanning products: %v", err) // More specific error message
@@ -597,26 +813,11 @@ func FetchProducts(app *conf.Config) gin.HandlerFunc {
 
 func FetchProduct(app *conf.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-
-		_userID, exists := c.MustGet("merchantID").(string) // Get merchant ID if available
-		if !exists {
-			_userID = "" // or handle the case where it's not available appropriately
-		}
-
-		merchantID, err := uuid.Parse(_userID) // Assuming merchant ID is a UUID.  If not, adjust this part.
-
-		if err != nil && _userID != "" {
-			l.DebugF("Invalid merchant ID: %s. Error: %v", _userID, err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
-			return
-		}
-
-		userRole, ok := c.MustGet("role").(string)
+		merchantID, ok := middleware.MerchantScope(c, app, permission.ProductWrite)
 		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get user role"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
 			return
 		}
-		role := common.GetUserRole(userRole)
 
 		productIDStr := c.Param("id")
 		productID, err := uuid.Parse(productIDStr)
@@ -629,21 +830,11 @@ func FetchProduct(app *conf.Config) gin.HandlerFunc {
 
 		var product Product
 
-		if role == common.RoleMerchant {
-			query := `
-			SELECT id, sku, name, slug, image_url, image_key, description, quantity, price, taxable, is_active, brand_id, merchant_id, updated, created
-			FROM products WHERE id = $1 AND merchant_id = $2
-			`
-			err = app.DB.QueryRowContext(c, query, productID, merchantID).Scan(&product.ID, &product.SKU, &product.Name, &product.Slug, &product.ImageURL, &product.ImageKey, &product.Description, &product.Quantity, &product.Price, &product.Taxable, &product.IsActive, &product.BrandID, &product.MerchantID, &product.Updated, &product.Created)
-		} else if role == common.RoleAdmin {
-			query := `
-			SELECT id, sku, name, slug, image_url, image_key, description, quantity, price, taxable, is_active, brand_id, merchant_id, updated, created
-			FROM products WHERE id = $1
-			`
-
-			err = app.DB.QueryRowContext(c, query, productID).Scan(&product.ID, &product.SKU, &product.Name, &product.Slug, &product.ImageURL, &product.ImageKey, &product.Description, &product.Quantity, &product.Price, &product.Taxable, &product.IsActive, &product.BrandID, &product.MerchantID, &product.Updated, &product.Created)
-
-		}
+		query := `
+		SELECT id, sku, name, slug, image_url, image_key, description, quantity, price, taxable, is_active, status, status_reason, brand_id, merchant_id, updated, created
+		FROM products WHERE id = $1 AND ($2 = $3 OR merchant_id = $2) AND deleted_at IS NULL
+		`
+		err = app.DB.QueryRowContext(c, query, productID, merchantID, uuid.Nil).Scan(&product.ID, &product.SKU, &product.Name, &product.Slug, &product.ImageURL, &product.ImageKey, &product.Description, &product.Quantity, &product.Price, &product.Taxable, &product.IsActive, &product.Status, &product.Reason, &product.BrandID, &product.MerchantID, &product.Updated, &product.Created)
 
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
@@ -663,7 +854,6 @@ func FetchProduct(app *conf.Config) gin.HandlerFunc {
 
 func UpdateProduct(app *conf.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// ... (get productID, userID, userRole - same as before)
 		productIDStr := c.Param("id")
 		productID, err := uuid.Parse(productIDStr)
 		if err != nil {
@@ -672,45 +862,33 @@ func UpdateProduct(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
-		userRoleStr, ok := c.MustGet("role").(string)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get user role"})
+		if !middleware.CheckProductAccess(c, app, permission.ProductWrite, productID) {
 			return
 		}
 
-		userRole := common.GetUserRole(userRoleStr)
-
-		// userIdStr, exists := c.MustGet("userID").(string)
-		// if !exists {
-		// 	c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		// 	return
-		// }
-
-		// userID, err := uuid.Parse(userIdStr)
-		// if err != nil {
-		// 	l.DebugF("Invalid user ID: %v", err)
-		// 	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-		// 	return
-		// }
+		var updateProduct ProductUpdate
+		if err := c.ShouldBindJSON(&updateProduct); err != nil {
 
-		merchantIDStr, exists := c.MustGet("merchantID").(string)
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"}) // Return a 400 error for invalid input
 			return
 		}
 
-		merchantID, err := uuid.Parse(merchantIDStr)
+		var status ProductStatus
+		err = app.DB.QueryRowContext(c, "SELECT status FROM products WHERE id = $1 AND deleted_at IS NULL", productID).Scan(&status)
 		if err != nil {
-			l.DebugF("Invalid merchant ID: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			} else {
+				l.ErrorF("Error fetching product status: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+			}
 			return
 		}
 
-		var updateProduct ProductUpdate
-		if err := c.ShouldBindJSON(&updateProduct); err != nil {
-
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"}) // Return a 400 error for invalid input
-			return
+		// Only moderators change what a live listing says without review
+		var listingChanges *ListingChanges
+		if status == StatusPublished && !middleware.HasPermission(c, app, permission.ProductModerate) {
+			listingChanges = updateProduct.takeListingChanges()
 		}
 
 		ctx := context.Background()
@@ -750,27 +928,41 @@ func UpdateProduct(app *conf.Config) gin.HandlerFunc {
 			argIndex++
 		}
 
-		if updateProduct.Quantity != nil {
-			updateQuery += fmt.Sprintf(", quantity = $%d", argIndex)
-			args = append(args, *updateProduct.Quantity)
-			argIndex++
-		}
-
 		if updateProduct.Price != nil {
 			updateQuery += fmt.Sprintf(", price = $%d", argIndex)
 			args = append(args, *updateProduct.Price)
 			argIndex++
 		}
 
-		if updateProduct.Taxable != nil {
-			updateQuery += fmt.Sprintf(", taxable = $%d", argIndex)
-			args = append(args, *updateProduct.Taxable)
+		if updateProduct.DownloadLimit != nil {
+			if *updateProduct.DownloadLimit < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "downloadLimit cannot be negative"})
+				return
+			}
+			updateQuery += fmt.Sprintf(", download_limit = $%d", argIndex)
+			args = append(args, *updateProduct.DownloadLimit)
 			argIndex++
 		}
 
-		if updateProduct.IsActive != nil {
-			updateQuery += fmt.Sprintf(", is_active = $%d", argIndex)
-			args = append(args, *updateProduct.IsActive)
+		if updateProduct.DownloadExpiryDays != nil {
+			if *updateProduct.DownloadExpiryDays < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "downloadExpiryDays cannot be negative"})
+				return
+			}
+			updateQuery += fmt.Sprintf(", download_expiry_days = $%d", argIndex)
+			args = append(args, *updateProduct.DownloadExpiryDays)
+			argIndex++
+		}
+
+		if updateProduct.CompareAt != nil {
+			updateQuery += fmt.Sprintf(", compare_at_price = NULLIF($%d::numeric, 0)", argIndex)
+			args = append(args, *updateProduct.CompareAt)
+			argIndex++
+		}
+
+		if updateProduct.Taxable != nil {
+			updateQuery += fmt.Sprintf(", taxable = $%d", argIndex)
+			args = append(args, *updateProduct.Taxable)
 			argIndex++
 		}
 
@@ -780,7 +972,6 @@ func UpdateProduct(app *conf.Config) gin.HandlerFunc {
 			argIndex++
 		}
 
-		// TODO add category ID
 		if updateProduct.Slug != nil {
 			updateQuery += fmt.Sprintf(", slug = $%d", argIndex)
 			args = append(args, *updateProduct.Slug)
@@ -791,43 +982,6 @@ func UpdateProduct(app *conf.Config) gin.HandlerFunc {
 		argIndex++
 		args = append(args, productID)
 
-		if userRole == common.RoleMerchant {
-			// Check if product belongs to merchant for authorization
-
-			var productMerchantID uuid.UUID
-			err = app.DB.QueryRowContext(c, "SELECT merchant_id FROM products WHERE id = $1", productID).Scan(&productMerchantID)
-			if err != nil {
-				if err == sql.ErrNoRows {
-					l.DebugF("Product not found: %v", err)
-					c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
-					return
-
-				} else {
-
-					l.DebugF("Error checking product ownership: %v", err)
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check product ownership"})
-					return
-				}
-
-			}
-
-			if productMerchantID != merchantID { // Authorization check
-
-				c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to update this product"})
-				return
-			}
-
-			updateQuery += fmt.Sprintf(" AND merchant_id = $%d", argIndex)
-			argIndex++
-			args = append(args, merchantID)
-
-		} else if userRole != common.RoleAdmin { // Admin can update any product
-			c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized"}) // Proper status code for authorization failure
-			return
-		} else {
-			l.DebugF("some diffrent way")
-		}
-
 		// Check for SKU and slug uniqueness only if being updated.
 		if updateProduct.SKU != nil {
 			var count int
@@ -857,6 +1011,91 @@ func UpdateProduct(app *conf.Config) gin.HandlerFunc {
 			}
 		}
 
+		// History only records prices that actually change
+		var oldPrice float64
+		var oldCompareAt null.Float
+		priceChanged := false
+		if updateProduct.Price != nil || updateProduct.CompareAt != nil {
+			err = tx.QueryRowContext(ctx, "SELECT price, compare_at_price FROM products WHERE id = $1 FOR UPDATE", productID).Scan(&oldPrice, &oldCompareAt)
+			if err != nil {
+				l.ErrorF("Failed to fetch current prices: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+				return
+			}
+			newPrice, newCompareAt := oldPrice, oldCompareAt
+			if updateProduct.Price != nil {
+				newPrice = *updateProduct.Price
+			}
+			if updateProduct.CompareAt != nil {
+				newCompareAt = null.NewFloat(*updateProduct.CompareAt, *updateProduct.CompareAt != 0)
+			}
+			if !validCompareAt(newPrice, newCompareAt) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "compareAtPrice must be higher than the price"})
+				return
+			}
+			priceChanged = newPrice != oldPrice || newCompareAt != oldCompareAt
+		}
+
+		// A new category brings its own attribute schema
+		var categoryValues []category.AttributeValue
+		if updateProduct.CategoryID != nil {
+			values, problem, err := checkCategoryChange(ctx, tx, productID, *updateProduct.CategoryID, updateProduct.Attributes)
+			if err != nil {
+				l.ErrorF("Failed to check category change: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+				return
+			}
+			if problem != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+				return
+			}
+			categoryValues = values
+		}
+
+		var revision *ProductRevision
+		if listingChanges != nil {
+			submitted, problem, err := holdListingChanges(c, tx, productID, *listingChanges)
+			if err != nil {
+				l.ErrorF("Failed to submit product revision: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+				return
+			}
+			if problem != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+				return
+			}
+			revision = &submitted
+		}
+
+		// A new quantity is booked as an adjustment at the default location so the
+		// stock ledger stays complete
+		if updateProduct.Quantity != nil {
+			var onHand int
+			var productType ProductType
+			err = tx.QueryRowContext(ctx, "SELECT quantity, product_type FROM products WHERE id = $1 FOR UPDATE", productID).Scan(&onHand, &productType)
+			if err != nil {
+				l.ErrorF("Failed to fetch current stock: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+				return
+			}
+			if productType != ProductPhysical {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Only physical products keep their own stock"})
+				return
+			}
+			if delta := *updateProduct.Quantity - onHand; delta != 0 {
+				err = inventory.Record(ctx, tx, productID, uuid.Nil, inventory.MovementAdjusted, delta, "Updated from product edit", uuid.NullUUID{}, middleware.ActorID(c))
+				if errors.Is(err, inventory.ErrInsufficientStock) {
+					c.JSON(http.StatusConflict, gin.H{"error": "Quantity is lower than the stock reserved for open orders"})
+					return
+				}
+				if err != nil {
+					l.ErrorF("Failed to record stock adjustment: %v", err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+					return
+				}
+			}
+		}
+
 		// Execute the update within the transaction
 		var product Product
 		updateQuery += " RETURNING id, name, sku, slug, image_url, description, quantity, price, taxable, is_active, brand_id, merchant_id, updated, created"
@@ -885,6 +1124,41 @@ func UpdateProduct(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
+		if priceChanged {
+			if err := recordPriceChange(ctx, tx, productID, PriceChangePrice, nil, middleware.ActorID(c)); err != nil {
+				l.ErrorF("Error recording price history: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+				return
+			}
+		}
+
+		if updateProduct.CategoryID != nil {
+			if err := moveProductCategory(ctx, tx, productID, *updateProduct.CategoryID, categoryValues); err != nil {
+				l.ErrorF("Error moving product to category: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+				return
+			}
+		} else if updateProduct.Attributes != nil {
+			schema, err := category.ProductAttributes(ctx, tx, productID)
+			if err != nil {
+				l.ErrorF("Error fetching product attribute schema: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+				return
+			}
+
+			set, cleared, err := category.ValidateAttributes(schema, updateProduct.Attributes, false)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if err := saveProductAttributes(ctx, tx, productID, set, cleared); err != nil {
+				l.ErrorF("Error saving product attributes: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+				return
+			}
+		}
+
 		// l.DebugF("Product updated: %#v", updateProduct)
 
 		if err = tx.Commit(); err != nil { // Commit only if no errors
@@ -893,6 +1167,16 @@ func UpdateProduct(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
+		if revision != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success":  true,
+				"message":  "Product updated, listing changes are waiting for review",
+				"product":  product,
+				"revision": revision,
+			})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
 			"message": "Product updated successfully",
@@ -902,9 +1186,10 @@ func UpdateProduct(app *conf.Config) gin.HandlerFunc {
 	}
 }
 
+// UpdateProductStatus keeps the old isActive switch working on top of the
+// moderation workflow.
 func UpdateProductStatus(app *conf.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// ... (Get userID, userRole, productID)
 		productIDStr := c.Param("id")
 		productID, err := uuid.Parse(productIDStr)
 		if err != nil {
@@ -913,36 +1198,7 @@ func UpdateProductStatus(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
-		userRoleStr, ok := c.MustGet("role").(string)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get user role"})
-			return
-		}
-
-		userRole := common.GetUserRole(userRoleStr)
-
-		// userIdStr, exists := c.MustGet("userID").(string)
-		// if !exists {
-		// 	c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		// 	return
-		// }
-
-		// userID, err := uuid.Parse(userIdStr)
-		// if err != nil {
-		// 	l.DebugF("Invalid user ID: %v", err)
-		// 	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-		// 	return
-		// }
-		merchantIDStr, exists := c.MustGet("merchantID").(string)
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-			return
-		}
-
-		merchantID, err := uuid.Parse(merchantIDStr)
-		if err != nil {
-			l.DebugF("Invalid merchant ID: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
+		if !middleware.CheckProductAccess(c, app, permission.ProductWrite, productID) {
 			return
 		}
 
@@ -959,128 +1215,89 @@ func UpdateProductStatus(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
-		// Check if the user is a merchant or admin and authorize
-		if userRole == common.RoleMerchant {
-			var selectMerchantID uuid.UUID
-			err = app.DB.QueryRowContext(c, "SELECT merchant_id FROM products WHERE id = $1", productID).Scan(&selectMerchantID)
-			if err != nil {
-				if errors.Is(err, sql.ErrNoRows) {
-					c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
-				} else {
-					l.DebugF("Error getting merchant ID: %v", err)
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify product"})
-				}
-				return
-			}
-			if selectMerchantID != merchantID {
-				c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized to update this product"})
-				return
-			}
-		} else if userRole != common.RoleAdmin {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized"})
-			return
-		}
-
-		_, err = app.DB.ExecContext(c, `UPDATE products SET is_active = $1, updated = $2 WHERE id = $3`, updateData.IsActive, time.Now(), productID)
-
-		if err != nil {
-
-			l.ErrorF("Error updating product status: %v", err)                                        // Log error
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product status"}) // Generic error message for security
-			return
+		// Going live needs a review unless a moderator flips the switch, going
+		// offline archives the product
+		switch {
+		case !*updateData.IsActive:
+			transitionProduct(c, app, productID, StatusArchived, "", func(from ProductStatus) bool {
+				return canTransition(from, StatusArchived)
+			})
+		case middleware.HasPermission(c, app, permission.ProductModerate):
+			transitionProduct(c, app, productID, StatusPublished, "", func(from ProductStatus) bool {
+				return from == StatusPendingReview
+			})
+		default:
+			transitionProduct(c, app, productID, StatusPendingReview, "", func(from ProductStatus) bool {
+				return canTransition(from, StatusPendingReview)
+			})
 		}
-
-		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Product status updated successfully"})
 	}
 }
 
+// DeleteProduct moves a product to the trash. Orders, reviews and carts keep
+// pointing at it until it is restored or purged.
 func DeleteProduct(app *conf.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-
-		// ... (Get productID, userID, userRole)
-		productIDStr := c.Param("id")
-		productID, err := uuid.Parse(productIDStr)
+		productID, err := uuid.Parse(c.Param("id"))
 		if err != nil {
 			l.DebugF("Invalid product ID: %v", err)
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
 			return
 		}
 
-		userRoleStr, ok := c.MustGet("role").(string)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "failed to get user role"})
+		if !middleware.CheckProductAccess(c, app, permission.ProductWrite, productID) {
 			return
 		}
 
-		userRole := common.GetUserRole(userRoleStr)
-
-		userIdStr, exists := c.MustGet("userID").(string)
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-			return
-		}
-
-		userID, err := uuid.Parse(userIdStr)
-		if err != nil {
-			l.DebugF("Invalid user ID: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
-			return
-		}
-
-		ctx := context.Background()
+		ctx := c.Request.Context()
 		tx, err := app.DB.BeginTx(ctx, nil)
-
 		if err != nil {
-
 			l.DebugF("Failed to start trasaction: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
 			return
 		}
 		defer tx.Rollback()
 
-		if userRole == common.RoleMerchant {
-			// Check if the product belongs to the merchant for authorization.
-
-			var count int
-			err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM products WHERE id = $1 AND merchant_id = $2", productID, userID).Scan(&count) // Check with transaction
-
-			if err != nil {
-				l.ErrorF("Error checking product: %v\n", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify product."})
-				return
-			}
-			if count == 0 { // No matching product found
-
-				c.JSON(http.StatusNotFound, gin.H{"error": "Product not found or you are not authorized"}) // Correct handling for not found or unauthorized
-				return
-			}
-
-		} else if userRole != common.RoleAdmin {
-
-			c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized"}) // Correct status code
+		var inBundle bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT EXISTS(
+				SELECT 1 FROM bundle_components bc
+				JOIN products b ON b.id = bc.bundle_id
+				WHERE bc.component_id = $1 AND b.deleted_at IS NULL
+			)`, productID).Scan(&inBundle)
+		if err != nil {
+			l.ErrorF("Error checking bundles: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product."})
+			return
+		}
+		if inBundle {
+			c.JSON(http.StatusConflict, gin.H{"error": "Product is part of a bundle, remove it from the bundle first."})
 			return
 		}
 
-		res, err := tx.ExecContext(ctx, "DELETE FROM products WHERE id = $1", productID) // Corrected SQL statement to reflect database/sql usage
+		res, err := tx.ExecContext(ctx, `
+			UPDATE products SET deleted_at = NOW(), deleted_by = $1, updated = NOW()
+			WHERE id = $2 AND deleted_at IS NULL
+		`, middleware.ActorID(c), productID)
 		if err != nil {
-
 			l.DebugF("Error deleting product : %v\n", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product."}) // Generic message for security
-
 			return
 		}
 
-		rowsAffected, err := res.RowsAffected()
-		if err != nil {
-
+		if rowsAffected, err := res.RowsAffected(); err != nil {
 			l.DebugF("Error getting rows affected : %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product."}) // Generic message for security
-
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product."})
+			return
+		} else if rowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"message": "No product found."})
 			return
 		}
 
-		if rowsAffected == 0 {
-			c.JSON(http.StatusNotFound, gin.H{"message": "No product found."})
+		// Nobody should be able to approve changes to a product that is gone
+		if _, err := tx.ExecContext(ctx, "DELETE FROM product_revisions WHERE product_id = $1 AND status = $2", productID, RevisionPending); err != nil {
+			l.ErrorF("Error dropping pending revision: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product."})
 			return
 		}
 
@@ -1090,7 +1307,6 @@ func DeleteProduct(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Product deleted successfully"})
-
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Product moved to trash"})
 	}
 }
@@ -0,0 +1,891 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/guregu/null/v5"
+	"github.com/lib/pq"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/middleware"
+	"src/pkg/misc"
+	"src/pkg/permission"
+)
+
+const (
+	maxImageSize          = 5 << 20 // 5MB
+	maxImagesPerUpload    = 10
+	productImageKeyPrefix = "products"
+	productImageColumns   = `id, product_id, content_hash, image_key, image_url, webp_key, webp_url, thumbnail_key, thumbnail_url,
+		width, height, alt_text, sort_order, is_primary, staged, updated, created`
+)
+
+func scanProductImage(rows interface{ Scan(...any) error }, img *ProductImage) error {
+	return rows.Scan(&img.ID, &img.ProductID, &img.ContentHash, &img.ImageKey, &img.ImageURL, &img.WebPKey, &img.WebPURL,
+		&img.ThumbnailKey, &img.ThumbnailURL, &img.Width, &img.Height, &img.AltText, &img.SortOrder, &img.IsPrimary,
+		&img.Staged, &img.Updated, &img.Created)
+}
+
+// fetchProductImages returns the gallery of every given product, ordered for
+// display. Staged uploads are left out until they are reviewed.
+func fetchProductImages(ctx context.Context, db queryer, productIDs []uuid.UUID) (map[uuid.UUID][]ProductImage, error) {
+	images := make(map[uuid.UUID][]ProductImage)
+	if len(productIDs) == 0 {
+		return images, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+productImageColumns+`
+		FROM product_images
+		WHERE product_id = ANY($1) AND NOT staged
+		ORDER BY is_primary DESC, sort_order, created
+	`, pq.Array(productIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var img ProductImage
+		if err := scanProductImage(rows, &img); err != nil {
+			return nil, err
+		}
+		images[img.ProductID] = append(images[img.ProductID], img)
+	}
+	return images, rows.Err()
+}
+
+// imageURLs flattens a gallery into display URLs, preferring the WebP rendition.
+func imageURLs(images []ProductImage) []string {
+	urls := make([]string, 0, len(images))
+	for _, img := range images {
+		if img.WebPURL.Valid {
+			urls = append(urls, img.WebPURL.String)
+		} else {
+			urls = append(urls, img.ImageURL)
+		}
+	}
+	return urls
+}
+
+// syncPrimaryImage makes sure exactly one image of the product is primary and
+// mirrors it into products.image_url/image_key for older clients.
+func syncPrimaryImage(ctx context.Context, tx *sql.Tx, productID uuid.UUID) error {
+	var primaryID uuid.UUID
+	err := tx.QueryRowContext(ctx, `
+		SELECT id FROM product_images
+		WHERE product_id = $1 AND NOT staged
+		ORDER BY is_primary DESC, sort_order, created
+		LIMIT 1
+	`, productID).Scan(&primaryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = tx.ExecContext(ctx, "UPDATE products SET image_url = NULL, image_key = NULL, updated = $1 WHERE id = $2", time.Now(), productID)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE product_images SET is_primary = FALSE WHERE product_id = $1 AND id != $2 AND is_primary", productID, primaryID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE product_images SET is_primary = TRUE WHERE id = $1", primaryID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE products p
+		SET image_url = pi.image_url, image_key = pi.image_key, updated = $1
+		FROM product_images pi
+		WHERE pi.id = $2 AND p.id = pi.product_id
+	`, time.Now(), primaryID)
+	return err
+}
+
+// lockImageContent serializes, until tx ends, the rows and cleanups of the
+// images sharing a content hash, and so the same objects.
+func lockImageContent(ctx context.Context, tx *sql.Tx, contentHash string) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", "image:"+contentHash)
+	return err
+}
+
+// insertProductImage stores the gallery row for an uploaded image and returns
+// it. Staged images are never primary, the revision holds that choice. The
+// objects of the upload are put back if a cleanup removed them meanwhile,
+// and cannot be removed again until tx ends.
+func insertProductImage(ctx context.Context, tx *sql.Tx, productID uuid.UUID, processed *misc.ProcessedImage, app *conf.Config, altText string, isPrimary, staged bool) (ProductImage, error) {
+	if err := lockImageContent(ctx, tx, processed.Hash); err != nil {
+		return ProductImage{}, err
+	}
+	if err := misc.RestoreProcessedImage(app, processed); err != nil {
+		return ProductImage{}, err
+	}
+
+	var nextOrder int
+	err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(sort_order) + 1, 0) FROM product_images WHERE product_id = $1", productID).Scan(&nextOrder)
+	if err != nil {
+		return ProductImage{}, err
+	}
+
+	img := ProductImage{
+		ID:           uuid.New(),
+		ProductID:    productID,
+		ContentHash:  processed.Hash,
+		ImageKey:     processed.Original.Key,
+		ImageURL:     misc.S3ObjectURL(app, processed.Original.Key),
+		WebPKey:      null.StringFrom(processed.WebP.Key),
+		WebPURL:      null.StringFrom(misc.S3ObjectURL(app, processed.WebP.Key)),
+		ThumbnailKey: null.StringFrom(processed.Thumbnail.Key),
+		ThumbnailURL: null.StringFrom(misc.S3ObjectURL(app, processed.Thumbnail.Key)),
+		Width:        processed.Original.Width,
+		Height:       processed.Original.Height,
+		AltText:      altText,
+		SortOrder:    nextOrder,
+		IsPrimary:    isPrimary && !staged,
+		Staged:       staged,
+		Created:      time.Now(),
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO product_images (id, product_id, content_hash, image_key, image_url, webp_key, webp_url, thumbnail_key, thumbnail_url,
+			width, height, alt_text, sort_order, is_primary, staged, updated, created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $16)
+	`, img.ID, img.ProductID, img.ContentHash, img.ImageKey, img.ImageURL, img.WebPKey, img.WebPURL, img.ThumbnailKey, img.ThumbnailURL,
+		img.Width, img.Height, img.AltText, img.SortOrder, img.IsPrimary, img.Staged, img.Created)
+	return img, err
+}
+
+// cleanupImageObjects removes the stored files of deleted images unless
+// another gallery row still points at the same content hash, or is being
+// inserted for it.
+func cleanupImageObjects(ctx context.Context, app *conf.Config, images []ProductImage) {
+	for _, img := range images {
+		if err := cleanupImageObject(ctx, app, img); err != nil {
+			l.ErrorF("Failed to delete image objects for %s: %v", img.ContentHash, err)
+		}
+	}
+}
+
+// cleanupImageObject checks the references to the image and deletes its
+// objects under the lock of its content hash.
+func cleanupImageObject(ctx context.Context, app *conf.Config, img ProductImage) error {
+	tx, err := app.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockImageContent(ctx, tx, img.ContentHash); err != nil {
+		return err
+	}
+	var stillUsed bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM product_images WHERE content_hash = $1)", img.ContentHash).Scan(&stillUsed)
+	if err != nil || stillUsed {
+		return err
+	}
+	if err := misc.S3DeleteObjects(app, img.ImageKey, img.WebPKey.String, img.ThumbnailKey.String); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// discardUploads removes the files of uploads that were not saved to a
+// gallery, keeping those another image row shares.
+func discardUploads(ctx context.Context, app *conf.Config, uploads []*misc.ProcessedImage) {
+	images := make([]ProductImage, 0, len(uploads))
+	for _, p := range uploads {
+		images = append(images, ProductImage{
+			ContentHash:  p.Hash,
+			ImageKey:     p.Original.Key,
+			WebPKey:      null.StringFrom(p.WebP.Key),
+			ThumbnailKey: null.StringFrom(p.Thumbnail.Key),
+		})
+	}
+	cleanupImageObjects(ctx, app, images)
+}
+
+// processAndUploadImage turns an uploaded file into its renditions and stores them.
+func processAndUploadImage(app *conf.Config, data []byte) (*misc.ProcessedImage, error) {
+	processed, err := misc.ProcessImage(data, productImageKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if err := misc.UploadProcessedImage(app, processed); err != nil {
+		discardUploads(context.Background(), app, []*misc.ProcessedImage{processed})
+		return nil, fmt.Errorf("uploading image: %w", err)
+	}
+	return processed, nil
+}
+
+// proposedGallery returns the gallery of a product as the pending revision
+// would leave it, in display order.
+func proposedGallery(ctx context.Context, db queryer, productID uuid.UUID) ([]GalleryImage, error) {
+	changes, err := pendingChanges(ctx, db, productID)
+	if err != nil {
+		return nil, err
+	}
+	if changes.Images != nil {
+		return slices.Clone(*changes.Images), nil
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, alt_text, is_primary FROM product_images
+		WHERE product_id = $1 AND NOT staged
+		ORDER BY sort_order, created
+	`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	gallery := []GalleryImage{}
+	for rows.Next() {
+		var img GalleryImage
+		if err := rows.Scan(&img.ID, &img.AltText, &img.IsPrimary); err != nil {
+			return nil, err
+		}
+		gallery = append(gallery, img)
+	}
+	return gallery, rows.Err()
+}
+
+func galleryIndex(gallery []GalleryImage, imageID uuid.UUID) int {
+	return slices.IndexFunc(gallery, func(img GalleryImage) bool { return img.ID == imageID })
+}
+
+// setGalleryPrimary makes the image at i the only primary one.
+func setGalleryPrimary(gallery []GalleryImage, i int) {
+	for j := range gallery {
+		gallery[j].IsPrimary = j == i
+	}
+}
+
+// moveGalleryImage moves the image at i to position to, clamped to the
+// gallery.
+func moveGalleryImage(gallery []GalleryImage, i, to int) []GalleryImage {
+	img := gallery[i]
+	gallery = slices.Delete(gallery, i, i+1)
+	to = min(max(to, 0), len(gallery))
+	return slices.Insert(gallery, to, img)
+}
+
+// reorderGallery puts the given images first, in the given order, followed by
+// the rest as they were. It reports false if an ID is unknown or repeated.
+func reorderGallery(gallery []GalleryImage, imageIDs []uuid.UUID) ([]GalleryImage, bool) {
+	ordered := make([]GalleryImage, 0, len(gallery))
+	placed := make(map[uuid.UUID]bool, len(imageIDs))
+	for _, id := range imageIDs {
+		i := galleryIndex(gallery, id)
+		if i < 0 || placed[id] {
+			return nil, false
+		}
+		placed[id] = true
+		ordered = append(ordered, gallery[i])
+	}
+	for _, img := range gallery {
+		if !placed[img.ID] {
+			ordered = append(ordered, img)
+		}
+	}
+	return ordered, true
+}
+
+// applyGallery makes the approved gallery live and returns the images it
+// dropped.
+func applyGallery(ctx context.Context, tx *sql.Tx, productID uuid.UUID, gallery []GalleryImage) ([]ProductImage, error) {
+	ids := make([]uuid.UUID, 0, len(gallery))
+	for _, img := range gallery {
+		ids = append(ids, img.ID)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		DELETE FROM product_images WHERE product_id = $1 AND NOT (id = ANY($2))
+		RETURNING `+productImageColumns, productID, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	var dropped []ProductImage
+	for rows.Next() {
+		var img ProductImage
+		if err := scanProductImage(rows, &img); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		dropped = append(dropped, img)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Clear the old primary first so the partial unique index is never violated
+	if _, err := tx.ExecContext(ctx, "UPDATE product_images SET is_primary = FALSE WHERE product_id = $1 AND is_primary", productID); err != nil {
+		return nil, err
+	}
+	hasPrimary := false
+	for i, img := range gallery {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE product_images SET sort_order = $1, alt_text = $2, is_primary = $3, staged = FALSE, updated = $4
+			WHERE id = $5 AND product_id = $6
+		`, i, img.AltText, img.IsPrimary && !hasPrimary, time.Now(), img.ID, productID)
+		if err != nil {
+			return nil, err
+		}
+		hasPrimary = hasPrimary || img.IsPrimary
+	}
+
+	return dropped, syncPrimaryImage(ctx, tx, productID)
+}
+
+// holdGallery submits the gallery for review. The response is written here
+// when it reports false.
+func holdGallery(c *gin.Context, tx *sql.Tx, productID uuid.UUID, gallery []GalleryImage, failure string) (ProductRevision, bool) {
+	revision, problem, err := holdListingChanges(c, tx, productID, ListingChanges{Images: &gallery})
+	if err != nil {
+		l.ErrorF("Error submitting product revision: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": failure})
+		return revision, false
+	}
+	if problem != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+		return revision, false
+	}
+	return revision, true
+}
+
+func AddProductImages(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		if !middleware.CheckProductAccess(c, app, permission.ProductWrite, productID) {
+			return
+		}
+
+		form, err := c.MultipartForm()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid multipart form"})
+			return
+		}
+		files := form.File["images"]
+		if len(files) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "At least one image is required"})
+			return
+		}
+		if len(files) > maxImagesPerUpload {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("You can upload at most %d images at once", maxImagesPerUpload)})
+			return
+		}
+		altTexts := form.Value["altText"]
+
+		// Files are uploaded before the rows are written, and removed again
+		// unless the rows are committed
+		processedImages := make([]*misc.ProcessedImage, 0, len(files))
+		saved := false
+		defer func() {
+			if !saved {
+				discardUploads(context.WithoutCancel(c.Request.Context()), app, processedImages)
+			}
+		}()
+		for _, file := range files {
+			if file.Size > maxImageSize {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Image size should be less than 5MB"})
+				return
+			}
+			data, err := misc.ReadFormFile(file)
+			if err != nil {
+				l.ErrorF("Error reading image: %v", err)
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read image"})
+				return
+			}
+			processed, err := processAndUploadImage(app, data)
+			if err != nil {
+				if errors.Is(err, misc.ErrUnsupportedImage) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported image format"})
+					return
+				}
+				if errors.Is(err, misc.ErrImageTooLarge) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "Image dimensions are too large"})
+					return
+				}
+				l.ErrorF("Image upload failed: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Image upload failed"})
+				return
+			}
+			processedImages = append(processedImages, processed)
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error starting transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		// Images added to a published product are staged until reviewed
+		held, err := heldForReview(c, app, tx, productID)
+		if err != nil {
+			l.ErrorF("Error fetching product status: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add images"})
+			return
+		}
+
+		var gallery []GalleryImage
+		var hasPrimary bool
+		if held {
+			gallery, err = proposedGallery(ctx, tx, productID)
+			hasPrimary = slices.ContainsFunc(gallery, func(img GalleryImage) bool { return img.IsPrimary })
+		} else {
+			err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM product_images WHERE product_id = $1 AND is_primary)", productID).Scan(&hasPrimary)
+		}
+		if err != nil {
+			l.ErrorF("Error checking primary image: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add images"})
+			return
+		}
+
+		added := []ProductImage{}
+		for i, processed := range processedImages {
+			var exists bool
+			err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM product_images WHERE product_id = $1 AND content_hash = $2)", productID, processed.Hash).Scan(&exists)
+			if err != nil {
+				l.ErrorF("Error checking duplicate image: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add images"})
+				return
+			}
+			if exists { // Same file uploaded twice for this product
+				continue
+			}
+
+			altText := ""
+			if i < len(altTexts) {
+				altText = altTexts[i]
+			}
+			img, err := insertProductImage(ctx, tx, productID, processed, app, altText, !hasPrimary, held)
+			if err != nil {
+				l.ErrorF("Error inserting product image: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add images"})
+				return
+			}
+			gallery = append(gallery, GalleryImage{ID: img.ID, AltText: img.AltText, IsPrimary: !hasPrimary})
+			hasPrimary = true
+			added = append(added, img)
+		}
+
+		var revision *ProductRevision
+		if held {
+			submitted, ok := holdGallery(c, tx, productID, gallery, "Failed to add images")
+			if !ok {
+				return
+			}
+			revision = &submitted
+		} else if err := syncPrimaryImage(ctx, tx, productID); err != nil {
+			l.ErrorF("Error updating primary image: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add images"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+		saved = true
+
+		if revision != nil {
+			c.JSON(http.StatusOK, gin.H{"success": true, "message": "Images added, they are waiting for review", "images": added, "revision": revision})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Images added successfully", "images": added})
+	}
+}
+
+func ListProductImages(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		if !middleware.CheckProductAccess(c, app, permission.ProductWrite, productID) {
+			return
+		}
+
+		// Merchants also see the uploads waiting for review
+		rows, err := app.DB.QueryContext(c, `
+			SELECT `+productImageColumns+`
+			FROM product_images
+			WHERE product_id = $1
+			ORDER BY staged, is_primary DESC, sort_order, created
+		`, productID)
+		if err != nil {
+			l.ErrorF("Error fetching product images: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+			return
+		}
+		defer rows.Close()
+
+		gallery := []ProductImage{}
+		for rows.Next() {
+			var img ProductImage
+			if err := scanProductImage(rows, &img); err != nil {
+				l.ErrorF("Error scanning product image: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images"})
+				return
+			}
+			gallery = append(gallery, img)
+		}
+		c.JSON(http.StatusOK, gin.H{"images": gallery})
+	}
+}
+
+func UpdateProductImage(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+		imageID, err := uuid.Parse(c.Param("imageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+			return
+		}
+
+		if !middleware.CheckProductAccess(c, app, permission.ProductWrite, productID) {
+			return
+		}
+
+		var req ProductImageUpdate
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error starting transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		held, err := heldForReview(c, app, tx, productID)
+		if err != nil {
+			l.ErrorF("Error fetching product status: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update image"})
+			return
+		}
+		if held {
+			gallery, err := proposedGallery(ctx, tx, productID)
+			if err != nil {
+				l.ErrorF("Error fetching product gallery: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update image"})
+				return
+			}
+			i := galleryIndex(gallery, imageID)
+			if i < 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+				return
+			}
+			if req.AltText != nil {
+				gallery[i].AltText = *req.AltText
+			}
+			if req.IsPrimary != nil && *req.IsPrimary {
+				setGalleryPrimary(gallery, i)
+			} else if req.IsPrimary != nil {
+				gallery[i].IsPrimary = false
+			}
+			if req.SortOrder != nil {
+				gallery = moveGalleryImage(gallery, i, *req.SortOrder)
+			}
+
+			revision, ok := holdGallery(c, tx, productID, gallery, "Failed to update image")
+			if !ok {
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				l.ErrorF("Error committing transaction: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"success": true, "message": "Image changes are waiting for review", "revision": revision})
+			return
+		}
+
+		if req.IsPrimary != nil && *req.IsPrimary {
+			// Clear the old primary first so the partial unique index is never violated
+			_, err = tx.ExecContext(ctx, "UPDATE product_images SET is_primary = FALSE WHERE product_id = $1 AND id != $2", productID, imageID)
+			if err != nil {
+				l.ErrorF("Error clearing primary image: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update image"})
+				return
+			}
+		}
+
+		updateQuery := "UPDATE product_images SET updated = $1"
+		args := []interface{}{time.Now()}
+		argIndex := 2
+
+		if req.AltText != nil {
+			updateQuery += fmt.Sprintf(", alt_text = $%d", argIndex)
+			args = append(args, *req.AltText)
+			argIndex++
+		}
+		if req.SortOrder != nil {
+			updateQuery += fmt.Sprintf(", sort_order = $%d", argIndex)
+			args = append(args, *req.SortOrder)
+			argIndex++
+		}
+		if req.IsPrimary != nil {
+			updateQuery += fmt.Sprintf(", is_primary = $%d", argIndex)
+			args = append(args, *req.IsPrimary)
+			argIndex++
+		}
+
+		updateQuery += fmt.Sprintf(" WHERE id = $%d AND product_id = $%d AND NOT staged RETURNING "+productImageColumns, argIndex, argIndex+1)
+		args = append(args, imageID, productID)
+
+		var img ProductImage
+		err = scanProductImage(tx.QueryRowContext(ctx, updateQuery, args...), &img)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			} else {
+				l.ErrorF("Error updating product image: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update image"})
+			}
+			return
+		}
+
+		if err := syncPrimaryImage(ctx, tx, productID); err != nil {
+			l.ErrorF("Error updating primary image: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update image"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Image updated successfully", "image": img})
+	}
+}
+
+func ReorderProductImages(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		if !middleware.CheckProductAccess(c, app, permission.ProductWrite, productID) {
+			return
+		}
+
+		var req ReorderProductImagesRequest
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.ImageIDs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "imageIds are required"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error starting transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		held, err := heldForReview(c, app, tx, productID)
+		if err != nil {
+			l.ErrorF("Error fetching product status: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reorder images"})
+			return
+		}
+		if held {
+			gallery, err := proposedGallery(ctx, tx, productID)
+			if err != nil {
+				l.ErrorF("Error fetching product gallery: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reorder images"})
+				return
+			}
+			gallery, ok := reorderGallery(gallery, req.ImageIDs)
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Some images do not belong to this product"})
+				return
+			}
+
+			revision, ok := holdGallery(c, tx, productID, gallery, "Failed to reorder images")
+			if !ok {
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				l.ErrorF("Error committing transaction: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"success": true, "message": "The new order is waiting for review", "revision": revision})
+			return
+		}
+
+		// The position in the array becomes the new sort order
+		res, err := tx.ExecContext(ctx, `
+			UPDATE product_images pi
+			SET sort_order = o.position - 1, updated = $3
+			FROM unnest($1::uuid[]) WITH ORDINALITY AS o(id, position)
+			WHERE pi.id = o.id AND pi.product_id = $2 AND NOT pi.staged
+		`, pq.Array(req.ImageIDs), productID, time.Now())
+		if err != nil {
+			l.ErrorF("Error reordering product images: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reorder images"})
+			return
+		}
+
+		rowsAffected, err := res.RowsAffected()
+		if err != nil || int(rowsAffected) != len(req.ImageIDs) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Some images do not belong to this product"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Images reordered successfully"})
+	}
+}
+
+func DeleteProductImage(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+		imageID, err := uuid.Parse(c.Param("imageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+			return
+		}
+
+		if !middleware.CheckProductAccess(c, app, permission.ProductWrite, productID) {
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error starting transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		held, err := heldForReview(c, app, tx, productID)
+		if err != nil {
+			l.ErrorF("Error fetching product status: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete image"})
+			return
+		}
+
+		// A published product keeps showing the image until the removal is
+		// reviewed, uploads that never went live are dropped right away
+		if held {
+			gallery, err := proposedGallery(ctx, tx, productID)
+			if err != nil {
+				l.ErrorF("Error fetching product gallery: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete image"})
+				return
+			}
+			i := galleryIndex(gallery, imageID)
+			if i < 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+				return
+			}
+			gallery = slices.Delete(gallery, i, i+1)
+
+			revision, ok := holdGallery(c, tx, productID, gallery, "Failed to delete image")
+			if !ok {
+				return
+			}
+
+			var img ProductImage
+			err = scanProductImage(tx.QueryRowContext(ctx, `
+				DELETE FROM product_images WHERE id = $1 AND product_id = $2 AND staged
+				RETURNING `+productImageColumns, imageID, productID), &img)
+			deleted := err == nil
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				l.ErrorF("Error deleting product image: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete image"})
+				return
+			}
+
+			if err := tx.Commit(); err != nil {
+				l.ErrorF("Error committing transaction: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+				return
+			}
+			if deleted {
+				cleanupImageObjects(ctx, app, []ProductImage{img})
+			}
+			c.JSON(http.StatusOK, gin.H{"success": true, "message": "Image removed, the change is waiting for review", "revision": revision})
+			return
+		}
+
+		var img ProductImage
+		err = scanProductImage(tx.QueryRowContext(ctx, `
+			DELETE FROM product_images WHERE id = $1 AND product_id = $2 AND NOT staged
+			RETURNING `+productImageColumns, imageID, productID), &img)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+			} else {
+				l.ErrorF("Error deleting product image: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete image"})
+			}
+			return
+		}
+
+		if err := syncPrimaryImage(ctx, tx, productID); err != nil {
+			l.ErrorF("Error updating primary image: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete image"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+
+		// Storage cleanup happens after commit so a failed delete never loses files
+		cleanupImageObjects(ctx, app, []ProductImage{img})
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Image deleted successfully"})
+	}
+}
@@ -24,23 +24,53 @@ type Product struct {
 	BrandID     uuid.NullUUID `db:"brand_id" json:"brandId"`
 	CategoryID  uuid.NullUUID `db:"category_id" json:"categoryId"`
 	MerchantID  uuid.UUID     `db:"merchant_id" json:"merchantId"`
+	Type        ProductType   `db:"product_type" json:"type"`
+	Status      ProductStatus `db:"status" json:"status"`
+	Reason      null.String   `db:"status_reason" json:"statusReason,omitempty"` // set when rejected
+	DeletedAt   null.Time     `db:"deleted_at" json:"deletedAt,omitempty"`
 	Updated     null.Time     `db:"updated" json:"updated"`
 	Created     time.Time     `db:"created" json:"created"`
 }
 
-type ProductUpdate struct { // Struct for partial updates
-	SKU         *string    `json:"sku"`
-	Name        *string    `json:"name"`
-	Slug        *string    `json:"slug"`
-	ImageURL    *string    `json:"imageUrl"`
-	ImageKey    *string    `json:"imageKey"`
-	Description *string    `json:"description"`
-	Quantity    *int       `json:"quantity"`
-	Price       *float64   `json:"price"`
-	Taxable     *bool      `json:"taxable"`
-	IsActive    *bool      `json:"isActive"`
-	BrandID     *uuid.UUID `json:"brandId"`
-	CategoryID  *uuid.UUID `json:"categoryId"`
+type ProductType string
+
+const (
+	ProductPhysical ProductType = "physical"
+	ProductDigital  ProductType = "digital" // delivered as file downloads, never shipped
+	ProductBundle   ProductType = "bundle"  // sold as one, stocked and shipped as its components
+)
+
+// ProductStatus is where a listing stands in moderation. Only published
+// products are shown in the store and can be bought.
+type ProductStatus string
+
+const (
+	StatusDraft         ProductStatus = "draft"
+	StatusPendingReview ProductStatus = "pending_review"
+	StatusPublished     ProductStatus = "published"
+	StatusRejected      ProductStatus = "rejected"
+	StatusArchived      ProductStatus = "archived"
+)
+
+// ProductUpdate is a partial update. On published products the listing
+// fields (SKU, name, slug, description, brand, category and attributes) of a
+// merchant edit are held as a revision until an admin approves them, the rest
+// applies right away. Images are managed through the gallery endpoints.
+type ProductUpdate struct {
+	SKU         *string                `json:"sku"`
+	Name        *string                `json:"name"`
+	Slug        *string                `json:"slug"`
+	Description *string                `json:"description"`
+	Quantity    *int                   `json:"quantity"`
+	Price       *float64               `json:"price"`
+	CompareAt   *float64               `json:"compareAtPrice"` // 0 removes the compare-at price
+	Taxable     *bool                  `json:"taxable"`
+	BrandID     *uuid.UUID             `json:"brandId"`
+	CategoryID  *uuid.UUID             `json:"categoryId"` // replaces the categories, attributes are checked against the new one
+	Attributes  map[string]interface{} `json:"attributes"` // null values clear an attribute
+
+	DownloadLimit      *int `json:"downloadLimit"`      // digital products only, 0 is unlimited
+	DownloadExpiryDays *int `json:"downloadExpiryDays"` // digital products only, 0 never expires
 }
 
 type AddProductInput struct { // Request input struct for AddProduct
@@ -48,12 +78,18 @@ type AddProductInput struct { // Request input struct for AddProduct
 	Name        string    `form:"name" binding:"required"`
 	Slug        string    `form:"slug"`
 	Description string    `form:"description" binding:"required"`
-	Quantity    int       `form:"quantity" binding:"required"`
+	Quantity    int       `form:"quantity" binding:"required_unless=Type digital Type bundle"`
 	Price       float64   `form:"price" binding:"required"`
+	CompareAt   float64   `form:"compareAtPrice"`
 	Taxable     bool      `form:"taxable"`
-	IsActive    bool      `form:"isActive"`
+	IsActive    bool      `form:"isActive"` // submit for review right away, admins publish directly
 	BrandID     uuid.UUID `form:"brandId"`
 	CategoryID  uuid.UUID `form:"categoryId"`
+	Attributes  string    `form:"attributes"` // JSON object of attribute slug to value
+
+	Type               ProductType `form:"type"` // physical when empty
+	DownloadLimit      int         `form:"downloadLimit"`
+	DownloadExpiryDays int         `form:"downloadExpiryDays"`
 }
 
 type GetProduct struct {
@@ -64,12 +100,265 @@ type GetProduct struct {
 	ImageURL    []string            `json:"imageUrl"`
 	Description string              `json:"description"`
 	Quantity    int                 `json:"quantity"`
-	Price       float64             `json:"price"`
+	Price       float64             `json:"price"` // effective price at request time
 	Taxable     bool                `json:"taxable"`
 	IsActive    bool                `json:"isActive"`
+	Type        ProductType         `json:"type"`
 	Brand       brand.Brand         `json:"brandId,omitempty"`
+	Images      []ProductImage      `json:"images"`
+	Attributes  []ProductAttribute  `json:"attributes"`
 	Categories  []category.Category `json:"categories,omitempty"`
+	Components  []BundleComponent   `json:"components,omitempty"`
 	MerchantID  uuid.UUID           `json:"merchantId,omitempty"`
 	Updated     time.Time           `json:"updated,omitempty"`
 	Created     time.Time           `json:"created,omitempty"`
+	Pricing
+}
+
+type ProductImage struct {
+	ID           uuid.UUID   `db:"id" json:"_id"`
+	ProductID    uuid.UUID   `db:"product_id" json:"productId"`
+	ContentHash  string      `db:"content_hash" json:"-"`
+	ImageKey     string      `db:"image_key" json:"-"`
+	ImageURL     string      `db:"image_url" json:"imageUrl"`
+	WebPKey      null.String `db:"webp_key" json:"-"`
+	WebPURL      null.String `db:"webp_url" json:"webpUrl"`
+	ThumbnailKey null.String `db:"thumbnail_key" json:"-"`
+	ThumbnailURL null.String `db:"thumbnail_url" json:"thumbnailUrl"`
+	Width        int         `db:"width" json:"width"`
+	Height       int         `db:"height" json:"height"`
+	AltText      string      `db:"alt_text" json:"altText"`
+	SortOrder    int         `db:"sort_order" json:"sortOrder"`
+	IsPrimary    bool        `db:"is_primary" json:"isPrimary"`
+	Staged       bool        `db:"staged" json:"staged"` // uploaded to a published product, waiting for review
+	Updated      null.Time   `db:"updated" json:"updated"`
+	Created      time.Time   `db:"created" json:"created"`
+}
+
+type ProductImageUpdate struct { // Struct for partial updates
+	AltText   *string `json:"altText"`
+	SortOrder *int    `json:"sortOrder"`
+	IsPrimary *bool   `json:"isPrimary"`
+}
+
+type ReorderProductImagesRequest struct {
+	ImageIDs []uuid.UUID `json:"imageIds" binding:"required"`
+}
+
+// ProductAttribute is a specification value shown on a product, e.g. RAM: 8 GB.
+type ProductAttribute struct {
+	AttributeID  uuid.UUID              `json:"attributeId"`
+	Name         string                 `json:"name"`
+	Slug         string                 `json:"slug"`
+	Type         category.AttributeType `json:"type"`
+	Unit         string                 `json:"unit"`
+	IsFilterable bool                   `json:"isFilterable"`
+	Value        interface{}            `json:"value"`
+}
+
+type FacetValue struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// AttributeFacet lists the values of a filterable attribute on the store listing.
+type AttributeFacet struct {
+	Slug   string                 `json:"slug"`
+	Name   string                 `json:"name"`
+	Type   category.AttributeType `json:"type"`
+	Unit   string                 `json:"unit"`
+	Values []FacetValue           `json:"values"`
+}
+
+// Pricing holds the price details of a product at a point in time.
+type Pricing struct {
+	RegularPrice   float64    `json:"regularPrice"`
+	CompareAtPrice null.Float `json:"compareAtPrice"`
+	OnSale         bool       `json:"onSale"`
+	SaleEndsAt     null.Time  `json:"saleEndsAt"`
+}
+
+// SalePrice is a scheduled price reduction, active from StartsAt until EndsAt.
+type SalePrice struct {
+	ID        uuid.UUID     `db:"id" json:"id"`
+	ProductID uuid.UUID     `db:"product_id" json:"productId"`
+	SalePrice float64       `db:"sale_price" json:"salePrice"`
+	StartsAt  time.Time     `db:"starts_at" json:"startsAt"`
+	EndsAt    null.Time     `db:"ends_at" json:"endsAt"`
+	CreatedBy uuid.NullUUID `db:"created_by" json:"createdBy"`
+	Created   time.Time     `db:"created" json:"created"`
+}
+
+type AddSalePriceRequest struct {
+	SalePrice float64   `json:"salePrice" binding:"required,gt=0"`
+	StartsAt  time.Time `json:"startsAt" binding:"required"`
+	EndsAt    null.Time `json:"endsAt"`
+}
+
+// PriceChange is one entry of product_price_history.
+type PriceChange struct {
+	ID             uuid.UUID     `db:"id" json:"id"`
+	ChangeType     string        `db:"change_type" json:"changeType"`
+	Price          float64       `db:"price" json:"price"`
+	CompareAtPrice null.Float    `db:"compare_at_price" json:"compareAtPrice"`
+	SalePrice      null.Float    `db:"sale_price" json:"salePrice"`
+	SaleStartsAt   null.Time     `db:"sale_starts_at" json:"saleStartsAt"`
+	SaleEndsAt     null.Time     `db:"sale_ends_at" json:"saleEndsAt"`
+	ChangedBy      uuid.NullUUID `db:"changed_by" json:"changedBy"`
+	Created        time.Time     `db:"created" json:"created"`
+}
+
+// ProductFile is a file delivered to buyers of a digital product.
+type ProductFile struct {
+	ID          uuid.UUID `db:"id" json:"_id"`
+	ProductID   uuid.UUID `db:"product_id" json:"productId"`
+	FileKey     string    `db:"file_key" json:"-"`
+	FileName    string    `db:"file_name" json:"fileName"`
+	ContentType string    `db:"content_type" json:"contentType"`
+	SizeBytes   int64     `db:"size_bytes" json:"sizeBytes"`
+	Staged      bool      `db:"staged" json:"staged"` // uploaded to a published product, waiting for review
+	Created     time.Time `db:"created" json:"created"`
+}
+
+// BundleComponent is a product contained in a bundle. Available is how many
+// units of the component can currently be sold.
+type BundleComponent struct {
+	ComponentID uuid.UUID `json:"componentId"`
+	Name        string    `json:"name"`
+	SKU         string    `json:"sku"`
+	Slug        string    `json:"slug"`
+	Quantity    int       `json:"quantity"` // units per bundle
+	Available   int       `json:"available"`
+}
+
+type BundleComponentInput struct {
+	ComponentID uuid.UUID `json:"componentId" binding:"required"`
+	Quantity    int       `json:"quantity" binding:"required,gt=0"`
+}
+
+type SetBundleComponentsRequest struct {
+	Components []BundleComponentInput `json:"components" binding:"required,dive"`
+}
+
+// ListingChanges are the edits to a published product that wait for review.
+// Images, components and files hold the whole new set, not a diff.
+type ListingChanges struct {
+	SKU         *string                 `json:"sku,omitempty"`
+	Name        *string                 `json:"name,omitempty"`
+	Slug        *string                 `json:"slug,omitempty"`
+	Description *string                 `json:"description,omitempty"`
+	BrandID     *uuid.UUID              `json:"brandId,omitempty"`
+	CategoryID  *uuid.UUID              `json:"categoryId,omitempty"`
+	Attributes  map[string]interface{}  `json:"attributes,omitempty"` // null values clear an attribute
+	Images      *[]GalleryImage         `json:"images,omitempty"`     // in display order
+	Components  *[]BundleComponentInput `json:"components,omitempty"`
+	Files       *[]uuid.UUID            `json:"files,omitempty"`
+}
+
+// GalleryImage is an image of a gallery waiting for review.
+type GalleryImage struct {
+	ID        uuid.UUID `json:"id"`
+	AltText   string    `json:"altText"`
+	IsPrimary bool      `json:"isPrimary"`
+}
+
+type RevisionStatus string
+
+const (
+	RevisionPending  RevisionStatus = "pending"
+	RevisionApproved RevisionStatus = "approved"
+	RevisionRejected RevisionStatus = "rejected"
+)
+
+type ProductRevision struct {
+	ID          uuid.UUID      `db:"id" json:"_id"`
+	ProductID   uuid.UUID      `db:"product_id" json:"productId"`
+	Changes     ListingChanges `db:"changes" json:"changes"`
+	Status      RevisionStatus `db:"status" json:"status"`
+	Reason      null.String    `db:"reason" json:"reason"`
+	SubmittedBy uuid.NullUUID  `db:"submitted_by" json:"submittedBy"`
+	ReviewedBy  uuid.NullUUID  `db:"reviewed_by" json:"reviewedBy"`
+	ReviewedAt  null.Time      `db:"reviewed_at" json:"reviewedAt"`
+	Updated     time.Time      `db:"updated" json:"updated"`
+	Created     time.Time      `db:"created" json:"created"`
+}
+
+type StatusChange struct {
+	ID         uuid.UUID     `db:"id" json:"_id"`
+	FromStatus ProductStatus `db:"from_status" json:"fromStatus"`
+	ToStatus   ProductStatus `db:"to_status" json:"toStatus"`
+	Reason     null.String   `db:"reason" json:"reason"`
+	ChangedBy  uuid.NullUUID `db:"changed_by" json:"changedBy"`
+	Created    time.Time     `db:"created" json:"created"`
+}
+
+type ChangeStatusRequest struct {
+	Status ProductStatus `json:"status" binding:"required"`
+}
+
+type ModerationRequest struct {
+	Reason string `json:"reason"`
+}
+
+// PendingProduct is an entry of the moderation queue.
+type PendingProduct struct {
+	ID           uuid.UUID   `json:"_id"`
+	SKU          string      `json:"sku"`
+	Name         string      `json:"name"`
+	Slug         string      `json:"slug"`
+	Type         ProductType `json:"type"`
+	MerchantID   uuid.UUID   `json:"merchantId"`
+	MerchantName string      `json:"merchantName"`
+	SubmittedAt  time.Time   `json:"submittedAt"`
+}
+
+// PendingRevision is a revision in the moderation queue.
+type PendingRevision struct {
+	ProductRevision
+	ProductName  string    `json:"productName"`
+	MerchantID   uuid.UUID `json:"merchantId"`
+	MerchantName string    `json:"merchantName"`
+}
+
+// TrashedProduct is a deleted product waiting to be restored or purged.
+type TrashedProduct struct {
+	ID         uuid.UUID     `json:"_id"`
+	SKU        string        `json:"sku"`
+	Name       string        `json:"name"`
+	Slug       string        `json:"slug"`
+	Type       ProductType   `json:"type"`
+	Status     ProductStatus `json:"status"`
+	MerchantID uuid.UUID     `json:"merchantId"`
+	DeletedAt  time.Time     `json:"deletedAt"`
+	DeletedBy  uuid.NullUUID `json:"deletedBy"`
+	PurgeAfter time.Time     `json:"purgeAfter"`
+}
+
+// RecommendationKind tells why a product is recommended next to another.
+type RecommendationKind string
+
+const (
+	RecommendBoughtTogether RecommendationKind = "bought_together" // paid for in the same orders
+	RecommendSimilar        RecommendationKind = "similar"         // shares categories, brand or price band
+	RecommendAlsoViewed     RecommendationKind = "also_viewed"     // viewed by the same customers
+)
+
+// RelatedProduct is a recommended product as shown on a product page.
+type RelatedProduct struct {
+	ID       uuid.UUID   `json:"_id"`
+	Name     string      `json:"name"`
+	Slug     string      `json:"slug"`
+	ImageURL null.String `json:"imageUrl"`
+	Price    float64     `json:"price"`
+	Score    float64     `json:"score"`
+}
+
+// ViewedProduct is a product in a browsing history.
+type ViewedProduct struct {
+	ID       uuid.UUID   `json:"_id"`
+	Name     string      `json:"name"`
+	Slug     string      `json:"slug"`
+	ImageURL null.String `json:"imageUrl"`
+	Price    float64     `json:"price"`
+	ViewedAt time.Time   `json:"viewedAt"`
 }
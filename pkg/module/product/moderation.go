@@ -0,0 +1,884 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/middleware"
+	category "src/pkg/module/category"
+	"src/pkg/permission"
+)
+
+// statusTransitions lists the moves merchants can make on their own
+// products. Publishing and rejecting are left to the moderators.
+var statusTransitions = map[ProductStatus][]ProductStatus{
+	StatusDraft:         {StatusPendingReview, StatusArchived},
+	StatusPendingReview: {StatusDraft},
+	StatusRejected:      {StatusPendingReview, StatusArchived},
+	StatusPublished:     {StatusArchived},
+	StatusArchived:      {StatusPendingReview},
+}
+
+func canTransition(from, to ProductStatus) bool {
+	return slices.Contains(statusTransitions[from], to)
+}
+
+// setStatus moves a product from one status to another and records the move
+// in its status history. Published and rejected are only reached through
+// moderation, so those moves also stamp the reviewer.
+func setStatus(ctx context.Context, tx *sql.Tx, productID uuid.UUID, from, to ProductStatus, reason string, actor uuid.NullUUID) error {
+	moderated := to == StatusPublished || to == StatusRejected
+	res, err := tx.ExecContext(ctx, `
+		UPDATE products SET status = $1, status_reason = NULLIF($2, ''),
+			reviewed_by = CASE WHEN $3 THEN $4 ELSE reviewed_by END,
+			reviewed_at = CASE WHEN $3 THEN NOW() ELSE reviewed_at END,
+			updated = NOW()
+		WHERE id = $5 AND status = $6
+	`, to, reason, moderated, actor, productID, from)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("product %s is no longer %s", productID, from)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO product_status_history (product_id, from_status, to_status, reason, changed_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+	`, productID, from, to, reason, actor)
+	return err
+}
+
+// checkPublishable returns why a product cannot go live yet, or an empty
+// string when it can.
+func checkPublishable(ctx context.Context, db queryer, productID uuid.UUID, productType ProductType) (string, error) {
+	var query, problem string
+	switch productType {
+	case ProductDigital:
+		query, problem = "SELECT EXISTS(SELECT 1 FROM product_files WHERE product_id = $1 AND NOT staged)", "Add at least one file before publishing a digital product"
+	case ProductBundle:
+		query, problem = "SELECT EXISTS(SELECT 1 FROM bundle_components WHERE bundle_id = $1)", "Add components before publishing a bundle"
+	default:
+		return "", nil
+	}
+
+	var ok bool
+	if err := db.QueryRowContext(ctx, query, productID).Scan(&ok); err != nil {
+		return "", err
+	}
+	if !ok {
+		return problem, nil
+	}
+	return "", nil
+}
+
+// transitionProduct moves a product to a new status if allowed accepts its
+// current one. The response is written here.
+func transitionProduct(c *gin.Context, app *conf.Config, productID uuid.UUID, to ProductStatus, reason string, allowed func(from ProductStatus) bool) {
+	ctx := c.Request.Context()
+	tx, err := app.DB.BeginTx(ctx, nil)
+	if err != nil {
+		l.ErrorF("Error starting transaction: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	var from ProductStatus
+	var productType ProductType
+	err = tx.QueryRowContext(ctx, "SELECT status, product_type FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", productID).Scan(&from, &productType)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		} else {
+			l.ErrorF("Error fetching product status: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product status"})
+		}
+		return
+	}
+	if from == to {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Product is already %s", to)})
+		return
+	}
+	if !allowed(from) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A %s product cannot be moved to %s", from, to)})
+		return
+	}
+
+	if to == StatusPendingReview || to == StatusPublished {
+		problem, err := checkPublishable(ctx, tx, productID, productType)
+		if err != nil {
+			l.ErrorF("Error checking product: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product status"})
+			return
+		}
+		if problem != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": problem})
+			return
+		}
+	}
+
+	if err := setStatus(ctx, tx, productID, from, to, reason, middleware.ActorID(c)); err != nil {
+		l.ErrorF("Error updating product status: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product status"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		l.ErrorF("Error committing transaction: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Product status updated successfully", "status": to})
+}
+
+// ChangeProductStatus lets merchants submit, withdraw and archive their
+// products.
+func ChangeProductStatus(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		var req ChangeStatusRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, ok := statusTransitions[req.Status]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+			return
+		}
+
+		if !middleware.CheckProductAccess(c, app, permission.ProductWrite, productID) {
+			return
+		}
+
+		transitionProduct(c, app, productID, req.Status, "", func(from ProductStatus) bool {
+			return canTransition(from, req.Status)
+		})
+	}
+}
+
+func ApproveProduct(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		transitionProduct(c, app, productID, StatusPublished, "", func(from ProductStatus) bool {
+			return from == StatusPendingReview
+		})
+	}
+}
+
+// RejectProduct turns down a submitted product, or takes down a published
+// one. The reason is shown to the merchant.
+func RejectProduct(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		var req ModerationRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.Reason == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required"})
+			return
+		}
+
+		transitionProduct(c, app, productID, StatusRejected, req.Reason, func(from ProductStatus) bool {
+			return from == StatusPendingReview || from == StatusPublished
+		})
+	}
+}
+
+func FetchProductStatusHistory(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		if !middleware.CheckProductAccess(c, app, permission.ProductWrite, productID) {
+			return
+		}
+
+		rows, err := app.DB.QueryContext(c, `
+			SELECT id, from_status, to_status, reason, changed_by, created
+			FROM product_status_history WHERE product_id = $1
+			ORDER BY created DESC
+		`, productID)
+		if err != nil {
+			l.ErrorF("Error fetching status history: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch status history"})
+			return
+		}
+		defer rows.Close()
+
+		history := []StatusChange{}
+		for rows.Next() {
+			var h StatusChange
+			if err := rows.Scan(&h.ID, &h.FromStatus, &h.ToStatus, &h.Reason, &h.ChangedBy, &h.Created); err != nil {
+				l.ErrorF("Error scanning status history: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch status history"})
+				return
+			}
+			history = append(history, h)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"history": history})
+	}
+}
+
+// takeListingChanges moves the listing fields out of the update, returning
+// nil when it has none.
+func (u *ProductUpdate) takeListingChanges() *ListingChanges {
+	changes := ListingChanges{
+		SKU:         u.SKU,
+		Name:        u.Name,
+		Slug:        u.Slug,
+		Description: u.Description,
+		BrandID:     u.BrandID,
+		CategoryID:  u.CategoryID,
+		Attributes:  u.Attributes,
+	}
+	u.SKU, u.Name, u.Slug, u.Description, u.BrandID, u.CategoryID, u.Attributes = nil, nil, nil, nil, nil, nil, nil
+
+	if changes.SKU == nil && changes.Name == nil && changes.Slug == nil && changes.Description == nil &&
+		changes.BrandID == nil && changes.CategoryID == nil && changes.Attributes == nil {
+		return nil
+	}
+	return &changes
+}
+
+// merge lays newer changes over a pending revision. Attributes are merged
+// one by one so an edit does not drop the ones changed before.
+func (lc ListingChanges) merge(newer ListingChanges) ListingChanges {
+	merged := lc
+	if newer.SKU != nil {
+		merged.SKU = newer.SKU
+	}
+	if newer.Name != nil {
+		merged.Name = newer.Name
+	}
+	if newer.Slug != nil {
+		merged.Slug = newer.Slug
+	}
+	if newer.Description != nil {
+		merged.Description = newer.Description
+	}
+	if newer.BrandID != nil {
+		merged.BrandID = newer.BrandID
+	}
+	if newer.CategoryID != nil {
+		merged.CategoryID = newer.CategoryID
+	}
+	if newer.Images != nil {
+		merged.Images = newer.Images
+	}
+	if newer.Components != nil {
+		merged.Components = newer.Components
+	}
+	if newer.Files != nil {
+		merged.Files = newer.Files
+	}
+	if newer.Attributes != nil {
+		merged.Attributes = make(map[string]interface{}, len(lc.Attributes)+len(newer.Attributes))
+		for slug, value := range lc.Attributes {
+			merged.Attributes[slug] = value
+		}
+		for slug, value := range newer.Attributes {
+			merged.Attributes[slug] = value
+		}
+	}
+	return merged
+}
+
+// checkListingChanges returns why the changes cannot be applied to the
+// product, or an empty string when they can.
+func checkListingChanges(ctx context.Context, db queryer, productID uuid.UUID, changes ListingChanges) (string, error) {
+	if changes.SKU != nil {
+		var taken bool
+		err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM products WHERE sku = $1 AND id <> $2)", *changes.SKU, productID).Scan(&taken)
+		if err != nil {
+			return "", err
+		}
+		if taken {
+			return "SKU already exists.", nil
+		}
+	}
+	if changes.Slug != nil {
+		var taken bool
+		err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM products WHERE slug = $1 AND id <> $2)", *changes.Slug, productID).Scan(&taken)
+		if err != nil {
+			return "", err
+		}
+		if taken {
+			return "Slug already exists.", nil
+		}
+	}
+	switch {
+	case changes.CategoryID != nil:
+		_, problem, err := checkCategoryChange(ctx, db, productID, *changes.CategoryID, changes.Attributes)
+		if err != nil || problem != "" {
+			return problem, err
+		}
+	case changes.Attributes != nil:
+		schema, err := category.ProductAttributes(ctx, db, productID)
+		if err != nil {
+			return "", err
+		}
+		if _, _, err := category.ValidateAttributes(schema, changes.Attributes, false); err != nil {
+			return err.Error(), nil
+		}
+	}
+	if changes.Components != nil {
+		problem, err := checkBundleComponents(ctx, db, productID, *changes.Components)
+		if err != nil || problem != "" {
+			return problem, err
+		}
+	}
+	if changes.Files != nil {
+		problem, err := checkFileChanges(ctx, db, productID, *changes.Files)
+		if err != nil || problem != "" {
+			return problem, err
+		}
+	}
+	return "", nil
+}
+
+// applyListingChanges writes approved changes to the product. They must have
+// passed checkListingChanges. The stored files the product no longer uses are
+// returned for cleanup after commit.
+func applyListingChanges(ctx context.Context, tx *sql.Tx, productID uuid.UUID, changes ListingChanges) (discarded, error) {
+	var gone discarded
+	updateQuery := "UPDATE products SET updated = NOW()"
+	args := []interface{}{}
+	for _, field := range []struct {
+		column string
+		value  interface{}
+		set    bool
+	}{
+		{"sku", changes.SKU, changes.SKU != nil},
+		{"name", changes.Name, changes.Name != nil},
+		{"slug", changes.Slug, changes.Slug != nil},
+		{"description", changes.Description, changes.Description != nil},
+		{"brand_id", changes.BrandID, changes.BrandID != nil},
+	} {
+		if field.set {
+			args = append(args, field.value)
+			updateQuery += fmt.Sprintf(", %s = $%d", field.column, len(args))
+		}
+	}
+	args = append(args, productID)
+	updateQuery += fmt.Sprintf(" WHERE id = $%d", len(args))
+
+	if _, err := tx.ExecContext(ctx, updateQuery, args...); err != nil {
+		return gone, err
+	}
+
+	switch {
+	case changes.CategoryID != nil:
+		values, _, err := checkCategoryChange(ctx, tx, productID, *changes.CategoryID, changes.Attributes)
+		if err != nil {
+			return gone, err
+		}
+		if err := moveProductCategory(ctx, tx, productID, *changes.CategoryID, values); err != nil {
+			return gone, err
+		}
+	case changes.Attributes != nil:
+		schema, err := category.ProductAttributes(ctx, tx, productID)
+		if err != nil {
+			return gone, err
+		}
+		set, cleared, err := category.ValidateAttributes(schema, changes.Attributes, false)
+		if err != nil {
+			return gone, err
+		}
+		if err := saveProductAttributes(ctx, tx, productID, set, cleared); err != nil {
+			return gone, err
+		}
+	}
+
+	if changes.Components != nil {
+		if err := replaceBundleComponents(ctx, tx, productID, *changes.Components); err != nil {
+			return gone, err
+		}
+	}
+
+	var err error
+	if changes.Images != nil {
+		if gone.images, err = applyGallery(ctx, tx, productID, *changes.Images); err != nil {
+			return gone, err
+		}
+	}
+	if changes.Files != nil {
+		if gone.files, err = applyFiles(ctx, tx, productID, *changes.Files); err != nil {
+			return gone, err
+		}
+	}
+	return gone, nil
+}
+
+// discarded are stored files a product let go of. Their objects are removed
+// once the transaction that dropped their rows commits.
+type discarded struct {
+	images []ProductImage
+	files  []ProductFile
+}
+
+func (d discarded) cleanup(ctx context.Context, app *conf.Config) {
+	cleanupImageObjects(ctx, app, d.images)
+	cleanupFileObjects(ctx, app, d.files)
+}
+
+// discardStaged drops the uploads that were waiting on a revision which will
+// not be applied.
+func discardStaged(ctx context.Context, tx *sql.Tx, productID uuid.UUID) (discarded, error) {
+	var gone discarded
+	rows, err := tx.QueryContext(ctx, "DELETE FROM product_images WHERE product_id = $1 AND staged RETURNING "+productImageColumns, productID)
+	if err != nil {
+		return gone, err
+	}
+	for rows.Next() {
+		var img ProductImage
+		if err := scanProductImage(rows, &img); err != nil {
+			rows.Close()
+			return gone, err
+		}
+		gone.images = append(gone.images, img)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return gone, err
+	}
+
+	rows, err = tx.QueryContext(ctx, "DELETE FROM product_files WHERE product_id = $1 AND staged RETURNING "+productFileColumns, productID)
+	if err != nil {
+		return gone, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f ProductFile
+		if err := scanProductFile(rows, &f); err != nil {
+			return gone, err
+		}
+		gone.files = append(gone.files, f)
+	}
+	return gone, rows.Err()
+}
+
+// heldForReview reports whether changes to the listing of the product wait
+// for a moderator, locking the product until tx ends.
+func heldForReview(c *gin.Context, app *conf.Config, tx *sql.Tx, productID uuid.UUID) (bool, error) {
+	var status ProductStatus
+	err := tx.QueryRowContext(c, "SELECT status FROM products WHERE id = $1 FOR UPDATE", productID).Scan(&status)
+	if err != nil {
+		return false, err
+	}
+	return status == StatusPublished && !middleware.HasPermission(c, app, permission.ProductModerate), nil
+}
+
+// pendingChanges returns the changes of the pending revision of a product, or
+// none.
+func pendingChanges(ctx context.Context, db queryer, productID uuid.UUID) (ListingChanges, error) {
+	var changes ListingChanges
+	var pending []byte
+	err := db.QueryRowContext(ctx, "SELECT changes FROM product_revisions WHERE product_id = $1 AND status = $2", productID, RevisionPending).Scan(&pending)
+	if errors.Is(err, sql.ErrNoRows) {
+		return changes, nil
+	}
+	if err != nil {
+		return changes, err
+	}
+	return changes, json.Unmarshal(pending, &changes)
+}
+
+// holdListingChanges adds the changes to the pending revision and returns why
+// the revision as a whole cannot be applied, if it cannot.
+func holdListingChanges(c *gin.Context, tx *sql.Tx, productID uuid.UUID, changes ListingChanges) (ProductRevision, string, error) {
+	revision, err := submitRevision(c, tx, productID, changes, middleware.ActorID(c))
+	if err != nil {
+		return revision, "", err
+	}
+	problem, err := checkListingChanges(c, tx, productID, revision.Changes)
+	return revision, problem, err
+}
+
+// submitRevision adds listing changes to the pending revision of a product,
+// opening one if there is none.
+func submitRevision(ctx context.Context, tx *sql.Tx, productID uuid.UUID, changes ListingChanges, actor uuid.NullUUID) (ProductRevision, error) {
+	var revisionID uuid.UUID
+	var pending []byte
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, changes FROM product_revisions WHERE product_id = $1 AND status = $2 FOR UPDATE
+	`, productID, RevisionPending).Scan(&revisionID, &pending)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return ProductRevision{}, err
+	}
+	if err == nil {
+		var earlier ListingChanges
+		if err := json.Unmarshal(pending, &earlier); err != nil {
+			return ProductRevision{}, err
+		}
+		changes = earlier.merge(changes)
+	} else {
+		revisionID = uuid.New()
+	}
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return ProductRevision{}, err
+	}
+
+	return scanRevision(tx.QueryRowContext(ctx, `
+		INSERT INTO product_revisions (id, product_id, changes, submitted_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO UPDATE SET changes = EXCLUDED.changes, submitted_by = EXCLUDED.submitted_by, updated = NOW()
+		RETURNING `+revisionColumns,
+		revisionID, productID, data, actor))
+}
+
+const revisionColumns = "id, product_id, changes, status, reason, submitted_by, reviewed_by, reviewed_at, updated, created"
+
+func scanRevision(row interface{ Scan(...any) error }) (ProductRevision, error) {
+	var r ProductRevision
+	var changes []byte
+	if err := row.Scan(&r.ID, &r.ProductID, &changes, &r.Status, &r.Reason, &r.SubmittedBy, &r.ReviewedBy, &r.ReviewedAt, &r.Updated, &r.Created); err != nil {
+		return r, err
+	}
+	return r, json.Unmarshal(changes, &r.Changes)
+}
+
+// FetchProductRevision returns the latest revision of a product, pending or
+// reviewed, so merchants can see why changes were turned down.
+func FetchProductRevision(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		if !middleware.CheckProductAccess(c, app, permission.ProductWrite, productID) {
+			return
+		}
+
+		revision, err := scanRevision(app.DB.QueryRowContext(c, `
+			SELECT `+revisionColumns+` FROM product_revisions
+			WHERE product_id = $1 ORDER BY created DESC LIMIT 1
+		`, productID))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Product has no revisions"})
+			} else {
+				l.ErrorF("Error fetching product revision: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch revision"})
+			}
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"revision": revision})
+	}
+}
+
+// WithdrawProductRevision drops the pending changes of a product.
+func WithdrawProductRevision(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		if !middleware.CheckProductAccess(c, app, permission.ProductWrite, productID) {
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error starting transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		res, err := tx.ExecContext(ctx, "DELETE FROM product_revisions WHERE product_id = $1 AND status = $2", productID, RevisionPending)
+		if err != nil {
+			l.ErrorF("Error withdrawing product revision: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to withdraw revision"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No pending revision"})
+			return
+		}
+		gone, err := discardStaged(ctx, tx, productID)
+		if err != nil {
+			l.ErrorF("Error discarding staged uploads: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to withdraw revision"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+		gone.cleanup(context.WithoutCancel(ctx), app)
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Revision withdrawn successfully"})
+	}
+}
+
+// reviewRevision locks the pending revision of the product in the request.
+// The response is written here when it reports false.
+func reviewRevision(c *gin.Context, tx *sql.Tx) (ProductRevision, bool) {
+	productID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+		return ProductRevision{}, false
+	}
+
+	revision, err := scanRevision(tx.QueryRowContext(c, `
+		SELECT `+revisionColumns+` FROM product_revisions
+		WHERE product_id = $1 AND status = $2 FOR UPDATE
+	`, productID, RevisionPending))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No pending revision"})
+		} else {
+			l.ErrorF("Error fetching product revision: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to review revision"})
+		}
+		return ProductRevision{}, false
+	}
+	return revision, true
+}
+
+func closeRevision(ctx context.Context, tx *sql.Tx, revisionID uuid.UUID, status RevisionStatus, reason string, actor uuid.NullUUID) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE product_revisions SET status = $1, reason = NULLIF($2, ''), reviewed_by = $3, reviewed_at = NOW(), updated = NOW()
+		WHERE id = $4
+	`, status, reason, actor, revisionID)
+	return err
+}
+
+// ApproveProductRevision applies the pending changes of a product.
+func ApproveProductRevision(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error starting transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		revision, ok := reviewRevision(c, tx)
+		if !ok {
+			return
+		}
+
+		// The catalog may have moved on since the changes were submitted
+		problem, err := checkListingChanges(ctx, tx, revision.ProductID, revision.Changes)
+		if err != nil {
+			l.ErrorF("Error checking revision: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve revision"})
+			return
+		}
+		if problem != "" {
+			c.JSON(http.StatusConflict, gin.H{"error": problem})
+			return
+		}
+
+		gone, err := applyListingChanges(ctx, tx, revision.ProductID, revision.Changes)
+		if err != nil {
+			l.ErrorF("Error applying revision: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve revision"})
+			return
+		}
+		leftover, err := discardStaged(ctx, tx, revision.ProductID)
+		if err != nil {
+			l.ErrorF("Error discarding staged uploads: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve revision"})
+			return
+		}
+		if err := closeRevision(ctx, tx, revision.ID, RevisionApproved, "", middleware.ActorID(c)); err != nil {
+			l.ErrorF("Error closing revision: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to approve revision"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+		gone.cleanup(context.WithoutCancel(ctx), app)
+		leftover.cleanup(context.WithoutCancel(ctx), app)
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Revision approved successfully"})
+	}
+}
+
+func RejectProductRevision(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ModerationRequest
+		if err := c.ShouldBindJSON(&req); err != nil || req.Reason == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error starting transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		revision, ok := reviewRevision(c, tx)
+		if !ok {
+			return
+		}
+
+		if err := closeRevision(ctx, tx, revision.ID, RevisionRejected, req.Reason, middleware.ActorID(c)); err != nil {
+			l.ErrorF("Error closing revision: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject revision"})
+			return
+		}
+		gone, err := discardStaged(ctx, tx, revision.ProductID)
+		if err != nil {
+			l.ErrorF("Error discarding staged uploads: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject revision"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+		gone.cleanup(context.WithoutCancel(ctx), app)
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Revision rejected successfully"})
+	}
+}
+
+// FetchModerationQueue lists the submitted products and pending revisions,
+// oldest first.
+func FetchModerationQueue(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit < 1 {
+			limit = 20
+		}
+		offset := (page - 1) * limit
+
+		rows, err := app.DB.QueryContext(c, `
+			SELECT p.id, p.sku, p.name, p.slug, p.product_type, p.merchant_id, m.name,
+				COALESCE((SELECT MAX(h.created) FROM product_status_history h WHERE h.product_id = p.id AND h.to_status = $1), p.created) AS submitted_at
+			FROM products p
+			JOIN merchants m ON m.id = p.merchant_id
+			WHERE p.status = $1 AND p.deleted_at IS NULL
+			ORDER BY submitted_at
+			LIMIT $2 OFFSET $3
+		`, StatusPendingReview, limit, offset)
+		if err != nil {
+			l.ErrorF("Error fetching moderation queue: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch moderation queue"})
+			return
+		}
+		defer rows.Close()
+
+		products := []PendingProduct{}
+		for rows.Next() {
+			var p PendingProduct
+			if err := rows.Scan(&p.ID, &p.SKU, &p.Name, &p.Slug, &p.Type, &p.MerchantID, &p.MerchantName, &p.SubmittedAt); err != nil {
+				l.ErrorF("Error scanning moderation queue: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch moderation queue"})
+				return
+			}
+			products = append(products, p)
+		}
+
+		revisionRows, err := app.DB.QueryContext(c, `
+			SELECT r.id, r.product_id, r.changes, r.status, r.reason, r.submitted_by, r.reviewed_by, r.reviewed_at, r.updated, r.created,
+				p.name, p.merchant_id, m.name
+			FROM product_revisions r
+			JOIN products p ON p.id = r.product_id
+			JOIN merchants m ON m.id = p.merchant_id
+			WHERE r.status = $1
+			ORDER BY r.updated
+			LIMIT $2 OFFSET $3
+		`, RevisionPending, limit, offset)
+		if err != nil {
+			l.ErrorF("Error fetching pending revisions: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch moderation queue"})
+			return
+		}
+		defer revisionRows.Close()
+
+		revisions := []PendingRevision{}
+		for revisionRows.Next() {
+			var r PendingRevision
+			var changes []byte
+			if err := revisionRows.Scan(&r.ID, &r.ProductID, &changes, &r.Status, &r.Reason, &r.SubmittedBy, &r.ReviewedBy, &r.ReviewedAt, &r.Updated, &r.Created,
+				&r.ProductName, &r.MerchantID, &r.MerchantName); err != nil {
+				l.ErrorF("Error scanning pending revision: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch moderation queue"})
+				return
+			}
+			if err := json.Unmarshal(changes, &r.Changes); err != nil {
+				l.ErrorF("Error decoding revision changes: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch moderation queue"})
+				return
+			}
+			revisions = append(revisions, r)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"products": products, "revisions": revisions, "page": page, "limit": limit})
+	}
+}
+
+// initialStatus is the status a new product starts in: drafts unless the
+// creator asks to go live, which moderators can do without review.
+func initialStatus(c *gin.Context, app *conf.Config, goLive bool) ProductStatus {
+	switch {
+	case !goLive:
+		return StatusDraft
+	case middleware.HasPermission(c, app, permission.ProductModerate):
+		return StatusPublished
+	default:
+		return StatusPendingReview
+	}
+}
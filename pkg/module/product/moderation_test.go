@@ -0,0 +1,111 @@
+package product
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to ProductStatus
+		want     bool
+	}{
+		{StatusDraft, StatusPendingReview, true},
+		{StatusDraft, StatusPublished, false},
+		{StatusPendingReview, StatusDraft, true},
+		{StatusPendingReview, StatusPublished, false},
+		{StatusRejected, StatusPendingReview, true},
+		{StatusPublished, StatusArchived, true},
+		{StatusPublished, StatusDraft, false},
+		{StatusArchived, StatusPendingReview, true},
+		{StatusArchived, StatusPublished, false},
+	}
+
+	for _, tt := range tests {
+		if got := canTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("canTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestTakeListingChanges(t *testing.T) {
+	name, price := "Mug", 12.5
+	categoryID := uuid.New()
+	update := ProductUpdate{Name: &name, Price: &price, CategoryID: &categoryID}
+
+	changes := update.takeListingChanges()
+	if changes == nil || changes.Name != &name || changes.CategoryID != &categoryID {
+		t.Fatalf("takeListingChanges() = %+v, want the name and category", changes)
+	}
+	if update.Name != nil || update.CategoryID != nil || update.Price != &price {
+		t.Errorf("update after take = %+v, want only the price left", update)
+	}
+
+	if changes := (&ProductUpdate{Price: &price}).takeListingChanges(); changes != nil {
+		t.Errorf("takeListingChanges() = %+v, want nil without listing fields", changes)
+	}
+}
+
+func TestListingChangesMerge(t *testing.T) {
+	oldName, newName, sku := "Mug", "Big mug", "MUG-1"
+	brandID := uuid.New()
+	earlier := ListingChanges{
+		Name:       &oldName,
+		BrandID:    &brandID,
+		Attributes: map[string]interface{}{"color": "red", "size": "L"},
+	}
+	newer := ListingChanges{
+		Name:       &newName,
+		SKU:        &sku,
+		Attributes: map[string]interface{}{"size": nil},
+	}
+
+	files := []uuid.UUID{uuid.New()}
+	earlier.Files = &files
+	gallery := []GalleryImage{}
+	newer.Images = &gallery
+
+	merged := earlier.merge(newer)
+	if *merged.Name != newName || *merged.SKU != sku || *merged.BrandID != brandID {
+		t.Errorf("merge() fields = %+v", merged)
+	}
+	if merged.Attributes["color"] != "red" || merged.Attributes["size"] != nil || len(merged.Attributes) != 2 {
+		t.Errorf("merge() attributes = %v", merged.Attributes)
+	}
+	if merged.Files != &files || merged.Images != &gallery || merged.Components != nil {
+		t.Errorf("merge() sets = %+v", merged)
+	}
+	if earlier.Attributes["size"] != "L" {
+		t.Errorf("merge() changed the earlier attributes")
+	}
+}
+
+func TestReorderGallery(t *testing.T) {
+	a, b, c := GalleryImage{ID: uuid.New()}, GalleryImage{ID: uuid.New()}, GalleryImage{ID: uuid.New()}
+	gallery := []GalleryImage{a, b, c}
+
+	got, ok := reorderGallery(gallery, []uuid.UUID{c.ID, a.ID})
+	if !ok || len(got) != 3 || got[0].ID != c.ID || got[1].ID != a.ID || got[2].ID != b.ID {
+		t.Errorf("reorderGallery() = %v, %v, want c, a, b", got, ok)
+	}
+	if _, ok := reorderGallery(gallery, []uuid.UUID{a.ID, a.ID}); ok {
+		t.Errorf("reorderGallery() accepted a repeated image")
+	}
+	if _, ok := reorderGallery(gallery, []uuid.UUID{uuid.New()}); ok {
+		t.Errorf("reorderGallery() accepted an unknown image")
+	}
+}
+
+func TestMoveGalleryImage(t *testing.T) {
+	a, b, c := GalleryImage{ID: uuid.New()}, GalleryImage{ID: uuid.New()}, GalleryImage{ID: uuid.New()}
+
+	got := moveGalleryImage([]GalleryImage{a, b, c}, 0, 9)
+	if got[0].ID != b.ID || got[1].ID != c.ID || got[2].ID != a.ID {
+		t.Errorf("moveGalleryImage() to the end = %v", got)
+	}
+	got = moveGalleryImage([]GalleryImage{a, b, c}, 2, -1)
+	if got[0].ID != c.ID || got[1].ID != a.ID || got[2].ID != b.ID {
+		t.Errorf("moveGalleryImage() to the start = %v", got)
+	}
+}
@@ -0,0 +1,354 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/guregu/null/v5"
+	"github.com/lib/pq"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/middleware"
+	"src/pkg/permission"
+)
+
+// Price history change types
+const (
+	PriceChangeInitial       = "initial"
+	PriceChangePrice         = "price"
+	PriceChangeSaleScheduled = "sale_scheduled"
+	PriceChangeSaleRemoved   = "sale_removed"
+)
+
+// EffectivePriceSQL is the SQL expression for the price a customer pays right
+// now for the products row aliased as alias: the lowest running sale price,
+// or the regular price when no sale is active.
+func EffectivePriceSQL(alias string) string {
+	return fmt.Sprintf(`LEAST(%[1]s.price, COALESCE((
+		SELECT MIN(sp.sale_price) FROM product_sale_prices sp
+		WHERE sp.product_id = %[1]s.id AND sp.starts_at <= NOW() AND (sp.ends_at IS NULL OR sp.ends_at > NOW())
+	), %[1]s.price))`, alias)
+}
+
+// fetchPricing returns the effective price and price details of the given products.
+func fetchPricing(ctx context.Context, db queryer, productIDs []uuid.UUID) (map[uuid.UUID]Pricing, map[uuid.UUID]float64, error) {
+	pricing := make(map[uuid.UUID]Pricing)
+	effective := make(map[uuid.UUID]float64)
+	if len(productIDs) == 0 {
+		return pricing, effective, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT p.id, p.price, p.compare_at_price, sp.sale_price, sp.ends_at
+		FROM products p
+		LEFT JOIN LATERAL (
+			SELECT sale_price, ends_at FROM product_sale_prices
+			WHERE product_id = p.id AND starts_at <= NOW() AND (ends_at IS NULL OR ends_at > NOW())
+			ORDER BY sale_price
+			LIMIT 1
+		) sp ON TRUE
+		WHERE p.id = ANY($1)
+	`, pq.Array(productIDs))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var productID uuid.UUID
+		var price Pricing
+		var salePrice null.Float
+		var saleEnds null.Time
+		if err := rows.Scan(&productID, &price.RegularPrice, &price.CompareAtPrice, &salePrice, &saleEnds); err != nil {
+			return nil, nil, err
+		}
+
+		effective[productID] = price.RegularPrice
+		if salePrice.Valid && salePrice.Float64 < price.RegularPrice {
+			effective[productID] = salePrice.Float64
+			price.OnSale = true
+			price.SaleEndsAt = saleEnds
+		}
+		pricing[productID] = price
+	}
+	return pricing, effective, rows.Err()
+}
+
+// validCompareAt tells whether compareAt can be shown as the price before a
+// discount: it must be above the price.
+func validCompareAt(price float64, compareAt null.Float) bool {
+	return !compareAt.Valid || compareAt.Float64 > price
+}
+
+// recordPriceChange appends the current prices of a product to its history.
+func recordPriceChange(ctx context.Context, tx *sql.Tx, productID uuid.UUID, changeType string, sale *SalePrice, actorID uuid.NullUUID) error {
+	var salePrice null.Float
+	var saleStarts, saleEnds null.Time
+	if sale != nil {
+		salePrice = null.FloatFrom(sale.SalePrice)
+		saleStarts = null.TimeFrom(sale.StartsAt)
+		saleEnds = sale.EndsAt
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO product_price_history (id, product_id, change_type, price, compare_at_price, sale_price, sale_starts_at, sale_ends_at, changed_by, created)
+		SELECT $1, id, $2, price, compare_at_price, $3, $4, $5, $6, $7
+		FROM products WHERE id = $8
+	`, uuid.New(), changeType, salePrice, saleStarts, saleEnds, actorID, time.Now(), productID)
+	return err
+}
+
+func ListProductPrices(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		if !middleware.CheckProductAccess(c, app, permission.ProductWrite, productID) {
+			return
+		}
+
+		pricing, effective, err := fetchPricing(c, app.DB, []uuid.UUID{productID})
+		if err != nil {
+			l.ErrorF("Error fetching product pricing: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch prices"})
+			return
+		}
+
+		rows, err := app.DB.QueryContext(c, `
+			SELECT id, product_id, sale_price, starts_at, ends_at, created_by, created
+			FROM product_sale_prices
+			WHERE product_id = $1 AND (ends_at IS NULL OR ends_at > NOW())
+			ORDER BY starts_at
+		`, productID)
+		if err != nil {
+			l.ErrorF("Error fetching sale prices: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch prices"})
+			return
+		}
+		defer rows.Close()
+
+		sales := []SalePrice{}
+		for rows.Next() {
+			var sale SalePrice
+			if err := rows.Scan(&sale.ID, &sale.ProductID, &sale.SalePrice, &sale.StartsAt, &sale.EndsAt, &sale.CreatedBy, &sale.Created); err != nil {
+				l.ErrorF("Error scanning sale price: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch prices"})
+				return
+			}
+			sales = append(sales, sale)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"price":   effective[productID],
+			"pricing": pricing[productID],
+			"sales":   sales,
+		})
+	}
+}
+
+func AddProductSalePrice(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		if !middleware.CheckProductAccess(c, app, permission.ProductWrite, productID) {
+			return
+		}
+
+		var req AddSalePriceRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if req.EndsAt.Valid && !req.EndsAt.Time.After(req.StartsAt) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "endsAt must be after startsAt"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error starting transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		// Lock the product so two overlapping windows cannot be added at once
+		var regularPrice float64
+		err = tx.QueryRowContext(ctx, "SELECT price FROM products WHERE id = $1 FOR UPDATE", productID).Scan(&regularPrice)
+		if err != nil {
+			l.ErrorF("Error locking product: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add sale price"})
+			return
+		}
+		if req.SalePrice >= regularPrice {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Sale price must be lower than the regular price"})
+			return
+		}
+
+		var overlaps bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT EXISTS(
+				SELECT 1 FROM product_sale_prices
+				WHERE product_id = $1
+					AND starts_at < COALESCE($3, 'infinity'::timestamptz)
+					AND COALESCE(ends_at, 'infinity'::timestamptz) > $2
+			)
+		`, productID, req.StartsAt, req.EndsAt).Scan(&overlaps)
+		if err != nil {
+			l.ErrorF("Error checking sale windows: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add sale price"})
+			return
+		}
+		if overlaps {
+			c.JSON(http.StatusConflict, gin.H{"error": "Another sale is already scheduled in this period"})
+			return
+		}
+
+		sale := SalePrice{
+			ID:        uuid.New(),
+			ProductID: productID,
+			SalePrice: req.SalePrice,
+			StartsAt:  req.StartsAt,
+			EndsAt:    req.EndsAt,
+			CreatedBy: middleware.ActorID(c),
+			Created:   time.Now(),
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO product_sale_prices (id, product_id, sale_price, starts_at, ends_at, created_by, created)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, sale.ID, sale.ProductID, sale.SalePrice, sale.StartsAt, sale.EndsAt, sale.CreatedBy, sale.Created)
+		if err != nil {
+			l.ErrorF("Error inserting sale price: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add sale price"})
+			return
+		}
+
+		if err := recordPriceChange(ctx, tx, productID, PriceChangeSaleScheduled, &sale, sale.CreatedBy); err != nil {
+			l.ErrorF("Error recording price history: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add sale price"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Sale price scheduled successfully", "sale": sale})
+	}
+}
+
+func DeleteProductSalePrice(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+		saleID, err := uuid.Parse(c.Param("saleId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sale ID"})
+			return
+		}
+
+		if !middleware.CheckProductAccess(c, app, permission.ProductWrite, productID) {
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error starting transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		var sale SalePrice
+		err = tx.QueryRowContext(ctx, `
+			DELETE FROM product_sale_prices WHERE id = $1 AND product_id = $2
+			RETURNING id, product_id, sale_price, starts_at, ends_at, created_by, created
+		`, saleID, productID).Scan(&sale.ID, &sale.ProductID, &sale.SalePrice, &sale.StartsAt, &sale.EndsAt, &sale.CreatedBy, &sale.Created)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Sale price not found"})
+			} else {
+				l.ErrorF("Error deleting sale price: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete sale price"})
+			}
+			return
+		}
+
+		if err := recordPriceChange(ctx, tx, productID, PriceChangeSaleRemoved, &sale, middleware.ActorID(c)); err != nil {
+			l.ErrorF("Error recording price history: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete sale price"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Sale price removed successfully"})
+	}
+}
+
+func FetchProductPriceHistory(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		if !middleware.CheckProductAccess(c, app, permission.ProductWrite, productID) {
+			return
+		}
+
+		rows, err := app.DB.QueryContext(c, `
+			SELECT id, change_type, price, compare_at_price, sale_price, sale_starts_at, sale_ends_at, changed_by, created
+			FROM product_price_history
+			WHERE product_id = $1
+			ORDER BY created DESC
+		`, productID)
+		if err != nil {
+			l.ErrorF("Error fetching price history: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch price history"})
+			return
+		}
+		defer rows.Close()
+
+		history := []PriceChange{}
+		for rows.Next() {
+			var change PriceChange
+			err := rows.Scan(&change.ID, &change.ChangeType, &change.Price, &change.CompareAtPrice, &change.SalePrice,
+				&change.SaleStartsAt, &change.SaleEndsAt, &change.ChangedBy, &change.Created)
+			if err != nil {
+				l.ErrorF("Error scanning price history: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch price history"})
+				return
+			}
+			history = append(history, change)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"history": history})
+	}
+}
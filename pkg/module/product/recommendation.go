@@ -0,0 +1,195 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"src/l"
+	"src/pkg/conf"
+)
+
+const (
+	// VisitorHeader carries the id the client keeps for an anonymous visitor.
+	VisitorHeader = "X-Visitor-Id"
+
+	recommendationsPerKind = 20   // stored per product and kind
+	viewRetentionDays      = 90   // older views are dropped by the refresh
+	similarPriceBand       = 0.25 // similar products cost within 25% either way
+)
+
+// viewer returns who is looking at the store: the signed in user, or else the
+// anonymous visitor the client identifies.
+func viewer(c *gin.Context) (userID, visitorID uuid.NullUUID) {
+	if id, err := uuid.Parse(c.GetString("userID")); err == nil {
+		return uuid.NullUUID{UUID: id, Valid: true}, uuid.NullUUID{}
+	}
+	if id, err := uuid.Parse(c.GetHeader(VisitorHeader)); err == nil {
+		return uuid.NullUUID{}, uuid.NullUUID{UUID: id, Valid: true}
+	}
+	return uuid.NullUUID{}, uuid.NullUUID{}
+}
+
+func recordProductView(ctx context.Context, db queryer, productID uuid.UUID, userID, visitorID uuid.NullUUID) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO product_views (product_id, user_id, visitor_id) VALUES ($1, $2, $3)", productID, userID, visitorID)
+	return err
+}
+
+// RefreshRecommendations recomputes every recommendation from orders, the
+// catalog and recent views. Only published products are recommended.
+func RefreshRecommendations(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM product_views WHERE created < NOW() - make_interval(days => $1)", viewRetentionDays); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM product_recommendations"); err != nil {
+		return err
+	}
+
+	// Products paid for together, scored by the number of orders
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO product_recommendations (product_id, related_id, kind, score)
+		SELECT product_id, related_id, $1, score FROM (
+			SELECT a.product_id, b.product_id AS related_id, COUNT(DISTINCT a.order_id) AS score,
+				ROW_NUMBER() OVER (PARTITION BY a.product_id ORDER BY COUNT(DISTINCT a.order_id) DESC) AS rank
+			FROM order_items a
+			JOIN order_items b ON b.order_id = a.order_id AND b.product_id <> a.product_id
+			JOIN products p ON p.id = b.product_id AND p.status = 'published' AND p.deleted_at IS NULL
+			WHERE a.status <> 'Cancelled' AND b.status <> 'Cancelled'
+				AND EXISTS (SELECT 1 FROM receipts r WHERE r.order_id = a.order_id AND r.payment_status = 'captured')
+			GROUP BY a.product_id, b.product_id
+		) pairs
+		WHERE rank <= $2
+	`, RecommendBoughtTogether, recommendationsPerKind)
+	if err != nil {
+		return err
+	}
+
+	// Products sharing categories or the brand; every shared category counts
+	// twice, the same brand and a close price once each
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO product_recommendations (product_id, related_id, kind, score)
+		SELECT product_id, related_id, $1, score FROM (
+			SELECT c.product_id, c.related_id, s.score,
+				ROW_NUMBER() OVER (PARTITION BY c.product_id ORDER BY s.score DESC) AS rank
+			FROM (
+				SELECT product_id, related_id, SUM(shared) AS shared FROM (
+					SELECT a.product_id, b.product_id AS related_id, 1 AS shared
+					FROM product_categories a
+					JOIN product_categories b ON b.category_id = a.category_id AND b.product_id <> a.product_id
+					JOIN categories cat ON cat.id = a.category_id AND cat.deleted_at IS NULL
+					UNION ALL
+					SELECT a.id, b.id, 0
+					FROM products a
+					JOIN products b ON b.brand_id = a.brand_id AND b.id <> a.id
+				) pairs
+				GROUP BY product_id, related_id
+			) c
+			JOIN products a ON a.id = c.product_id
+			JOIN products b ON b.id = c.related_id AND b.status = 'published' AND b.deleted_at IS NULL
+			CROSS JOIN LATERAL (
+				SELECT 2 * c.shared
+					+ CASE WHEN a.brand_id = b.brand_id THEN 1 ELSE 0 END
+					+ CASE WHEN b.price BETWEEN a.price * (1 - $3) AND a.price * (1 + $3) THEN 1 ELSE 0 END AS score
+			) s
+		) ranked
+		WHERE rank <= $2
+	`, RecommendSimilar, recommendationsPerKind, similarPriceBand)
+	if err != nil {
+		return err
+	}
+
+	// Products looked at by the same people, scored by the number of viewers
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO product_recommendations (product_id, related_id, kind, score)
+		SELECT product_id, related_id, $1, score FROM (
+			SELECT a.product_id, b.product_id AS related_id, COUNT(*) AS score,
+				ROW_NUMBER() OVER (PARTITION BY a.product_id ORDER BY COUNT(*) DESC) AS rank
+			FROM (SELECT DISTINCT COALESCE(user_id, visitor_id) AS viewer, product_id FROM product_views) a
+			JOIN (SELECT DISTINCT COALESCE(user_id, visitor_id) AS viewer, product_id FROM product_views) b
+				ON b.viewer = a.viewer AND b.product_id <> a.product_id
+			JOIN products p ON p.id = b.product_id AND p.status = 'published' AND p.deleted_at IS NULL
+			GROUP BY a.product_id, b.product_id
+		) pairs
+		WHERE rank <= $2
+	`, RecommendAlsoViewed, recommendationsPerKind)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// FetchRelatedProducts returns the recommendations of a product, grouped by
+// kind and best first.
+func FetchRelatedProducts(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "8"))
+		if err != nil || limit < 1 || limit > recommendationsPerKind {
+			limit = 8
+		}
+
+		var productID uuid.UUID
+		err = app.DB.QueryRowContext(c, "SELECT id FROM products WHERE slug = $1 AND status = 'published' AND deleted_at IS NULL", c.Param("slug")).Scan(&productID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"message": "No product found."})
+			} else {
+				l.ErrorF("Error fetching product: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch related products"})
+			}
+			return
+		}
+
+		// Related products may have been taken off the store since the last refresh
+		rows, err := app.DB.QueryContext(c, `
+			SELECT kind, id, name, slug, image_url, price, score FROM (
+				SELECT r.kind, p.id, p.name, p.slug, p.image_url, `+EffectivePriceSQL("p")+` AS price, r.score,
+					ROW_NUMBER() OVER (PARTITION BY r.kind ORDER BY r.score DESC, p.name) AS rank
+				FROM product_recommendations r
+				JOIN products p ON p.id = r.related_id
+				WHERE r.product_id = $1 AND p.status = 'published' AND p.deleted_at IS NULL
+			) ranked
+			WHERE rank <= $2
+			ORDER BY kind, rank
+		`, productID, limit)
+		if err != nil {
+			l.ErrorF("Error fetching related products: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch related products"})
+			return
+		}
+		defer rows.Close()
+
+		related := map[RecommendationKind][]RelatedProduct{
+			RecommendBoughtTogether: {},
+			RecommendSimilar:        {},
+			RecommendAlsoViewed:     {},
+		}
+		for rows.Next() {
+			var kind RecommendationKind
+			var p RelatedProduct
+			if err := rows.Scan(&kind, &p.ID, &p.Name, &p.Slug, &p.ImageURL, &p.Price, &p.Score); err != nil {
+				l.ErrorF("Error scanning related product: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch related products"})
+				return
+			}
+			related[kind] = append(related[kind], p)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"boughtTogether": related[RecommendBoughtTogether],
+			"similar":        related[RecommendSimilar],
+			"alsoViewed":     related[RecommendAlsoViewed],
+		})
+	}
+}
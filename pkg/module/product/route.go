@@ -1,9 +1,9 @@
 package product
 
 import (
-	"src/common"
 	"src/pkg/conf"
 	"src/pkg/middleware"
+	"src/pkg/permission"
 
 	"github.com/gin-gonic/gin"
 )
@@ -11,7 +11,22 @@ import (
 func SetupRouter(path string, r *gin.RouterGroup, app *conf.Config) {
 	product_route := r.Group(path)
 	{
-		product_route.GET("/item/:slug", GetProductBySlug(app))
+		product_route.GET("/item/:slug",
+			middleware.OptionalAuthMiddleware(app),
+			GetProductBySlug(app))
+		product_route.GET("/item/:slug/related", FetchRelatedProducts(app))
+
+		product_route.GET("/recent",
+			middleware.AuthOrNotMiddleware(app),
+			FetchRecentlyViewed(app))
+
+		product_route.DELETE("/recent",
+			middleware.AuthOrNotMiddleware(app),
+			ClearRecentlyViewed(app))
+
+		product_route.POST("/recent/merge",
+			middleware.AuthMiddleware(app),
+			MergeRecentlyViewed(app))
 		product_route.GET("/list/search/:name", SearchProductsByName(app))
 		product_route.GET("/list", FetchStoreProductsByFilters(app))
 
@@ -20,33 +35,159 @@ func SetupRouter(path string, r *gin.RouterGroup, app *conf.Config) {
 
 		product_route.POST("/add",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleMerchant, common.RoleAdmin),
+			middleware.RequirePermission(app, permission.ProductWrite),
 			AddProduct(app))
 
 		product_route.GET("",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleMerchant, common.RoleAdmin),
+			middleware.RequirePermission(app, permission.ProductWrite),
 			FetchProducts(app))
 
 		product_route.GET("/:id",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleMerchant, common.RoleAdmin),
+			middleware.RequirePermission(app, permission.ProductWrite),
 			FetchProduct(app))
 
 		product_route.PUT("/:id",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleMerchant, common.RoleAdmin),
+			middleware.RequirePermission(app, permission.ProductWrite),
 			UpdateProduct(app))
 
 		product_route.PUT("/:id/active",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleMerchant, common.RoleAdmin),
+			middleware.RequirePermission(app, permission.ProductWrite),
 			UpdateProductStatus(app))
 
+		product_route.PUT("/:id/status",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			ChangeProductStatus(app))
+
+		product_route.GET("/:id/status-history",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			FetchProductStatusHistory(app))
+
+		product_route.GET("/:id/revision",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			FetchProductRevision(app))
+
+		product_route.DELETE("/:id/revision",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			WithdrawProductRevision(app))
+
+		// Moderation
+		product_route.GET("/moderation",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductModerate),
+			FetchModerationQueue(app))
+
+		product_route.POST("/:id/approve",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductModerate),
+			ApproveProduct(app))
+
+		product_route.POST("/:id/reject",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductModerate),
+			RejectProduct(app))
+
+		product_route.POST("/:id/revision/approve",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductModerate),
+			ApproveProductRevision(app))
+
+		product_route.POST("/:id/revision/reject",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductModerate),
+			RejectProductRevision(app))
+
 		product_route.DELETE("/delete/:id",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleMerchant, common.RoleAdmin),
+			middleware.RequirePermission(app, permission.ProductWrite),
 			DeleteProduct(app))
+
+		product_route.GET("/trash",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			FetchProductTrash(app))
+
+		product_route.POST("/:id/restore",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			RestoreProduct(app))
+
+		product_route.GET("/:id/images",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			ListProductImages(app))
+
+		product_route.POST("/:id/images",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			AddProductImages(app))
+
+		product_route.PUT("/:id/images/reorder",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			ReorderProductImages(app))
+
+		product_route.PUT("/:id/images/:imageId",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			UpdateProductImage(app))
+
+		product_route.DELETE("/:id/images/:imageId",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			DeleteProductImage(app))
+
+		product_route.GET("/:id/prices",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			ListProductPrices(app))
+
+		product_route.POST("/:id/prices",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			AddProductSalePrice(app))
+
+		product_route.DELETE("/:id/prices/:saleId",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			DeleteProductSalePrice(app))
+
+		product_route.GET("/:id/price-history",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			FetchProductPriceHistory(app))
+
+		product_route.GET("/:id/files",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			ListProductFiles(app))
+
+		product_route.POST("/:id/files",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			AddProductFiles(app))
+
+		product_route.DELETE("/:id/files/:fileId",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			DeleteProductFile(app))
+
+		product_route.GET("/:id/components",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			ListBundleComponents(app))
+
+		product_route.PUT("/:id/components",
+			middleware.AuthMiddleware(app),
+			middleware.RequirePermission(app, permission.ProductWrite),
+			SetBundleComponents(app))
 	}
 
 }
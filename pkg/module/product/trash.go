@@ -0,0 +1,240 @@
+package product
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/middleware"
+	"src/pkg/misc"
+	"src/pkg/permission"
+)
+
+// FetchProductTrash lists deleted products, newest first. Merchants only see
+// their own.
+func FetchProductTrash(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit < 1 {
+			limit = 20
+		}
+
+		// uuid.Nil lifts the merchant filter for product:write
+		merchantID, ok := middleware.MerchantScope(c, app, permission.ProductWrite)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
+			return
+		}
+
+		rows, err := app.DB.QueryContext(c, `
+			SELECT id, sku, name, slug, product_type, status, merchant_id, deleted_at, deleted_by
+			FROM products
+			WHERE deleted_at IS NOT NULL AND ($1 = $2 OR merchant_id = $1)
+			ORDER BY deleted_at DESC
+			LIMIT $3 OFFSET $4
+		`, merchantID, uuid.Nil, limit, (page-1)*limit)
+		if err != nil {
+			l.ErrorF("Error fetching deleted products: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
+			return
+		}
+		defer rows.Close()
+
+		retention := time.Duration(app.Env.TrashRetentionDays) * 24 * time.Hour
+		products := []TrashedProduct{}
+		for rows.Next() {
+			var p TrashedProduct
+			if err := rows.Scan(&p.ID, &p.SKU, &p.Name, &p.Slug, &p.Type, &p.Status, &p.MerchantID, &p.DeletedAt, &p.DeletedBy); err != nil {
+				l.ErrorF("Error scanning deleted product: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
+				return
+			}
+			p.PurgeAfter = p.DeletedAt.Add(retention)
+			products = append(products, p)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"products": products, "page": page, "limit": limit})
+	}
+}
+
+// RestoreProduct takes a product out of the trash with the status it had.
+func RestoreProduct(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		var productMerchantID uuid.UUID
+		err = app.DB.QueryRowContext(c, "SELECT merchant_id FROM products WHERE id = $1 AND deleted_at IS NOT NULL", productID).Scan(&productMerchantID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Product not found in trash"})
+			} else {
+				l.ErrorF("Error fetching deleted product: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore product"})
+			}
+			return
+		}
+		if !middleware.Allowed(c, app, permission.ProductWrite, productMerchantID) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to manage this product"})
+			return
+		}
+
+		// A bundle cannot be sold while one of its components is gone
+		var missingComponent bool
+		err = app.DB.QueryRowContext(c, `
+			SELECT EXISTS(
+				SELECT 1 FROM bundle_components bc
+				JOIN products p ON p.id = bc.component_id
+				WHERE bc.bundle_id = $1 AND p.deleted_at IS NOT NULL
+			)`, productID).Scan(&missingComponent)
+		if err != nil {
+			l.ErrorF("Error checking bundle components: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore product"})
+			return
+		}
+		if missingComponent {
+			c.JSON(http.StatusConflict, gin.H{"error": "Restore the components of this bundle first"})
+			return
+		}
+
+		_, err = app.DB.ExecContext(c, `
+			UPDATE products SET deleted_at = NULL, deleted_by = NULL, updated = NOW()
+			WHERE id = $1 AND deleted_at IS NOT NULL
+		`, productID)
+		if err != nil {
+			l.ErrorF("Error restoring product: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore product"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Product restored successfully"})
+	}
+}
+
+// PurgeDeletedProducts removes the products deleted before the given time
+// along with their images and files. Products that were ever ordered stay in
+// the trash for good, their buyers keep access to downloads and reviews.
+func PurgeDeletedProducts(ctx context.Context, app *conf.Config, before time.Time) (int, error) {
+	tx, err := app.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT p.id FROM products p
+		WHERE p.deleted_at < $1
+			AND NOT EXISTS (
+				SELECT 1 FROM order_items oi WHERE oi.product_id = p.id
+			)
+		ORDER BY p.product_type = 'bundle' DESC
+		FOR UPDATE OF p
+	`, before)
+	if err != nil {
+		return 0, err
+	}
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	images, err := fetchProductImages(ctx, tx, ids)
+	if err != nil {
+		return 0, err
+	}
+	files, err := fetchFileKeys(ctx, tx, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	// Bundles go first so their components are free to go as well; components
+	// of a bundle that has to stay are left for a later run
+	purged := 0
+	for _, id := range ids {
+		res, err := tx.ExecContext(ctx, `
+			DELETE FROM products p WHERE p.id = $1
+				AND NOT EXISTS (SELECT 1 FROM bundle_components WHERE component_id = p.id)
+		`, id)
+		if err != nil {
+			return 0, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			delete(images, id)
+			delete(files, id)
+			continue
+		}
+		purged++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	for _, productImages := range images {
+		cleanupImageObjects(ctx, app, productImages)
+	}
+	for _, keys := range files {
+		for _, key := range keys {
+			// The same content may still be attached to another product
+			var stillUsed bool
+			if err := app.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM product_files WHERE file_key = $1)", key).Scan(&stillUsed); err != nil {
+				l.ErrorF("Error checking file references: %v", err)
+				continue
+			}
+			if !stillUsed {
+				if err := misc.S3DeleteObjects(app, key); err != nil {
+					l.ErrorF("Failed to delete file object %s: %v", key, err)
+				}
+			}
+		}
+	}
+	return purged, nil
+}
+
+// fetchFileKeys returns the storage keys of the files of the given products.
+func fetchFileKeys(ctx context.Context, db queryer, productIDs []uuid.UUID) (map[uuid.UUID][]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT product_id, file_key FROM product_files WHERE product_id = ANY($1)", pq.Array(productIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[uuid.UUID][]string)
+	for rows.Next() {
+		var productID uuid.UUID
+		var key string
+		if err := rows.Scan(&productID, &key); err != nil {
+			return nil, err
+		}
+		keys[productID] = append(keys[productID], key)
+	}
+	return keys, rows.Err()
+}
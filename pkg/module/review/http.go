@@ -12,10 +12,11 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
-	"src/common"
 	"src/l"
 	"src/pkg/conf"
+	"src/pkg/middleware"
 	"src/pkg/module/product"
+	"src/pkg/permission"
 )
 
 // Model Structs
@@ -49,7 +50,7 @@ func AddReview(app *conf.Config) gin.HandlerFunc {
 
 		// Product existence check (using QueryRowContext for efficiency)
 		var productExists bool
-		err = app.DB.QueryRowContext(c, "SELECT EXISTS (SELECT 1 FROM products WHERE id = $1)", reviewInput.ProductID).Scan(&productExists)
+		err = app.DB.QueryRowContext(c, "SELECT EXISTS (SELECT 1 FROM products WHERE id = $1 AND deleted_at IS NULL)", reviewInput.ProductID).Scan(&productExists)
 
 		if err != nil {
 			l.ErrorF("Error checking for product: %v", err)
@@ -203,7 +204,7 @@ func GetProductReviewsBySlug(app *conf.Config) gin.HandlerFunc {
 
 		slug := c.Param("slug")
 		var productID uuid.UUID
-		err := app.DB.QueryRowContext(c, `SELECT id FROM products WHERE slug = $1`, slug).Scan(&productID)
+		err := app.DB.QueryRowContext(c, `SELECT id FROM products WHERE slug = $1 AND deleted_at IS NULL`, slug).Scan(&productID)
 
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
@@ -365,15 +366,6 @@ func ApproveReview(app *conf.Config) gin.HandlerFunc { // Updated ApproveReview
 			return
 		}
 
-		merchantIDStr := c.GetString("merchantID") // From authentication middleware
-
-		merchantID, err := uuid.Parse(merchantIDStr)
-		if err != nil {
-
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
-			return
-		}
-
 		// Get the review and product details inside a transaction for consistency
 
 		ctx := context.Background()
@@ -419,10 +411,7 @@ func ApproveReview(app *conf.Config) gin.HandlerFunc { // Updated ApproveReview
 
 		}
 
-		role := c.MustGet("role")
-		roleType := common.GetUserRole(role)
-
-		if productMerchantID != merchantID && roleType != common.RoleAdmin {
+		if !middleware.Allowed(c, app, permission.ReviewModerate, productMerchantID) {
 
 			c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized to approve review"}) // Correct status code
 			return
@@ -464,13 +453,6 @@ func RejectReview(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
-		merchantIDStr := c.GetString("merchantID")
-		merchantID, err := uuid.Parse(merchantIDStr)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid merchant ID"})
-			return
-		}
-
 		ctx := context.Background()
 		tx, err := app.DB.BeginTx(ctx, nil)
 		if err != nil {
@@ -504,10 +486,7 @@ func RejectReview(app *conf.Config) gin.HandlerFunc {
 			return
 		}
 
-		role := c.MustGet("role")
-		roleType := common.GetUserRole(role)
-
-		if productMerchantID != merchantID && roleType != common.RoleAdmin {
+		if !middleware.Allowed(c, app, permission.ReviewModerate, productMerchantID) {
 			c.JSON(http.StatusForbidden, gin.H{"error": "Unauthorized to reject review"})
 			return
 		}
@@ -552,9 +531,6 @@ func DeleteReview(app *conf.Config) gin.HandlerFunc { // Updated DeleteReview fu
 			return
 		}
 
-		userRoleStr := c.MustGet("role").(string)
-		userRole := common.GetUserRole(userRoleStr)
-
 		ctx := context.Background()
 		tx, err := app.DB.BeginTx(ctx, nil) // Start transaction
 
@@ -587,49 +563,25 @@ func DeleteReview(app *conf.Config) gin.HandlerFunc { // Updated DeleteReview fu
 
 		}
 
-		if userRole == common.RoleMerchant {
-			// Merchant can delete reviews for products they own
-			var productID uuid.UUID
-
-			err = tx.QueryRowContext(ctx, "SELECT product_id FROM reviews WHERE id = $1", reviewID).Scan(&productID)
-			if err != nil {
-				l.ErrorF("Error fetching product ID from review: %v", err) // Detailed log message
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve product information"})
-
-				return
-			}
-
+		// Authors delete their reviews, moderators the reviews of the products
+		// they moderate
+		if userID != reviewUserID {
 			var merchantID uuid.UUID
-			err = tx.QueryRowContext(ctx, "SELECT merchant_id FROM products WHERE id = $1", productID).Scan(&merchantID)
-
+			err = tx.QueryRowContext(ctx, `
+				SELECT p.merchant_id FROM reviews r
+				JOIN products p ON p.id = r.product_id
+				WHERE r.id = $1
+			`, reviewID).Scan(&merchantID)
 			if err != nil {
-
-				l.DebugF("Failed to retrieve merchant ID for product: %v", err)
+				l.ErrorF("Failed to retrieve merchant ID for review: %v", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify product ownership"})
-
 				return
 			}
 
-			// fetch user_id from merchants table using merchantID, then compare it with userID from token.
-
-			var merchantUserID uuid.UUID
-			err = tx.QueryRowContext(ctx, `select user_id from merchants where id = $1`, merchantID).Scan(&merchantUserID)
-
-			if err != nil {
-				l.DebugF("Failed to retrieve merchant user ID: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify product ownership"}) // Generic message
-
-				return
-			}
-
-			if userID != merchantUserID {
+			if !middleware.Allowed(c, app, permission.ReviewModerate, merchantID) {
 				c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to delete this review"})
 				return
 			}
-
-		} else if userRole != common.RoleAdmin && userID != reviewUserID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to delete this review"})
-			return
 		}
 
 		_, err = tx.ExecContext(ctx, "DELETE FROM reviews WHERE id = $1", reviewID) // Use ExecContext
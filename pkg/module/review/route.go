@@ -1,9 +1,9 @@
 package review
 
 import (
-	"src/common"
 	"src/pkg/conf"
 	"src/pkg/middleware"
+	"src/pkg/permission"
 
 	"github.com/gin-gonic/gin"
 )
@@ -24,12 +24,12 @@ func SetupRouter(path string, r *gin.RouterGroup, config *conf.Config) {
 
 		reviewRoute.PUT("/approve/:reviewId",
 			middleware.AuthMiddleware(config),
-			middleware.RoleCheck(common.RoleAdmin, common.RoleMerchant),
+			middleware.RequirePermission(config, permission.ReviewModerate),
 			ApproveReview(config))
 
 		reviewRoute.PUT("/reject/:reviewId",
 			middleware.AuthMiddleware(config),
-			middleware.RoleCheck(common.RoleAdmin, common.RoleMerchant),
+			middleware.RequirePermission(config, permission.ReviewModerate),
 			ApproveReview(config))
 
 		reviewRoute.DELETE("/delete/:id",
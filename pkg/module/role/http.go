@@ -0,0 +1,334 @@
+package role
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/permission"
+)
+
+const roleColumns = `r.name, r.description, r.scope, r.built_in, r.updated, r.created,
+	COALESCE(ARRAY(SELECT rp.permission FROM role_permissions rp WHERE rp.role = r.name ORDER BY rp.permission), '{}')`
+
+func scanRole(row interface{ Scan(...any) error }, r *Role) error {
+	return row.Scan(&r.Name, &r.Description, &r.Scope, &r.BuiltIn, &r.Updated, &r.Created, pq.Array(&r.Permissions))
+}
+
+// checkPermissions returns why the permissions cannot be given to a role of
+// the scope, or "" when they can.
+func checkPermissions(scope string, perms []string) string {
+	for _, perm := range perms {
+		if !permission.Valid(perm) {
+			return "Unknown permission " + perm
+		}
+		if scope == ScopeMerchant && !strings.HasSuffix(perm, permission.OwnSuffix) {
+			return "Merchant roles only hold :own permissions, not " + perm
+		}
+	}
+	return ""
+}
+
+// replacePermissions sets the permissions of the role.
+func replacePermissions(c *gin.Context, tx *sql.Tx, name string, perms []string) error {
+	if _, err := tx.ExecContext(c, "DELETE FROM role_permissions WHERE role = $1", name); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(c, `
+		INSERT INTO role_permissions (role, permission)
+		SELECT $1, UNNEST($2::text[])
+		ON CONFLICT DO NOTHING
+	`, name, pq.Array(perms))
+	return err
+}
+
+// ListRoles lists the roles with their permissions. ?scope= filters them.
+func ListRoles(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rows, err := app.DB.QueryContext(c, `
+			SELECT `+roleColumns+`
+			FROM roles r
+			WHERE $1 = '' OR r.scope = $1
+			ORDER BY r.scope, r.name
+		`, c.Query("scope"))
+		if err != nil {
+			l.ErrorF("Error fetching roles: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch roles"})
+			return
+		}
+		defer rows.Close()
+
+		roles := []Role{}
+		for rows.Next() {
+			var r Role
+			if err := scanRole(rows, &r); err != nil {
+				l.ErrorF("Error scanning role: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch roles"})
+				return
+			}
+			roles = append(roles, r)
+		}
+		if err := rows.Err(); err != nil {
+			l.ErrorF("Error fetching roles: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch roles"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"roles": roles})
+	}
+}
+
+// ListPermissions lists the permissions roles can hold, with their
+// description. Each of them but "*" also exists in its ":own" form.
+func ListPermissions(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"permissions": permission.Known})
+	}
+}
+
+func CreateRole(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateRoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || len(req.Name) > 50 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The name must have 1 to 50 characters"})
+			return
+		}
+		if req.Scope == "" {
+			req.Scope = ScopeGlobal
+		}
+		if req.Scope != ScopeGlobal && req.Scope != ScopeMerchant {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scope"})
+			return
+		}
+		if msg := checkPermissions(req.Scope, req.Permissions); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO roles (name, description, scope) VALUES ($1, $2, $3)
+			ON CONFLICT (name) DO NOTHING
+		`, req.Name, req.Description, req.Scope)
+		if err != nil {
+			l.ErrorF("Error creating role: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "A role with this name already exists"})
+			return
+		}
+		if err := replacePermissions(c, tx, req.Name, req.Permissions); err != nil {
+			l.ErrorF("Error setting role permissions: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create role"})
+			return
+		}
+		app.Permissions.Invalidate()
+
+		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Role created"})
+	}
+}
+
+// UpdateRole changes the description or the permissions of a role. The
+// permissions of ROLE ADMIN cannot change.
+func UpdateRole(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+		var req UpdateRoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		var scope string
+		err = tx.QueryRowContext(ctx, "SELECT scope FROM roles WHERE name = $1 FOR UPDATE", name).Scan(&scope)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			} else {
+				l.ErrorF("Error fetching role: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+			}
+			return
+		}
+
+		if req.Description != nil {
+			if _, err := tx.ExecContext(ctx, "UPDATE roles SET description = $1, updated = NOW() WHERE name = $2", *req.Description, name); err != nil {
+				l.ErrorF("Error updating role: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+				return
+			}
+		}
+		if req.Permissions != nil {
+			if name == adminRole && !slices.Equal(*req.Permissions, []string{permission.All}) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "The permissions of " + adminRole + " cannot change"})
+				return
+			}
+			if msg := checkPermissions(scope, *req.Permissions); msg != "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+				return
+			}
+			if err := replacePermissions(c, tx, name, *req.Permissions); err != nil {
+				l.ErrorF("Error setting role permissions: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+				return
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE roles SET updated = NOW() WHERE name = $1", name); err != nil {
+				l.ErrorF("Error updating role: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+				return
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+			return
+		}
+		app.Permissions.Invalidate()
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Role updated"})
+	}
+}
+
+// DeleteRole deletes a role nobody has. Built-in roles stay.
+func DeleteRole(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		var builtIn, inUse bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT built_in,
+				EXISTS(SELECT 1 FROM users WHERE role = $1) OR EXISTS(SELECT 1 FROM merchant_staff WHERE role = $1)
+			FROM roles WHERE name = $1 FOR UPDATE
+		`, name).Scan(&builtIn, &inUse)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			} else {
+				l.ErrorF("Error fetching role: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+			}
+			return
+		}
+		if builtIn {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Built-in roles cannot be deleted"})
+			return
+		}
+		if inUse {
+			c.JSON(http.StatusConflict, gin.H{"error": "Users still have this role"})
+			return
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM roles WHERE name = $1", name); err != nil {
+			l.ErrorF("Error deleting role: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+			return
+		}
+		app.Permissions.Invalidate()
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Role deleted"})
+	}
+}
+
+// AssignRole gives a global role to a user. It takes effect when their
+// access token is refreshed.
+func AssignRole(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		if userID.String() == c.GetString("userID") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot change your own role"})
+			return
+		}
+
+		var req AssignRoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		var scope string
+		err = app.DB.QueryRowContext(c, "SELECT scope FROM roles WHERE name = $1", req.Role).Scan(&scope)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+			} else {
+				l.ErrorF("Error fetching role: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign role"})
+			}
+			return
+		}
+		if scope != ScopeGlobal {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Merchant roles are given to staff by their merchant"})
+			return
+		}
+
+		res, err := app.DB.ExecContext(c, "UPDATE users SET role = $1, updated = NOW() WHERE id = $2", req.Role, userID)
+		if err != nil {
+			l.ErrorF("Error assigning role: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign role"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Role assigned"})
+	}
+}
@@ -0,0 +1,39 @@
+package role
+
+import "time"
+
+// Scopes of roles. Global roles are given to users, merchant roles to the
+// staff of a merchant and only hold ":own" permissions.
+const (
+	ScopeGlobal   = "global"
+	ScopeMerchant = "merchant"
+)
+
+// adminRole keeps every permission, so admins cannot lock themselves out.
+const adminRole = "ROLE ADMIN"
+
+type Role struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Scope       string    `json:"scope"`
+	BuiltIn     bool      `json:"builtIn"`
+	Permissions []string  `json:"permissions"`
+	Updated     time.Time `json:"updated"`
+	Created     time.Time `json:"created"`
+}
+
+type CreateRoleRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	Description string   `json:"description"`
+	Scope       string   `json:"scope"`
+	Permissions []string `json:"permissions"`
+}
+
+type UpdateRoleRequest struct {
+	Description *string   `json:"description"`
+	Permissions *[]string `json:"permissions"` // replaces the permissions of the role
+}
+
+type AssignRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
@@ -0,0 +1,28 @@
+package role
+
+import (
+	"src/pkg/conf"
+	"src/pkg/middleware"
+	"src/pkg/permission"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SetupRouter(path string, r *gin.RouterGroup, app *conf.Config) {
+	role_route := r.Group(path,
+		middleware.AuthMiddleware(app),
+		middleware.RequirePermission(app, permission.RoleManage))
+	{
+		role_route.GET("", ListRoles(app))
+
+		role_route.GET("/permissions", ListPermissions(app))
+
+		role_route.POST("", CreateRole(app))
+
+		role_route.PUT("/user/:userId", AssignRole(app))
+
+		role_route.PUT("/:name", UpdateRole(app))
+
+		role_route.DELETE("/:name", DeleteRole(app))
+	}
+}
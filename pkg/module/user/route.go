@@ -1,9 +1,9 @@
 package user
 
 import (
-	"src/common"
 	"src/pkg/conf"
 	"src/pkg/middleware"
+	"src/pkg/permission"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,12 +13,12 @@ func SetupRouter(path string, r *gin.RouterGroup, app *conf.Config) {
 	{
 		userRoute.GET("/search",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleAdmin, common.RoleMerchant),
+			middleware.RequirePermission(app, permission.UserRead),
 			SearchUsers(app))
 
 		userRoute.GET("",
 			middleware.AuthMiddleware(app),
-			middleware.RoleCheck(common.RoleAdmin),
+			middleware.RequirePermission(app, permission.UserManage),
 			FetchUsers(app))
 
 		userRoute.GET("/me",
@@ -0,0 +1,510 @@
+package wishlist
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/module/cart"
+	"src/pkg/module/product"
+)
+
+// ListWishlists returns the wishlists of the signed in user, default first.
+func ListWishlists(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		rows, err := app.DB.QueryContext(c, `
+			SELECT `+wishlistColumns+`, COUNT(wi.id)
+			FROM wishlists w
+			LEFT JOIN wishlist_items wi ON wi.wishlist_id = w.id
+			WHERE w.user_id = $1
+			GROUP BY w.id
+			ORDER BY w.is_default DESC, w.created
+		`, userID)
+		if err != nil {
+			l.ErrorF("Error fetching wishlists: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wishlists"})
+			return
+		}
+		defer rows.Close()
+
+		wishlists := []Wishlist{}
+		for rows.Next() {
+			var w Wishlist
+			if err := rows.Scan(&w.ID, &w.UserID, &w.Name, &w.IsDefault, &w.ShareToken, &w.Updated, &w.Created, &w.ItemCount); err != nil {
+				l.ErrorF("Error scanning wishlist: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wishlists"})
+				return
+			}
+			wishlists = append(wishlists, w)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"wishlists": wishlists})
+	}
+}
+
+// CreateWishlist adds a named wishlist. The first list of a user becomes the
+// default one.
+func CreateWishlist(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		var req WishlistRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var w Wishlist
+		err = scanWishlist(app.DB.QueryRowContext(c, `
+			INSERT INTO wishlists AS w (user_id, name, is_default)
+			VALUES ($1, $2, NOT EXISTS (SELECT 1 FROM wishlists WHERE user_id = $1 AND is_default))
+			RETURNING `+wishlistColumns, userID, req.Name), &w)
+		if err != nil {
+			l.ErrorF("Error creating wishlist: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create wishlist"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"success": true, "message": "Wishlist created", "wishlist": w})
+	}
+}
+
+// FetchWishlist returns a wishlist of the signed in user with its products.
+func FetchWishlist(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w, ok := fetchOwnedWishlist(c, app)
+		if !ok {
+			return
+		}
+
+		items, err := fetchItems(c, app.DB, w.ID, false)
+		if err != nil {
+			l.ErrorF("Error fetching wishlist items: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wishlist"})
+			return
+		}
+		w.ItemCount = len(items)
+
+		c.JSON(http.StatusOK, gin.H{"wishlist": w, "items": items})
+	}
+}
+
+func RenameWishlist(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w, ok := fetchOwnedWishlist(c, app)
+		if !ok {
+			return
+		}
+
+		var req WishlistRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if _, err := app.DB.ExecContext(c, "UPDATE wishlists SET name = $1, updated = NOW() WHERE id = $2", req.Name, w.ID); err != nil {
+			l.ErrorF("Error renaming wishlist: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename wishlist"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Wishlist renamed"})
+	}
+}
+
+// DeleteWishlist removes a wishlist and its items. The default list stays so
+// there is always somewhere to save products to.
+func DeleteWishlist(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w, ok := fetchOwnedWishlist(c, app)
+		if !ok {
+			return
+		}
+		if w.IsDefault {
+			c.JSON(http.StatusConflict, gin.H{"error": "The default wishlist cannot be deleted"})
+			return
+		}
+
+		if _, err := app.DB.ExecContext(c, "DELETE FROM wishlists WHERE id = $1", w.ID); err != nil {
+			l.ErrorF("Error deleting wishlist: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete wishlist"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Wishlist deleted"})
+	}
+}
+
+// AddItem saves a product to a wishlist, the default one unless the request
+// names another. Adding a product that is already on the list updates its
+// alert flags.
+func AddItem(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		var req AddItemRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		var wishlistID uuid.UUID
+		if req.WishlistID != nil {
+			err = tx.QueryRowContext(ctx, "SELECT id FROM wishlists WHERE id = $1 AND user_id = $2", *req.WishlistID, userID).Scan(&wishlistID)
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Wishlist not found"})
+				return
+			}
+		} else {
+			wishlistID, err = defaultWishlist(ctx, tx, userID)
+		}
+		if err != nil {
+			l.ErrorF("Error fetching wishlist: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add to wishlist"})
+			return
+		}
+
+		// The current price and stock are the baseline for the alerts
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO wishlist_items (wishlist_id, product_id, notify_price_drop, notify_back_in_stock, price_at_add, in_stock_at_add)
+			SELECT $1, p.id, $3, $4, `+product.EffectivePriceSQL("p")+`, `+product.InStockSQL("p")+`
+			FROM products p
+			WHERE p.id = $2 AND p.status = 'published' AND p.deleted_at IS NULL
+			ON CONFLICT (wishlist_id, product_id) DO UPDATE SET
+				notify_price_drop = EXCLUDED.notify_price_drop,
+				notify_back_in_stock = EXCLUDED.notify_back_in_stock,
+				price_at_add = EXCLUDED.price_at_add,
+				in_stock_at_add = EXCLUDED.in_stock_at_add,
+				updated = NOW()
+		`, wishlistID, req.ProductID, req.NotifyPriceDrop, req.NotifyBackInStock)
+		if err != nil {
+			l.ErrorF("Error adding wishlist item: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add to wishlist"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+			return
+		}
+
+		if _, err := tx.ExecContext(ctx, "UPDATE wishlists SET updated = NOW() WHERE id = $1", wishlistID); err != nil {
+			l.ErrorF("Error touching wishlist: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add to wishlist"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add to wishlist"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Added to wishlist", "wishlistId": wishlistID})
+	}
+}
+
+// UpdateItem changes the alert flags of a wishlist item and takes the
+// current price and stock as the new baseline.
+func UpdateItem(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w, ok := fetchOwnedWishlist(c, app)
+		if !ok {
+			return
+		}
+		productID, err := uuid.Parse(c.Param("productId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		var req UpdateItemRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		res, err := app.DB.ExecContext(c, `
+			UPDATE wishlist_items wi SET
+				notify_price_drop = COALESCE($3, wi.notify_price_drop),
+				notify_back_in_stock = COALESCE($4, wi.notify_back_in_stock),
+				price_at_add = `+product.EffectivePriceSQL("p")+`,
+				in_stock_at_add = `+product.InStockSQL("p")+`,
+				updated = NOW()
+			FROM products p
+			WHERE p.id = wi.product_id AND wi.wishlist_id = $1 AND wi.product_id = $2
+		`, w.ID, productID, req.NotifyPriceDrop, req.NotifyBackInStock)
+		if err != nil {
+			l.ErrorF("Error updating wishlist item: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update wishlist item"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product is not on the wishlist"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Wishlist item updated"})
+	}
+}
+
+func RemoveItem(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w, ok := fetchOwnedWishlist(c, app)
+		if !ok {
+			return
+		}
+		productID, err := uuid.Parse(c.Param("productId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		res, err := app.DB.ExecContext(c, "DELETE FROM wishlist_items WHERE wishlist_id = $1 AND product_id = $2", w.ID, productID)
+		if err != nil {
+			l.ErrorF("Error removing wishlist item: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove from wishlist"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product is not on the wishlist"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Removed from wishlist"})
+	}
+}
+
+// MoveToCart adds a wishlist item to the user's cart and takes it off the
+// wishlist.
+func MoveToCart(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w, ok := fetchOwnedWishlist(c, app)
+		if !ok {
+			return
+		}
+		productID, err := uuid.Parse(c.Param("productId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid product ID"})
+			return
+		}
+
+		var req MoveToCartRequest
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if req.Quantity < 1 {
+			req.Quantity = 1
+		}
+
+		ctx := c.Request.Context()
+		tx, err := app.DB.BeginTx(ctx, nil)
+		if err != nil {
+			l.ErrorF("Error beginning transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+			return
+		}
+		defer tx.Rollback()
+
+		res, err := tx.ExecContext(ctx, "DELETE FROM wishlist_items WHERE wishlist_id = $1 AND product_id = $2", w.ID, productID)
+		if err != nil {
+			l.ErrorF("Error removing wishlist item: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move to cart"})
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Product is not on the wishlist"})
+			return
+		}
+
+		cartID, err := cart.AddToUserCart(ctx, tx, req.CartID, w.UserID, productID, req.Quantity)
+		switch {
+		case errors.Is(err, cart.ErrProductNotFound):
+			c.JSON(http.StatusConflict, gin.H{"error": "Product is not available"})
+			return
+		case errors.Is(err, cart.ErrCartNotOwned):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Cart not found"})
+			return
+		case err != nil:
+			l.ErrorF("Error adding wishlist item to cart: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move to cart"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			l.ErrorF("Error committing transaction: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move to cart"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Moved to cart", "cartId": cartID})
+	}
+}
+
+// ShareWishlist makes a wishlist readable by anyone with its share link. The
+// token is kept if the list is already shared.
+func ShareWishlist(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w, ok := fetchOwnedWishlist(c, app)
+		if !ok {
+			return
+		}
+
+		token, err := generateShareToken()
+		if err != nil {
+			l.ErrorF("Error generating share token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share wishlist"})
+			return
+		}
+
+		err = app.DB.QueryRowContext(c, `
+			UPDATE wishlists SET share_token = COALESCE(share_token, $1), updated = NOW()
+			WHERE id = $2
+			RETURNING share_token
+		`, token, w.ID).Scan(&token)
+		if err != nil {
+			l.ErrorF("Error sharing wishlist: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to share wishlist"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Wishlist shared", "shareToken": token})
+	}
+}
+
+// UnshareWishlist revokes the share link of a wishlist. Sharing it again
+// gives a new link.
+func UnshareWishlist(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w, ok := fetchOwnedWishlist(c, app)
+		if !ok {
+			return
+		}
+
+		if _, err := app.DB.ExecContext(c, "UPDATE wishlists SET share_token = NULL, updated = NOW() WHERE id = $1", w.ID); err != nil {
+			l.ErrorF("Error unsharing wishlist: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unshare wishlist"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Wishlist is private again"})
+	}
+}
+
+// FetchSharedWishlist is the public view of a shared wishlist. It leaves out
+// the owner and products that cannot be bought.
+func FetchSharedWishlist(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var w Wishlist
+		err := scanWishlist(app.DB.QueryRowContext(c, `
+			SELECT `+wishlistColumns+` FROM wishlists w WHERE w.share_token = $1
+		`, c.Param("token")), &w)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Wishlist not found"})
+			return
+		}
+		if err != nil {
+			l.ErrorF("Error fetching shared wishlist: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wishlist"})
+			return
+		}
+
+		items, err := fetchItems(c, app.DB, w.ID, true)
+		if err != nil {
+			l.ErrorF("Error fetching wishlist items: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wishlist"})
+			return
+		}
+		for i := range items {
+			items[i].NotifyPriceDrop, items[i].NotifyBackInStock = false, false
+		}
+
+		c.JSON(http.StatusOK, gin.H{"name": w.Name, "items": items})
+	}
+}
+
+// ListAlerts returns the flagged wishlist items of the signed in user whose
+// price dropped or that came back in stock since they were saved.
+func ListAlerts(app *conf.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := uuid.Parse(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+
+		alerts, err := fetchAlerts(c, app.DB, userID)
+		if err != nil {
+			l.ErrorF("Error fetching wishlist alerts: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch alerts"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"alerts": alerts})
+	}
+}
+
+// fetchAlerts returns the due wishlist alerts of the user.
+func fetchAlerts(ctx context.Context, db *sql.DB, userID uuid.UUID) ([]WishlistAlert, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT w.id, w.name, wi.in_stock_at_add, `+itemColumns+`
+		FROM wishlist_items wi
+		JOIN wishlists w ON w.id = wi.wishlist_id
+		JOIN products p ON p.id = wi.product_id
+		WHERE w.user_id = $1 AND (wi.notify_price_drop OR wi.notify_back_in_stock)
+		ORDER BY wi.created DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []WishlistAlert{}
+	for rows.Next() {
+		var alert WishlistAlert
+		var inStockAtAdd bool
+		item := &alert.Item
+		err := rows.Scan(&alert.WishlistID, &alert.WishlistName, &inStockAtAdd,
+			&item.ID, &item.ProductID, &item.Name, &item.Slug, &item.ImageURL, &item.Price,
+			&item.PriceAtAdd, &item.InStock, &item.Listed, &item.NotifyPriceDrop, &item.NotifyBackInStock, &item.Created)
+		if err != nil {
+			return nil, err
+		}
+		for _, kind := range alertKinds(alert.Item, inStockAtAdd) {
+			alert.Kind = kind
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts, rows.Err()
+}
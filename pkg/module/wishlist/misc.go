@@ -0,0 +1,130 @@
+package wishlist
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"src/l"
+	"src/pkg/conf"
+	"src/pkg/module/product"
+)
+
+const defaultWishlistName = "My wishlist"
+
+const wishlistColumns = "w.id, w.user_id, w.name, w.is_default, w.share_token, w.updated, w.created"
+
+func scanWishlist(row interface{ Scan(...any) error }, w *Wishlist) error {
+	return row.Scan(&w.ID, &w.UserID, &w.Name, &w.IsDefault, &w.ShareToken, &w.Updated, &w.Created)
+}
+
+// itemColumns selects a wishlist item aliased wi joined with its product p.
+var itemColumns = `wi.id, wi.product_id, p.name, p.slug, p.image_url, ` + product.EffectivePriceSQL("p") + `,
+	wi.price_at_add, ` + product.InStockSQL("p") + `, p.status = 'published' AND p.deleted_at IS NULL,
+	wi.notify_price_drop, wi.notify_back_in_stock, wi.created`
+
+func scanItem(row interface{ Scan(...any) error }, item *WishlistItem) error {
+	return row.Scan(&item.ID, &item.ProductID, &item.Name, &item.Slug, &item.ImageURL, &item.Price,
+		&item.PriceAtAdd, &item.InStock, &item.Listed, &item.NotifyPriceDrop, &item.NotifyBackInStock, &item.Created)
+}
+
+// fetchItems returns the items of a wishlist, newest first. Shared lists
+// only show products that can still be bought.
+func fetchItems(ctx context.Context, db *sql.DB, wishlistID uuid.UUID, listedOnly bool) ([]WishlistItem, error) {
+	query := `
+		SELECT ` + itemColumns + `
+		FROM wishlist_items wi
+		JOIN products p ON p.id = wi.product_id
+		WHERE wi.wishlist_id = $1`
+	if listedOnly {
+		query += ` AND p.status = 'published' AND p.deleted_at IS NULL`
+	}
+	rows, err := db.QueryContext(ctx, query+` ORDER BY wi.created DESC`, wishlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []WishlistItem{}
+	for rows.Next() {
+		var item WishlistItem
+		if err := scanItem(rows, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// fetchOwnedWishlist loads the wishlist in the id path parameter and checks
+// it belongs to the signed in user. It writes the error response itself.
+func fetchOwnedWishlist(c *gin.Context, app *conf.Config) (Wishlist, bool) {
+	var w Wishlist
+	userID, err := uuid.Parse(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return w, false
+	}
+	wishlistID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid wishlist ID"})
+		return w, false
+	}
+
+	err = scanWishlist(app.DB.QueryRowContext(c, `
+		SELECT `+wishlistColumns+` FROM wishlists w WHERE w.id = $1 AND w.user_id = $2
+	`, wishlistID, userID), &w)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Wishlist not found"})
+		return w, false
+	}
+	if err != nil {
+		l.ErrorF("Error fetching wishlist: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wishlist"})
+		return w, false
+	}
+	return w, true
+}
+
+// defaultWishlist returns the user's default wishlist, creating it for users
+// that have none yet.
+func defaultWishlist(ctx context.Context, tx *sql.Tx, userID uuid.UUID) (uuid.UUID, error) {
+	var wishlistID uuid.UUID
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO wishlists (user_id, name, is_default) VALUES ($1, $2, TRUE)
+		ON CONFLICT (user_id) WHERE is_default DO UPDATE SET updated = wishlists.updated
+		RETURNING id
+	`, userID, defaultWishlistName).Scan(&wishlistID)
+	return wishlistID, err
+}
+
+// alertKinds reports which of the alerts the user asked for are due for the
+// item. inStockAtAdd is whether the product was in stock when the item was
+// added or its flags were last saved.
+func alertKinds(item WishlistItem, inStockAtAdd bool) []AlertKind {
+	if !item.Listed {
+		return nil
+	}
+	var kinds []AlertKind
+	if item.NotifyPriceDrop && item.Price < item.PriceAtAdd {
+		kinds = append(kinds, AlertPriceDrop)
+	}
+	if item.NotifyBackInStock && !inStockAtAdd && item.InStock {
+		kinds = append(kinds, AlertBackInStock)
+	}
+	return kinds
+}
+
+func generateShareToken() (string, error) {
+	buffer := make([]byte, 24)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buffer), nil
+}
@@ -0,0 +1,29 @@
+package wishlist
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAlertKinds(t *testing.T) {
+	tests := []struct {
+		name         string
+		item         WishlistItem
+		inStockAtAdd bool
+		want         []AlertKind
+	}{
+		{"no flags", WishlistItem{Listed: true, Price: 5, PriceAtAdd: 10, InStock: true}, false, nil},
+		{"price dropped", WishlistItem{Listed: true, NotifyPriceDrop: true, Price: 5, PriceAtAdd: 10}, true, []AlertKind{AlertPriceDrop}},
+		{"price unchanged", WishlistItem{Listed: true, NotifyPriceDrop: true, Price: 10, PriceAtAdd: 10}, true, nil},
+		{"back in stock", WishlistItem{Listed: true, NotifyBackInStock: true, InStock: true}, false, []AlertKind{AlertBackInStock}},
+		{"was never out of stock", WishlistItem{Listed: true, NotifyBackInStock: true, InStock: true}, true, nil},
+		{"both", WishlistItem{Listed: true, NotifyPriceDrop: true, NotifyBackInStock: true, Price: 5, PriceAtAdd: 10, InStock: true}, false,
+			[]AlertKind{AlertPriceDrop, AlertBackInStock}},
+		{"unlisted", WishlistItem{NotifyPriceDrop: true, NotifyBackInStock: true, Price: 5, PriceAtAdd: 10, InStock: true}, false, nil},
+	}
+	for _, tt := range tests {
+		if got := alertKinds(tt.item, tt.inStockAtAdd); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: alertKinds() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -4,13 +4,72 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/guregu/null/v5"
 )
 
+// Wishlist is a named list of products a user saved for later.
 type Wishlist struct {
-	ID      uuid.UUID `bson:"_id,omitempty" json:"_id,omitempty"`
-	Product uuid.UUID `bson:"product,omitempty" json:"product,omitempty"`
-	User    uuid.UUID `bson:"user,omitempty" json:"user,omitempty"`
-	IsLiked bool      `bson:"isLiked,omitempty" json:"isLiked,omitempty"`
-	Updated time.Time `bson:"updated,omitempty" json:"updated,omitempty"`
-	Created time.Time `bson:"created,omitempty" json:"created,omitempty"`
+	ID         uuid.UUID   `db:"id" json:"_id"`
+	UserID     uuid.UUID   `db:"user_id" json:"userId"`
+	Name       string      `db:"name" json:"name"`
+	IsDefault  bool        `db:"is_default" json:"isDefault"`
+	ShareToken null.String `db:"share_token" json:"shareToken"` // empty while the list is private
+	ItemCount  int         `json:"itemCount"`
+	Updated    time.Time   `db:"updated" json:"updated"`
+	Created    time.Time   `db:"created" json:"created"`
+}
+
+// WishlistItem is a product on a wishlist with its current details.
+type WishlistItem struct {
+	ID                uuid.UUID   `db:"id" json:"_id"`
+	ProductID         uuid.UUID   `db:"product_id" json:"productId"`
+	Name              string      `json:"name"`
+	Slug              string      `json:"slug"`
+	ImageURL          null.String `json:"imageUrl"`
+	Price             float64     `json:"price"` // effective price right now
+	PriceAtAdd        float64     `db:"price_at_add" json:"priceAtAdd"`
+	InStock           bool        `json:"inStock"`
+	Listed            bool        `json:"listed"` // false while the product cannot be bought
+	NotifyPriceDrop   bool        `db:"notify_price_drop" json:"notifyPriceDrop"`
+	NotifyBackInStock bool        `db:"notify_back_in_stock" json:"notifyBackInStock"`
+	Created           time.Time   `db:"created" json:"created"`
+}
+
+// AlertKind is why a wishlist item needs the user's attention.
+type AlertKind string
+
+const (
+	AlertPriceDrop   AlertKind = "price_drop"
+	AlertBackInStock AlertKind = "back_in_stock"
+)
+
+// WishlistAlert is a flagged wishlist item whose condition is met.
+type WishlistAlert struct {
+	Kind         AlertKind    `json:"kind"`
+	WishlistID   uuid.UUID    `json:"wishlistId"`
+	WishlistName string       `json:"wishlistName"`
+	Item         WishlistItem `json:"item"`
+}
+
+type WishlistRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+type AddItemRequest struct {
+	ProductID         uuid.UUID  `json:"productId" binding:"required"`
+	WishlistID        *uuid.UUID `json:"wishlistId"` // default list when empty
+	NotifyPriceDrop   bool       `json:"notifyPriceDrop"`
+	NotifyBackInStock bool       `json:"notifyBackInStock"`
+}
+
+// UpdateItemRequest changes the alert flags of an item. Saving the flags
+// also takes the current price and stock as the new baseline.
+type UpdateItemRequest struct {
+	NotifyPriceDrop   *bool `json:"notifyPriceDrop"`
+	NotifyBackInStock *bool `json:"notifyBackInStock"`
+}
+
+type MoveToCartRequest struct {
+	CartID   uuid.UUID `json:"cartId"` // a new cart is created when empty
+	Quantity int       `json:"quantity"`
 }
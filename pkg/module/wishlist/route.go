@@ -0,0 +1,31 @@
+package wishlist
+
+import (
+	"src/pkg/conf"
+	"src/pkg/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SetupRouter(path string, r *gin.RouterGroup, app *conf.Config) {
+	wishlistRoute := r.Group(path)
+	{
+		wishlistRoute.GET("/shared/:token", FetchSharedWishlist(app))
+
+		wishlistRoute.Use(middleware.AuthMiddleware(app))
+
+		wishlistRoute.GET("", ListWishlists(app))
+		wishlistRoute.POST("", CreateWishlist(app))
+		wishlistRoute.GET("/alerts", ListAlerts(app))
+		wishlistRoute.POST("/items", AddItem(app))
+
+		wishlistRoute.GET("/:id", FetchWishlist(app))
+		wishlistRoute.PUT("/:id", RenameWishlist(app))
+		wishlistRoute.DELETE("/:id", DeleteWishlist(app))
+		wishlistRoute.POST("/:id/share", ShareWishlist(app))
+		wishlistRoute.DELETE("/:id/share", UnshareWishlist(app))
+		wishlistRoute.PUT("/:id/items/:productId", UpdateItem(app))
+		wishlistRoute.DELETE("/:id/items/:productId", RemoveItem(app))
+		wishlistRoute.POST("/:id/items/:productId/cart", MoveToCart(app))
+	}
+}
@@ -0,0 +1,126 @@
+package oauth
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+// OAuth2Provider is a provider without OpenID Connect. The identity comes
+// from its API, called with the access token.
+type OAuth2Provider struct {
+	name       string
+	config     oauth2.Config
+	authParams map[string]string
+	profile    func(ctx context.Context, client *http.Client) (Identity, error)
+}
+
+func newOAuth2(name string, cfg Config, endpoint oauth2.Endpoint, scopes []string, profile func(context.Context, *http.Client) (Identity, error)) *OAuth2Provider {
+	if len(cfg.Scopes) > 0 {
+		scopes = cfg.Scopes
+	}
+	return &OAuth2Provider{
+		name: name,
+		config: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     endpoint,
+			Scopes:       scopes,
+		},
+		authParams: cfg.AuthParams,
+		profile:    profile,
+	}
+}
+
+func (p *OAuth2Provider) Name() string { return p.name }
+
+// AuthCodeURL ignores the nonce, which only OIDC has.
+func (p *OAuth2Provider) AuthCodeURL(ctx context.Context, state, verifier, nonce string) (string, error) {
+	return p.config.AuthCodeURL(state, authOptions(verifier, p.authParams)...), nil
+}
+
+func (p *OAuth2Provider) Exchange(ctx context.Context, cb Callback) (Identity, error) {
+	cfg := p.config
+	if cb.RedirectURL != "" {
+		cfg.RedirectURL = cb.RedirectURL
+	}
+	token, err := cfg.Exchange(withClient(ctx), cb.Code, exchangeOptions(cb)...)
+	if err != nil {
+		return Identity{}, err
+	}
+	return p.profile(ctx, cfg.Client(withClient(ctx), token))
+}
+
+// NewFacebook returns a Facebook Login provider. Facebook does not say
+// whether the address was confirmed, so it is treated as unverified.
+func NewFacebook(name string, cfg Config) *OAuth2Provider {
+	return newOAuth2(name, cfg, endpoints.Facebook, []string{"email", "public_profile"}, facebookProfile)
+}
+
+func facebookProfile(ctx context.Context, client *http.Client) (Identity, error) {
+	var me struct {
+		ID        string `json:"id"`
+		Email     string `json:"email"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Picture   struct {
+			Data struct {
+				URL string `json:"url"`
+			} `json:"data"`
+		} `json:"picture"`
+	}
+	err := getJSONWith(ctx, client, "https://graph.facebook.com/me?fields=id,email,first_name,last_name,picture.type(large)", &me)
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{
+		Subject:    me.ID,
+		Email:      me.Email,
+		GivenName:  me.FirstName,
+		FamilyName: me.LastName,
+		Picture:    me.Picture.Data.URL,
+	}, nil
+}
+
+// NewGitHub returns a GitHub provider. The identity gets the primary
+// address of the account when GitHub verified it.
+func NewGitHub(name string, cfg Config) *OAuth2Provider {
+	return newOAuth2(name, cfg, endpoints.GitHub, []string{"read:user", "user:email"}, githubProfile)
+}
+
+func githubProfile(ctx context.Context, client *http.Client) (Identity, error) {
+	var user struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := getJSONWith(ctx, client, "https://api.github.com/user", &user); err != nil {
+		return Identity{}, err
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSONWith(ctx, client, "https://api.github.com/user/emails", &emails); err != nil {
+		return Identity{}, err
+	}
+
+	id := Identity{Subject: strconv.FormatInt(user.ID, 10), Picture: user.AvatarURL}
+	id.GivenName, id.FamilyName, _ = strings.Cut(user.Name, " ")
+	if id.GivenName == "" {
+		id.GivenName = user.Login
+	}
+	for _, e := range emails {
+		if e.Primary {
+			id.Email, id.EmailVerified = e.Email, e.Verified
+		}
+	}
+	return id, nil
+}
@@ -0,0 +1,345 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/oauth2"
+)
+
+const (
+	clockSkew        = time.Minute
+	jwksRefreshDelay = time.Minute // between two fetches of the keys, for tokens signed with an unknown key
+)
+
+// OIDCProvider is an OpenID Connect provider. Its endpoints are discovered
+// from the issuer on first use, and ID tokens are checked against its keys.
+type OIDCProvider struct {
+	name       string
+	issuer     string
+	config     oauth2.Config
+	authParams map[string]string
+
+	mu          sync.Mutex
+	discovery   *discovery
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func NewOIDC(name string, cfg Config) *OIDCProvider {
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	return &OIDCProvider{
+		name:   name,
+		issuer: strings.TrimSuffix(cfg.Issuer, "/"),
+		config: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       scopes,
+		},
+		authParams: cfg.AuthParams,
+	}
+}
+
+func (p *OIDCProvider) Name() string { return p.name }
+
+// discover fetches the configuration of the issuer once.
+func (p *OIDCProvider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d discovery
+	if err := getJSON(ctx, p.issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("discovering %s: %w", p.issuer, err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("discovering %s: configuration of issuer %s", p.issuer, d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("discovering %s: incomplete configuration", p.issuer)
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// oauth2Config returns the client config with the discovered endpoints.
+func (p *OIDCProvider) oauth2Config(d *discovery) oauth2.Config {
+	cfg := p.config
+	cfg.Endpoint = oauth2.Endpoint{AuthURL: d.AuthorizationEndpoint, TokenURL: d.TokenEndpoint}
+	return cfg
+}
+
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, verifier, nonce string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	cfg := p.oauth2Config(d)
+	opts := authOptions(verifier, p.authParams)
+	if nonce != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", nonce))
+	}
+	return cfg.AuthCodeURL(state, opts...), nil
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, cb Callback) (Identity, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+	cfg := p.oauth2Config(d)
+	if cb.RedirectURL != "" {
+		cfg.RedirectURL = cb.RedirectURL
+	}
+
+	token, err := cfg.Exchange(withClient(ctx), cb.Code, exchangeOptions(cb)...)
+	if err != nil {
+		return Identity{}, err
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, errors.New("no id token in the token response")
+	}
+	claims, err := p.verifyIDToken(ctx, d, rawIDToken, cb.Nonce)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	id := Identity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		GivenName:     claims.GivenName,
+		FamilyName:    claims.FamilyName,
+		Picture:       claims.Picture,
+	}
+
+	// Some providers keep the profile out of the ID token
+	if id.Email == "" && d.UserinfoEndpoint != "" {
+		var info idTokenClaims
+		if err := getJSONWith(ctx, cfg.Client(withClient(ctx), token), d.UserinfoEndpoint, &info); err != nil {
+			return Identity{}, fmt.Errorf("fetching user info: %w", err)
+		}
+		if info.Subject == id.Subject {
+			id.Email, id.EmailVerified = info.Email, bool(info.EmailVerified)
+			id.GivenName, id.FamilyName, id.Picture = info.GivenName, info.FamilyName, info.Picture
+		}
+	}
+	return id, nil
+}
+
+// idTokenClaims are the claims read from ID tokens and user info responses.
+type idTokenClaims struct {
+	Issuer        string    `json:"iss"`
+	Subject       string    `json:"sub"`
+	Audience      audience  `json:"aud"`
+	Expiry        int64     `json:"exp"`
+	Nonce         string    `json:"nonce"`
+	Email         string    `json:"email"`
+	EmailVerified looseBool `json:"email_verified"`
+	GivenName     string    `json:"given_name"`
+	FamilyName    string    `json:"family_name"`
+	Picture       string    `json:"picture"`
+}
+
+// Valid is checked by verifyIDToken instead.
+func (c *idTokenClaims) Valid() error { return nil }
+
+// audience is a single audience or a list of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+func (a audience) contains(s string) bool {
+	for _, aud := range a {
+		if aud == s {
+			return true
+		}
+	}
+	return false
+}
+
+// looseBool also accepts "true" and "false", as sent by Apple.
+type looseBool bool
+
+func (b *looseBool) UnmarshalJSON(data []byte) error {
+	*b = looseBool(strings.Trim(string(data), `"`) == "true")
+	return nil
+}
+
+// verifyIDToken checks the signature and claims of an ID token.
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, d *discovery, raw, nonce string) (*idTokenClaims, error) {
+	var claims idTokenClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(t *jwt.Token) (interface{}, error) {
+		switch t.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, d, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != p.issuer:
+		return nil, fmt.Errorf("id token of issuer %s", claims.Issuer)
+	case !claims.Audience.contains(p.config.ClientID):
+		return nil, errors.New("id token of another client")
+	case time.Unix(claims.Expiry, 0).Add(clockSkew).Before(time.Now()):
+		return nil, errors.New("id token expired")
+	case claims.Subject == "":
+		return nil, errors.New("id token without subject")
+	case nonce != "" && claims.Nonce != nonce:
+		return nil, errors.New("id token of another sign in")
+	}
+	return &claims, nil
+}
+
+// key returns the signing key with the ID, fetching the keys of the provider
+// again when it is unknown, as after a rotation.
+func (p *OIDCProvider) key(ctx context.Context, d *discovery, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < jwksRefreshDelay {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("fetching signing keys: %w", err)
+	}
+	p.keys = make(map[string]crypto.PublicKey, len(set.Keys))
+	p.keysFetched = time.Now()
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			p.keys[jwk.Kid] = key
+		}
+	}
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey finds a fetched key. Tokens without key ID are only accepted
+// from providers with a single key.
+func (p *OIDCProvider) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// jsonWebKey is a public key of a JWKS document (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if err := errors.Join(errN, errE); err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(k.X)
+		y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+		if err := errors.Join(errX, errY); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func getJSON(ctx context.Context, url string, v any) error {
+	return getJSONWith(ctx, httpClient, url, v)
+}
+
+func getJSONWith(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,111 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/oauth2"
+)
+
+// mockOIDC is a local OpenID Connect provider that signs in a single user.
+type mockOIDC struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	challenge string // of the last authorization request
+	nonce     string
+}
+
+func newMockOIDC(t *testing.T) *mockOIDC {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockOIDC{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discovery{
+			Issuer:                m.URL,
+			AuthorizationEndpoint: m.URL + "/authorize",
+			TokenEndpoint:         m.URL + "/token",
+			JWKSURI:               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []jsonWebKey{{
+			Kty: "RSA", Kid: "k1", Use: "sig",
+			N: base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "good-code" || oauth2.S256ChallengeFromVerifier(r.Form.Get("code_verifier")) != m.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss": m.URL, "sub": "user-1", "aud": "client-1", "exp": time.Now().Add(time.Hour).Unix(),
+			"nonce": m.nonce, "email": "ann@example.com", "email_verified": true, "given_name": "Ann",
+		})
+		token.Header["kid"] = "k1"
+		idToken, _ := token.SignedString(key)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "at", "token_type": "Bearer", "id_token": idToken})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// authorize plays the user signing in at the provider.
+func (m *mockOIDC) authorize(t *testing.T, authURL string) {
+	u, err := url.Parse(authURL)
+	if err != nil || !strings.HasPrefix(authURL, m.URL+"/authorize") {
+		t.Fatalf("authorization URL %s", authURL)
+	}
+	m.challenge, m.nonce = u.Query().Get("code_challenge"), u.Query().Get("nonce")
+}
+
+func TestOIDCExchange(t *testing.T) {
+	ctx := context.Background()
+	m := newMockOIDC(t)
+	p := NewOIDC("mock", Config{ClientID: "client-1", ClientSecret: "secret", RedirectURL: "http://app/callback", Issuer: m.URL})
+
+	verifier := oauth2.GenerateVerifier()
+	authURL, err := p.AuthCodeURL(ctx, "state-1", verifier, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.authorize(t, authURL)
+
+	id, err := p.Exchange(ctx, Callback{Code: "good-code", Verifier: verifier, Nonce: "nonce-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Subject != "user-1" || id.Email != "ann@example.com" || !id.EmailVerified || id.GivenName != "Ann" {
+		t.Errorf("identity = %+v", id)
+	}
+
+	if _, err := p.Exchange(ctx, Callback{Code: "good-code", Verifier: verifier, Nonce: "another"}); err == nil {
+		t.Error("id token of another sign in accepted")
+	}
+	if _, err := p.Exchange(ctx, Callback{Code: "good-code", Verifier: oauth2.GenerateVerifier(), Nonce: "nonce-1"}); err == nil {
+		t.Error("code exchanged without its verifier")
+	}
+
+	other := NewOIDC("mock", Config{ClientID: "client-2", Issuer: m.URL})
+	if _, err := other.Exchange(ctx, Callback{Code: "good-code", Verifier: verifier, Nonce: "nonce-1"}); err == nil {
+		t.Error("id token of another client accepted")
+	}
+}
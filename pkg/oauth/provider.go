@@ -0,0 +1,181 @@
+// Package oauth signs users in with external identity providers: any OpenID
+// Connect provider, found through discovery, plus Facebook and GitHub, which
+// only speak OAuth 2.0. Every flow uses PKCE; the caller keeps the state,
+// code verifier and nonce between the redirect and the callback.
+package oauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"golang.org/x/oauth2"
+
+	"src/pkg/env"
+)
+
+// Identity is the user as the provider knows them.
+type Identity struct {
+	Subject       string // stable ID of the user at the provider
+	Email         string
+	EmailVerified bool // the provider vouches for the address
+	GivenName     string
+	FamilyName    string
+	Picture       string
+}
+
+// Callback is what the provider sent back, with what the flow started with.
+type Callback struct {
+	Code        string
+	Verifier    string // PKCE code verifier, empty when the flow had none
+	Nonce       string // OIDC nonce, empty when the flow had none
+	RedirectURL string // replaces the configured one, for codes the client got itself
+}
+
+// Provider is an identity provider users sign in with.
+type Provider interface {
+	Name() string
+	AuthCodeURL(ctx context.Context, state, verifier, nonce string) (string, error)
+	Exchange(ctx context.Context, cb Callback) (Identity, error)
+}
+
+// Provider types
+const (
+	TypeOIDC     = "oidc"
+	TypeFacebook = "facebook"
+	TypeGitHub   = "github"
+)
+
+// Issuers of the OIDC providers known by name
+var wellKnownIssuers = map[string]string{
+	"google": "https://accounts.google.com",
+	"apple":  "https://appleid.apple.com",
+}
+
+// Config of a provider, read from the OAUTH_<NAME>_* variables.
+type Config struct {
+	Type         string            `envconfig:"TYPE"` // oidc, facebook or github, guessed from the name when empty
+	ClientID     string            `envconfig:"CLIENT_ID" required:"true"`
+	ClientSecret string            `envconfig:"CLIENT_SECRET"`
+	RedirectURL  string            `envconfig:"REDIRECT_URL" required:"true"` // .../api/auth/oauth/<name>/callback
+	Issuer       string            `envconfig:"ISSUER"`                       // oidc only, known for google and apple
+	Scopes       []string          `envconfig:"SCOPES"`
+	AuthParams   map[string]string `envconfig:"AUTH_PARAMS"` // extra authorization parameters, like response_mode:form_post for Apple
+}
+
+// New returns the provider of the config.
+func New(name string, cfg Config) (Provider, error) {
+	typ := cfg.Type
+	if typ == "" {
+		typ = TypeOIDC
+		if name == TypeFacebook || name == TypeGitHub {
+			typ = name
+		}
+	}
+
+	switch typ {
+	case TypeOIDC:
+		if cfg.Issuer == "" {
+			cfg.Issuer = wellKnownIssuers[name]
+		}
+		if cfg.Issuer == "" {
+			return nil, fmt.Errorf("oauth provider %s: issuer is required", name)
+		}
+		return NewOIDC(name, cfg), nil
+	case TypeFacebook:
+		return NewFacebook(name, cfg), nil
+	case TypeGitHub:
+		return NewGitHub(name, cfg), nil
+	}
+	return nil, fmt.Errorf("oauth provider %s: unknown type %q", name, typ)
+}
+
+// Registry holds the enabled providers by name.
+type Registry struct {
+	providers map[string]Provider
+}
+
+func NewRegistry(providers ...Provider) *Registry {
+	r := &Registry{providers: make(map[string]Provider, len(providers))}
+	for _, p := range providers {
+		r.providers[p.Name()] = p
+	}
+	return r
+}
+
+func (r *Registry) Get(name string) (Provider, bool) {
+	p, ok := r.providers[name]
+	return p, ok
+}
+
+// Names returns the names of the providers, sorted.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Load returns the providers of the environment: Google, configured with the
+// GOOGLE_* variables, and every provider named in OAUTH_PROVIDERS.
+func Load(e *env.Env) (*Registry, error) {
+	google, err := New("google", Config{
+		ClientID:     e.GoogleClientID,
+		ClientSecret: e.GoogleClientSecret,
+		RedirectURL:  e.GoogleRedirectURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	providers := []Provider{google}
+
+	for _, name := range e.OAuthProviders {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || name == "google" {
+			continue
+		}
+		var cfg Config
+		if err := envconfig.Process("OAUTH_"+strings.ToUpper(name), &cfg); err != nil {
+			return nil, err
+		}
+		p, err := New(name, cfg)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+	return NewRegistry(providers...), nil
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// withClient makes the oauth2 package use httpClient.
+func withClient(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+}
+
+// authOptions are the authorization parameters every flow sends.
+func authOptions(verifier string, params map[string]string) []oauth2.AuthCodeOption {
+	var opts []oauth2.AuthCodeOption
+	if verifier != "" {
+		opts = append(opts, oauth2.S256ChallengeOption(verifier))
+	}
+	for key, value := range params {
+		opts = append(opts, oauth2.SetAuthURLParam(key, value))
+	}
+	return opts
+}
+
+// exchangeOptions are the token parameters matching authOptions.
+func exchangeOptions(cb Callback) []oauth2.AuthCodeOption {
+	if cb.Verifier == "" {
+		return nil
+	}
+	return []oauth2.AuthCodeOption{oauth2.VerifierOption(cb.Verifier)}
+}
@@ -0,0 +1,128 @@
+// Package permission maps roles to permissions.
+//
+// A permission is "<resource>:<action>", like "product:write". The ":own"
+// form of a permission, like "product:write:own", only covers the resources
+// of the merchant of the user; the permission itself covers every merchant.
+// Roles and their permissions are stored in the database and managed by
+// admins, see Store.
+package permission
+
+import (
+	"slices"
+	"strings"
+)
+
+// OwnSuffix limits a permission to the resources of the merchant of the user.
+const OwnSuffix = ":own"
+
+// All grants every permission.
+const All = "*"
+
+const (
+	ProductWrite    = "product:write"    // create, edit, delete and restore products
+	ProductModerate = "product:moderate" // approve and reject products and revisions
+	CategoryWrite   = "category:write"
+	BrandWrite      = "brand:write"   // create, edit and delete brands
+	BrandRestore    = "brand:restore" // see the trash and restore brands
+	InventoryWrite  = "inventory:write"
+	OrderRead       = "order:read" // see the orders of merchants
+	OrderFulfill    = "order:fulfill"
+	ReviewModerate  = "review:moderate"
+	SupportReply    = "support:reply" // answer and update support tickets
+	MerchantManage  = "merchant:manage"
+	StaffManage     = "staff:manage" // invite, update and remove staff of merchants
+	UserRead        = "user:read"
+	UserManage      = "user:manage"
+	RoleManage      = "role:manage"
+	SecurityManage  = "security:manage" // two-factor policy and login attempts
+)
+
+// Info describes a permission. Only ownable permissions, on resources of
+// merchants, have an ":own" form.
+type Info struct {
+	Description string `json:"description"`
+	Ownable     bool   `json:"ownable"`
+}
+
+// Known lists the permissions checked by the API.
+var Known = map[string]Info{
+	All:             {"Everything", false},
+	ProductWrite:    {"Create, edit, delete and restore products", true},
+	ProductModerate: {"Approve and reject products and their revisions", false},
+	CategoryWrite:   {"Manage categories and their attributes", false},
+	BrandWrite:      {"Create, edit and delete brands", false},
+	BrandRestore:    {"See deleted brands and restore them", false},
+	InventoryWrite:  {"Manage stock and stock locations", true},
+	OrderRead:       {"See the orders of merchants", true},
+	OrderFulfill:    {"Update the status of order items", true},
+	ReviewModerate:  {"Approve, reject and delete reviews", true},
+	SupportReply:    {"Answer and update support tickets", true},
+	MerchantManage:  {"Approve, disable and search merchants", true},
+	StaffManage:     {"Invite, update and remove staff of merchants", true},
+	UserRead:        {"Search users", false},
+	UserManage:      {"List users", false},
+	RoleManage:      {"Manage roles and their permissions", false},
+	SecurityManage:  {"Manage the two-factor policy and see login attempts", false},
+}
+
+// Own returns the form of perm limited to the merchant of the user.
+func Own(perm string) string {
+	return perm + OwnSuffix
+}
+
+// Ownable tells whether perm has an ":own" form.
+func Ownable(perm string) bool {
+	return Known[perm].Ownable
+}
+
+// Valid tells whether perm is a known permission or the ":own" form of an
+// ownable one.
+func Valid(perm string) bool {
+	if _, ok := Known[perm]; ok {
+		return true
+	}
+	base, ok := strings.CutSuffix(perm, OwnSuffix)
+	return ok && Ownable(base)
+}
+
+// Set is a set of permissions.
+type Set map[string]struct{}
+
+func NewSet(perms ...string) Set {
+	s := make(Set, len(perms))
+	s.Add(perms...)
+	return s
+}
+
+func (s Set) Add(perms ...string) {
+	for _, perm := range perms {
+		s[perm] = struct{}{}
+	}
+}
+
+// Has tells whether the set grants perm. "*" grants every permission, and a
+// permission also grants its ":own" form.
+func (s Set) Has(perm string) bool {
+	if _, ok := s[All]; ok {
+		return true
+	}
+	if _, ok := s[perm]; ok {
+		return true
+	}
+	base, ok := strings.CutSuffix(perm, OwnSuffix)
+	if !ok {
+		return false
+	}
+	_, ok = s[base]
+	return ok
+}
+
+// List returns the permissions of the set, sorted.
+func (s Set) List() []string {
+	perms := make([]string, 0, len(s))
+	for perm := range s {
+		perms = append(perms, perm)
+	}
+	slices.Sort(perms)
+	return perms
+}
@@ -0,0 +1,44 @@
+package permission
+
+import "testing"
+
+func TestSetHas(t *testing.T) {
+	merchant := NewSet(Own(ProductWrite), OrderRead)
+	admin := NewSet(All)
+
+	cases := []struct {
+		set  Set
+		perm string
+		want bool
+	}{
+		{merchant, Own(ProductWrite), true},
+		{merchant, ProductWrite, false},
+		{merchant, OrderRead, true},
+		{merchant, Own(OrderRead), true},
+		{merchant, CategoryWrite, false},
+		{admin, CategoryWrite, true},
+		{admin, Own(InventoryWrite), true},
+		{NewSet(), Own(ProductWrite), false},
+	}
+	for _, tc := range cases {
+		if got := tc.set.Has(tc.perm); got != tc.want {
+			t.Errorf("%v.Has(%q) = %v, want %v", tc.set.List(), tc.perm, got, tc.want)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	for perm, want := range map[string]bool{
+		ProductWrite:       true,
+		Own(ProductWrite):  true,
+		All:                true,
+		Own(All):           false,
+		Own(RoleManage):    false,
+		"product:fly":      false,
+		"product:write:it": false,
+	} {
+		if got := Valid(perm); got != want {
+			t.Errorf("Valid(%q) = %v, want %v", perm, got, want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package permission
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// cacheLifetime bounds how long other instances keep granting permissions
+// an admin removed.
+const cacheLifetime = 30 * time.Second
+
+// Store reads the permissions of the roles from the database, and keeps
+// them for cacheLifetime.
+type Store struct {
+	db *sql.DB
+
+	mu     sync.Mutex
+	roles  map[string]Set
+	loaded time.Time
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Permissions returns the permissions granted by any of the roles. Unknown
+// roles grant nothing.
+func (s *Store) Permissions(ctx context.Context, roles ...string) (Set, error) {
+	all, err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	set := NewSet()
+	for _, role := range roles {
+		for perm := range all[role] {
+			set.Add(perm)
+		}
+	}
+	return set, nil
+}
+
+// Invalidate drops the cache, after roles changed.
+func (s *Store) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roles = nil
+}
+
+func (s *Store) load(ctx context.Context) (map[string]Set, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.roles != nil && time.Since(s.loaded) < cacheLifetime {
+		return s.roles, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT role, permission FROM role_permissions")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make(map[string]Set)
+	for rows.Next() {
+		var role, perm string
+		if err := rows.Scan(&role, &perm); err != nil {
+			return nil, err
+		}
+		if roles[role] == nil {
+			roles[role] = NewSet()
+		}
+		roles[role].Add(perm)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	s.roles, s.loaded = roles, time.Now()
+	return roles, nil
+}
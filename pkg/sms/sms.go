@@ -0,0 +1,76 @@
+// Package sms sends text messages, such as the one time codes of phone sign
+// ins, and normalizes phone numbers.
+package sms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"src/l"
+	"src/pkg/env"
+)
+
+// Sender delivers a text message to a number in E.164 format.
+type Sender interface {
+	Send(ctx context.Context, to, text string) error
+}
+
+// Drivers selected with SMS_DRIVER.
+const (
+	DriverLog = "log"
+)
+
+// NewSender returns the sender configured in the environment.
+func NewSender(e *env.Env) (Sender, error) {
+	switch e.SMSDriver {
+	case DriverLog:
+		return LogSender{}, nil
+	}
+	return nil, fmt.Errorf("unknown SMS_DRIVER %q", e.SMSDriver)
+}
+
+// LogSender only logs the messages, for development.
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, to, text string) error {
+	l.InfoF("SMS to %s: %s", to, text)
+	return nil
+}
+
+var ErrInvalidNumber = errors.New("invalid phone number")
+
+// Normalize returns the number in E.164 format, +<country code><number>.
+// Numbers without a country code, with or without the trunk prefix 0, get
+// countryCode.
+func Normalize(number, countryCode string) (string, error) {
+	number = strings.TrimSpace(number)
+	international := strings.HasPrefix(number, "+")
+
+	digits := make([]byte, 0, len(number))
+	for i := 0; i < len(number); i++ {
+		switch ch := number[i]; {
+		case ch >= '0' && ch <= '9':
+			digits = append(digits, ch)
+		case ch == ' ' || ch == '-' || ch == '.' || ch == '(' || ch == ')' || (ch == '+' && i == 0):
+		default:
+			return "", ErrInvalidNumber
+		}
+	}
+
+	e164 := string(digits)
+	switch {
+	case international:
+	case strings.HasPrefix(e164, "00"):
+		e164 = e164[2:]
+	default:
+		e164 = strings.TrimPrefix(countryCode, "+") + strings.TrimPrefix(e164, "0")
+	}
+
+	// E.164 numbers have at most 15 digits, and no country code starts with 0
+	if len(e164) < 8 || len(e164) > 15 || e164[0] == '0' {
+		return "", ErrInvalidNumber
+	}
+	return "+" + e164, nil
+}
@@ -0,0 +1,29 @@
+package sms
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"98765 43210":       "+919876543210",
+		"098765-43210":      "+919876543210",
+		"+91 98765 43210":   "+919876543210",
+		"0091 9876543210":   "+919876543210",
+		"+1 (415) 555-0100": "+14155550100",
+		"12345":             "",
+		"+0123456789":       "",
+		"98765x43210":       "",
+		"98+76543210":       "",
+	}
+	for number, want := range cases {
+		got, err := Normalize(number, "91")
+		if want == "" {
+			if err == nil {
+				t.Errorf("Normalize(%q) = %s, want an error", number, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("Normalize(%q) = %s, %v, want %s", number, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package throttle
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the counts in the process. It only protects a single
+// instance.
+type MemoryStore struct {
+	mu     sync.Mutex
+	states map[string]State
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: make(map[string]State)}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.states[key], nil
+}
+
+func (s *MemoryStore) Attempt(ctx context.Context, key string, now time.Time, p Policy) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, wait := p.Attempt(s.states[key], now)
+	if wait == 0 {
+		s.states[key] = state
+	}
+	return wait, nil
+}
+
+func (s *MemoryStore) Refund(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state, ok := s.states[key]; ok && state.Failures > 0 {
+		state.Failures--
+		s.states[key] = state
+	}
+	return nil
+}
+
+func (s *MemoryStore) Lock(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.states[key]
+	state.LockedUntil = until
+	s.states[key] = state
+	return nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, key)
+	return nil
+}
+
+func (s *MemoryStore) Purge(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, state := range s.states {
+		if state.LastFailure.Before(before) && state.LockedUntil.Before(before) {
+			delete(s.states, key)
+		}
+	}
+	return nil
+}
+
+// PostgresStore keeps the counts in the login_throttle table, shared by every
+// instance.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Get(ctx context.Context, key string) (State, error) {
+	var state State
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRowContext(ctx, "SELECT failures, last_failure, locked_until FROM login_throttle WHERE key = $1", key).
+		Scan(&state.Failures, &state.LastFailure, &lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return State{}, nil
+	}
+	state.LockedUntil = lockedUntil.Time
+	return state, err
+}
+
+// Attempt locks the row of the key for the check and the count. A key
+// without one gets an empty row first, which Purge removes later.
+func (s *PostgresStore) Attempt(ctx context.Context, key string, now time.Time, p Policy) (time.Duration, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO login_throttle (key, failures, last_failure) VALUES ($1, 0, $2)
+		ON CONFLICT (key) DO NOTHING
+	`, key, now)
+	if err != nil {
+		return 0, err
+	}
+	var state State
+	var lockedUntil sql.NullTime
+	err = tx.QueryRowContext(ctx, "SELECT failures, last_failure, locked_until FROM login_throttle WHERE key = $1 FOR UPDATE", key).
+		Scan(&state.Failures, &state.LastFailure, &lockedUntil)
+	if err != nil {
+		return 0, err
+	}
+	state.LockedUntil = lockedUntil.Time
+
+	state, wait := p.Attempt(state, now)
+	if wait > 0 {
+		return wait, tx.Commit()
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE login_throttle SET failures = $2, last_failure = $3 WHERE key = $1", key, state.Failures, state.LastFailure)
+	if err != nil {
+		return 0, err
+	}
+	return 0, tx.Commit()
+}
+
+func (s *PostgresStore) Refund(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE login_throttle SET failures = failures - 1 WHERE key = $1 AND failures > 0", key)
+	return err
+}
+
+func (s *PostgresStore) Lock(ctx context.Context, key string, until time.Time) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE login_throttle SET locked_until = $2 WHERE key = $1", key, until)
+	return err
+}
+
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM login_throttle WHERE key = $1", key)
+	return err
+}
+
+func (s *PostgresStore) Purge(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM login_throttle
+		WHERE last_failure < $1 AND (locked_until IS NULL OR locked_until < $1)
+	`, before)
+	return err
+}
@@ -0,0 +1,163 @@
+// Package throttle slows down password guessing. Failed sign ins are counted
+// per account and per client IP; past a few free attempts every new attempt
+// has to wait exponentially longer, and an account with too many failures is
+// locked for a while. An attempt is counted as failed when it is let through,
+// so parallel attempts cannot all pass before the first one failed.
+package throttle
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// State is what a Store keeps per key.
+type State struct {
+	Failures    int       // consecutive failures within the policy window
+	LastFailure time.Time // zero when there was none
+	LockedUntil time.Time // zero when not locked
+}
+
+// Store keeps the failure counts. Use a shared store, such as NewPostgresStore,
+// when several instances serve sign ins.
+type Store interface {
+	Get(ctx context.Context, key string) (State, error)
+	// Attempt counts a failure at now unless the key has to wait first, and
+	// returns how long, atomically. See Policy.Attempt.
+	Attempt(ctx context.Context, key string, now time.Time, p Policy) (time.Duration, error)
+	// Refund takes back a failure counted by Attempt.
+	Refund(ctx context.Context, key string) error
+	Lock(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+	// Purge forgets the keys without failures or lock since before.
+	Purge(ctx context.Context, before time.Time) error
+}
+
+// Policy is how a kind of key is throttled.
+type Policy struct {
+	FreeAttempts int           // failures before attempts are delayed
+	BaseDelay    time.Duration // delay after the first throttled failure, doubled after each next one
+	MaxDelay     time.Duration
+	LockAfter    int // failures that lock the key, 0 never locks
+	LockFor      time.Duration
+	Window       time.Duration // failures are forgotten after this long without another one
+}
+
+var (
+	// AccountPolicy protects an account from being guessed from anywhere.
+	AccountPolicy = Policy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 5 * time.Minute, LockAfter: 10, LockFor: 30 * time.Minute, Window: 24 * time.Hour}
+	// IPPolicy slows down a client trying many accounts.
+	IPPolicy = Policy{FreeAttempts: 20, BaseDelay: time.Second, MaxDelay: 15 * time.Minute, Window: time.Hour}
+)
+
+// RetryAfter is how long the key has to wait before its next attempt.
+func (p Policy) RetryAfter(s State, now time.Time) time.Duration {
+	var wait time.Duration
+	if s.LockedUntil.After(now) {
+		wait = s.LockedUntil.Sub(now)
+	}
+	if s.Failures < p.FreeAttempts || s.LastFailure.IsZero() || now.Sub(s.LastFailure) >= p.Window {
+		return wait
+	}
+
+	delay := p.MaxDelay
+	if shift := s.Failures - p.FreeAttempts; shift < 30 {
+		delay = min(p.BaseDelay<<shift, p.MaxDelay)
+	}
+	if until := s.LastFailure.Add(delay); until.Sub(now) > wait {
+		wait = until.Sub(now)
+	}
+	return wait
+}
+
+// Attempt returns the state after counting a failure at now, or how long the
+// key has to wait when it cannot try yet. Failures older than the window are
+// forgotten first.
+func (p Policy) Attempt(s State, now time.Time) (State, time.Duration) {
+	if wait := p.RetryAfter(s, now); wait > 0 {
+		return s, wait
+	}
+	if now.Sub(s.LastFailure) >= p.Window {
+		s.Failures = 0
+	}
+	s.Failures++
+	s.LastFailure = now
+	return s, 0
+}
+
+// Guard applies AccountPolicy and IPPolicy to sign ins. Every attempt let
+// through by Check ends with Fail, Succeed or Release.
+type Guard struct {
+	store Store
+	now   func() time.Time
+}
+
+func NewGuard(store Store) *Guard {
+	return &Guard{store: store, now: time.Now}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns how long the sign in of the account from the IP has to wait,
+// and whether that is because the account is locked. When it does not have
+// to wait, the attempt is counted as failed already.
+func (g *Guard) Check(ctx context.Context, email, ip string) (time.Duration, bool, error) {
+	now := g.now()
+	wait, err := g.store.Attempt(ctx, accountKey(email), now, AccountPolicy)
+	if err != nil {
+		return 0, false, err
+	}
+	if wait > 0 {
+		account, err := g.store.Get(ctx, accountKey(email))
+		return wait, account.LockedUntil.After(now), err
+	}
+	wait, err = g.store.Attempt(ctx, ipKey(ip), now, IPPolicy)
+	if err == nil && wait > 0 {
+		err = g.store.Refund(ctx, accountKey(email))
+	}
+	return wait, false, err
+}
+
+// Fail settles an attempt that failed. It returns true when the account just
+// got locked, so its owner can be told.
+func (g *Guard) Fail(ctx context.Context, email string) (bool, error) {
+	account, err := g.store.Get(ctx, accountKey(email))
+	if err != nil {
+		return false, err
+	}
+
+	// Locked again after every LockAfter failures
+	if AccountPolicy.LockAfter == 0 || account.Failures == 0 || account.Failures%AccountPolicy.LockAfter != 0 {
+		return false, nil
+	}
+	return true, g.store.Lock(ctx, accountKey(email), g.now().Add(AccountPolicy.LockFor))
+}
+
+// Succeed forgets the failures of the account. Those of the IP are kept so
+// a valid account does not reset the throttle of a client trying others.
+func (g *Guard) Succeed(ctx context.Context, email, ip string) error {
+	return errors.Join(g.store.Reset(ctx, accountKey(email)), g.store.Refund(ctx, ipKey(ip)))
+}
+
+// Release takes back an attempt that neither failed nor finished the sign
+// in, such as a right password still waiting for the second factor.
+func (g *Guard) Release(ctx context.Context, email, ip string) error {
+	return errors.Join(g.store.Refund(ctx, accountKey(email)), g.store.Refund(ctx, ipKey(ip)))
+}
+
+// Unlock lifts the lock of the account and forgets its failures.
+func (g *Guard) Unlock(ctx context.Context, email string) error {
+	return g.store.Reset(ctx, accountKey(email))
+}
+
+// Purge forgets the keys that have nothing left to throttle.
+func (g *Guard) Purge(ctx context.Context) error {
+	return g.store.Purge(ctx, g.now().Add(-max(AccountPolicy.Window, IPPolicy.Window)))
+}
@@ -0,0 +1,107 @@
+package throttle
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Now()
+	p := Policy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute, Window: time.Hour}
+
+	cases := []struct {
+		state State
+		want  time.Duration
+	}{
+		{State{}, 0},
+		{State{Failures: 2, LastFailure: now}, 0},
+		{State{Failures: 3, LastFailure: now}, time.Second},
+		{State{Failures: 5, LastFailure: now}, 4 * time.Second},
+		{State{Failures: 5, LastFailure: now.Add(-3 * time.Second)}, time.Second},
+		{State{Failures: 50, LastFailure: now}, time.Minute},
+		{State{Failures: 50, LastFailure: now.Add(-2 * time.Hour)}, 0},
+		{State{Failures: 1, LastFailure: now, LockedUntil: now.Add(time.Hour)}, time.Hour},
+	}
+	for _, tc := range cases {
+		if got := p.RetryAfter(tc.state, now); got != tc.want {
+			t.Errorf("RetryAfter(%+v) = %v, want %v", tc.state, got, tc.want)
+		}
+	}
+}
+
+func TestGuardLocksAccount(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	g := NewGuard(NewMemoryStore())
+	g.now = func() time.Time { return now }
+
+	for i := 1; i <= AccountPolicy.LockAfter; i++ {
+		if wait, _, err := g.Check(ctx, "Ann@example.com", "10.0.0.1"); err != nil || wait != 0 {
+			t.Fatalf("attempt %d: wait %v, err %v", i, wait, err)
+		}
+		locked, err := g.Fail(ctx, "Ann@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked != (i == AccountPolicy.LockAfter) {
+			t.Fatalf("failure %d: locked = %v", i, locked)
+		}
+		now = now.Add(AccountPolicy.MaxDelay) // wait out the backoff
+	}
+
+	wait, locked, err := g.Check(ctx, "ann@example.com", "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !locked || wait <= 0 {
+		t.Errorf("account not locked from another IP: wait %v, locked %v", wait, locked)
+	}
+
+	if err := g.Unlock(ctx, "ann@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if wait, locked, _ := g.Check(ctx, "ann@example.com", "10.0.0.2"); locked || wait != 0 {
+		t.Errorf("account still throttled after unlock: wait %v, locked %v", wait, locked)
+	}
+}
+
+func TestGuardCountsParallelAttempts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	g := NewGuard(NewMemoryStore())
+	g.now = func() time.Time { return now }
+
+	// None of them failed yet, as in a burst of parallel requests
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		wait, _, err := g.Check(ctx, "ann@example.com", "10.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait == 0 {
+			allowed++
+		}
+	}
+	if allowed != AccountPolicy.FreeAttempts {
+		t.Errorf("allowed %d parallel attempts, want %d", allowed, AccountPolicy.FreeAttempts)
+	}
+}
+
+func TestGuardReleasesAttempt(t *testing.T) {
+	ctx := context.Background()
+	g := NewGuard(NewMemoryStore())
+
+	for i := 0; i < 10; i++ {
+		wait, _, err := g.Check(ctx, "ann@example.com", "10.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != 0 {
+			t.Fatalf("attempt %d throttled after releasing the previous ones: wait %v", i, wait)
+		}
+		if err := g.Release(ctx, "ann@example.com", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
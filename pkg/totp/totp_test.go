@@ -0,0 +1,58 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B, SHA-1 key, truncated to 6 digits
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range cases {
+		got, err := Code(rfcSecret, Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Code at %d = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	previous, _ := Code(rfcSecret, Step(now)-1)
+	tooOld, _ := Code(rfcSecret, Step(now)-2)
+
+	step, ok := Validate(rfcSecret, "050 471", now, 0)
+	if !ok || step != Step(now) {
+		t.Errorf("current code refused")
+	}
+	if _, ok := Validate(rfcSecret, previous, now, 0); !ok {
+		t.Errorf("code of the previous step refused")
+	}
+	if _, ok := Validate(rfcSecret, tooOld, now, 0); ok {
+		t.Errorf("code two steps old accepted")
+	}
+	if _, ok := Validate(rfcSecret, "050471", now, Step(now)); ok {
+		t.Errorf("code accepted twice")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Shop", "ann@example.com", "ABC")
+	if !strings.HasPrefix(uri, "otpauth://totp/Shop:ann@example.com?") || !strings.Contains(uri, "secret=ABC") {
+		t.Errorf("URI = %s", uri)
+	}
+}